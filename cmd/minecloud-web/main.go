@@ -0,0 +1,128 @@
+// Serves the Minecloud website, either standalone or as a lambda behind an
+// API Gateway proxy integration. Users log in with the OpenID Connect provider
+// set in the config, see the oidc settings.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	ls "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/sirupsen/logrus"
+
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/functions"
+	"github.com/owengage/minecloud/pkg/localdetail"
+	"github.com/owengage/minecloud/pkg/mcconfig"
+	"github.com/owengage/minecloud/pkg/oidc"
+	"github.com/owengage/minecloud/pkg/web"
+)
+
+// backgroundInvoker invokes functions without waiting for them, like lambda
+// event invocations, so requests return while worlds come up locally.
+type backgroundInvoker struct {
+	invoker functions.Invoker
+	logger  *logrus.Logger
+}
+
+func (b *backgroundInvoker) Invoke(name string, payload []byte) error {
+	go func() {
+		if err := b.invoker.Invoke(name, payload); err != nil {
+			b.logger.Errorf("%s failed: %v", name, err)
+		}
+	}()
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on when standalone")
+	flag.Parse()
+
+	logger := logrus.New()
+	inLambda := os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != ""
+
+	awsSession := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// Lambdas are configured by the environment alone.
+	home := os.Getenv("HOME")
+	configPath := mcconfig.DefaultPath(home)
+	if inLambda {
+		configPath = ""
+	}
+
+	settings, err := mcconfig.Load(configPath)
+	if err != nil && os.Getenv("MINECLOUD_BACKEND") != "local" {
+		logger.Fatal(err)
+	}
+	if settings.OIDCIssuer == "" || settings.OIDCClientID == "" || settings.OIDCAuthURL == "" || settings.OIDCTokenURL == "" {
+		logger.Fatal("set oidcIssuer, oidcClientId, oidcAuthUrl and oidcTokenUrl to log users in")
+	}
+
+	var b *backend.Backend
+	var invoker functions.Invoker
+
+	if os.Getenv("MINECLOUD_BACKEND") == "local" {
+		localDir := os.Getenv("MINECLOUD_LOCAL_DIR")
+		if localDir == "" {
+			localDir = path.Join(home, ".minecloud", "local")
+		}
+
+		local := localdetail.NewDetail(localdetail.Config{
+			Dir:         localDir,
+			WrapperPath: os.Getenv("MINECLOUD_LOCAL_WRAPPER"),
+			Image:       os.Getenv("MINECLOUD_LOCAL_IMAGE"),
+			Host:        os.Getenv("MINECLOUD_LOCAL_HOST"),
+		})
+		local.Logger = logger
+
+		b = localdetail.NewBackend(local)
+		invoker = &backgroundInvoker{invoker: &functions.LocalInvoker{Backend: b}, logger: logger}
+	} else {
+		config := awsdetail.Config{
+			Config:            settings,
+			SSHPrivateKeyFile: path.Join(home, ".minecloud", settings.KeyPairName+".pem"),
+			SSHKnownHostsPath: path.Join(home, ".ssh/known_hosts"),
+		}
+
+		if inLambda {
+			// Touch the hosts file to make sure it exists.
+			f, err := os.OpenFile("/tmp/known_hosts", os.O_CREATE|os.O_APPEND, 0600)
+			if err != nil {
+				panic(err)
+			}
+			f.Close()
+
+			config.SSHPrivateKeyFile = ""
+			config.SSHPrivateKey = functions.GetSSHKey(awsSession, settings)
+			config.SSHKnownHostsPath = "/tmp/known_hosts"
+			config.SSHDefaultNewKeyBehaviour = awsdetail.SSHNewKeyAccept
+		}
+
+		detail := awsdetail.NewDetail(awsSession, config)
+		detail.Logger = logger
+
+		b = awsdetail.NewBackend(detail)
+		invoker = &awsdetail.LambdaInvoker{LS: ls.New(awsSession)}
+	}
+
+	server := web.NewServer(b, invoker, oidc.Config{
+		Issuer:   settings.OIDCIssuer,
+		ClientID: settings.OIDCClientID,
+		JWKSURL:  settings.OIDCJWKSURL,
+	}, settings.OIDCAuthURL, settings.OIDCTokenURL)
+
+	if inLambda {
+		lambda.Start(web.LambdaHandler(server))
+		return
+	}
+
+	logger.Infof("listening on %s", *addr)
+	logger.Fatal(http.ListenAndServe(*addr, server))
+}
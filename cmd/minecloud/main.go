@@ -1,24 +1,40 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
+	"net"
 	"os"
 	"path"
+	"strings"
+	"time"
 
+	"github.com/owengage/minecloud/pkg/localdetail"
 	"github.com/owengage/minecloud/pkg/mcaws"
+	"github.com/owengage/minecloud/pkg/mcconfig"
+	"github.com/owengage/minecloud/pkg/mclocal"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/backups"
+	"github.com/owengage/minecloud/pkg/functions"
 	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/owengage/minecloud/pkg/rcon"
+	"github.com/owengage/minecloud/pkg/serverwrapper"
 	"github.com/sirupsen/logrus"
 )
 
 // CLI for minecloud
 type CLI struct {
-	mc     minecloud.Interface
-	detail *awsdetail.Detail
-	logger *logrus.Logger
+	mc         minecloud.Interface
+	detail     *awsdetail.Detail
+	backend    *backend.Backend
+	logger     *logrus.Logger
+	configPath string
 }
 
 // Exec based on command line args
@@ -28,8 +44,11 @@ func (cli *CLI) Exec(args []string) error {
 
 	cmdMap := map[string]func([]string) error{
 		// high level commands
-		"up":   cli.up,
-		"down": cli.down,
+		"up":       cli.up,
+		"down":     cli.down,
+		"resume":   cli.resume,
+		"rollback": cli.rollback,
+		"op":       cli.op,
 
 		// plumbing commands
 		"init":       cli.init,
@@ -49,6 +68,11 @@ func (cli *CLI) Exec(args []string) error {
 		"unclaim":    cli.debugUnclaim,
 		"update-dns": cli.updateDNS,
 		"save":       cli.save,
+		"rcon":       cli.rcon,
+		"backup":     cli.backup,
+		"image":      cli.image,
+		"config":     cli.config,
+		"region":     cli.region,
 		"aws-account": func(remainder []string) error {
 			account, err := cli.detail.Account()
 			if err == nil {
@@ -66,43 +90,231 @@ func (cli *CLI) Exec(args []string) error {
 }
 
 func (cli *CLI) up(args []string) error {
-	flags := NewSmartFlags(cli.detail, "up").RequireWorld().RequireInstanceType()
+	flags := NewSmartFlags(cli.detail, cli.backend, "up").RequireWorld().RequireUpOptions()
+	wait := flags.flags.Bool("wait", false, "wait for the world to be up, showing progress")
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	return cli.mc.Up(minecloud.World(flags.World()), flags.InstanceType())
+	id, err := cli.mc.Up(minecloud.World(flags.World()), flags.UpOptions())
+	return cli.followOperation(id, err, *wait)
 }
 
 func (cli *CLI) down(args []string) error {
-	flags := NewSmartFlags(cli.detail, "down").RequireInstance().RequireWorld()
+	flags := NewSmartFlags(cli.detail, cli.backend, "down").RequireInstance().RequireWorld()
+	wait := flags.flags.Bool("wait", false, "wait for the world to be stored, showing progress")
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	return cli.mc.Down(minecloud.World(flags.World()))
+	id, err := cli.mc.Down(minecloud.World(flags.World()))
+	return cli.followOperation(id, err, *wait)
+}
+
+// operationPollInterval is how often to check on an operation being waited for.
+const operationPollInterval = 5 * time.Second
+
+// followOperation tells the user the ID of an operation just requested, and
+// waits for it if asked.
+func (cli *CLI) followOperation(id string, err error, wait bool) error {
+	if id != "" {
+		cli.logger.Infof("operation: %s", id)
+	}
+	if err != nil || !wait {
+		return err
+	}
+	return cli.waitOperation(id)
+}
+
+// op follows operations requested by up and down.
+func (cli *CLI) op(args []string) error {
+	if len(args) < 2 || args[0] != "status" {
+		return errors.New("expected op status <id>")
+	}
+
+	id := args[1]
+	flags := NewSmartFlags(cli.detail, cli.backend, "op status")
+	wait := flags.flags.Bool("wait", false, "wait for the operation to finish, showing progress")
+	if err := flags.ParseValidate(cli.detail, args[2:]); err != nil {
+		return err
+	}
+
+	if *wait {
+		return cli.waitOperation(id)
+	}
+
+	op, err := backend.GetOperation(cli.backend, id)
+	if err != nil {
+		return err
+	}
+	cli.logOperation(op)
+	return nil
+}
+
+// waitOperation shows each step of the operation until it's done. Errors if
+// the operation failed.
+func (cli *CLI) waitOperation(id string) error {
+	op, err := backend.WaitOperation(cli.backend, id, operationPollInterval, cli.logOperation)
+	if err != nil {
+		return err
+	}
+	if op.Status == backend.StatusFailed {
+		return fmt.Errorf("%s %s failed", op.Kind, op.World)
+	}
+	return nil
+}
+
+func (cli *CLI) logOperation(op backend.Operation) {
+	line := fmt.Sprintf("%s %s: %s", op.Kind, op.World, op.Status)
+	switch op.Status {
+	case backend.StatusRunning:
+		if op.Step != "" {
+			line += ", " + op.Step
+		}
+	case backend.StatusSucceeded:
+		if op.Address != "" {
+			line += ", available at " + op.Address
+		}
+	case backend.StatusFailed:
+		line += ": " + op.Error
+	}
+	cli.logger.Info(line)
+}
+
+// resume a failed up or down from the step that failed.
+func (cli *CLI) resume(args []string) error {
+	flags := NewSmartFlags(cli.detail, cli.backend, "resume").RequireWorld().RequireUpOptions()
+	if err := flags.ParseValidate(cli.detail, args); err != nil {
+		return err
+	}
+
+	return backend.Resume(cli.backend, minecloud.World(flags.World()), flags.UpOptions())
+}
+
+// rollback undoes a failed up, so the world can be brought up afresh.
+func (cli *CLI) rollback(args []string) error {
+	flags := NewSmartFlags(cli.detail, cli.backend, "rollback").RequireWorld()
+	if err := flags.ParseValidate(cli.detail, args); err != nil {
+		return err
+	}
+
+	return backend.Rollback(cli.backend, minecloud.World(flags.World()))
 }
 
 func (cli *CLI) terminate(args []string) error {
-	flags := NewSmartFlags(cli.detail, "terminate").RequireInstance()
+	flags := NewSmartFlags(cli.detail, cli.backend, "terminate").RequireInstance()
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	return awsdetail.TerminateInstance(cli.detail, flags.InstanceID())
+	detail, id := flags.Instance()
+	return awsdetail.TerminateInstance(detail, id)
 }
 
 func (cli *CLI) init(args []string) error {
-	return awsdetail.Init(cli.detail)
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	plan := flags.Bool("plan", false, "only show what would be created or updated")
+	check := flags.Bool("check", false, "only report drift, failing if there is any")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !*plan && !*check {
+		return awsdetail.Init(cli.detail)
+	}
+
+	changes, err := awsdetail.PlanInit(cli.detail)
+	if err != nil {
+		return err
+	}
+
+	if *check {
+		changes = awsdetail.Drift(changes)
+		cli.showPlan(changes)
+		if len(changes) > 0 {
+			return fmt.Errorf("%d resources have drifted, run 'minecloud init' to fix them", len(changes))
+		}
+		cli.logger.Info("no drift")
+		return nil
+	}
+
+	cli.showPlan(changes)
+	return nil
 }
 
 func (cli *CLI) deinit(args []string) error {
-	return awsdetail.Deinit(cli.detail)
+	flags := flag.NewFlagSet("deinit", flag.ExitOnError)
+	plan := flags.Bool("plan", false, "only show what would be deleted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !*plan {
+		return awsdetail.Deinit(cli.detail)
+	}
+
+	changes, err := awsdetail.PlanDeinit(cli.detail)
+	if err != nil {
+		return err
+	}
+
+	cli.showPlan(changes)
+	return nil
+}
+
+var planSymbols = map[awsdetail.Action]string{
+	awsdetail.ActionNone:   " ",
+	awsdetail.ActionCreate: "+",
+	awsdetail.ActionUpdate: "~",
+	awsdetail.ActionDelete: "-",
+	awsdetail.ActionKeep:   "=",
+}
+
+func (cli *CLI) showPlan(changes []awsdetail.Change) {
+	for _, change := range changes {
+		cli.logger.Infof("%s %s %s", planSymbols[change.Action], change.Action, change.Resource)
+		for _, detail := range change.Details {
+			cli.logger.Infof("    %s", detail)
+		}
+	}
 }
 
 func (cli *CLI) ls(args []string) error {
+	leases, err := cli.backend.Claims.List()
+	if err != nil {
+		return err
+	}
 
-	servers, err := awsdetail.GetRunning(cli.detail.EC2)
+	for _, lease := range leases {
+		state := string(lease.State)
+		if state == "" {
+			state = "claimed"
+		}
+
+		line := fmt.Sprintf("%s: %s", lease.World, state)
+		if !lease.StateChanged.IsZero() {
+			line += " since " + lease.StateChanged.Format(time.RFC3339)
+		}
+		if lease.Owner != "" {
+			line += ", claimed by " + lease.Owner
+		}
+		if lease.InstanceID != "" {
+			line += " on " + lease.InstanceID
+		}
+		if lease.Expired(time.Now()) {
+			line += ", lease expired"
+		}
+		if lease.State == backend.StateFailed && lease.Operation != "" {
+			line += fmt.Sprintf(", %s failed", lease.Operation)
+			if lease.Step != "" {
+				line += " after " + lease.Step
+			}
+			line += ", resume or rollback"
+		}
+		cli.logger.Info(line)
+	}
+
+	servers, err := cli.backend.Compute.List()
 	if err != nil {
 		return err
 	}
@@ -114,53 +326,73 @@ func (cli *CLI) ls(args []string) error {
 		}
 
 		cli.logger.Infof("%s", j)
+
+		if awsdetail.IsActiveInstanceState(server.State) {
+			resp, err := cli.backend.Compute.Status(server.ID)
+			if err != nil {
+				cli.logger.Warnf("  could not get status: %v", err)
+				continue
+			}
+
+			names := []string{}
+			for _, player := range resp.Players {
+				names = append(names, player.Name)
+			}
+			cli.logger.Infof("  %s, %d players online: %s", resp.Status, len(resp.Players), strings.Join(names, ", "))
+		}
 	}
 
 	return nil
 }
 
 func (cli *CLI) remoteBootstrap(args []string) error {
-	flags := NewSmartFlags(cli.detail, "bootstrap").RequireInstance()
+	flags := NewSmartFlags(cli.detail, cli.backend, "bootstrap").RequireInstance()
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	return awsdetail.BootstrapInstance(cli.detail, flags.InstanceID())
+	detail, id := flags.Instance()
+	return awsdetail.BootstrapInstance(detail, id)
 }
 
 func (cli *CLI) remoteReserve(args []string) error {
-	flags := NewSmartFlags(cli.detail, "reserve").RequireWorld().RequireInstanceType()
+	flags := NewSmartFlags(cli.detail, cli.backend, "reserve").RequireWorld().RequireUpOptions()
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	id, err := awsdetail.ReserveInstance(cli.detail, flags.World(), flags.InstanceType())
+	detail, err := flags.WorldDetail()
 	if err != nil {
 		return err
 	}
 
-	cli.logger.Infof("instance-id: %s", id)
+	id, err := awsdetail.ReserveInstance(detail, flags.World(), flags.UpOptions())
+	if err != nil {
+		return err
+	}
+
+	cli.logger.Infof("instance-id: %s", detail.QualifyID(id))
 
 	return nil
 }
 
 func (cli *CLI) debugClaim(args []string) error {
-	flags := NewSmartFlags(cli.detail, "claim").RequireWorld()
+	flags := NewSmartFlags(cli.detail, cli.backend, "claim").RequireWorld()
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	err := awsdetail.ClaimWorld(cli.detail, flags.World())
+	lease, err := awsdetail.ClaimWorld(cli.detail, flags.World(), functions.LocalOwner())
 	if err != nil {
 		return err
 	}
 
-	cli.logger.Infof("claimed: %s", flags.World())
+	cli.logger.Infof("claimed: %s until %s, token %d", flags.World(), lease.Expires.Format(time.RFC3339), lease.Token)
 	return nil
 }
 
 func (cli *CLI) debugUnclaim(args []string) error {
-	flags := NewSmartFlags(cli.detail, "unclaim").RequireWorld()
+	flags := NewSmartFlags(cli.detail, cli.backend, "unclaim").RequireWorld()
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
@@ -174,7 +406,7 @@ func (cli *CLI) debugUnclaim(args []string) error {
 }
 
 func (cli *CLI) updateDNS(args []string) error {
-	flags := NewSmartFlags(cli.detail, "update-dns").RequireWorld()
+	flags := NewSmartFlags(cli.detail, cli.backend, "update-dns").RequireWorld()
 	ip := flags.flags.String("ip", "", "IP address to point DNS record to")
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
@@ -185,78 +417,398 @@ func (cli *CLI) updateDNS(args []string) error {
 	return awsdetail.UpdateDNS(cli.detail, *ip, minecloud.World(world))
 }
 
+// save forces the server to flush the world to disk.
 func (cli *CLI) save(args []string) error {
-	return errors.New("not implemented")
+	flags := NewSmartFlags(cli.detail, cli.backend, "save").RequireInstance()
+	timeout := flags.flags.Duration("timeout", time.Minute, "time to wait for the game to save")
+	if err := flags.ParseValidate(cli.detail, args); err != nil {
+		return err
+	}
+
+	var err error
+	if *flags.world != "" {
+		err = backend.SaveRunning(cli.backend, minecloud.World(*flags.world), *timeout)
+	} else {
+		err = cli.backend.Compute.Save(flags.InstanceID(), *timeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	cli.logger.Info("saved the game")
+	return nil
 }
 
 func (cli *CLI) remoteDownloadWorld(args []string) error {
-	flags := NewSmartFlags(cli.detail, "download-world").RequireInstance().RequireWorld()
+	flags := NewSmartFlags(cli.detail, cli.backend, "download-world").RequireInstance().RequireWorld()
+	backupID := flags.flags.String("backup", "", "ID of a backup to download instead of the stored world")
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	return awsdetail.DownloadWorld(cli.detail, flags.InstanceID(), flags.World())
+	detail, id := flags.Instance()
+	return awsdetail.DownloadWorldVersion(detail, id, flags.World(), *backupID)
 }
 
 func (cli *CLI) remoteUploadWorld(args []string) error {
-	flags := NewSmartFlags(cli.detail, "upload-world").RequireInstance().RequireWorld()
+	flags := NewSmartFlags(cli.detail, cli.backend, "upload-world").RequireInstance().RequireWorld()
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	return awsdetail.UploadWorld(cli.detail, flags.InstanceID(), flags.World())
+	detail, id := flags.Instance()
+	return awsdetail.UploadWorld(detail, id, flags.World())
 }
 
 func (cli *CLI) remoteStartServer(args []string) error {
-	flags := NewSmartFlags(cli.detail, "status").RequireInstance()
+	flags := NewSmartFlags(cli.detail, cli.backend, "start").RequireInstance().RequireWorld()
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	return awsdetail.StartServerWrapper(cli.detail, flags.InstanceID())
+	detail, id := flags.Instance()
+	return awsdetail.StartServerWrapper(detail, id, flags.World())
 }
 
 func (cli *CLI) remoteStatus(args []string) error {
-	flags := NewSmartFlags(cli.detail, "status").RequireInstance()
+	flags := NewSmartFlags(cli.detail, cli.backend, "status").RequireInstance()
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	resp, err := awsdetail.Status(cli.detail, flags.InstanceID())
+	resp, err := cli.backend.Compute.Status(flags.InstanceID())
 	if err != nil {
 		return err
 	}
 
-	cli.logger.Info(resp.Status)
+	cli.logStatus(resp)
 
 	return nil
 }
 
+// logStatus prints a wrapper status in a human friendly way.
+func (cli *CLI) logStatus(resp serverwrapper.StatusResponse) {
+	cli.logger.Info(resp.Status)
+
+	if resp.Version != "" {
+		cli.logger.Infof("version: %s", resp.Version)
+	}
+	if resp.StartedAt != nil {
+		cli.logger.Infof("uptime: %s", time.Duration(resp.UptimeSeconds)*time.Second)
+	}
+	if resp.TPS != nil {
+		cli.logger.Infof("tps: %.1f", *resp.TPS)
+	}
+	if resp.Memory != nil {
+		cli.logger.Infof("memory: %d/%d MiB", resp.Memory.UsedMiB, resp.Memory.MaxMiB)
+	}
+
+	cli.logger.Infof("players online: %d", len(resp.Players))
+	for _, player := range resp.Players {
+		online := time.Since(player.JoinedAt).Round(time.Second)
+		cli.logger.Infof("  %s (%s) for %s", player.Name, player.UUID, online)
+	}
+}
+
 func (cli *CLI) remoteStopServer(args []string) error {
-	flags := NewSmartFlags(cli.detail, "stop-server").RequireInstance()
+	flags := NewSmartFlags(cli.detail, cli.backend, "stop-server").RequireInstance()
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	return cli.detail.RunOn(flags.InstanceID(), "curl -X POST localhost:8080/stop", awsdetail.RunOpts{})
+	return cli.backend.Compute.Stop(flags.InstanceID())
 }
 
 func (cli *CLI) remoteRmServer(args []string) error {
-	flags := NewSmartFlags(cli.detail, "rm-server").RequireInstance()
+	flags := NewSmartFlags(cli.detail, cli.backend, "rm-server").RequireInstance()
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	return cli.detail.RunOn(flags.InstanceID(), "docker rm -f serverwrapper", awsdetail.RunOpts{})
+	detail, id := flags.Instance()
+	return detail.RunOn(id, "docker rm -f serverwrapper", awsdetail.RunOpts{})
 }
 
 func (cli *CLI) remoteLogs(args []string) error {
-	flags := NewSmartFlags(cli.detail, "logs").RequireInstance()
+	flags := NewSmartFlags(cli.detail, cli.backend, "logs").RequireInstance()
+	follow := flags.flags.Bool("f", false, "follow the log, printing new lines as they are output")
+	grep := flags.flags.String("grep", "", "only show lines matching this regular expression")
+	tail := flags.flags.Int("n", 100, "number of buffered lines to show first, 0 for all")
 	if err := flags.ParseValidate(cli.detail, args); err != nil {
 		return err
 	}
 
-	return cli.detail.RunOn(flags.InstanceID(), "docker logs serverwrapper", awsdetail.RunOpts{})
+	opts := serverwrapper.LogsOptions{
+		Tail:   *tail,
+		Grep:   *grep,
+		Follow: *follow,
+	}
+
+	return cli.backend.Compute.Logs(flags.InstanceID(), opts, func(line serverwrapper.LogLine) {
+		fmt.Println(line.Text)
+	})
+}
+
+// backup manages versioned backups of a world: ls, create, restore or prune.
+func (cli *CLI) backup(args []string) error {
+	if len(args) < 1 {
+		return errors.New("expected backup subcommand: ls, create, restore or prune")
+	}
+
+	subcommand := args[0]
+	flags := NewSmartFlags(cli.detail, cli.backend, "backup "+subcommand).RequireWorld()
+
+	var id *string
+	var hourly, daily, monthly *time.Duration
+	var dryRun *bool
+
+	switch subcommand {
+	case "restore":
+		id = flags.flags.String("id", "", "ID of the backup to restore")
+	case "prune":
+		hourly = flags.flags.Duration("hourly", backups.DefaultPolicy.Hourly, "keep hourly backups for this long")
+		daily = flags.flags.Duration("daily", backups.DefaultPolicy.Daily, "then keep daily backups for this long")
+		monthly = flags.flags.Duration("monthly", backups.DefaultPolicy.Monthly, "then keep monthly backups for this long, 0 for forever")
+		dryRun = flags.flags.Bool("dry-run", false, "only list the backups that would be deleted")
+	}
+
+	if err := flags.ParseValidate(cli.detail, args[1:]); err != nil {
+		return err
+	}
+	world := flags.World()
+
+	detail, err := flags.WorldDetail()
+	if err != nil {
+		return err
+	}
+
+	switch subcommand {
+	case "ls":
+		manifests, err := awsdetail.ListBackups(detail, world)
+		if err != nil {
+			return err
+		}
+		for _, m := range manifests {
+			cli.logBackup(m)
+		}
+		return nil
+
+	case "create":
+		manifest, err := awsdetail.CreateBackup(detail, world)
+		if err != nil {
+			return err
+		}
+		cli.logBackup(manifest)
+		return nil
+
+	case "restore":
+		if *id == "" {
+			return errors.New("-id required")
+		}
+		previous, err := awsdetail.RestoreBackup(detail, world, *id)
+		if err != nil {
+			return err
+		}
+		cli.logger.Infof("restored %s, previous world backed up as %s", *id, previous.ID)
+		return nil
+
+	case "prune":
+		policy := backups.Policy{Hourly: *hourly, Daily: *daily, Monthly: *monthly}
+		removed, err := awsdetail.PruneBackups(detail, world, policy, *dryRun)
+		if err != nil {
+			return err
+		}
+		for _, m := range removed {
+			cli.logBackup(m)
+		}
+		if *dryRun {
+			cli.logger.Infof("would delete %d backups", len(removed))
+		} else {
+			cli.logger.Infof("deleted %d backups", len(removed))
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown backup subcommand: %s", subcommand)
+}
+
+func (cli *CLI) logBackup(m backups.Manifest) {
+	from := "stored world"
+	if m.SourceInstance != "" {
+		from = m.SourceInstance
+	}
+	cli.logger.Infof("%s  %s  %d files, %.1f MiB, from %s", m.ID, m.Time.Local().Format(time.RFC1123), m.Files, float64(m.Size)/(1024*1024), from)
+}
+
+// image manages prebaked server images: ls or bake.
+func (cli *CLI) image(args []string) error {
+	if len(args) < 1 {
+		return errors.New("expected image subcommand: ls or bake")
+	}
+
+	subcommand := args[0]
+	flags := NewSmartFlags(cli.detail, cli.backend, "image "+subcommand)
+	region := flags.flags.String("region", cli.detail.Region(), "region the images are in")
+	if subcommand == "bake" {
+		flags.RequireInstanceType()
+	}
+
+	if err := flags.ParseValidate(cli.detail, args[1:]); err != nil {
+		return err
+	}
+
+	if !cli.detail.Config.HasRegion(*region) {
+		return fmt.Errorf("%w: %s", awsdetail.ErrUnknownRegion, *region)
+	}
+	detail := cli.detail.In(*region)
+
+	switch subcommand {
+	case "ls":
+		images, err := awsdetail.ListImages(detail)
+		if err != nil {
+			return err
+		}
+		for _, image := range images {
+			cli.logImage(image)
+		}
+		return nil
+
+	case "bake":
+		image, err := awsdetail.BakeImage(detail, flags.InstanceType())
+		if err != nil {
+			return err
+		}
+		cli.logImage(image)
+		return nil
+	}
+
+	return fmt.Errorf("unknown image subcommand: %s", subcommand)
+}
+
+func (cli *CLI) logImage(image awsdetail.Image) {
+	cli.logger.Infof("%s  %s  %s  %s", image.ID, image.Name, image.State, image.Created.Local().Format(time.RFC1123))
+}
+
+// region shows the region a world lives in, or moves it to another.
+func (cli *CLI) region(args []string) error {
+	flags := NewSmartFlags(cli.detail, cli.backend, "region").RequireWorld()
+	set := flags.flags.String("set", "", "region to move the world to, one of the configured regions")
+	if err := flags.ParseValidate(cli.detail, args); err != nil {
+		return err
+	}
+
+	if *set != "" {
+		return awsdetail.SetWorldRegion(cli.detail, flags.World(), *set)
+	}
+
+	region, err := awsdetail.WorldRegion(cli.detail, flags.World())
+	if err != nil {
+		return err
+	}
+
+	cli.logger.Info(region)
+	return nil
+}
+
+// config shows the settings in use and where they came from, or sets one in
+// the config file.
+func (cli *CLI) config(args []string) error {
+	if len(args) < 1 {
+		return errors.New("expected config subcommand: show or set")
+	}
+
+	file, err := mcconfig.Read(cli.configPath)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "show":
+		effective := file.FromEnv(os.Getenv).WithDefaults()
+		for _, field := range mcconfig.Fields {
+			value, _ := effective.Get(field.Key)
+			fromFile, _ := file.Get(field.Key)
+
+			source := "default"
+			switch {
+			case os.Getenv(field.Env) != "":
+				source = "$" + field.Env
+			case fromFile != "":
+				source = cli.configPath
+			case value == "":
+				source = "unset"
+			}
+			cli.logger.Infof("%-18s %-30s (%s)", field.Key, value, source)
+		}
+
+		if err := effective.Validate(); err != nil {
+			cli.logger.Warn(err)
+		}
+		return nil
+
+	case "set":
+		if len(args) != 3 {
+			return errors.New("usage: config set <key> <value>")
+		}
+		if err := file.Set(args[1], args[2]); err != nil {
+			return err
+		}
+		return mcconfig.Write(cli.configPath, file)
+	}
+
+	return fmt.Errorf("unknown config subcommand: %s", args[0])
+}
+
+// rcon runs commands on any RCON enabled server, not only ones running our
+// wrapper. With no command given it reads commands from stdin.
+func (cli *CLI) rcon(args []string) error {
+	flags := flag.NewFlagSet("rcon", flag.ExitOnError)
+	world := flags.String("world", "", "name of world, used to work out the address")
+	address := flags.String("address", "", "address of the server, eg example.com:25575")
+	password := flags.String("password", os.Getenv("MINECLOUD_RCON_PASSWORD"), "RCON password, defaults to $MINECLOUD_RCON_PASSWORD")
+	timeout := flags.Duration("timeout", 10*time.Second, "time to wait for a response")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *address == "" {
+		if *world == "" {
+			return errors.New("-address or -world required")
+		}
+		host := *world + "." + strings.TrimSuffix(cli.detail.Config.HostedZoneSuffix, ".")
+		*address = net.JoinHostPort(host, fmt.Sprint(rcon.DefaultPort))
+	}
+
+	client, err := rcon.Dial(*address, *password, *timeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if flags.NArg() > 0 {
+		out, err := client.Command(strings.Join(flags.Args(), " "))
+		if err != nil {
+			return err
+		}
+		fmt.Println(out)
+		return nil
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		cmd := strings.TrimSpace(scanner.Text())
+		if cmd == "" {
+			continue
+		}
+
+		out, err := client.Command(cmd)
+		if err != nil {
+			return err
+		}
+		fmt.Println(out)
+	}
+
+	return scanner.Err()
 }
 
 func main() {
@@ -271,25 +823,54 @@ func main() {
 	}))
 
 	home := os.Getenv("HOME")
+	configPath := mcconfig.DefaultPath(home)
+
+	// The config command is how a missing config gets fixed, and the local
+	// backend doesn't need one.
+	settings, err := mcconfig.Load(configPath)
+	if err != nil && os.Args[1] != "config" && os.Getenv("MINECLOUD_BACKEND") != "local" {
+		logger.Fatalf("%v: set with 'minecloud config set <key> <value>' or the environment", err)
+	}
 
 	config := awsdetail.Config{
-		SSHPrivateKeyFile: path.Join(home, ".minecloud", "MinecraftServerKeyPair.pem"),
+		Config:            settings,
+		SSHPrivateKeyFile: path.Join(home, ".minecloud", settings.KeyPairName+".pem"),
 		SSHKnownHostsPath: path.Join(home, ".ssh/known_hosts"),
-		HostedZoneID:      "Z0259601KLGA9PWJ5S0",
-		HostedZoneSuffix:  "owengage.com.",
 	}
 
 	detail := awsdetail.NewDetail(sess, config)
-	mc := mcaws.NewMinecloudAWS(sess, detail, true)
+	detail.Logger = logger
 
 	cli := CLI{
-		mc:     mc,
-		detail: detail,
-		logger: logger,
+		detail:     detail,
+		logger:     logger,
+		configPath: configPath,
+	}
+
+	// The local backend is for running worlds on this machine, eg a LAN box.
+	// AWS specific plumbing commands still talk to AWS.
+	if os.Getenv("MINECLOUD_BACKEND") == "local" {
+		localDir := os.Getenv("MINECLOUD_LOCAL_DIR")
+		if localDir == "" {
+			localDir = path.Join(home, ".minecloud", "local")
+		}
+
+		local := localdetail.NewDetail(localdetail.Config{
+			Dir:         localDir,
+			WrapperPath: os.Getenv("MINECLOUD_LOCAL_WRAPPER"),
+			Image:       os.Getenv("MINECLOUD_LOCAL_IMAGE"),
+			Host:        os.Getenv("MINECLOUD_LOCAL_HOST"),
+		})
+		local.Logger = logger
+
+		cli.backend = localdetail.NewBackend(local)
+		cli.mc = mclocal.NewMinecloudLocal(cli.backend)
+	} else {
+		cli.backend = awsdetail.NewBackend(detail)
+		cli.mc = mcaws.NewMinecloudAWS(sess, detail, true)
 	}
-	cli.detail.Logger = logger
 
-	err := cli.Exec(os.Args)
+	err = cli.Exec(os.Args)
 	if err != nil {
 		cli.logger.Fatal(err)
 	}
@@ -4,9 +4,10 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"strings"
 
 	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/minecloud"
 )
 
 type SmartFlags struct {
@@ -18,13 +19,17 @@ type SmartFlags struct {
 	world         *string
 	instanceID    *string
 	instanceType  *string
-	server        *awsdetail.MCServer
+	spot          *bool
+	maxPrice      *string
+	backup        *string
+	server        *backend.Server
 	acceptNewHost *bool
 
-	detail *awsdetail.Detail
+	detail  *awsdetail.Detail
+	backend *backend.Backend
 }
 
-func NewSmartFlags(detail *awsdetail.Detail, name string) *SmartFlags {
+func NewSmartFlags(detail *awsdetail.Detail, b *backend.Backend, name string) *SmartFlags {
 	flags := flag.NewFlagSet(name, flag.ExitOnError)
 	acceptNewHost := flags.Bool("accept", false, "accept a new SSH key if found")
 
@@ -32,6 +37,7 @@ func NewSmartFlags(detail *awsdetail.Detail, name string) *SmartFlags {
 		flags:         flags,
 		acceptNewHost: acceptNewHost,
 		detail:        detail,
+		backend:       b,
 	}
 }
 
@@ -42,13 +48,24 @@ func (f *SmartFlags) InstanceID() string {
 	return *f.instanceID
 }
 
-func (f *SmartFlags) Server() *awsdetail.MCServer {
+func (f *SmartFlags) Server() *backend.Server {
 	if f.server == nil {
 		panic("server not found, forgot to parse flags?")
 	}
 	return f.server
 }
 
+// Instance is the plain instance ID and the detail for its region, for AWS
+// plumbing commands. InstanceID may be qualified with a region.
+func (f *SmartFlags) Instance() (*awsdetail.Detail, string) {
+	return f.detail.ResolveID(f.InstanceID())
+}
+
+// WorldDetail is the detail for the region the world lives in.
+func (f *SmartFlags) WorldDetail() (*awsdetail.Detail, error) {
+	return f.detail.ForWorld(f.World())
+}
+
 func (f *SmartFlags) World() string {
 	if *f.world == "" {
 		panic("world not found, forgot to parse flags?")
@@ -66,12 +83,23 @@ func (f *SmartFlags) InstanceType() *string {
 	return nil
 }
 
+// UpOptions for bringing up the world, from -instance-type, -spot,
+// -max-price and -backup.
+func (f *SmartFlags) UpOptions() minecloud.UpOptions {
+	return minecloud.UpOptions{
+		InstanceType: f.InstanceType(),
+		Spot:         *f.spot,
+		MaxPrice:     *f.maxPrice,
+		Backup:       *f.backup,
+	}
+}
+
 func (f *SmartFlags) RequireInstance() *SmartFlags {
 	if f.world == nil {
 		f.world = f.flags.String("world", "", "name of world")
 	}
 
-	f.instanceID = f.flags.String("instance-id", "", "instance ID of EC2 instance")
+	f.instanceID = f.flags.String("instance-id", "", "instance ID of server")
 	f.instanceRequired = true
 	return f
 }
@@ -91,6 +119,15 @@ func (f *SmartFlags) RequireInstanceType() *SmartFlags {
 	return f
 }
 
+// RequireUpOptions adds the flags for UpOptions.
+func (f *SmartFlags) RequireUpOptions() *SmartFlags {
+	f.RequireInstanceType()
+	f.spot = f.flags.Bool("spot", false, "run on spot capacity, which is cheaper but can be reclaimed at two minutes notice")
+	f.maxPrice = f.flags.String("max-price", "", "most to pay per hour for spot capacity in USD, defaults to the on-demand price")
+	f.backup = f.flags.String("backup", "", "ID of a backup to run instead of the stored world, see 'backup ls'. The stored world is replaced by it on down")
+	return f
+}
+
 func (f *SmartFlags) ParseValidate(detail *awsdetail.Detail, args []string) error {
 	f.flags.Parse(args)
 
@@ -100,11 +137,11 @@ func (f *SmartFlags) ParseValidate(detail *awsdetail.Detail, args []string) erro
 		}
 
 		if *f.instanceID == "" {
-			server, err := awsdetail.FindRunning(detail.EC2, *f.world)
+			server, err := f.backend.Compute.Find(minecloud.World(*f.world))
 			if err != nil {
 				return err
 			}
-			*f.instanceID = server.InstanceID
+			*f.instanceID = server.ID
 			f.server = &server
 
 			if err := validateInstanceID(*f.instanceID); err != nil {
@@ -119,6 +156,10 @@ func (f *SmartFlags) ParseValidate(detail *awsdetail.Detail, args []string) erro
 		}
 	}
 
+	if f.spot != nil && !*f.spot && *f.maxPrice != "" {
+		return errors.New("-max-price only applies with -spot")
+	}
+
 	if *f.acceptNewHost {
 		f.detail.Config.SSHDefaultNewKeyBehaviour = awsdetail.SSHNewKeyAccept
 	}
@@ -130,8 +171,5 @@ func validateInstanceID(id string) error {
 	if id == "" {
 		return fmt.Errorf("require -instance-id")
 	}
-	if !strings.HasPrefix(id, "i-") {
-		return errors.New("Instance IDs start with 'i-'")
-	}
 	return nil
 }
@@ -0,0 +1,25 @@
+package main
+
+// CommandTask runs a console command, see Wrapper.Command. Being a task, it
+// can't land in the middle of another, eg turning saving back on while a
+// SaveOffTask has it off.
+type CommandTask struct {
+	wrapper *Wrapper
+	cmd     string
+	result  chan commandResult
+}
+
+type commandResult struct {
+	output string
+	err    error
+}
+
+func (t *CommandTask) Init() TaskStep {
+	output, err := t.wrapper.command(t.cmd)
+	t.result <- commandResult{output: output, err: err}
+	return TaskDone
+}
+
+func (t *CommandTask) OnOutput(out string) TaskStep {
+	return TaskDone
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os/exec"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/functions"
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+)
+
+// idleCheckInterval is how often the player count is checked.
+const idleCheckInterval = 15 * time.Second
+
+// watchIdle calls shutdown once the server has had no players for the timer's
+// timeout. The countdown then starts again, so if the server is somehow
+// still running another timeout later, shutdown is tried again.
+func watchIdle(ctx context.Context, wrapper *Wrapper, timer *serverwrapper.IdleTimer, shutdown func() error) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-wrapper.done:
+			return
+		case now := <-ticker.C:
+			players := wrapper.tracker.PlayerCount()
+
+			switch timer.Update(now, wrapper.tracker.StartedAt(), players) {
+			case serverwrapper.IdleCountdownStarted:
+				log.Printf("no players online, shutting down in %s", timer.Timeout)
+			case serverwrapper.IdleCountdownCancelled:
+				log.Printf("idle shutdown cancelled, %d players online", players)
+			case serverwrapper.IdleExpired:
+				log.Println("idle timeout reached, shutting down")
+
+				if err := shutdown(); err != nil {
+					log.Println("idle shutdown failed:", err)
+				}
+				timer.Restart(now)
+			}
+		}
+	}
+}
+
+// lambdaCommand sends the Minecloud singleton lambda a command for the world.
+// A "down" saves, uploads and unclaims the world before terminating the
+// instance. The lambda is in the main region, which may not be the instance's.
+// The lease token is sent if there is one, so a server that has lost its
+// lease can't renew, release or take it down. The lambda is waited for, so
+// a command it rejects is returned as an error.
+func lambdaCommand(functionName, region, command, world string, token int64) func() error {
+	return func() error {
+		config := &aws.Config{}
+		if region != "" {
+			config.Region = aws.String(region)
+		}
+
+		sess, err := session.NewSession(config)
+		if err != nil {
+			return err
+		}
+
+		event := functions.Event{
+			Command: &command,
+			World:   &world,
+		}
+		if token != 0 {
+			event.Token = &token
+		}
+
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		invoker := &awsdetail.LambdaInvoker{LS: lambda.New(sess), Wait: true}
+		return invoker.Invoke(functionName, payload)
+	}
+}
+
+// commandShutdown runs a shell command to take the world down, eg a local
+// `minecloud down`.
+func commandShutdown(command string) func() error {
+	return func() error {
+		out, err := exec.Command("sh", "-c", command).CombinedOutput()
+		log.Printf("idle command output: %s", out)
+		return err
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/backend"
+)
+
+// leaseRenewInterval renews often enough that a few failed renewals in a
+// row don't let the lease expire.
+var leaseRenewInterval = backend.LeaseDuration / 5
+
+// renewLease renews the world's lease until the wrapper stops. A lost lease
+// is logged, and shows up in the lambda's logs too.
+func renewLease(ctx context.Context, wrapper *Wrapper, renew func() error) {
+	ticker := time.NewTicker(leaseRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-wrapper.done:
+			return
+		case <-ticker.C:
+			if err := renew(); err != nil {
+				log.Println("failed to renew lease:", err)
+			}
+		}
+	}
+}
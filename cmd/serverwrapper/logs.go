@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+)
+
+// logBufferSize is how many lines of console output the wrapper keeps.
+const logBufferSize = 10000
+
+// serveLogs writes buffered console lines as newline delimited JSON. Query
+// parameters:
+//
+//	since   only lines with a greater sequence number
+//	tail    only the last n of the buffered lines
+//	grep    only lines matching this regular expression
+//	follow  if true keep the response open, writing new lines as they arrive
+func serveLogs(w http.ResponseWriter, r *http.Request, buf *serverwrapper.LogBuffer) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	var since uint64
+	var tail int
+	var filter *regexp.Regexp
+	var err error
+
+	if s := query.Get("since"); s != "" {
+		since, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "bad since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if s := query.Get("tail"); s != "" {
+		tail, err = strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "bad tail: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if s := query.Get("grep"); s != "" {
+		filter, err = regexp.Compile(s)
+		if err != nil {
+			http.Error(w, "bad grep: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	follow := query.Get("follow") == "true"
+	flusher, _ := w.(http.Flusher)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	// Following only looks at lines after the last one looked at, matched
+	// or not, so a busy log isn't searched again for every new line.
+	enc := json.NewEncoder(w)
+	lines, since, changed := buf.Follow(since, filter)
+
+	if tail > 0 && len(lines) > tail {
+		lines = lines[len(lines)-tail:]
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		for _, line := range lines {
+			if err := enc.Encode(line); err != nil {
+				return
+			}
+		}
+
+		if !follow {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			// Blank lines are ignored by clients.
+			if _, err := w.Write([]byte("\n")); err != nil {
+				return
+			}
+			lines = nil
+		case <-changed:
+			lines, since, changed = buf.Follow(since, filter)
+		}
+	}
+}
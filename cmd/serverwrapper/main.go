@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
 
 	"github.com/owengage/minecloud/pkg/serverwrapper"
 )
@@ -24,10 +28,9 @@ Example of leaving
 
 */
 
-// CommandRequest represents HTTP request to perform a command.
-type CommandRequest struct {
-	Command string `json:"command"`
-}
+// defaultSaveTimeout is how long /save waits for the game to save if the
+// request doesn't say.
+const defaultSaveTimeout = time.Minute
 
 // MaybeErrResponse returned from requests.
 type MaybeErrResponse struct {
@@ -39,20 +42,64 @@ func main() {
 	serverJar := flag.String("jar", "", "Minecraft server JAR file")
 	worldDir := flag.String("world-dir", "", "Directory containing world files")
 	serverDir := flag.String("server-dir", "", "Directory containing server files")
-	snapshotDir := flag.String("snapshot-dir", "", "Path to write world snapshot to")
+	snapshotPath := flag.String("snapshot-path", "", "file to write snapshots to, instead of uploading them to -bucket")
+	bucket := flag.String("bucket", "", "S3 bucket the world is stored in, for uploads and snapshots")
 	jvmMem := flag.String("server-memory", "", "amount of memory to run server with, defaults to 80% of available. eg 10G")
+	worldName := flag.String("world-name", "", "name of the world, used to shut it down when idle")
+	idleTimeout := flag.Duration("idle-timeout", 0, "shut down once no players have been online for this long, 0 to disable")
+	idleGrace := flag.Duration("idle-grace", 15*time.Minute, "time after starting before the idle countdown can begin")
+	idleLambda := flag.String("idle-lambda", "MinecloudSingleton", "lambda to invoke with a down event when idle, or a release event when interrupted")
+	idleLambdaRegion := flag.String("idle-lambda-region", "", "region of -idle-lambda, defaults to $AWS_REGION")
+	idleCommand := flag.String("idle-command", "", "shell command to run when idle, instead of invoking -idle-lambda")
+	spot := flag.Bool("spot", false, "watch for a spot interruption notice, then save and upload the world and release its claim")
+	leaseToken := flag.Int64("lease-token", 0, "token of the world's lease, renewed via -idle-lambda while running and sent with idle downs, 0 to not renew")
+	metadataURL := flag.String("metadata-url", serverwrapper.MetadataURL, "instance metadata service to get spot interruption notices from")
+	rconAddress := flag.String("rcon-address", "", "RCON address to send commands to, defaults to the settings in server.properties")
+	rconPassword := flag.String("rcon-password", "", "RCON password, used with -rcon-address")
+	tlsAddress := flag.String("tls-address", "", "address to serve the API over HTTPS on, requiring -token-file")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate for -tls-address")
+	tlsKey := flag.String("tls-key", "", "PEM private key for -tls-address")
+	tokenFile := flag.String("token-file", "", "file containing the bearer token HTTPS requests must have")
 	flag.Parse()
 
 	wrapper := NewWrapper(WrapperOpts{
-		Jar:       *serverJar,
-		WorldDir:  *worldDir,
-		ServerDir: *serverDir,
-		JVMMemory: *jvmMem,
+		Jar:          *serverJar,
+		WorldDir:     *worldDir,
+		ServerDir:    *serverDir,
+		JVMMemory:    *jvmMem,
+		RCONAddress:  *rconAddress,
+		RCONPassword: *rconPassword,
 	})
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go wrapper.Run(ctx)
 
+	if *idleTimeout > 0 {
+		var shutdown func() error
+		if *idleCommand != "" {
+			shutdown = commandShutdown(*idleCommand)
+		} else if *worldName != "" {
+			shutdown = lambdaCommand(*idleLambda, *idleLambdaRegion, "down", *worldName, *leaseToken)
+		} else {
+			log.Fatal("-idle-timeout requires -world-name or -idle-command")
+		}
+
+		timer := &serverwrapper.IdleTimer{
+			Timeout: *idleTimeout,
+			Grace:   *idleGrace,
+		}
+		go watchIdle(ctx, wrapper, timer, shutdown)
+	}
+
+	if *leaseToken != 0 {
+		if *worldName == "" {
+			log.Fatal("-lease-token requires -world-name")
+		}
+
+		renew := lambdaCommand(*idleLambda, *idleLambdaRegion, "renew", *worldName, *leaseToken)
+		go renewLease(ctx, wrapper, renew)
+	}
+
 	http.HandleFunc("/command", func(w http.ResponseWriter, r *http.Request) {
 
 		if r.Method != http.MethodPost {
@@ -60,25 +107,27 @@ func main() {
 			return
 		}
 
-		var req CommandRequest
+		var req serverwrapper.CommandRequest
 		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		err = wrapper.Send(req.Command)
+		var resp serverwrapper.CommandResponse
+		resp.Output, err = wrapper.Command(r.Context(), req.Command)
+		if err != nil {
+			resp.Error = err.Error()
+		}
 
 		w.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(w).Encode(MaybeErrResponse{Error: err})
+		_ = json.NewEncoder(w).Encode(resp)
 
 	})
 
 	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
 
-		response := serverwrapper.StatusResponse{}
-
-		response.Status = string(wrapper.Status())
+		response := wrapper.StatusResponse()
 
 		enc := json.NewEncoder(w)
 		err := enc.Encode(response)
@@ -87,25 +136,68 @@ func main() {
 		}
 	})
 
-	http.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		serveEvents(w, r, wrapper.events)
+	})
+
+	http.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
+		serveLogs(w, r, wrapper.logs)
+	})
+
+	http.Handle("/snapshot", &Snapshotter{
+		wrapper:  wrapper,
+		worldDir: *worldDir,
+		path:     *snapshotPath,
+		bucket:   *bucket,
+		world:    *worldName,
+	})
+
+	uploader := &Uploader{
+		wrapper:   wrapper,
+		worldDir:  *worldDir,
+		serverDir: *serverDir,
+		bucket:    *bucket,
+		world:     *worldName,
+	}
+	http.Handle("/upload", uploader)
+
+	if *spot {
+		if *worldName == "" {
+			log.Fatal("-spot requires -world-name")
+		}
+
+		// Without a lease the world isn't claimed, so there's nothing to release.
+		release := func() error { return nil }
+		if *leaseToken != 0 {
+			release = lambdaCommand(*idleLambda, *idleLambdaRegion, "release", *worldName, *leaseToken)
+		}
+		go watchInterruption(ctx, wrapper, *metadataURL, uploader, release)
+	}
+
+	http.HandleFunc("/save", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
 
-		result := make(chan error)
-		saveTask := &SnapshotTask{
-			wrapper:     wrapper,
-			snapshotDir: *snapshotDir,
-			worldDir:    *worldDir,
-			result:      result,
+		timeout := defaultSaveTimeout
+		if s := r.URL.Query().Get("timeout"); s != "" {
+			var err error
+			timeout, err = time.ParseDuration(s)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
 		}
 
-		wrapper.Execute(saveTask)
-		err := <-result
+		var resp serverwrapper.SaveResponse
+		err := wrapper.Save(timeout)
+		if err != nil {
+			resp.Error = err.Error()
+		}
 
 		w.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(w).Encode(MaybeErrResponse{Error: err})
+		_ = json.NewEncoder(w).Encode(resp)
 	})
 
 	http.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
@@ -130,6 +222,27 @@ func main() {
 	server := &http.Server{Addr: *address, Handler: nil}
 	go server.ListenAndServe()
 
+	// The plain API is only meant to be reachable from the instance itself.
+	// Anything remote uses HTTPS with the token.
+	var tlsServer *http.Server
+	if *tlsAddress != "" {
+		token, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			log.Fatal("-tls-address needs a readable -token-file: ", err)
+		}
+
+		tlsServer = &http.Server{
+			Addr:    *tlsAddress,
+			Handler: serverwrapper.RequireToken(strings.TrimSpace(string(token)), http.DefaultServeMux),
+		}
+		go func() {
+			err := tlsServer.ListenAndServeTLS(*tlsCert, *tlsKey)
+			if err != http.ErrServerClosed {
+				log.Println("https api failed:", err)
+			}
+		}()
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 
@@ -140,5 +253,8 @@ func main() {
 
 	cancel()
 	server.Close()
+	if tlsServer != nil {
+		tlsServer.Close()
+	}
 	wrapper.Stop()
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errSaveOffNotStarted is returned when saving wasn't turned off in time for
+// the work to run. Saving is left on, and the work never runs.
+var errSaveOffNotStarted = errors.New("saving wasn't turned off in time")
+
+// SaveOffTask runs work against the world files while the server is running.
+// Saving is turned off and the world flushed first so the files are
+// consistent, and saving is always turned back on afterwards. Being a task, no
+// other task can interleave with it.
+type SaveOffTask struct {
+	wrapper *Wrapper
+	work    func() error
+	result  chan error
+
+	// done gets the result of work, sent before saving is turned back on.
+	done chan error
+
+	// Whoever is waiting can give up until work starts, see abandon.
+	mu        sync.Mutex
+	started   bool
+	abandoned bool
+}
+
+// abandon the task if work hasn't started, returning whether it was. Work
+// that has started can't be called back, so it has to be waited for.
+func (t *SaveOffTask) abandon() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.started {
+		return false
+	}
+	t.abandoned = true
+	return true
+}
+
+// start work, unless the task has been abandoned.
+func (t *SaveOffTask) start() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.abandoned {
+		return false
+	}
+	t.started = true
+	return true
+}
+
+func (t *SaveOffTask) Init() TaskStep {
+	t.done = make(chan error, 1)
+
+	t.mu.Lock()
+	abandoned := t.abandoned
+	t.mu.Unlock()
+	if abandoned {
+		t.result <- errSaveOffNotStarted
+		return TaskDone
+	}
+
+	err := t.wrapper.Send("save-off")
+	if err != nil {
+		t.result <- fmt.Errorf("save-off failed: %w", err)
+		return TaskDone
+	}
+
+	err = t.wrapper.Send("save-all flush")
+	if err != nil {
+		t.result <- fmt.Errorf("save-all failed: %w", err)
+		return TaskDone
+	}
+
+	return TaskContinue
+}
+
+func (t *SaveOffTask) OnOutput(out string) TaskStep {
+	if strings.Contains(out, "[Server thread/INFO]: Saved the game") {
+		// Work in the background so console output keeps being handled
+		// while a large world is compressed or uploaded.
+		go func() {
+			if t.start() {
+				t.done <- t.work()
+			} else {
+				t.done <- errSaveOffNotStarted
+			}
+
+			// If this fails the server has gone away, and OnTerminate
+			// reports it.
+			_ = t.wrapper.Send("save-on")
+		}()
+
+		return TaskContinue
+	}
+
+	if strings.Contains(out, "[Server thread/INFO]: Automatic saving is now enabled") {
+		select {
+		case err := <-t.done:
+			t.result <- err
+			return TaskDone
+		default:
+			// Someone else turned saving on, keep waiting for the work.
+			return TaskContinue
+		}
+	}
+
+	return TaskContinue
+}
+
+func (t *SaveOffTask) OnTerminate() {
+	select {
+	case err := <-t.done:
+		if err == nil {
+			err = errors.New("server stopped before saving was turned back on")
+		}
+		t.result <- err
+	default:
+		t.result <- errors.New("server stopped while saving was off")
+	}
+}
+
+// RunWithSaveOff runs work as a SaveOffTask, blocking until it is done or ctx
+// is. If saving hasn't been turned off within startTimeout, 0 meaning as long
+// as ctx allows, work doesn't run and errSaveOffNotStarted is returned.
+// Otherwise work is expected to honour ctx itself.
+func (wrapper *Wrapper) RunWithSaveOff(ctx context.Context, startTimeout time.Duration, work func() error) error {
+	start := ctx
+	if startTimeout > 0 {
+		var cancel context.CancelFunc
+		start, cancel = context.WithTimeout(ctx, startTimeout)
+		defer cancel()
+	}
+
+	// Buffered, as nobody may be left to receive the result.
+	result := make(chan error, 1)
+	task := &SaveOffTask{
+		wrapper: wrapper,
+		work:    work,
+		result:  result,
+	}
+
+	select {
+	case wrapper.tasks <- task:
+	case <-start.Done():
+		return fmt.Errorf("%w: %v", errSaveOffNotStarted, start.Err())
+	}
+
+	starting := start.Done()
+	for {
+		select {
+		case err := <-result:
+			return err
+		case <-starting:
+			if task.abandon() {
+				return fmt.Errorf("%w: %v", errSaveOffNotStarted, start.Err())
+			}
+			// Work is under way, only ctx can stop waiting for it now.
+			starting = nil
+		case <-ctx.Done():
+			if task.abandon() {
+				return fmt.Errorf("%w: %v", errSaveOffNotStarted, ctx.Err())
+			}
+			return ctx.Err()
+		}
+	}
+}
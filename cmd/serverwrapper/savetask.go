@@ -1,14 +1,23 @@
 package main
 
-import "strings"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+)
+
+// SaveTask flushes the world to disk, finishing once the server reports the
+// game saved.
 type SaveTask struct {
 	wrapper *Wrapper
 	result  chan error
 }
 
 func (t *SaveTask) Init() TaskStep {
-	err := t.wrapper.Send("save-all")
+	err := t.wrapper.Send("save-all flush")
 	if err != nil {
 		t.result <- err
 		return TaskDone
@@ -23,3 +32,34 @@ func (t *SaveTask) OnOutput(out string) TaskStep {
 	}
 	return TaskContinue
 }
+
+func (t *SaveTask) OnTerminate() {
+	t.result <- errors.New("server stopped before the game was saved")
+}
+
+// Save runs a SaveTask, blocking until the game is saved or timeout passes.
+// The timeout includes waiting for any task already running.
+func (wrapper *Wrapper) Save(timeout time.Duration) error {
+	if status := wrapper.Status(); status != serverwrapper.StatusRunning {
+		return fmt.Errorf("server is %s", status)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// Buffered so the task never blocks the wrapper if we've given up.
+	result := make(chan error, 1)
+
+	select {
+	case wrapper.tasks <- &SaveTask{wrapper: wrapper, result: result}:
+	case <-timer.C:
+		return fmt.Errorf("timed out after %s waiting for another task to finish", timeout)
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("timed out after %s waiting for the game to save", timeout)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+)
+
+// Snapshotter takes consistent archives of the world while it is running.
+type Snapshotter struct {
+	wrapper  *Wrapper
+	worldDir string
+
+	// Where POSTed snapshots go. A local path takes precedence over S3.
+	path   string
+	bucket string
+	world  string
+}
+
+// Snapshot streams an archive of the world to out.
+func (s *Snapshotter) Snapshot(out io.Writer) error {
+	return s.wrapper.RunWithSaveOff(context.Background(), 0, func() error {
+		return serverwrapper.WriteArchive(out, s.worldDir)
+	})
+}
+
+// Store a snapshot, returning where it was stored.
+func (s *Snapshotter) Store() (string, error) {
+	if s.path != "" {
+		return s.path, s.storeFile()
+	}
+
+	if s.bucket == "" || s.world == "" {
+		return "", errors.New("no snapshot destination, need -snapshot-path or -bucket and -world-name")
+	}
+
+	key := awsdetail.SnapshotKey(s.world, time.Now())
+	return fmt.Sprintf("s3://%s/%s", s.bucket, key), s.storeS3(key)
+}
+
+func (s *Snapshotter) storeFile() error {
+	f, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+
+	err = s.Snapshot(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// storeS3 uploads the archive as it is written, so the world is never copied
+// in full on disk.
+func (s *Snapshotter) storeS3(key string) error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	uploaded := make(chan error, 1)
+
+	go func() {
+		_, err := s3manager.NewUploader(sess).Upload(&s3manager.UploadInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+			Body:   reader,
+		})
+
+		// Unblock the archive if the upload gave up early.
+		reader.CloseWithError(err)
+		uploaded <- err
+	}()
+
+	err = s.Snapshot(writer)
+	writer.CloseWithError(err)
+
+	uploadErr := <-uploaded
+	if err != nil {
+		return err
+	}
+	return uploadErr
+}
+
+// ServeHTTP downloads a snapshot with GET, or stores one with POST.
+func (s *Snapshotter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar.gz"`, s.filename()))
+
+		// Too late to change the status if this fails, but the archive
+		// will be truncated so the client sees a broken gzip stream.
+		err := s.Snapshot(w)
+		if err != nil {
+			log.Println("snapshot download failed:", err)
+		}
+
+	case http.MethodPost:
+		var resp serverwrapper.SnapshotResponse
+		location, err := s.Store()
+		if err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.Location = location
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Snapshotter) filename() string {
+	name := s.world
+	if name == "" {
+		name = "world"
+	}
+	return name + "-" + time.Now().UTC().Format(awsdetail.SnapshotTimeFormat)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+)
+
+// interruptionCheckInterval is how often the metadata service is asked for an
+// interruption notice. AWS suggests every five seconds.
+const interruptionCheckInterval = 5 * time.Second
+
+// interruptionDeadline bounds handling an interruption, leaving some of the
+// two minute notice for releasing the claim and slack for the notice being
+// noticed late.
+const interruptionDeadline = 90 * time.Second
+
+// interruptionSaveTimeout leaves most of the notice for uploading. It bounds
+// the save and separately waiting for saving to be turned off to upload.
+const interruptionSaveTimeout = 20 * time.Second
+
+// sayTimeout is how long warning players can hold up an interruption, if
+// another task has the server.
+const sayTimeout = 5 * time.Second
+
+// watchInterruption waits for notice that the spot instance is being
+// reclaimed, then saves the world and hands it back.
+func watchInterruption(ctx context.Context, wrapper *Wrapper, metadataURL string, uploader *Uploader, release func() error) {
+	client := &http.Client{Timeout: 2 * time.Second}
+	ticker := time.NewTicker(interruptionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-wrapper.done:
+			return
+		case <-ticker.C:
+			notice, err := serverwrapper.CheckInterruption(client, metadataURL)
+			if err != nil {
+				log.Println("checking for interruption:", err)
+				continue
+			}
+			if notice == nil {
+				continue
+			}
+
+			log.Printf("spot interruption notice, %s at %s", notice.Action, notice.Time)
+			handleInterruption(wrapper, uploader, release)
+			return
+		}
+	}
+}
+
+// handleInterruption warns players, saves and uploads the world and server
+// files like a normal down, then releases its claim so it can be brought up
+// elsewhere. If the world upload fails the claim is kept, so the world isn't
+// brought up from an old copy. Everything but the release is given up on by
+// interruptionDeadline.
+func handleInterruption(wrapper *Wrapper, uploader *Uploader, release func() error) {
+	ctx, cancel := context.WithTimeout(context.Background(), interruptionDeadline)
+	defer cancel()
+
+	say(ctx, wrapper, "This server is being reclaimed and will shut down in under two minutes. Saving the world now.")
+
+	err := wrapper.Save(interruptionSaveTimeout)
+	if err != nil {
+		// The upload saves again, so it's still worth trying.
+		log.Println("interruption save failed:", err)
+	}
+
+	stats, err := uploader.Upload(ctx, uploader.world, interruptionSaveTimeout)
+	if errors.Is(err, errSaveOffNotStarted) {
+		// Something else has the server, eg another upload. A copy that
+		// may be torn beats losing everything since the last one.
+		log.Println("interruption upload couldn't turn saving off, uploading anyway:", err)
+		stats, err = uploader.UploadSavingOn(ctx, uploader.world)
+	}
+	if err != nil {
+		log.Println("interruption upload failed, keeping claim:", err)
+		say(ctx, wrapper, "Saving the world failed, recent progress may be lost.")
+		return
+	}
+	log.Println("uploaded world:", stats)
+
+	// A normal down carries on if server files fail to upload, so this does
+	// too, rather than holding the world back.
+	sent, err := uploader.UploadServer(ctx)
+	if err != nil {
+		log.Println("interruption server upload failed:", err)
+	} else {
+		log.Printf("uploaded %d server files", sent)
+	}
+	say(ctx, wrapper, "World saved. Anything done from now on will be lost.")
+
+	err = release()
+	if err != nil {
+		log.Println("failed to release claim:", err)
+		return
+	}
+	log.Println("released claim")
+}
+
+func say(ctx context.Context, wrapper *Wrapper, message string) {
+	ctx, cancel := context.WithTimeout(ctx, sayTimeout)
+	defer cancel()
+
+	_, err := wrapper.Command(ctx, "say "+message)
+	if err != nil {
+		log.Println("failed to warn players:", err)
+	}
+}
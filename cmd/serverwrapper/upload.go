@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+	"github.com/owengage/minecloud/pkg/worldsync"
+)
+
+// Uploader copies the world back to S3 while the server is running.
+type Uploader struct {
+	wrapper   *Wrapper
+	worldDir  string
+	serverDir string
+	bucket    string
+	world     string
+}
+
+// serverUploadExcludes are server directories left out of uploads, as they
+// are by awsdetail.UploadScript.
+var serverUploadExcludes = []string{"logs", ".fabric", ".mixin.out"}
+
+// Upload the world, with saving off for the duration. Only files that have
+// changed since the last upload are sent. See RunWithSaveOff for how ctx and
+// saveOffTimeout bound it.
+func (u *Uploader) Upload(ctx context.Context, world string, saveOffTimeout time.Duration) (worldsync.Stats, error) {
+	store, err := u.worldStore(ctx, world)
+	if err != nil {
+		return worldsync.Stats{}, err
+	}
+
+	var stats worldsync.Stats
+	err = u.wrapper.RunWithSaveOff(ctx, saveOffTimeout, func() error {
+		var err error
+		stats, err = worldsync.Upload(u.worldDir, store)
+		return err
+	})
+	if err != nil {
+		// Work given up on may still be writing stats.
+		return worldsync.Stats{}, err
+	}
+	return stats, nil
+}
+
+// UploadSavingOn uploads the world without turning saving off, for when the
+// server is too busy to. Files being saved as they're read may be torn, so
+// this is only worth it if the alternative is losing the world's progress.
+func (u *Uploader) UploadSavingOn(ctx context.Context, world string) (worldsync.Stats, error) {
+	store, err := u.worldStore(ctx, world)
+	if err != nil {
+		return worldsync.Stats{}, err
+	}
+	return worldsync.Upload(u.worldDir, store)
+}
+
+func (u *Uploader) worldStore(ctx context.Context, world string) (*worldsync.S3Store, error) {
+	if u.bucket == "" || u.world == "" {
+		return nil, fmt.Errorf("no upload destination, need -bucket and -world-name")
+	}
+
+	// Guard against overwriting some other world in the bucket.
+	if world != u.world {
+		return nil, fmt.Errorf("running world is '%s', refusing to upload it as '%s'", u.world, world)
+	}
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return &worldsync.S3Store{
+		S3:      s3.New(sess),
+		Bucket:  u.bucket,
+		Prefix:  awsdetail.S3WorldPrefix(u.world),
+		Context: ctx,
+	}, nil
+}
+
+// UploadServer copies the server files to S3, as a normal down does. Like the
+// down, files that can't be read are skipped. It returns how many were sent.
+func (u *Uploader) UploadServer(ctx context.Context) (int, error) {
+	if u.bucket == "" || u.world == "" || u.serverDir == "" {
+		return 0, fmt.Errorf("no upload destination, need -bucket, -world-name and -server-dir")
+	}
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return 0, err
+	}
+
+	store := &worldsync.S3Store{
+		S3:      s3.New(sess),
+		Bucket:  u.bucket,
+		Prefix:  awsdetail.S3ServerPrefix(u.world),
+		Context: ctx,
+	}
+
+	sent := 0
+	err = filepath.Walk(u.serverDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(u.serverDir, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			for _, exclude := range serverUploadExcludes {
+				if rel == exclude {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			log.Println("skipping server file:", err)
+			return nil
+		}
+		defer f.Close()
+
+		err = store.Put(filepath.ToSlash(rel), f)
+		if err != nil {
+			return err
+		}
+		sent++
+		return nil
+	})
+	return sent, err
+}
+
+func (u *Uploader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req serverwrapper.UploadRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var resp serverwrapper.UploadResponse
+	stats, err := u.Upload(context.Background(), req.World, 0)
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		log.Println("uploaded world:", stats)
+		resp.Sync = &stats
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -10,10 +11,16 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/owengage/minecloud/pkg/rcon"
 	"github.com/owengage/minecloud/pkg/serverwrapper"
 )
 
+// rconTimeout is how long to wait for the server to respond to a command.
+const rconTimeout = 10 * time.Second
+
 // Wrapper is a Minecraft server.
 type Wrapper struct {
 	output        chan string
@@ -29,7 +36,19 @@ type Wrapper struct {
 	finishedStarting bool
 	stopRequested    bool
 
-	tasks chan Task
+	tasks   chan Task
+	tracker *serverwrapper.Tracker
+	events  *serverwrapper.EventBus
+	logs    *serverwrapper.LogBuffer
+
+	procMu    sync.Mutex
+	javaPID   int
+	jvmMaxMiB int
+
+	rconAddress  string
+	rconPassword string
+	rconMu       sync.Mutex
+	rcon         *rcon.Client
 }
 
 // WrapperOpts are the options for creating a server.
@@ -38,6 +57,11 @@ type WrapperOpts struct {
 	WorldDir  string
 	ServerDir string
 	JVMMemory string // leave blank for auto. Same format as JVM option.
+
+	// RCON to send commands over. Leave blank to use the settings in the
+	// server's server.properties, if it is enabled there.
+	RCONAddress  string
+	RCONPassword string
 }
 
 // NewWrapper prepares a new Minecraft server for launch.
@@ -48,6 +72,14 @@ func NewWrapper(opts WrapperOpts) *Wrapper {
 
 	done := make(chan struct{}, 0)
 
+	rconAddress, rconPassword := opts.RCONAddress, opts.RCONPassword
+	if rconAddress == "" {
+		props, err := serverwrapper.ReadProperties(filepath.Join(opts.ServerDir, "server.properties"))
+		if err == nil {
+			rconAddress, rconPassword, _ = serverwrapper.RCONSettings(props)
+		}
+	}
+
 	return &Wrapper{
 		output:           out,
 		input:            in,
@@ -59,6 +91,11 @@ func NewWrapper(opts WrapperOpts) *Wrapper {
 		jvmMemory:        opts.JVMMemory,
 		finishedStarting: false,
 		tasks:            make(chan Task),
+		tracker:          serverwrapper.NewTracker(),
+		events:           serverwrapper.NewEventBus(),
+		logs:             serverwrapper.NewLogBuffer(logBufferSize),
+		rconAddress:      rconAddress,
+		rconPassword:     rconPassword,
 	}
 }
 
@@ -72,6 +109,59 @@ func (wrapper *Wrapper) Send(cmd string) error {
 	return <-wrapper.inputResponse
 }
 
+// Command runs a console command, eg Command(ctx, "list"). If RCON is
+// available the command is sent over it and its output returned. Otherwise it
+// is written to the server's stdin and there is no output. Once the server is
+// running the command is a CommandTask, so it waits for any task already
+// running, or until ctx is done.
+func (wrapper *Wrapper) Command(ctx context.Context, cmd string) (string, error) {
+	if wrapper.Status() != serverwrapper.StatusRunning {
+		return "", wrapper.Send(cmd)
+	}
+
+	// Buffered so the task never blocks the wrapper.
+	result := make(chan commandResult, 1)
+
+	select {
+	case wrapper.tasks <- &CommandTask{wrapper: wrapper, cmd: cmd, result: result}:
+	case <-wrapper.done:
+		return "", errors.New("server stopped")
+	case <-ctx.Done():
+		return "", fmt.Errorf("waiting for another task to finish: %w", ctx.Err())
+	}
+
+	r := <-result
+	return r.output, r.err
+}
+
+// command sends cmd over RCON if it can, otherwise to stdin. Only tasks call
+// it, so it never interleaves with one.
+func (wrapper *Wrapper) command(cmd string) (string, error) {
+	if wrapper.rconAddress == "" {
+		return "", wrapper.Send(cmd)
+	}
+
+	wrapper.rconMu.Lock()
+	defer wrapper.rconMu.Unlock()
+
+	if wrapper.rcon == nil {
+		client, err := rcon.Dial(wrapper.rconAddress, wrapper.rconPassword, rconTimeout)
+		if err != nil {
+			log.Println("rcon unavailable, using stdin:", err)
+			return "", wrapper.Send(cmd)
+		}
+		wrapper.rcon = client
+	}
+
+	out, err := wrapper.rcon.Command(cmd)
+	if err != nil {
+		// Reconnect next time, the connection may be broken.
+		wrapper.rcon.Close()
+		wrapper.rcon = nil
+	}
+	return out, err
+}
+
 // Status of the server
 func (wrapper *Wrapper) Status() serverwrapper.Status {
 	select {
@@ -87,6 +177,39 @@ func (wrapper *Wrapper) Status() serverwrapper.Status {
 	return serverwrapper.StatusStarting
 }
 
+// StatusResponse describes the server's status in full.
+func (wrapper *Wrapper) StatusResponse() serverwrapper.StatusResponse {
+	resp := serverwrapper.StatusResponse{
+		Status: string(wrapper.Status()),
+	}
+
+	wrapper.tracker.Fill(&resp)
+
+	if resp.Status != serverwrapper.StatusStopped {
+		resp.Memory = wrapper.memory()
+	}
+
+	return resp
+}
+
+// memory used by the JVM, nil if it isn't running.
+func (wrapper *Wrapper) memory() *serverwrapper.Memory {
+	wrapper.procMu.Lock()
+	pid, max := wrapper.javaPID, wrapper.jvmMaxMiB
+	wrapper.procMu.Unlock()
+
+	if pid == 0 {
+		return nil
+	}
+
+	used, err := serverwrapper.ProcessRSSMiB(pid)
+	if err != nil {
+		return nil
+	}
+
+	return &serverwrapper.Memory{UsedMiB: used, MaxMiB: max}
+}
+
 func (wrapper *Wrapper) Stop() {
 	close(wrapper.done)
 }
@@ -94,6 +217,7 @@ func (wrapper *Wrapper) Stop() {
 func (wrapper *Wrapper) Run(ctx context.Context) {
 	var currentTask Task = &WaitForStartedTask{wrapper}
 	var tasks chan Task
+	wrapper.publishTask(serverwrapper.EventTaskStarted, currentTask)
 
 	go func() {
 		err := wrapper.runServer(ctx)
@@ -105,19 +229,31 @@ func (wrapper *Wrapper) Run(ctx context.Context) {
 	for {
 		select {
 		case line := <-wrapper.output:
+			wrapper.tracker.Observe(line)
+			wrapper.logs.Append(line, time.Now())
+			if parsed, ok := serverwrapper.ParseConsoleLine(line); ok {
+				if event, ok := serverwrapper.ParseEvent(parsed, time.Now()); ok {
+					wrapper.events.Publish(event)
+				}
+			}
+
 			claimedMsg := "NoTask"
 			if currentTask != nil {
 				claimedMsg = getTaskName(currentTask)
 				if currentTask.OnOutput(line) == TaskDone {
+					wrapper.publishTask(serverwrapper.EventTaskFinished, currentTask)
 					currentTask = nil
 					tasks = wrapper.tasks
 				}
 			}
 			fmt.Printf("%s %s\n", claimedMsg, line)
 		case task := <-tasks:
+			wrapper.publishTask(serverwrapper.EventTaskStarted, task)
 			if task.Init() == TaskContinue {
 				currentTask = task
 				tasks = nil
+			} else {
+				wrapper.publishTask(serverwrapper.EventTaskFinished, task)
 			}
 		case <-wrapper.done:
 			if currentTask == nil {
@@ -132,6 +268,14 @@ func (wrapper *Wrapper) Run(ctx context.Context) {
 	}
 }
 
+func (wrapper *Wrapper) publishTask(eventType serverwrapper.EventType, task Task) {
+	wrapper.events.Publish(serverwrapper.Event{
+		Type: eventType,
+		Time: time.Now(),
+		Task: getTaskName(task),
+	})
+}
+
 func getTaskName(task Task) string {
 	t := reflect.TypeOf(task)
 	if t.Kind() == reflect.Ptr {
@@ -272,6 +416,13 @@ func (wrapper *Wrapper) runServer(ctx context.Context) (err error) {
 		return
 	}
 
+	maxMiB, _ := serverwrapper.JVMMemoryMiB(jvmMemStr)
+
+	wrapper.procMu.Lock()
+	wrapper.javaPID = cmd.Process.Pid
+	wrapper.jvmMaxMiB = maxMiB
+	wrapper.procMu.Unlock()
+
 	err = cmd.Wait()
 
 	if err != nil {
@@ -0,0 +1,59 @@
+// worldsync copies a world between a directory and S3, transferring only the
+// files that changed. It runs on the server, inside the server wrapper image.
+//
+//	worldsync download -bucket ogage-minecraft -prefix worlds/cliff -dir /world
+//	worldsync upload -bucket ogage-minecraft -prefix worlds/cliff -dir /world
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/owengage/minecloud/pkg/worldsync"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal("expected upload or download")
+	}
+	direction := os.Args[1]
+
+	flags := flag.NewFlagSet(direction, flag.ExitOnError)
+	bucket := flags.String("bucket", "", "S3 bucket the world is stored in")
+	prefix := flags.String("prefix", "", "key prefix of the world, eg worlds/cliff")
+	dir := flags.String("dir", "", "local directory of the world")
+	_ = flags.Parse(os.Args[2:])
+
+	if *bucket == "" || *prefix == "" || *dir == "" {
+		log.Fatal("-bucket, -prefix and -dir are required")
+	}
+
+	sess := session.Must(session.NewSession())
+	store := &worldsync.S3Store{
+		S3:     s3.New(sess),
+		Bucket: *bucket,
+		Prefix: *prefix,
+	}
+
+	var stats worldsync.Stats
+	var err error
+
+	switch direction {
+	case "upload":
+		stats, err = worldsync.Upload(*dir, store)
+	case "download":
+		stats, err = worldsync.Download(store, *dir)
+	default:
+		log.Fatalf("unknown direction: %s", direction)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%s: %s\n", direction, stats)
+}
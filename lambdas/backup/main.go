@@ -0,0 +1,46 @@
+// Takes scheduled backups of running worlds, pruning old backups.
+package main
+
+import (
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backups"
+	"github.com/owengage/minecloud/pkg/functions"
+	"github.com/owengage/minecloud/pkg/mcconfig"
+)
+
+func main() {
+	awsSession := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	// Touch the hosts file to make sure it exists.
+	f, err := os.OpenFile("/tmp/known_hosts", os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		panic(err)
+	}
+	f.Close()
+
+	settings, err := mcconfig.Load("")
+	if err != nil {
+		panic(err)
+	}
+
+	config := awsdetail.Config{
+		Config:                    settings,
+		SSHPrivateKey:             functions.GetSSHKey(awsSession, settings),
+		SSHKnownHostsPath:         "/tmp/known_hosts",
+		SSHDefaultNewKeyBehaviour: awsdetail.SSHNewKeyAccept,
+	}
+
+	backup := functions.Backup{
+		Detail: awsdetail.NewDetail(awsSession, config),
+		Policy: backups.DefaultPolicy,
+	}
+
+	lambda.Start(backup.HandleRequest)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/owengage/minecloud/pkg/awsdetail"
 	"github.com/owengage/minecloud/pkg/functions"
+	"github.com/owengage/minecloud/pkg/mcconfig"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -24,18 +25,22 @@ func main() {
 	}
 	f.Close()
 
+	settings, err := mcconfig.Load("")
+	if err != nil {
+		panic(err)
+	}
+
 	config := awsdetail.Config{
-		SSHPrivateKey:             functions.GetSSHKey(awsSession),
+		Config:                    settings,
+		SSHPrivateKey:             functions.GetSSHKey(awsSession, settings),
 		SSHKnownHostsPath:         "/tmp/known_hosts",
 		SSHDefaultNewKeyBehaviour: awsdetail.SSHNewKeyAccept,
-		HostedZoneID:              "Z0259601KLGA9PWJ5S0",
-		HostedZoneSuffix:          "owengage.com.",
 	}
 
 	detail = awsdetail.NewDetail(awsSession, config)
 
 	cmd := functions.Command{
-		Detail: detail,
+		Backend: awsdetail.NewBackend(detail),
 	}
 
 	lambda.Start(cmd.HandleRequest)
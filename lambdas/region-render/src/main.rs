@@ -33,7 +33,8 @@ struct TileDetails<'a> {
 async fn handler(e: S3Event, _: Context) -> Result<(), Error> {
     let dest_bucket = "owengage.com";
 
-    let client = S3Client::new(Region::EuWest2);
+    // Buckets only notify lambdas in their own region, so that's where we are.
+    let client = S3Client::new(Region::default());
     let r = renderer::TileRenderer::new();
 
     for record in e.records {
@@ -12,6 +12,7 @@ import (
 
 	"github.com/owengage/minecloud/pkg/awsdetail"
 	"github.com/owengage/minecloud/pkg/functions"
+	"github.com/owengage/minecloud/pkg/mcconfig"
 )
 
 var singleton functions.Singleton
@@ -28,18 +29,22 @@ func main() {
 	}
 	f.Close()
 
+	settings, err := mcconfig.Load("")
+	if err != nil {
+		panic(err)
+	}
+
 	config := awsdetail.Config{
-		SSHPrivateKey:             functions.GetSSHKey(awsSession),
+		Config:                    settings,
+		SSHPrivateKey:             functions.GetSSHKey(awsSession, settings),
 		SSHKnownHostsPath:         "/tmp/known_hosts",
 		SSHDefaultNewKeyBehaviour: awsdetail.SSHNewKeyAccept,
-		HostedZoneID:              "Z0259601KLGA9PWJ5S0",
-		HostedZoneSuffix:          "owengage.com.",
 	}
 
 	detail := awsdetail.NewDetail(awsSession, config)
 
 	singleton = functions.Singleton{
-		Detail:  detail,
+		Backend: awsdetail.NewBackend(detail),
 		Invoker: &awsdetail.LambdaInvoker{LS: ls.New(awsSession)},
 	}
 
@@ -0,0 +1,253 @@
+package awsdetail
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+)
+
+// NewBackend exposes AWS as a Minecloud backend: EC2 for compute, S3 for
+// storage and operations, DynamoDB for claims and Route53 for DNS. Worlds are run and stored
+// in the region they live in, see WorldRegion. Server IDs from outside the
+// main region are qualified with it, see QualifyID.
+func NewBackend(detail *Detail) *backend.Backend {
+	return &backend.Backend{
+		Compute: &ec2Compute{detail},
+		Storage: &s3Storage{detail},
+		Claims:  &dynamoClaims{detail},
+		DNS:     &route53DNS{detail},
+		Logger:  detail.Logger,
+
+		Operations: &s3Operations{detail},
+	}
+}
+
+type ec2Compute struct {
+	detail *Detail
+}
+
+func (c *ec2Compute) Reserve(world minecloud.World, opts minecloud.UpOptions) (string, error) {
+	detail, err := c.detail.ForWorld(string(world))
+	if err != nil {
+		return "", err
+	}
+
+	id, err := ReserveInstance(detail, string(world), opts)
+	if err != nil {
+		return "", err
+	}
+	return detail.QualifyID(id), nil
+}
+
+func (c *ec2Compute) WaitReady(id string) error {
+	detail, id := c.detail.ResolveID(id)
+	detail.Logger.Info("waiting for instance to be running")
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRunningTimeout)
+	defer cancel()
+
+	err := detail.EC2.WaitUntilInstanceRunningWithContext(ctx, descInput(id))
+	if err != nil {
+		return fmt.Errorf("wait for running, giving up after %s: %w", DefaultRunningTimeout, err)
+	}
+	return nil
+}
+
+func (c *ec2Compute) Address(id string) (string, error) {
+	detail, id := c.detail.ResolveID(id)
+	return detail.IP(id)
+}
+
+func (c *ec2Compute) Setup(id string, world minecloud.World) error {
+	// The instance sets itself up from its user data.
+	detail, id := c.detail.ResolveID(id)
+	return WaitForBoot(detail, id)
+}
+
+func (c *ec2Compute) Find(world minecloud.World) (backend.Server, error) {
+	detail, err := c.detail.ForWorld(string(world))
+	if err != nil {
+		return backend.Server{}, err
+	}
+
+	server, err := FindRunning(detail.EC2, string(world))
+	if err != nil {
+		return backend.Server{}, err
+	}
+	return server.toBackend(detail), nil
+}
+
+func (c *ec2Compute) FindLive(world minecloud.World) (backend.Server, error) {
+	detail, err := c.detail.ForWorld(string(world))
+	if err != nil {
+		return backend.Server{}, err
+	}
+
+	server, err := FindLive(detail.EC2, string(world))
+	if err != nil {
+		return backend.Server{}, err
+	}
+	return server.toBackend(detail), nil
+}
+
+// List servers in every configured region.
+func (c *ec2Compute) List() ([]backend.Server, error) {
+	out := []backend.Server{}
+
+	for _, region := range c.detail.Config.AllRegions() {
+		detail := c.detail.In(region)
+
+		servers, err := GetRunning(detail.EC2)
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", region, err)
+		}
+
+		for _, server := range servers {
+			out = append(out, server.toBackend(detail))
+		}
+	}
+	return out, nil
+}
+
+func (c *ec2Compute) Status(id string) (serverwrapper.StatusResponse, error) {
+	detail, id := c.detail.ResolveID(id)
+	return Status(detail, id)
+}
+
+func (c *ec2Compute) Logs(id string, opts serverwrapper.LogsOptions, handle func(serverwrapper.LogLine)) error {
+	detail, id := c.detail.ResolveID(id)
+	return Logs(detail, id, opts, handle)
+}
+
+func (c *ec2Compute) Save(id string, timeout time.Duration) error {
+	detail, id := c.detail.ResolveID(id)
+	return SaveWorld(detail, id, timeout)
+}
+
+func (c *ec2Compute) Stop(id string) error {
+	detail, id := c.detail.ResolveID(id)
+	return StopServerWrapper(detail, id)
+}
+
+func (c *ec2Compute) Upload(id string, world minecloud.World) error {
+	detail, id := c.detail.ResolveID(id)
+	return UploadWorld(detail, id, string(world))
+}
+
+func (c *ec2Compute) Terminate(id string) error {
+	detail, id := c.detail.ResolveID(id)
+	return TerminateInstance(detail, id)
+}
+
+type s3Storage struct {
+	detail *Detail
+}
+
+func (s *s3Storage) FindStored(world minecloud.World) error {
+	detail, err := s.detail.ForWorld(string(world))
+	if err != nil {
+		return err
+	}
+	return FindStored(detail, string(world))
+}
+
+// List worlds stored in every configured region.
+func (s *s3Storage) List() ([]minecloud.World, error) {
+	seen := map[string]bool{}
+	worlds := []minecloud.World{}
+
+	for _, region := range s.detail.Config.AllRegions() {
+		names, err := ListStored(s.detail.In(region))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				worlds = append(worlds, minecloud.World(name))
+			}
+		}
+	}
+
+	sort.Slice(worlds, func(i, j int) bool { return worlds[i] < worlds[j] })
+	return worlds, nil
+}
+
+type dynamoClaims struct {
+	detail *Detail
+}
+
+func (c *dynamoClaims) Claim(world minecloud.World, owner string) (backend.Lease, error) {
+	return ClaimWorld(c.detail, string(world), owner)
+}
+
+func (c *dynamoClaims) Lease(world minecloud.World) (backend.Lease, error) {
+	return GetLease(c.detail, string(world))
+}
+
+func (c *dynamoClaims) TakeOver(expired backend.Lease, owner string) (backend.Lease, error) {
+	return TakeOverLease(c.detail, expired, owner)
+}
+
+func (c *dynamoClaims) Renew(world minecloud.World, token int64, instanceID string) (backend.Lease, error) {
+	return RenewLease(c.detail, string(world), token, instanceID)
+}
+
+func (c *dynamoClaims) Release(world minecloud.World, token int64) error {
+	return ReleaseWorld(c.detail, string(world), token)
+}
+
+func (c *dynamoClaims) SetState(world minecloud.World, from, to backend.WorldState) (backend.Lease, error) {
+	return SetWorldState(c.detail, string(world), from, to)
+}
+
+func (c *dynamoClaims) SetStep(world minecloud.World, token int64, operation, step string) (backend.Lease, error) {
+	return SetWorldStep(c.detail, string(world), token, operation, step)
+}
+
+func (c *dynamoClaims) List() ([]backend.Lease, error) {
+	return ListLeases(c.detail)
+}
+
+func (c *dynamoClaims) Unclaim(world minecloud.World) error {
+	return UnclaimWorld(c.detail, string(world))
+}
+
+type s3Operations struct {
+	detail *Detail
+}
+
+func (o *s3Operations) Put(op backend.Operation) error {
+	return PutOperation(o.detail, op)
+}
+
+func (o *s3Operations) Get(id string) (backend.Operation, error) {
+	return GetOperation(o.detail, id)
+}
+
+type route53DNS struct {
+	detail *Detail
+}
+
+func (d *route53DNS) Update(world minecloud.World, address string) error {
+	return UpdateDNS(d.detail, address, world)
+}
+
+func (d *route53DNS) Remove(world minecloud.World) error {
+	return RemoveDNS(d.detail, world)
+}
+
+func (server MCServer) toBackend(detail *Detail) backend.Server {
+	return backend.Server{
+		Name:    server.Name,
+		State:   server.InstanceState,
+		ID:      detail.QualifyID(server.InstanceID),
+		Address: server.PublicIP,
+		Region:  detail.Region(),
+	}
+}
@@ -0,0 +1,323 @@
+package awsdetail
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/owengage/minecloud/pkg/backups"
+	"github.com/owengage/minecloud/pkg/worldsync"
+)
+
+// ErrBackupNotFound given if a world has no backup with the requested ID.
+var ErrBackupNotFound = errors.New("backup not found")
+
+// Backups are server side copies of the stored world, kept under
+//
+//	worlds/<name>/.minecloud/backups/<id>/manifest.json
+//	worlds/<name>/.minecloud/backups/<id>/world/...
+func s3BackupsPrefix(name string) string {
+	return s3MetaPrefix(name) + "/backups"
+}
+
+func s3BackupPrefix(name, id string) string {
+	return s3BackupsPrefix(name) + "/" + id
+}
+
+// S3BackupWorldPrefix is where the world files of a backup are. It can be
+// used in place of S3WorldPrefix to download a backup.
+func S3BackupWorldPrefix(name, id string) string {
+	return s3BackupPrefix(name, id) + "/world"
+}
+
+// CreateBackup of a world. If a server is running the world it is uploaded
+// first, so the backup is up to date.
+func CreateBackup(detail *Detail, world string) (backups.Manifest, error) {
+	manifest := backups.Manifest{
+		World: world,
+		Time:  time.Now().UTC(),
+	}
+	manifest.ID = backups.NewID(manifest.Time)
+
+	_, err := detail.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(detail.Config.Bucket),
+		Key:    aws.String(s3BackupPrefix(world, manifest.ID) + "/manifest.json"),
+	})
+	if err == nil {
+		return manifest, fmt.Errorf("create backup: %s already exists", manifest.ID)
+	}
+
+	server, err := FindRunning(detail.EC2, world)
+	if err == nil {
+		detail.Logger.Infof("uploading world from %s", server.InstanceID)
+		err = UploadWorld(detail, server.InstanceID, world)
+		if err != nil {
+			return manifest, fmt.Errorf("create backup: %w", err)
+		}
+		manifest.SourceInstance = server.InstanceID
+	} else if !errors.Is(err, ErrServerNotFound) {
+		return manifest, err
+	}
+
+	detail.Logger.Infof("copying world to backup %s", manifest.ID)
+	manifest.Size, manifest.Files, err = copyWorld(detail, S3WorldPrefix(world), S3BackupWorldPrefix(world, manifest.ID))
+	if err != nil {
+		return manifest, fmt.Errorf("create backup: %w", err)
+	}
+	if manifest.Files == 0 {
+		return manifest, fmt.Errorf("create backup: world '%s': %w", world, ErrServerNotFound)
+	}
+
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+
+	_, err = detail.S3.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(detail.Config.Bucket),
+		Key:    aws.String(s3BackupPrefix(world, manifest.ID) + "/manifest.json"),
+		Body:   bytes.NewReader(body),
+	})
+	if err != nil {
+		return manifest, fmt.Errorf("create backup: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// ListBackups of a world, newest first. Only each backup's manifest is
+// read, not the world files under it.
+func ListBackups(detail *Detail, world string) ([]backups.Manifest, error) {
+	prefixes := []string{}
+	err := detail.S3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(detail.Config.Bucket),
+		Prefix:    aws.String(s3BackupsPrefix(world) + "/"),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, prefix := range page.CommonPrefixes {
+			prefixes = append(prefixes, aws.StringValue(prefix.Prefix))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list backups: %w", err)
+	}
+
+	manifests := []backups.Manifest{}
+	for _, prefix := range prefixes {
+		key := prefix + "manifest.json"
+		out, err := detail.S3.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(detail.Config.Bucket),
+			Key:    aws.String(key),
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			continue // still being created, or a failed create.
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list backups: %w", err)
+		}
+
+		var manifest backups.Manifest
+		err = json.NewDecoder(out.Body).Decode(&manifest)
+		out.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list backups: %s: %w", key, err)
+		}
+
+		manifests = append(manifests, manifest)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].Time.After(manifests[j].Time)
+	})
+
+	return manifests, nil
+}
+
+// findBackup returns ErrBackupNotFound if the world has no backup with the ID.
+func findBackup(detail *Detail, world, id string) error {
+	_, err := detail.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(detail.Config.Bucket),
+		Key:    aws.String(s3BackupPrefix(world, id) + "/manifest.json"),
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", id, ErrBackupNotFound)
+	}
+	return nil
+}
+
+// RestoreBackup replaces the stored world with a backup of it. The world must
+// not be running. The world as it was is backed up first, and that backup's
+// manifest returned.
+func RestoreBackup(detail *Detail, world, id string) (backups.Manifest, error) {
+	_, err := FindRunning(detail.EC2, world)
+	if err == nil {
+		return backups.Manifest{}, fmt.Errorf("restore backup: world '%s' is running, take it down first", world)
+	} else if !errors.Is(err, ErrServerNotFound) {
+		return backups.Manifest{}, err
+	}
+
+	if err := findBackup(detail, world, id); err != nil {
+		return backups.Manifest{}, fmt.Errorf("restore backup: %w", err)
+	}
+
+	previous, err := CreateBackup(detail, world)
+	if err != nil && !errors.Is(err, ErrServerNotFound) {
+		return previous, fmt.Errorf("restore backup: backing up current world: %w", err)
+	}
+
+	detail.Logger.Infof("restoring backup %s", id)
+
+	current, err := listWorldObjects(detail, S3WorldPrefix(world))
+	if err != nil {
+		return previous, fmt.Errorf("restore backup: %w", err)
+	}
+
+	err = deleteObjects(detail, current)
+	if err != nil {
+		return previous, fmt.Errorf("restore backup: %w", err)
+	}
+
+	_, _, err = copyWorld(detail, S3BackupWorldPrefix(world, id), S3WorldPrefix(world))
+	if err != nil {
+		return previous, fmt.Errorf("restore backup: %w", err)
+	}
+
+	// The sync manifest describes the world we just replaced, so drop it and
+	// let the next sync compare every file.
+	_, err = detail.S3.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(detail.Config.Bucket),
+		Key:    aws.String(S3WorldPrefix(world) + "/" + worldsync.ManifestName),
+	})
+	if err != nil {
+		return previous, fmt.Errorf("restore backup: %w", err)
+	}
+
+	return previous, nil
+}
+
+// PruneBackups deletes backups the policy doesn't keep, returning them. With
+// dryRun nothing is deleted.
+func PruneBackups(detail *Detail, world string, policy backups.Policy, dryRun bool) ([]backups.Manifest, error) {
+	manifests, err := ListBackups(detail, world)
+	if err != nil {
+		return nil, err
+	}
+
+	_, remove := backups.Prune(manifests, time.Now(), policy)
+	if dryRun {
+		return remove, nil
+	}
+
+	for _, manifest := range remove {
+		detail.Logger.Infof("deleting backup %s", manifest.ID)
+
+		objects, err := listObjects(detail, s3BackupPrefix(world, manifest.ID)+"/")
+		if err != nil {
+			return nil, fmt.Errorf("prune backups: %w", err)
+		}
+
+		err = deleteObjects(detail, objects)
+		if err != nil {
+			return nil, fmt.Errorf("prune backups: %w", err)
+		}
+	}
+
+	return remove, nil
+}
+
+// copyWorld copies the world files under one prefix to another, skipping
+// Minecloud's own files.
+func copyWorld(detail *Detail, from, to string) (size int64, files int, err error) {
+	objects, err := listWorldObjects(detail, from)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, obj := range objects {
+		rel := strings.TrimPrefix(*obj.Key, from+"/")
+
+		_, err = detail.S3.CopyObject(&s3.CopyObjectInput{
+			Bucket:     aws.String(detail.Config.Bucket),
+			Key:        aws.String(to + "/" + rel),
+			CopySource: aws.String((&url.URL{Path: detail.Config.Bucket + "/" + *obj.Key}).EscapedPath()),
+		})
+		if err != nil {
+			return size, files, fmt.Errorf("copy %s: %w", *obj.Key, err)
+		}
+
+		size += aws.Int64Value(obj.Size)
+		files++
+	}
+
+	return size, files, nil
+}
+
+// listWorldObjects lists the world files under prefix, skipping Minecloud's
+// own files.
+func listWorldObjects(detail *Detail, prefix string) ([]*s3.Object, error) {
+	objects, err := listObjects(detail, prefix+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	world := []*s3.Object{}
+	for _, obj := range objects {
+		if !strings.HasPrefix(*obj.Key, prefix+"/.minecloud/") {
+			world = append(world, obj)
+		}
+	}
+	return world, nil
+}
+
+func listObjects(detail *Detail, prefix string) ([]*s3.Object, error) {
+	objects := []*s3.Object{}
+
+	err := detail.S3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(detail.Config.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		objects = append(objects, page.Contents...)
+		return true
+	})
+
+	return objects, err
+}
+
+// deleteObjects in batches, as S3 allows at most 1000 per request.
+func deleteObjects(detail *Detail, objects []*s3.Object) error {
+	for len(objects) > 0 {
+		n := len(objects)
+		if n > 1000 {
+			n = 1000
+		}
+
+		ids := []*s3.ObjectIdentifier{}
+		for _, obj := range objects[:n] {
+			ids = append(ids, &s3.ObjectIdentifier{Key: obj.Key})
+		}
+
+		out, err := detail.S3.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(detail.Config.Bucket),
+			Delete: &s3.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("delete %s: %s", aws.StringValue(out.Errors[0].Key), aws.StringValue(out.Errors[0].Message))
+		}
+
+		objects = objects[n:]
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package awsdetail_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/backups"
+	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateAndRestoreBackup(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	backup, err := awsdetail.CreateBackup(detail, "cliff")
+	require.NoError(t, err)
+	require.Equal(t, 1, backup.Files)
+	require.Equal(t, int64(len("level")), backup.Size)
+	require.Empty(t, backup.SourceInstance)
+
+	// Grief the world.
+	fakes.S3.Put("ogage-minecraft", "worlds/cliff/level.dat", []byte("griefed"))
+	fakes.S3.Put("ogage-minecraft", "worlds/cliff/region/r.0.0.mca", []byte("lava"))
+	fakes.S3.Put("ogage-minecraft", "worlds/cliff/.minecloud/manifest.json", []byte("{}"))
+
+	time.Sleep(2 * time.Millisecond) // backup IDs have millisecond resolution.
+
+	previous, err := awsdetail.RestoreBackup(detail, "cliff", backup.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, previous.Files)
+
+	require.Equal(t, []byte("level"), fakes.S3.Get("ogage-minecraft", "worlds/cliff/level.dat"))
+	require.Nil(t, fakes.S3.Get("ogage-minecraft", "worlds/cliff/region/r.0.0.mca"))
+	require.Nil(t, fakes.S3.Get("ogage-minecraft", "worlds/cliff/.minecloud/manifest.json"))
+
+	manifests, err := awsdetail.ListBackups(detail, "cliff")
+	require.NoError(t, err)
+	require.Len(t, manifests, 2)
+	require.Equal(t, previous.ID, manifests[0].ID)
+	require.Equal(t, backup.ID, manifests[1].ID)
+}
+
+func TestUpFromBackup(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+	b := awsdetail.NewBackend(detail)
+
+	backup, err := awsdetail.CreateBackup(detail, "cliff")
+	require.NoError(t, err)
+
+	claim(t, detail, "cliff")
+	require.NoError(t, backend.RunStored(b, "cliff", minecloud.UpOptions{Backup: backup.ID}))
+
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+	userData, err := base64.StdEncoding.DecodeString(*fakes.EC2.RunInput(server.InstanceID).UserData)
+	require.NoError(t, err)
+	require.Contains(t, string(userData), `-prefix "`+awsdetail.S3BackupWorldPrefix("cliff", backup.ID)+`"`)
+}
+
+func TestUpFromUnknownBackup(t *testing.T) {
+	_, detail := newFakeDetail(t)
+	b := awsdetail.NewBackend(detail)
+
+	claim(t, detail, "cliff")
+	err := backend.RunStored(b, "cliff", minecloud.UpOptions{Backup: "20200101T000000Z"})
+	require.True(t, errors.Is(err, awsdetail.ErrBackupNotFound))
+
+	_, err = b.Compute.Find("cliff")
+	require.True(t, errors.Is(err, backend.ErrServerNotFound))
+}
+
+func TestRestoreBackupRefusesRunningWorld(t *testing.T) {
+	_, detail := newFakeDetail(t)
+
+	backup, err := awsdetail.CreateBackup(detail, "cliff")
+	require.NoError(t, err)
+
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+
+	_, err = awsdetail.RestoreBackup(detail, "cliff", backup.ID)
+	require.Error(t, err)
+}
+
+func TestRestoreUnknownBackup(t *testing.T) {
+	_, detail := newFakeDetail(t)
+
+	_, err := awsdetail.RestoreBackup(detail, "cliff", "20200101T000000Z")
+	require.True(t, errors.Is(err, awsdetail.ErrBackupNotFound))
+}
+
+func TestCreateBackupOfRunningWorld(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+
+	backup, err := awsdetail.CreateBackup(detail, "cliff")
+	require.NoError(t, err)
+	require.Equal(t, server.InstanceID, backup.SourceInstance)
+	require.True(t, fakes.SSH.Ran(server.InstanceID, "localhost:8080/upload"))
+}
+
+func TestPruneBackups(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	backup, err := awsdetail.CreateBackup(detail, "cliff")
+	require.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	latest, err := awsdetail.CreateBackup(detail, "cliff")
+	require.NoError(t, err)
+
+	// Both are in the same hour, so only the latest is kept.
+	removed, err := awsdetail.PruneBackups(detail, "cliff", backups.DefaultPolicy, true)
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	require.NotEmpty(t, fakes.S3.Keys("ogage-minecraft", awsdetail.S3BackupWorldPrefix("cliff", backup.ID)))
+
+	removed, err = awsdetail.PruneBackups(detail, "cliff", backups.DefaultPolicy, false)
+	require.NoError(t, err)
+	require.Equal(t, backup.ID, removed[0].ID)
+	require.Empty(t, fakes.S3.Keys("ogage-minecraft", awsdetail.S3BackupWorldPrefix("cliff", backup.ID)))
+
+	manifests, err := awsdetail.ListBackups(detail, "cliff")
+	require.NoError(t, err)
+	require.Len(t, manifests, 1)
+	require.Equal(t, latest.ID, manifests[0].ID)
+}
@@ -1,10 +1,51 @@
 package awsdetail
 
+import "time"
+
 // serverTagKey is the key used to tag minecraft servers with their name.
 const serverTagKey = "MinecraftServerName"
 
-// TODO: This actually needs to be configurable.
-const s3BucketName = "ogage-minecraft"
+// DefaultIdleTimeout is how long a server can be empty before shutting down.
+const DefaultIdleTimeout = 30 * time.Minute
+
+// DefaultIdleGrace is how long after starting before a server can be idle.
+const DefaultIdleGrace = 15 * time.Minute
+
+// WrapperAPIPort is where the server wrapper serves its HTTPS API on
+// instances. The instances' security group must allow it.
+const WrapperAPIPort = 8443
+
+// BootStageTagKey is the instance tag that user data updates as the instance
+// boots, with one of the BootStage values.
+const BootStageTagKey = "MinecloudBootStage"
+
+// Boot stages, in the order an instance goes through them.
+const (
+	BootStageBootstrapping = "bootstrapping"
+	BootStagePulling       = "pulling" // only when baking an image.
+	BootStageDownloading   = "downloading"
+	BootStageStarting      = "starting"
+	BootStageReady         = "ready"
+	BootStageFailed        = "failed"
+)
+
+// DefaultRunningTimeout is how long to wait for a new instance to be running,
+// before it starts booting.
+const DefaultRunningTimeout = 2 * time.Minute
+
+// DefaultBootTimeout is how long to wait for an instance to finish booting.
+// With DefaultRunningTimeout it's well under backend.OperationTimeout, leaving
+// a few minutes for reserving, DNS and recording, so a slow boot fails the up
+// and is recorded, rather than the lambda running it being killed first.
+const DefaultBootTimeout = 10 * time.Minute
 
-// SecretsBucketName is the bucket name for obtaining secrets.
-const SecretsBucketName = "ogage-minecraft-secrets"
+// Names of the infrastructure Init creates. The server role's instance
+// profile has the same name as the role.
+const (
+	serverRoleName    = "Minecloud_ServerRole"
+	serverPolicyName  = "Minecloud_ServerPolicy"
+	lambdaRoleName    = "Minecloud_LambdaRole"
+	lambdaPolicyName  = "Minecloud_LambdaPolicy"
+	securityGroupName = "Minecloud_Servers"
+	wrapperRepository = "minecloud/server-wrapper"
+)
@@ -8,11 +8,31 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/owengage/minecloud/pkg/mcconfig"
+	"github.com/owengage/minecloud/pkg/serverwrapper"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
@@ -24,24 +44,97 @@ func NewDetail(sess *session.Session, config Config) *Detail {
 		config.SSHDefaultNewKeyBehaviour = SSHNewKeyReject
 	}
 
-	return &Detail{
-		Session: sess,
-		Logger:  logrus.New(),
-		EC2:     ec2.New(sess),
-		S3:      s3.New(sess),
-		Config:  config,
+	config.Config = config.Config.WithDefaults()
+
+	// Everything starts from the main region, other regions are reached
+	// through In.
+	if config.Region == "" {
+		config.Region = aws.StringValue(sess.Config.Region)
+	} else if config.Region != aws.StringValue(sess.Config.Region) {
+		sess = sess.Copy(&aws.Config{Region: aws.String(config.Region)})
+	}
+
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DefaultIdleTimeout
 	}
+
+	if config.IdleGrace == 0 {
+		config.IdleGrace = DefaultIdleGrace
+	}
+
+	detail := &Detail{
+		Session:  sess,
+		Logger:   logrus.New(),
+		EC2:      ec2.New(sess),
+		S3:       s3.New(sess),
+		DynamoDB: dynamodb.New(sess),
+		Route53:  route53.New(sess),
+		STS:      sts.New(sess),
+		SSM:      ssm.New(sess),
+		ECR:      ecr.New(sess),
+		IAM:      iam.New(sess),
+		Lambda:   lambda.New(sess),
+		Config:   config,
+
+		NewWrapperClient: serverwrapper.NewTLSClient,
+	}
+	detail.Runner = &SSHRunner{Detail: detail}
+
+	return detail
 }
 
-// Detail contains useful bits for working with AWS.
+// Detail contains useful bits for working with AWS. Services are held as
+// their SDK interfaces so they can be swapped for fakes, see package fakeaws.
 type Detail struct {
-	Session *session.Session
-	EC2     *ec2.EC2
-	S3      *s3.S3
-	Logger  *logrus.Logger
-	Config  Config
-
+	Session  *session.Session
+	EC2      ec2iface.EC2API
+	S3       s3iface.S3API
+	DynamoDB dynamodbiface.DynamoDBAPI
+	Route53  route53iface.Route53API
+	STS      stsiface.STSAPI
+	SSM      ssmiface.SSMAPI
+	ECR      ecriface.ECRAPI
+	IAM      iamiface.IAMAPI
+	Lambda   lambdaiface.LambdaAPI
+	Runner   Runner
+	Logger   *logrus.Logger
+	Config   Config
+
+	// NewWrapperClient creates clients for wrappers' HTTPS APIs, defaulting
+	// to serverwrapper.NewTLSClient.
+	NewWrapperClient func(baseURL string, creds serverwrapper.Credentials) (*serverwrapper.Client, error)
+
+	// NewRegionClients creates clients for the regional services of another
+	// region, defaulting to SDK clients. See In.
+	NewRegionClients func(sess *session.Session) RegionClients
+
+	// mu guards the lazily set account, regions and SSH key, since a Detail
+	// is used from many goroutines, eg by the website.
+	mu      sync.Mutex
 	account *string
+	main    *Detail
+	regions map[string]*Detail
+}
+
+// RegionClients are the services a Detail uses per region. DynamoDB and
+// Route53 are always used in the main region, so claims and DNS are the same
+// wherever a world lives. IAM is global, and the lambdas are all in the main
+// region.
+type RegionClients struct {
+	EC2 ec2iface.EC2API
+	S3  s3iface.S3API
+	SSM ssmiface.SSMAPI
+	ECR ecriface.ECRAPI
+}
+
+// Runner runs scripts on instances.
+type Runner interface {
+	Run(instanceID, script string, opts RunOpts) error
+}
+
+// SSHRunner runs scripts on instances over SSH.
+type SSHRunner struct {
+	Detail *Detail
 }
 
 // SSHNewKeyOpt indicates how to treat unknown hosts with SSH.
@@ -60,12 +153,19 @@ const (
 
 // Config for AWS Detail.
 type Config struct {
+	mcconfig.Config
+
 	SSHPrivateKey             []byte
 	SSHPrivateKeyFile         string
 	SSHKnownHostsPath         string
 	SSHDefaultNewKeyBehaviour SSHNewKeyOpt
-	HostedZoneID              string
-	HostedZoneSuffix          string // eg "example.com." note final dot.
+
+	// IdleTimeout is how long a server can have no players before it takes
+	// itself down. Negative disables idle shutdown.
+	IdleTimeout time.Duration
+
+	// IdleGrace is how long after starting before the idle countdown begins.
+	IdleGrace time.Duration
 }
 
 // RunOpts options when running commands tunnelling through SSH.
@@ -83,7 +183,7 @@ func (detail *Detail) RunOn(instanceID, script string, opts RunOpts) error {
 	if opts.Stderr == nil {
 		opts.Stderr = os.Stderr
 	}
-	return detail.runOn(instanceID, script, opts)
+	return detail.Runner.Run(instanceID, script, opts)
 }
 
 // OutputOn returns stdout of running the given script
@@ -93,15 +193,17 @@ func (detail *Detail) OutputOn(instanceID, script string, opts RunOpts) ([]byte,
 	opts.Stdout = &stdout
 	opts.Stderr = &stderr
 
-	err := detail.runOn(instanceID, script, opts)
+	err := detail.Runner.Run(instanceID, script, opts)
 	return stdout.Bytes(), stderr.Bytes(), err
 }
 
 // Account is the AWS account being used to make requests.
 func (detail *Detail) Account() (string, error) {
+	detail.mu.Lock()
+	defer detail.mu.Unlock()
+
 	if detail.account == nil {
-		STS := sts.New(detail.Session)
-		identity, err := STS.GetCallerIdentity(nil)
+		identity, err := detail.STS.GetCallerIdentity(&sts.GetCallerIdentityInput{})
 		if err != nil {
 			return "", err
 		}
@@ -117,6 +219,75 @@ func (detail *Detail) Region() string {
 	return *detail.Session.Config.Region
 }
 
+// Main returns the Detail for the main region, which this is if it wasn't
+// created by In.
+func (detail *Detail) Main() *Detail {
+	if detail.main != nil {
+		return detail.main
+	}
+	return detail
+}
+
+// In returns a Detail for working in another region. It stores worlds in the
+// region's bucket, and shares claims and DNS with the main region. Details
+// are created once per region and reused.
+func (detail *Detail) In(region string) *Detail {
+	main := detail.Main()
+	if region == "" || region == main.Region() {
+		return main
+	}
+
+	main.mu.Lock()
+	defer main.mu.Unlock()
+
+	if regional, ok := main.regions[region]; ok {
+		return regional
+	}
+
+	sess := main.Session.Copy(&aws.Config{Region: aws.String(region)})
+
+	clients := RegionClients{EC2: ec2.New(sess), S3: s3.New(sess), SSM: ssm.New(sess), ECR: ecr.New(sess)}
+	if main.NewRegionClients != nil {
+		clients = main.NewRegionClients(sess)
+	}
+
+	config := main.Config
+	config.Bucket = main.Config.RegionBucket(region)
+	config.ImageID = "" // images are per region.
+
+	regional := &Detail{
+		Session:  sess,
+		EC2:      clients.EC2,
+		S3:       clients.S3,
+		SSM:      clients.SSM,
+		ECR:      clients.ECR,
+		DynamoDB: main.DynamoDB,
+		Route53:  main.Route53,
+		STS:      main.STS,
+		IAM:      main.IAM,
+		Lambda:   main.Lambda,
+		Runner:   main.Runner,
+		Logger:   main.Logger,
+		Config:   config,
+
+		NewWrapperClient: main.NewWrapperClient,
+
+		account: main.account,
+		main:    main,
+	}
+
+	// The SSH runner looks up instances, so has to be in the region too.
+	if _, ok := main.Runner.(*SSHRunner); ok {
+		regional.Runner = &SSHRunner{Detail: regional}
+	}
+
+	if main.regions == nil {
+		main.regions = map[string]*Detail{}
+	}
+	main.regions[region] = regional
+	return regional
+}
+
 // IP gets the IP of an instance.
 func (detail *Detail) IP(instanceID string) (string, error) {
 	description, err := detail.EC2.DescribeInstances(descInput(instanceID))
@@ -141,9 +312,11 @@ func (detail *Detail) IP(instanceID string) (string, error) {
 	return *ipPtr, nil
 }
 
-// runOn runs the given script on the given instance.
-func (detail *Detail) runOn(instanceID, script string, opts RunOpts) error {
-	err := ensureKeyBytes(detail)
+// Run the given script on the given instance.
+func (runner *SSHRunner) Run(instanceID, script string, opts RunOpts) error {
+	detail := runner.Detail
+
+	key, err := ensureKeyBytes(detail)
 	if err != nil {
 		return err
 	}
@@ -158,7 +331,7 @@ func (detail *Detail) runOn(instanceID, script string, opts RunOpts) error {
 		return errors.New("instance has no public IP (terminated?)")
 	}
 
-	signer, err := ssh.ParsePrivateKey(detail.Config.SSHPrivateKey)
+	signer, err := ssh.ParsePrivateKey(key)
 	if err != nil {
 		return fmt.Errorf("private: %w", err)
 	}
@@ -230,18 +403,23 @@ func (detail *Detail) tofuCallback(opts RunOpts) func(hostname string, remote ne
 	}
 }
 
-func ensureKeyBytes(detail *Detail) error {
+// ensureKeyBytes returns the SSH private key, reading it from its file the
+// first time.
+func ensureKeyBytes(detail *Detail) ([]byte, error) {
+	detail.mu.Lock()
+	defer detail.mu.Unlock()
+
 	if detail.Config.SSHPrivateKey != nil {
-		return nil
+		return detail.Config.SSHPrivateKey, nil
 	}
 
 	key, err := ioutil.ReadFile(detail.Config.SSHPrivateKeyFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	detail.Config.SSHPrivateKey = key
-	return nil
+	return key, nil
 }
 
 func addToKnownHosts(knownHostsFile, hostname string, key ssh.PublicKey) error {
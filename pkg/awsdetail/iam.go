@@ -0,0 +1,470 @@
+package awsdetail
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/owengage/minecloud/pkg/mcconfig"
+)
+
+// iamResources are the roles the servers and lambdas run as.
+func iamResources(detail *Detail) []resource {
+	return []resource{
+		roleResource(detail, serverRoleName, "ec2.amazonaws.com",
+			"Allows access to required services for a Minecloud server to function."),
+		policyResource(detail, serverPolicyName, serverRoleName,
+			"Allows a Minecloud server to access world/server files.", serverPolicy),
+		instanceProfileResource(detail, serverRoleName),
+		roleResource(detail, lambdaRoleName, "lambda.amazonaws.com",
+			"Allows the Minecloud lambdas to run servers."),
+		policyResource(detail, lambdaPolicyName, lambdaRoleName,
+			"Allows the Minecloud lambdas to run servers and manage worlds.", lambdaPolicy),
+	}
+}
+
+func roleResource(detail *Detail, name, service, description string) resource {
+	return resource{
+		kind: "iam role",
+		name: name,
+
+		exists: func() (bool, error) {
+			_, err := detail.IAM.GetRole(&iam.GetRoleInput{RoleName: aws.String(name)})
+			return found(err, iam.ErrCodeNoSuchEntityException)
+		},
+		create: func() error {
+			_, err := detail.IAM.CreateRole(&iam.CreateRoleInput{
+				RoleName:    aws.String(name),
+				Description: aws.String(description),
+
+				AssumeRolePolicyDocument: aws.String(`{
+            "Version": "2012-10-17",
+            "Statement": [
+                {
+                    "Effect": "Allow",
+                    "Action": "sts:AssumeRole",
+                    "Principal": {"Service": "` + service + `"}
+                }
+            ]
+        }`),
+			})
+			return err
+		},
+		delete: func() error {
+			_, err := detail.IAM.DeleteRole(&iam.DeleteRoleInput{RoleName: aws.String(name)})
+			return err
+		},
+	}
+}
+
+// policyResource is a policy attached to a role. The document is generated
+// from the config and account.
+func policyResource(detail *Detail, name, role, description string, document func(mcconfig.Config, string) string) resource {
+	arn := func() (string, error) {
+		account, err := detail.Account()
+		return "arn:aws:iam::" + account + ":policy/" + name, err
+	}
+
+	return resource{
+		kind: "iam policy",
+		name: name,
+
+		exists: func() (bool, error) {
+			policyArn, err := arn()
+			if err != nil {
+				return false, err
+			}
+
+			_, err = detail.IAM.GetPolicy(&iam.GetPolicyInput{PolicyArn: aws.String(policyArn)})
+			return found(err, iam.ErrCodeNoSuchEntityException)
+		},
+		create: func() error {
+			account, err := detail.Account()
+			if err != nil {
+				return err
+			}
+
+			out, err := detail.IAM.CreatePolicy(&iam.CreatePolicyInput{
+				PolicyName:     aws.String(name),
+				Description:    aws.String(description),
+				PolicyDocument: aws.String(document(detail.Config.Config, account)),
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = detail.IAM.AttachRolePolicy(&iam.AttachRolePolicyInput{
+				PolicyArn: out.Policy.Arn,
+				RoleName:  aws.String(role),
+			})
+			return err
+		},
+		delete: func() error {
+			policyArn, err := arn()
+			if err != nil {
+				return err
+			}
+
+			_, err = detail.IAM.DetachRolePolicy(&iam.DetachRolePolicyInput{
+				PolicyArn: aws.String(policyArn),
+				RoleName:  aws.String(role),
+			})
+			if _, err := found(err, iam.ErrCodeNoSuchEntityException); err != nil {
+				return err
+			}
+
+			// Policies can't be deleted while they have old versions.
+			versions, err := detail.IAM.ListPolicyVersions(&iam.ListPolicyVersionsInput{
+				PolicyArn: aws.String(policyArn),
+			})
+			if err != nil {
+				return err
+			}
+			for _, version := range versions.Versions {
+				if aws.BoolValue(version.IsDefaultVersion) {
+					continue
+				}
+				_, err = detail.IAM.DeletePolicyVersion(&iam.DeletePolicyVersionInput{
+					PolicyArn: aws.String(policyArn),
+					VersionId: version.VersionId,
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err = detail.IAM.DeletePolicy(&iam.DeletePolicyInput{PolicyArn: aws.String(policyArn)})
+			return err
+		},
+		diff: func() ([]string, error) {
+			state, err := getPolicyState(detail, name, role, document)
+			if err != nil {
+				return nil, err
+			}
+
+			details := []string{}
+			if !state.current {
+				details = append(details, "document differs from config")
+			}
+			if !state.attached {
+				details = append(details, "not attached to "+role)
+			}
+			return details, nil
+		},
+		update: func() error {
+			state, err := getPolicyState(detail, name, role, document)
+			if err != nil {
+				return err
+			}
+
+			if !state.current {
+				err = setPolicyDocument(detail, state.arn, state.want)
+				if err != nil {
+					return err
+				}
+			}
+
+			if !state.attached {
+				_, err = detail.IAM.AttachRolePolicy(&iam.AttachRolePolicyInput{
+					PolicyArn: aws.String(state.arn),
+					RoleName:  aws.String(role),
+				})
+			}
+			return err
+		},
+	}
+}
+
+// policyState is how an existing policy compares to what it should be.
+type policyState struct {
+	arn      string
+	want     string // the document it should have.
+	current  bool   // whether the default version has the wanted document.
+	attached bool   // whether it's attached to its role.
+}
+
+func getPolicyState(detail *Detail, name, role string, document func(mcconfig.Config, string) string) (policyState, error) {
+	account, err := detail.Account()
+	if err != nil {
+		return policyState{}, err
+	}
+
+	state := policyState{
+		arn:  "arn:aws:iam::" + account + ":policy/" + name,
+		want: document(detail.Config.Config, account),
+	}
+
+	policy, err := detail.IAM.GetPolicy(&iam.GetPolicyInput{PolicyArn: aws.String(state.arn)})
+	if err != nil {
+		return state, err
+	}
+
+	version, err := detail.IAM.GetPolicyVersion(&iam.GetPolicyVersionInput{
+		PolicyArn: aws.String(state.arn),
+		VersionId: policy.Policy.DefaultVersionId,
+	})
+	if err != nil {
+		return state, err
+	}
+
+	// IAM gives documents URL encoded.
+	current, err := url.PathUnescape(aws.StringValue(version.PolicyVersion.Document))
+	if err != nil {
+		return state, err
+	}
+	state.current, err = sameJSON(current, state.want)
+	if err != nil {
+		return state, err
+	}
+
+	attached, err := detail.IAM.ListAttachedRolePolicies(&iam.ListAttachedRolePoliciesInput{
+		RoleName: aws.String(role),
+	})
+	if _, err := found(err, iam.ErrCodeNoSuchEntityException); err != nil {
+		return state, err
+	}
+	if attached != nil {
+		for _, p := range attached.AttachedPolicies {
+			state.attached = state.attached || aws.StringValue(p.PolicyArn) == state.arn
+		}
+	}
+
+	return state, nil
+}
+
+// setPolicyDocument makes a new default version of a policy. Policies can
+// only have a few versions, so the oldest is deleted to make room.
+func setPolicyDocument(detail *Detail, policyArn, document string) error {
+	versions, err := detail.IAM.ListPolicyVersions(&iam.ListPolicyVersionsInput{
+		PolicyArn: aws.String(policyArn),
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(versions.Versions) >= maxPolicyVersions {
+		var oldest *iam.PolicyVersion
+		for _, version := range versions.Versions {
+			if aws.BoolValue(version.IsDefaultVersion) {
+				continue
+			}
+			if oldest == nil || aws.TimeValue(version.CreateDate).Before(aws.TimeValue(oldest.CreateDate)) {
+				oldest = version
+			}
+		}
+
+		_, err = detail.IAM.DeletePolicyVersion(&iam.DeletePolicyVersionInput{
+			PolicyArn: aws.String(policyArn),
+			VersionId: oldest.VersionId,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = detail.IAM.CreatePolicyVersion(&iam.CreatePolicyVersionInput{
+		PolicyArn:      aws.String(policyArn),
+		PolicyDocument: aws.String(document),
+		SetAsDefault:   aws.Bool(true),
+	})
+	return err
+}
+
+// maxPolicyVersions is how many versions IAM keeps of a policy.
+const maxPolicyVersions = 5
+
+// sameJSON is whether two JSON documents have the same content, ignoring
+// formatting.
+func sameJSON(a, b string) (bool, error) {
+	var av, bv interface{}
+	if err := json.Unmarshal([]byte(a), &av); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal([]byte(b), &bv); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(av, bv), nil
+}
+
+// instanceProfileResource lets instances run as the role, and has its name.
+func instanceProfileResource(detail *Detail, role string) resource {
+	return resource{
+		kind: "iam instance profile",
+		name: role,
+
+		exists: func() (bool, error) {
+			_, err := detail.IAM.GetInstanceProfile(&iam.GetInstanceProfileInput{
+				InstanceProfileName: aws.String(role),
+			})
+			return found(err, iam.ErrCodeNoSuchEntityException)
+		},
+		create: func() error {
+			_, err := detail.IAM.CreateInstanceProfile(&iam.CreateInstanceProfileInput{
+				InstanceProfileName: aws.String(role),
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = detail.IAM.AddRoleToInstanceProfile(&iam.AddRoleToInstanceProfileInput{
+				InstanceProfileName: aws.String(role),
+				RoleName:            aws.String(role),
+			})
+			return err
+		},
+		delete: func() error {
+			_, err := detail.IAM.RemoveRoleFromInstanceProfile(&iam.RemoveRoleFromInstanceProfileInput{
+				InstanceProfileName: aws.String(role),
+				RoleName:            aws.String(role),
+			})
+			if _, err := found(err, iam.ErrCodeNoSuchEntityException); err != nil {
+				return err
+			}
+
+			_, err = detail.IAM.DeleteInstanceProfile(&iam.DeleteInstanceProfileInput{
+				InstanceProfileName: aws.String(role),
+			})
+			return err
+		},
+		diff: func() ([]string, error) {
+			out, err := detail.IAM.GetInstanceProfile(&iam.GetInstanceProfileInput{
+				InstanceProfileName: aws.String(role),
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			for _, r := range out.InstanceProfile.Roles {
+				if aws.StringValue(r.RoleName) == role {
+					return nil, nil
+				}
+			}
+			return []string{"doesn't have role " + role}, nil
+		},
+		update: func() error {
+			_, err := detail.IAM.AddRoleToInstanceProfile(&iam.AddRoleToInstanceProfileInput{
+				InstanceProfileName: aws.String(role),
+				RoleName:            aws.String(role),
+			})
+			return err
+		},
+	}
+}
+
+// allBuckets are the ARNs of every region's bucket and its objects.
+func allBuckets(config mcconfig.Config) []string {
+	buckets := []string{}
+	for _, region := range config.AllRegions() {
+		bucket := config.RegionBucket(region)
+		buckets = append(buckets, `"arn:aws:s3:::`+bucket+`"`, `"arn:aws:s3:::`+bucket+`/*"`)
+	}
+	return buckets
+}
+
+// serverPolicy allows servers to use the bucket of every region, pull the
+// wrapper image, read their own wrapper API secrets, which are tagged with
+// the instance they are for, tag themselves with their boot stage and invoke
+// the singleton lambda in the main region.
+func serverPolicy(config mcconfig.Config, account string) string {
+	return fmt.Sprintf(`{
+            "Version": "2012-10-17",
+            "Statement": [
+              {
+                "Effect": "Allow",
+                "Action": "s3:*",
+                "Resource": [%[1]s]
+              },
+              {
+                "Effect": "Allow",
+                "Action": "ecr:GetAuthorizationToken",
+                "Resource": "*"
+              },
+              {
+                "Effect": "Allow",
+                "Action": "ecr:*",
+                "Resource": "arn:aws:ecr:*:%[3]s:repository/%[4]s"
+              },
+              {
+                "Effect": "Allow",
+                "Action": "ssm:GetParameter",
+                "Resource": "arn:aws:ssm:*:%[3]s:parameter%[5]s*",
+                "Condition": {"StringEquals": {"ssm:resourceTag/%[6]s": "${ec2:SourceInstanceARN}"}}
+              },
+              {
+                "Effect": "Allow",
+                "Action": "ec2:CreateTags",
+                "Resource": "arn:aws:ec2:*:*:instance/*",
+                "Condition": {"StringLike": {"ec2:ResourceTag/MinecraftServerName": "*"}}
+              },
+              {
+                "Effect": "Allow",
+                "Action": "ec2:CreateTags",
+                "Resource": "arn:aws:ec2:*:*:instance/*",
+                "Condition": {"StringLike": {"ec2:ResourceTag/MinecloudImageBuilder": "*"}}
+              },
+              {
+                "Effect": "Allow",
+                "Action": "lambda:InvokeFunction",
+                "Resource": "arn:aws:lambda:%[2]s:%[3]s:function:MinecloudSingleton"
+              }
+        	]
+        }`, strings.Join(allBuckets(config), ", "), config.Region, account, wrapperRepository, wrapperSecretsPrefix, wrapperSecretsTag)
+}
+
+// lambdaPolicy allows the lambdas to run servers in every region, use their
+// buckets, the secrets bucket, the wrapper API secrets, the claims table and the hosted zone, and
+// invoke each other.
+func lambdaPolicy(config mcconfig.Config, account string) string {
+	buckets := append(allBuckets(config),
+		`"arn:aws:s3:::`+config.SecretsBucket+`"`, `"arn:aws:s3:::`+config.SecretsBucket+`/*"`)
+
+	return fmt.Sprintf(`{
+            "Version": "2012-10-17",
+            "Statement": [
+              {
+                "Effect": "Allow",
+                "Action": ["ec2:*", "ssm:GetParameter", "sts:GetCallerIdentity"],
+                "Resource": "*"
+              },
+              {
+                "Effect": "Allow",
+                "Action": "iam:PassRole",
+                "Resource": "arn:aws:iam::%[3]s:role/%[4]s"
+              },
+              {
+                "Effect": "Allow",
+                "Action": ["ssm:PutParameter", "ssm:AddTagsToResource", "ssm:DeleteParameters"],
+                "Resource": "arn:aws:ssm:*:%[3]s:parameter%[7]s*"
+              },
+              {
+                "Effect": "Allow",
+                "Action": "s3:*",
+                "Resource": [%[1]s]
+              },
+              {
+                "Effect": "Allow",
+                "Action": "dynamodb:*",
+                "Resource": "arn:aws:dynamodb:%[2]s:%[3]s:table/%[5]s"
+              },
+              {
+                "Effect": "Allow",
+                "Action": ["route53:ChangeResourceRecordSets", "route53:ListResourceRecordSets"],
+                "Resource": "arn:aws:route53:::hostedzone/%[6]s"
+              },
+              {
+                "Effect": "Allow",
+                "Action": "lambda:InvokeFunction",
+                "Resource": "arn:aws:lambda:%[2]s:%[3]s:function:*"
+              },
+              {
+                "Effect": "Allow",
+                "Action": ["logs:CreateLogGroup", "logs:CreateLogStream", "logs:PutLogEvents"],
+                "Resource": "*"
+              }
+        	]
+        }`, strings.Join(buckets, ", "), config.Region, account, serverRoleName, config.TableName, config.HostedZoneID, wrapperSecretsPrefix)
+}
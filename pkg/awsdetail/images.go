@@ -0,0 +1,205 @@
+package awsdetail
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// imageTagKey tags images baked for servers.
+const imageTagKey = "MinecloudImage"
+
+// builderTagKey tags instances that are baking an image.
+const builderTagKey = "MinecloudImageBuilder"
+
+// baseImageParameter is the public SSM parameter holding the latest Amazon
+// Linux 2 image in each region.
+const baseImageParameter = "/aws/service/ami-amazon-linux-latest/amzn2-ami-hvm-x86_64-gp2"
+
+// ErrImageNotFound given if no server image has been baked.
+var ErrImageNotFound = errors.New("image not found")
+
+// Image is a baked server image.
+type Image struct {
+	ID      string
+	Name    string
+	State   string
+	Created time.Time
+}
+
+// ListImages lists baked server images, newest first.
+func ListImages(detail *Detail) ([]Image, error) {
+	out, err := detail.EC2.DescribeImages(&ec2.DescribeImagesInput{
+		Owners: []*string{aws.String("self")},
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:" + imageTagKey), Values: []*string{aws.String("server")}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list images: %w", err)
+	}
+
+	images := []Image{}
+	for _, image := range out.Images {
+		created, _ := time.Parse(time.RFC3339, aws.StringValue(image.CreationDate))
+		images = append(images, Image{
+			ID:      aws.StringValue(image.ImageId),
+			Name:    aws.StringValue(image.Name),
+			State:   aws.StringValue(image.State),
+			Created: created,
+		})
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Created.After(images[j].Created)
+	})
+	return images, nil
+}
+
+// LatestImage is the newest baked server image that can be used.
+func LatestImage(detail *Detail) (Image, error) {
+	images, err := ListImages(detail)
+	if err != nil {
+		return Image{}, err
+	}
+
+	for _, image := range images {
+		if image.State == ec2.ImageStateAvailable {
+			return image, nil
+		}
+	}
+	return Image{}, ErrImageNotFound
+}
+
+// baseImage is what images are baked from, and what servers use if no image
+// has been baked: the configured image, or the latest Amazon Linux 2.
+func baseImage(detail *Detail) (string, error) {
+	if detail.Config.ImageID != "" {
+		return detail.Config.ImageID, nil
+	}
+
+	out, err := detail.SSM.GetParameter(&ssm.GetParameterInput{
+		Name: aws.String(baseImageParameter),
+	})
+	if err != nil {
+		return "", fmt.Errorf("base image: %w", err)
+	}
+	return aws.StringValue(out.Parameter.Value), nil
+}
+
+// BakeImage launches a builder instance from the base image, installs
+// everything a server needs on it and makes it into an image. Servers launched afterwards use the new
+// image and skip bootstrapping. The builder is always terminated.
+func BakeImage(detail *Detail, instanceType *string) (Image, error) {
+	if instanceType == nil {
+		instanceType = aws.String("t3.medium")
+	}
+
+	account, err := detail.Account()
+	if err != nil {
+		return Image{}, err
+	}
+
+	base, err := baseImage(detail)
+	if err != nil {
+		return Image{}, err
+	}
+
+	securityGroup, err := securityGroupID(detail)
+	if err != nil {
+		return Image{}, err
+	}
+
+	userData := BakeScript(BakeScriptOpts{
+		AccountID: account,
+		Region:    detail.Region(),
+	})
+
+	detail.Logger.Info("launching image builder")
+	reservation, err := detail.EC2.RunInstances(&ec2.RunInstancesInput{
+		MaxCount:     aws.Int64(1),
+		MinCount:     aws.Int64(1),
+		ImageId:      aws.String(base),
+		InstanceType: instanceType,
+		IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
+			Name: aws.String(serverRoleName),
+		},
+		TagSpecifications: []*ec2.TagSpecification{
+			{
+				ResourceType: aws.String("instance"),
+				Tags: []*ec2.Tag{
+					{Key: aws.String(builderTagKey), Value: aws.String("true")},
+					{Key: aws.String("Name"), Value: aws.String("minecloud-image-builder")},
+				},
+			},
+		},
+		SecurityGroupIds: []*string{
+			aws.String(securityGroup),
+		},
+		KeyName:  aws.String(detail.Config.KeyPairName),
+		UserData: aws.String(base64.StdEncoding.EncodeToString([]byte(userData))),
+	})
+	if err != nil {
+		return Image{}, fmt.Errorf("bake image: %w", err)
+	}
+
+	builderID := *reservation.Instances[0].InstanceId
+	defer func() {
+		err := TerminateInstance(detail, builderID)
+		if err != nil {
+			detail.Logger.Errorf("failed to terminate image builder %s: %v", builderID, err)
+		}
+	}()
+
+	err = detail.EC2.WaitUntilInstanceRunning(descInput(builderID))
+	if err != nil {
+		return Image{}, fmt.Errorf("bake image: %w", err)
+	}
+
+	err = WaitForBoot(detail, builderID)
+	if err != nil {
+		return Image{}, fmt.Errorf("bake image: %w", err)
+	}
+
+	image := Image{
+		Name:    "minecloud-server-" + time.Now().UTC().Format("20060102T150405Z"),
+		Created: time.Now(),
+	}
+
+	detail.Logger.Infof("creating image %s", image.Name)
+	out, err := detail.EC2.CreateImage(&ec2.CreateImageInput{
+		InstanceId:  aws.String(builderID),
+		Name:        aws.String(image.Name),
+		Description: aws.String("Minecloud server with Docker, Java and the server wrapper image"),
+	})
+	if err != nil {
+		return image, fmt.Errorf("bake image: %w", err)
+	}
+	image.ID = *out.ImageId
+
+	// Tag as soon as possible, so a failed bake can be found and cleaned up.
+	_, err = detail.EC2.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{out.ImageId},
+		Tags:      []*ec2.Tag{{Key: aws.String(imageTagKey), Value: aws.String("server")}},
+	})
+	if err != nil {
+		return image, fmt.Errorf("bake image: %w", err)
+	}
+
+	detail.Logger.Infof("waiting for image %s to be available", image.ID)
+	err = detail.EC2.WaitUntilImageAvailable(&ec2.DescribeImagesInput{
+		ImageIds: []*string{out.ImageId},
+	})
+	if err != nil {
+		return image, fmt.Errorf("bake image: %w", err)
+	}
+
+	image.State = ec2.ImageStateAvailable
+	return image, nil
+}
@@ -0,0 +1,50 @@
+package awsdetail_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBakeImage(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	_, err := awsdetail.LatestImage(detail)
+	require.True(t, errors.Is(err, awsdetail.ErrImageNotFound))
+
+	image, err := awsdetail.BakeImage(detail, nil)
+	require.NoError(t, err)
+	require.Equal(t, ec2.ImageStateAvailable, image.State)
+
+	latest, err := awsdetail.LatestImage(detail)
+	require.NoError(t, err)
+	require.Equal(t, image.ID, latest.ID)
+
+	// The builder is gone, and new servers use the image without bootstrapping.
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+
+	input := fakes.EC2.RunInput(server.InstanceID)
+	require.Equal(t, image.ID, *input.ImageId)
+
+	userData, err := base64.StdEncoding.DecodeString(*input.UserData)
+	require.NoError(t, err)
+	require.NotContains(t, string(userData), "yum install")
+	require.Contains(t, string(userData), "--name serverwrapper")
+
+	out, err := detail.EC2.DescribeInstances(&ec2.DescribeInstancesInput{})
+	require.NoError(t, err)
+	require.Len(t, out.Reservations, 2)
+	for _, reservation := range out.Reservations {
+		for _, instance := range reservation.Instances {
+			if *instance.InstanceId != server.InstanceID {
+				require.Equal(t, ec2.InstanceStateNameTerminated, *instance.State.Name)
+			}
+		}
+	}
+}
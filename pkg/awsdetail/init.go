@@ -1,164 +1,706 @@
 package awsdetail
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/owengage/minecloud/pkg/mcconfig"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrNoSecurityGroup given if servers have no security group to run in.
+var ErrNoSecurityGroup = errors.New("no security group")
+
+// resource is a piece of infrastructure that Init creates and Deinit deletes.
+type resource struct {
+	kind   string // eg "s3 bucket"
+	name   string
+	region string // empty for global resources.
+
+	exists func() (bool, error)
+	create func() error // nil for resources Init leaves to others, eg servers.
+	delete func() error
+
+	// diff lists how an existing resource differs from how Init would create
+	// it, and update fixes that. Both are nil for resources that can't drift.
+	diff   func() ([]string, error)
+	update func() error
+
+	// protect gives a reason Deinit shouldn't delete the resource, if there
+	// is one. Nil if it is always deleted.
+	protect func() (string, error)
+}
+
+func (r resource) String() string {
+	if r.region == "" {
+		return r.kind + " " + r.name
+	}
+	return r.kind + " " + r.name + " in " + r.region
+}
+
+// Action is what Init or Deinit would do to a resource.
+type Action string
+
+// Actions, see Change.
+const (
+	ActionNone   Action = "none"
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	ActionKeep   Action = "keep" // Deinit won't delete it, see Change.Details.
 )
 
+// Change is what Init or Deinit would do to a resource, from comparing what
+// should exist with what does.
+type Change struct {
+	Action   Action
+	Resource string   // eg "s3 bucket my-bucket in eu-west-2"
+	Details  []string // how it differs for updates, why it's kept for keeps.
+
+	resource resource
+}
+
+// PlanInit lists what Init would do to every resource, in order. Resources
+// that are as they should be have ActionNone.
+func PlanInit(detail *Detail) ([]Change, error) {
+	changes := []Change{}
+
+	for _, r := range resources(detail) {
+		change := Change{Action: ActionNone, Resource: r.String(), resource: r}
+
+		exists, err := r.exists()
+		if err != nil {
+			return nil, fmt.Errorf("plan: %s: %w", r, err)
+		}
+
+		if !exists && r.create == nil {
+			continue
+		} else if !exists {
+			change.Action = ActionCreate
+		} else if r.diff != nil {
+			change.Details, err = r.diff()
+			if err != nil {
+				return nil, fmt.Errorf("plan: %s: %w", r, err)
+			}
+			if len(change.Details) > 0 {
+				change.Action = ActionUpdate
+			}
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// PlanDeinit lists what Deinit would do to every resource that exists, in
+// order.
+func PlanDeinit(detail *Detail) ([]Change, error) {
+	changes := []Change{}
+
+	rs := resources(detail)
+	for i := len(rs) - 1; i >= 0; i-- {
+		r := rs[i]
+		change := Change{Action: ActionDelete, Resource: r.String(), resource: r}
+
+		exists, err := r.exists()
+		if err != nil {
+			return nil, fmt.Errorf("plan: %s: %w", r, err)
+		}
+		if !exists {
+			continue
+		}
+
+		if r.protect != nil {
+			reason, err := r.protect()
+			if err != nil {
+				return nil, fmt.Errorf("plan: %s: %w", r, err)
+			}
+			if reason != "" {
+				change.Action = ActionKeep
+				change.Details = []string{reason}
+			}
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// Drift is the changes that aren't ActionNone. For PlanInit, this is how the
+// account differs from what Minecloud needs.
+func Drift(changes []Change) []Change {
+	drift := []Change{}
+	for _, change := range changes {
+		if change.Action != ActionNone {
+			drift = append(drift, change)
+		}
+	}
+	return drift
+}
+
+// Init creates the infrastructure Minecloud needs, and updates anything that
+// has drifted, see PlanInit. Run it again after adding regions to set them
+// up.
+//
+// The lambdas are created with placeholder code, deploy them with the release
+// scripts afterwards.
 func Init(detail *Detail) error {
-	// TODO: Key pair
-	// TODO: Security groups
-	// TODO: Lambdas? Doesn't really fit into being able to do 'minecloud init'.
-	// Maybe terraform or cloudformation is a better approach afterall. CDK?
-	if err := InitServerRole(detail); err != nil {
-		return err
+	changes, err := PlanInit(detail)
+	if err != nil {
+		return fmt.Errorf("init: %w", err)
+	}
+
+	for _, change := range changes {
+		switch change.Action {
+		case ActionCreate:
+			detail.Logger.Infof("creating %s", change.Resource)
+			err = change.resource.create()
+		case ActionUpdate:
+			detail.Logger.Infof("updating %s: %s", change.Resource, strings.Join(change.Details, ", "))
+			err = change.resource.update()
+		default:
+			detail.Logger.Infof("%s is up to date", change.Resource)
+		}
+
+		if err != nil {
+			return fmt.Errorf("init: %s %s: %w", change.Action, change.Resource, err)
+		}
 	}
 
 	return nil
 }
 
+// Deinit deletes the infrastructure Init creates, in reverse, see
+// PlanDeinit. Buckets that aren't empty are kept, so worlds are never
+// deleted, and nothing is deleted while worlds are claimed or running. Failures don't stop the rest being deleted, the first is returned.
 func Deinit(detail *Detail) error {
-	return DeinitServerRole(detail)
-}
-
-func InitServerRole(detail *Detail) error {
-	iamServ := iam.New(detail.Session)
-	detail.Logger.Info("creating role")
-	roleOut, err := iamServ.CreateRole(&iam.CreateRoleInput{
-		RoleName:    aws.String("Minecloud_ServerRole"),
-		Description: aws.String("Allows access to required services for a Minecloud server to function."),
-
-		AssumeRolePolicyDocument: aws.String(`{
-            "Version": "2012-10-17",
-            "Statement": [
-                {
-                    "Effect": "Allow",
-                    "Action": "sts:AssumeRole",
-                    "Principal": {"Service": "ec2.amazonaws.com"}
-                }
-            ]
-        }`),
-	})
+	changes, err := PlanDeinit(detail)
 	if err != nil {
-		return err
+		return fmt.Errorf("deinit: %w", err)
 	}
 
-	detail.Logger.Info("creating instance profile")
-	outInstProf, err := iamServ.CreateInstanceProfile(&iam.CreateInstanceProfileInput{
-		InstanceProfileName: roleOut.Role.RoleName,
-	})
-	if err != nil {
-		return err
+	var first error
+	for _, change := range changes {
+		if change.Action == ActionKeep {
+			detail.Logger.Warnf("keeping %s: %s", change.Resource, strings.Join(change.Details, ", "))
+			continue
+		}
+
+		detail.Logger.Infof("deleting %s", change.Resource)
+		err := change.resource.delete()
+		if err != nil {
+			detail.Logger.Errorf("failed to delete %s: %v", change.Resource, err)
+			if first == nil {
+				first = fmt.Errorf("deinit: %s: %w", change.Resource, err)
+			}
+		}
 	}
 
-	detail.Logger.Info("adding role to instance profile")
-	_, err = iamServ.AddRoleToInstanceProfile(&iam.AddRoleToInstanceProfileInput{
-		RoleName:            roleOut.Role.RoleName,
-		InstanceProfileName: outInstProf.InstanceProfile.InstanceProfileName,
-	})
-	if err != nil {
+	return first
+}
+
+// resources Minecloud needs, in the order they are created.
+func resources(detail *Detail) []resource {
+	main := detail.Main()
+	regions := main.Config.AllRegions()
+
+	rs := []resource{}
+	for _, region := range regions {
+		regional := main.In(region)
+		rs = append(rs, bucketResource(regional, regional.Config.Bucket))
+	}
+	// Deleting the key pair deletes the secret, so the bucket can go after.
+	rs = append(rs, bucketResource(main, main.Config.SecretsBucket, SSHKeySecret(main.Config.Config)), tableResource(main))
+
+	for _, region := range regions {
+		rs = append(rs, repositoryResource(main.In(region)))
+	}
+
+	// The key pair is created in the main region, then imported into the
+	// others so the same private key works everywhere.
+	for _, region := range regions {
+		rs = append(rs, keyPairResource(main.In(region)))
+	}
+
+	for _, region := range regions {
+		if region == main.Region() && main.Config.SecurityGroupID != "" {
+			continue // managed by the user.
+		}
+		rs = append(rs, securityGroupResource(main.In(region)))
+	}
+
+	rs = append(rs, iamResources(main)...)
+	rs = append(rs, lambdaResources(main)...)
+
+	for _, region := range regions {
+		rs = append(rs, wrapperSecretsResource(main.In(region)))
+	}
+
+	// Claimed and running worlds still need everything, eg to be brought
+	// down, so nothing is deleted while there are any.
+	inUse := worldsInUse(main)
+	for i := range rs {
+		rs[i].protect = protectAll(inUse, rs[i].protect)
+	}
+	return rs
+}
+
+// worldsInUse gives a reason if any world is claimed, or has an instance that
+// hasn't been terminated in any region. It only looks once.
+func worldsInUse(main *Detail) func() (string, error) {
+	var checked bool
+	var reason string
+
+	return func() (string, error) {
+		if checked {
+			return reason, nil
+		}
+
+		out, err := main.DynamoDB.Scan(&dynamodb.ScanInput{
+			TableName: aws.String(main.Config.TableName),
+			Limit:     aws.Int64(1),
+		})
+		if exists, err := found(err, dynamodb.ErrCodeResourceNotFoundException); err != nil {
+			return "", err
+		} else if exists && len(out.Items) > 0 {
+			reason = "worlds are claimed, see 'minecloud ls'"
+		}
+
+		for _, region := range main.Config.AllRegions() {
+			if reason != "" {
+				break
+			}
+
+			servers, err := GetRunning(main.In(region).EC2)
+			if err != nil {
+				return "", err
+			}
+			for _, server := range servers {
+				if server.InstanceState != ec2.InstanceStateNameTerminated {
+					reason = fmt.Sprintf("%s is %s in %s", server.InstanceID, server.InstanceState, region)
+					break
+				}
+			}
+		}
+
+		checked = true
+		return reason, nil
+	}
+}
+
+// protectAll combines protect hooks, giving the first reason.
+func protectAll(protects ...func() (string, error)) func() (string, error) {
+	return func() (string, error) {
+		for _, protect := range protects {
+			if protect == nil {
+				continue
+			}
+			reason, err := protect()
+			if err != nil || reason != "" {
+				return reason, err
+			}
+		}
+		return "", nil
+	}
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// found turns the error from looking something up into whether it exists,
+// given the error codes that mean it doesn't.
+func found(err error, notFound ...string) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		for _, code := range notFound {
+			if aerr.Code() == code {
+				return false, nil
+			}
+		}
+	}
+	return false, err
+}
+
+// bucketResource is a bucket that Deinit keeps if it has anything in it,
+// other than the given keys which Deinit deletes first.
+func bucketResource(detail *Detail, bucket string, deletedKeys ...string) resource {
+	return resource{
+		kind:   "s3 bucket",
+		name:   bucket,
+		region: detail.Region(),
+
+		exists: func() (bool, error) {
+			_, err := detail.S3.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+			return found(err, "NotFound", s3.ErrCodeNoSuchBucket)
+		},
+		create: func() error {
+			input := &s3.CreateBucketInput{Bucket: aws.String(bucket)}
+
+			// us-east-1 is the default, and can't be given as a constraint.
+			if detail.Region() != "us-east-1" {
+				input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+					LocationConstraint: aws.String(detail.Region()),
+				}
+			}
+
+			_, err := detail.S3.CreateBucket(input)
+			return err
+		},
+		delete: func() error {
+			_, err := detail.S3.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String(bucket)})
+			return err
+		},
+		protect: func() (string, error) {
+			out, err := detail.S3.ListObjectsV2(&s3.ListObjectsV2Input{
+				Bucket:  aws.String(bucket),
+				MaxKeys: aws.Int64(int64(len(deletedKeys) + 1)),
+			})
+			if err != nil {
+				return "", err
+			}
+			for _, object := range out.Contents {
+				if !containsKey(deletedKeys, aws.StringValue(object.Key)) {
+					return "not empty", nil
+				}
+			}
+			return "", nil
+		},
+	}
+}
+
+func tableResource(detail *Detail) resource {
+	table := detail.Config.TableName
+
+	return resource{
+		kind:   "dynamodb table",
+		name:   table,
+		region: detail.Region(),
+
+		exists: func() (bool, error) {
+			_, err := detail.DynamoDB.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(table)})
+			return found(err, dynamodb.ErrCodeResourceNotFoundException)
+		},
+		create: func() error {
+			_, err := detail.DynamoDB.CreateTable(&dynamodb.CreateTableInput{
+				TableName:   aws.String(table),
+				BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{AttributeName: aws.String("world"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+				},
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{AttributeName: aws.String("world"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+				},
+			})
+			if err != nil {
+				return err
+			}
+			return detail.DynamoDB.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: aws.String(table)})
+		},
+		delete: func() error {
+			_, err := detail.DynamoDB.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(table)})
+			return err
+		},
+	}
+}
+
+func repositoryResource(detail *Detail) resource {
+	return resource{
+		kind:   "ecr repository",
+		name:   wrapperRepository,
+		region: detail.Region(),
+
+		exists: func() (bool, error) {
+			_, err := detail.ECR.DescribeRepositories(&ecr.DescribeRepositoriesInput{
+				RepositoryNames: []*string{aws.String(wrapperRepository)},
+			})
+			return found(err, ecr.ErrCodeRepositoryNotFoundException)
+		},
+		create: func() error {
+			_, err := detail.ECR.CreateRepository(&ecr.CreateRepositoryInput{
+				RepositoryName: aws.String(wrapperRepository),
+			})
+			return err
+		},
+		delete: func() error {
+			// The images can be published again by release-server-wrapper.sh.
+			_, err := detail.ECR.DeleteRepository(&ecr.DeleteRepositoryInput{
+				RepositoryName: aws.String(wrapperRepository),
+				Force:          aws.Bool(true),
+			})
+			return err
+		},
+	}
+}
+
+// SSHKeySecret is where the key pair's private key is kept in the secrets
+// bucket.
+func SSHKeySecret(config mcconfig.Config) string {
+	return config.KeyPairName + ".pem"
+}
+
+func keyPairResource(detail *Detail) resource {
+	name := detail.Config.KeyPairName
+	main := detail.Main()
+
+	create := func() error {
+		key, err := getSSHKeySecret(main)
+		if err != nil {
+			return fmt.Errorf("key pair must be created in %s first: %w", main.Region(), err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return err
+		}
+
+		_, err = detail.EC2.ImportKeyPair(&ec2.ImportKeyPairInput{
+			KeyName:           aws.String(name),
+			PublicKeyMaterial: ssh.MarshalAuthorizedKey(signer.PublicKey()),
+		})
 		return err
 	}
+	if detail == main {
+		create = func() error { return createKeyPair(main) }
+	}
+
+	return resource{
+		kind:   "key pair",
+		name:   name,
+		region: detail.Region(),
 
-	detail.Logger.Info("creating server policy")
-	outPolicy, err := iamServ.CreatePolicy(&iam.CreatePolicyInput{
-		PolicyName:  aws.String("Minecloud_ServerPolicy"),
-		Description: aws.String("Allows a Minecloud server to access world/server files."),
-		PolicyDocument: aws.String(`{
-            "Version": "2012-10-17",
-            "Statement": [
-              {
-                "Effect": "Allow",
-                "Action": "s3:*",
-                "Resource": [
-                    "arn:aws:s3:::ogage-minecraft",
-                    "arn:aws:s3:::ogage-minecraft/*"
-                ]
-              },
-              {
-                "Effect": "Allow",
-                "Action": "ecr:GetAuthorizationToken",
-                "Resource": "*"
-              },
-              {
-                "Effect": "Allow",
-                "Action": "ecr:*",
-                "Resource": "arn:aws:ecr:eu-west-2:344791319371:repository/minecloud/server-wrapper"
-              }
-        	]
-        }`),
+		exists: func() (bool, error) {
+			_, err := detail.EC2.DescribeKeyPairs(&ec2.DescribeKeyPairsInput{
+				KeyNames: []*string{aws.String(name)},
+			})
+			return found(err, "InvalidKeyPair.NotFound")
+		},
+		create: create,
+		delete: func() error {
+			_, err := detail.EC2.DeleteKeyPair(&ec2.DeleteKeyPairInput{KeyName: aws.String(name)})
+			if err != nil || detail != main {
+				return err
+			}
+
+			_, err = main.S3.DeleteObject(&s3.DeleteObjectInput{
+				Bucket: aws.String(main.Config.SecretsBucket),
+				Key:    aws.String(SSHKeySecret(main.Config.Config)),
+			})
+			return err
+		},
+	}
+}
+
+// createKeyPair keeps the private key in the secrets bucket for the lambdas,
+// and at SSHPrivateKeyFile for the CLI if there isn't one there already.
+func createKeyPair(detail *Detail) error {
+	out, err := detail.EC2.CreateKeyPair(&ec2.CreateKeyPairInput{
+		KeyName: aws.String(detail.Config.KeyPairName),
 	})
 	if err != nil {
 		return err
 	}
+	key := []byte(aws.StringValue(out.KeyMaterial))
 
-	detail.Logger.Info("attaching policy to role")
-	_, err = iamServ.AttachRolePolicy(&iam.AttachRolePolicyInput{
-		PolicyArn: outPolicy.Policy.Arn,
-		RoleName:  roleOut.Role.RoleName,
+	_, err = detail.S3.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(detail.Config.SecretsBucket),
+		Key:    aws.String(SSHKeySecret(detail.Config.Config)),
+		Body:   bytes.NewReader(key),
 	})
 	if err != nil {
 		return err
 	}
 
-	return nil
+	path := detail.Config.SSHPrivateKeyFile
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		detail.Logger.Warnf("not overwriting %s, the new key is in s3 bucket %s", path, detail.Config.SecretsBucket)
+		return nil
+	}
+	return ioutil.WriteFile(path, key, 0600)
 }
 
-func DeinitServerRole(detail *Detail) error {
-	iamServ := iam.New(detail.Session)
-
-	outPolicies, err := iamServ.ListPolicies(&iam.ListPoliciesInput{
-		Scope: aws.String(iam.PolicyScopeTypeLocal),
+func getSSHKeySecret(detail *Detail) ([]byte, error) {
+	out, err := detail.S3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(detail.Config.SecretsBucket),
+		Key:    aws.String(SSHKeySecret(detail.Config.Config)),
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer out.Body.Close()
+
+	return ioutil.ReadAll(out.Body)
+}
 
-	for _, policy := range outPolicies.Policies {
-		if *policy.PolicyName == "Minecloud_ServerPolicy" {
+func securityGroupResource(detail *Detail) resource {
+	return resource{
+		kind:   "security group",
+		name:   securityGroupName,
+		region: detail.Region(),
 
-			detail.Logger.Info("detaching server policy")
-			_, err = iamServ.DetachRolePolicy(&iam.DetachRolePolicyInput{
-				PolicyArn: policy.Arn,
-				RoleName:  aws.String("Minecloud_ServerRole"),
+		exists: func() (bool, error) {
+			_, err := findSecurityGroup(detail)
+			if errors.Is(err, ErrNoSecurityGroup) {
+				return false, nil
+			}
+			return err == nil, err
+		},
+		create: func() error {
+			out, err := detail.EC2.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+				GroupName:   aws.String(securityGroupName),
+				Description: aws.String("Minecraft, SSH and the server wrapper API for Minecloud servers."),
 			})
 			if err != nil {
-				detail.Logger.Warn("error:", err)
+				return err
 			}
 
-			detail.Logger.Info("destroying server policy")
-			_, err := iamServ.DeletePolicy(&iam.DeletePolicyInput{
-				PolicyArn: policy.Arn,
-			})
+			return openPorts(detail, aws.StringValue(out.GroupId), serverPorts)
+		},
+		delete: func() error {
+			id, err := findSecurityGroup(detail)
 			if err != nil {
-				detail.Logger.Warn("error:", err)
+				return err
 			}
 
-			break
-		}
+			_, err = detail.EC2.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: aws.String(id)})
+			return err
+		},
+		diff: func() ([]string, error) {
+			_, closed, err := closedPorts(detail)
+			if err != nil {
+				return nil, err
+			}
+
+			details := []string{}
+			for _, port := range closed {
+				details = append(details, fmt.Sprintf("port %d isn't open", port))
+			}
+			return details, nil
+		},
+		update: func() error {
+			id, closed, err := closedPorts(detail)
+			if err != nil {
+				return err
+			}
+			return openPorts(detail, id, closed)
+		},
 	}
+}
 
-	detail.Logger.Info("detaching instance role")
-	iamServ.RemoveRoleFromInstanceProfile(&iam.RemoveRoleFromInstanceProfileInput{
-		InstanceProfileName: aws.String("Minecloud_ServerRole"),
-		RoleName:            aws.String("Minecloud_ServerRole"),
-	})
+// serverPorts are SSH, Minecraft and the wrapper API.
+var serverPorts = []int64{22, 25565, WrapperAPIPort}
 
-	detail.Logger.Info("destroying instance profile")
-	iamServ.DeleteInstanceProfile(&iam.DeleteInstanceProfileInput{
-		InstanceProfileName: aws.String("Minecloud_ServerRole"),
+// openPorts lets anyone connect to TCP ports in a security group.
+func openPorts(detail *Detail, id string, ports []int64) error {
+	permissions := []*ec2.IpPermission{}
+	for _, port := range ports {
+		permissions = append(permissions, &ec2.IpPermission{
+			IpProtocol: aws.String("tcp"),
+			FromPort:   aws.Int64(port),
+			ToPort:     aws.Int64(port),
+			IpRanges:   []*ec2.IpRange{{CidrIp: aws.String(anywhere)}},
+		})
+	}
+
+	_, err := detail.EC2.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(id),
+		IpPermissions: permissions,
 	})
+	return err
+}
+
+const anywhere = "0.0.0.0/0"
+
+// closedPorts finds the security group Init created, and which of the
+// server ports anyone can't connect to.
+func closedPorts(detail *Detail) (string, []int64, error) {
+	id, err := findSecurityGroup(detail)
 	if err != nil {
-		detail.Logger.Warn("error:", err)
+		return "", nil, err
 	}
 
-	detail.Logger.Info("destroying role")
-	_, err = iamServ.DeleteRole(&iam.DeleteRoleInput{
-		RoleName: aws.String("Minecloud_ServerRole"),
+	out, err := detail.EC2.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String(id)},
 	})
 	if err != nil {
-		detail.Logger.Warn("error:", err)
+		return "", nil, err
 	}
 
-	return nil
+	closed := []int64{}
+	for _, port := range serverPorts {
+		open := false
+		for _, group := range out.SecurityGroups {
+			for _, perm := range group.IpPermissions {
+				if allowsFromAnywhere(perm, port) {
+					open = true
+				}
+			}
+		}
+		if !open {
+			closed = append(closed, port)
+		}
+	}
+	return id, closed, nil
+}
+
+func allowsFromAnywhere(perm *ec2.IpPermission, port int64) bool {
+	protocol := aws.StringValue(perm.IpProtocol)
+	if protocol != "tcp" && protocol != "-1" {
+		return false
+	}
+	if protocol == "tcp" && (aws.Int64Value(perm.FromPort) > port || aws.Int64Value(perm.ToPort) < port) {
+		return false
+	}
+	for _, r := range perm.IpRanges {
+		if aws.StringValue(r.CidrIp) == anywhere {
+			return true
+		}
+	}
+	return false
+}
+
+// securityGroupID is the security group servers run in: the configured one
+// in the main region, otherwise the one Init created.
+func securityGroupID(detail *Detail) (string, error) {
+	if detail.Config.SecurityGroupID != "" && detail == detail.Main() {
+		return detail.Config.SecurityGroupID, nil
+	}
+	return findSecurityGroup(detail)
+}
+
+func findSecurityGroup(detail *Detail) (string, error) {
+	out, err := detail.EC2.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("group-name"), Values: []*string{aws.String(securityGroupName)}},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(out.SecurityGroups) == 0 {
+		return "", fmt.Errorf("%w in %s, run 'minecloud init'", ErrNoSecurityGroup, detail.Region())
+	}
+	return aws.StringValue(out.SecurityGroups[0].GroupId), nil
 }
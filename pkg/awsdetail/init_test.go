@@ -0,0 +1,271 @@
+package awsdetail_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/fakeaws"
+	"github.com/owengage/minecloud/pkg/mcconfig"
+	"github.com/stretchr/testify/require"
+)
+
+func newEmptyDetail(t *testing.T) (*fakeaws.Services, *awsdetail.Detail, string) {
+	dir, err := ioutil.TempDir("", "minecloud-init")
+	require.NoError(t, err)
+
+	fakes := fakeaws.New()
+	detail := fakes.Detail(awsdetail.Config{
+		Config: mcconfig.Config{
+			Regions:          "us-east-1",
+			HostedZoneID:     zoneID,
+			HostedZoneSuffix: "example.com.",
+			Bucket:           "ogage-minecraft",
+		},
+		SSHPrivateKeyFile: filepath.Join(dir, "MinecraftServerKeyPair.pem"),
+	})
+	return fakes, detail, dir
+}
+
+func bucketExists(fakes *fakeaws.Services, bucket string) bool {
+	_, err := fakes.S3.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	return err == nil
+}
+
+func TestInit(t *testing.T) {
+	fakes, detail, dir := newEmptyDetail(t)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, awsdetail.Init(detail))
+
+	for _, bucket := range []string{"ogage-minecraft", "ogage-minecraft-us-east-1", "ogage-minecraft-secrets"} {
+		require.True(t, bucketExists(fakes, bucket), bucket)
+	}
+
+	_, err := fakes.DynamoDB.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String("MinecloudServers")})
+	require.NoError(t, err)
+
+	key, err := ioutil.ReadFile(filepath.Join(dir, "MinecraftServerKeyPair.pem"))
+	require.NoError(t, err)
+	require.Equal(t, key, fakes.S3.Get("ogage-minecraft-secrets", "MinecraftServerKeyPair.pem"))
+
+	for _, region := range []string{fakeaws.Region, "us-east-1"} {
+		require.NotNil(t, fakes.ECR.Repository(region, "minecloud/server-wrapper"), region)
+		require.NotNil(t, fakes.EC2.KeyPair(region, "MinecraftServerKeyPair"), region)
+
+		group := fakes.EC2.SecurityGroup(region, "Minecloud_Servers")
+		require.NotNil(t, group, region)
+		require.Len(t, group.IpPermissions, 3)
+	}
+
+	document, roles := fakes.IAM.PolicyDocument("Minecloud_ServerPolicy")
+	require.Contains(t, document, "arn:aws:s3:::ogage-minecraft-us-east-1")
+	require.Contains(t, document, `"ssm:resourceTag/MinecloudInstance": "${ec2:SourceInstanceARN}"`)
+	require.Equal(t, []string{"Minecloud_ServerRole"}, roles)
+	require.Len(t, fakes.IAM.InstanceProfile("Minecloud_ServerRole").Roles, 1)
+
+	_, roles = fakes.IAM.PolicyDocument("Minecloud_LambdaPolicy")
+	require.Equal(t, []string{"Minecloud_LambdaRole"}, roles)
+
+	for _, name := range []string{"MinecloudSingleton", "MinecraftCommand", "MinecloudBackup"} {
+		fn := fakes.Lambda.Function(name)
+		require.NotNil(t, fn, name)
+		require.Equal(t, "arn:aws:iam::"+fakeaws.Account+":role/Minecloud_LambdaRole", *fn.Role)
+		require.Equal(t, "ogage-minecraft", *fn.Environment.Variables["MINECLOUD_BUCKET"])
+	}
+
+	// Everything exists, so nothing is created again.
+	require.NoError(t, awsdetail.Init(detail))
+}
+
+func TestInitConfiguredSecurityGroup(t *testing.T) {
+	fakes, detail, dir := newEmptyDetail(t)
+	defer os.RemoveAll(dir)
+	detail.Config.SecurityGroupID = "sg-fake"
+
+	require.NoError(t, awsdetail.Init(detail))
+	require.Nil(t, fakes.EC2.SecurityGroup(fakeaws.Region, "Minecloud_Servers"))
+	require.NotNil(t, fakes.EC2.SecurityGroup("us-east-1", "Minecloud_Servers"))
+}
+
+func TestDeinit(t *testing.T) {
+	fakes, detail, dir := newEmptyDetail(t)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, awsdetail.Init(detail))
+	fakes.S3.Put("ogage-minecraft", "worlds/cliff/level.dat", []byte("level"))
+
+	// Left by a server that was never released.
+	_, err := fakes.SSM.PutParameter(&ssm.PutParameterInput{
+		Name:  aws.String("/minecloud/wrapper/cliff/token"),
+		Value: aws.String("token"),
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, awsdetail.Deinit(detail))
+	require.Nil(t, fakes.SSM.Parameter("/minecloud/wrapper/cliff/token"))
+
+	// Worlds are kept.
+	require.True(t, bucketExists(fakes, "ogage-minecraft"))
+	require.False(t, bucketExists(fakes, "ogage-minecraft-us-east-1"))
+	require.False(t, bucketExists(fakes, "ogage-minecraft-secrets"))
+
+	_, err = fakes.DynamoDB.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String("MinecloudServers")})
+	require.Error(t, err)
+
+	for _, region := range []string{fakeaws.Region, "us-east-1"} {
+		require.Nil(t, fakes.ECR.Repository(region, "minecloud/server-wrapper"), region)
+		require.Nil(t, fakes.EC2.KeyPair(region, "MinecraftServerKeyPair"), region)
+		require.Nil(t, fakes.EC2.SecurityGroup(region, "Minecloud_Servers"), region)
+	}
+
+	require.Nil(t, fakes.IAM.Role("Minecloud_ServerRole"))
+	require.Nil(t, fakes.IAM.Role("Minecloud_LambdaRole"))
+	require.Nil(t, fakes.IAM.InstanceProfile("Minecloud_ServerRole"))
+	require.Nil(t, fakes.Lambda.Function("MinecloudSingleton"))
+
+	// Nothing left to delete.
+	require.NoError(t, awsdetail.Deinit(detail))
+}
+
+func TestDeinitKeepsEverythingInUse(t *testing.T) {
+	fakes, detail, dir := newEmptyDetail(t)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, awsdetail.Init(detail))
+	claim(t, detail, "cliff")
+
+	changes, err := awsdetail.PlanDeinit(detail)
+	require.NoError(t, err)
+	for _, change := range changes {
+		require.Equal(t, awsdetail.ActionKeep, change.Action, change.Resource)
+	}
+
+	require.NoError(t, awsdetail.Deinit(detail))
+	require.NotNil(t, fakes.Lambda.Function("MinecloudSingleton"))
+	require.NotNil(t, fakes.EC2.KeyPair("us-east-1", "MinecraftServerKeyPair"))
+
+	// An instance still running without a claim needs them too.
+	require.NoError(t, awsdetail.UnclaimWorld(detail, "cliff"))
+	_, err = fakes.EC2.InRegion("us-east-1").RunInstances(&ec2.RunInstancesInput{
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: aws.String("instance"),
+			Tags:         []*ec2.Tag{{Key: aws.String("MinecraftServerName"), Value: aws.String("cliff")}},
+		}},
+	})
+	require.NoError(t, err)
+
+	changes, err = awsdetail.PlanDeinit(detail)
+	require.NoError(t, err)
+	require.Equal(t, awsdetail.ActionKeep, actions(changes)["dynamodb table MinecloudServers in "+fakeaws.Region])
+	require.Contains(t, changes[0].Details[0], "in us-east-1")
+}
+
+func actions(changes []awsdetail.Change) map[string]awsdetail.Action {
+	m := map[string]awsdetail.Action{}
+	for _, change := range changes {
+		m[change.Resource] = change.Action
+	}
+	return m
+}
+
+func TestPlanInit(t *testing.T) {
+	fakes, detail, dir := newEmptyDetail(t)
+	defer os.RemoveAll(dir)
+
+	changes, err := awsdetail.PlanInit(detail)
+	require.NoError(t, err)
+	require.NotEmpty(t, changes)
+	for _, change := range changes {
+		require.Equal(t, awsdetail.ActionCreate, change.Action, change.Resource)
+	}
+
+	// Planning changes nothing.
+	require.False(t, bucketExists(fakes, "ogage-minecraft"))
+
+	require.NoError(t, awsdetail.Init(detail))
+
+	changes, err = awsdetail.PlanInit(detail)
+	require.NoError(t, err)
+	require.Empty(t, awsdetail.Drift(changes))
+}
+
+func TestInitFixesDrift(t *testing.T) {
+	fakes, detail, dir := newEmptyDetail(t)
+	defer os.RemoveAll(dir)
+	require.NoError(t, awsdetail.Init(detail))
+
+	policyArn := "arn:aws:iam::" + fakeaws.Account + ":policy/Minecloud_ServerPolicy"
+	for i := 0; i < 4; i++ {
+		_, err := fakes.IAM.CreatePolicyVersion(&iam.CreatePolicyVersionInput{
+			PolicyArn:      aws.String(policyArn),
+			PolicyDocument: aws.String(`{"Version": "2012-10-17", "Statement": []}`),
+			SetAsDefault:   aws.Bool(true),
+		})
+		require.NoError(t, err)
+	}
+
+	_, err := fakes.Lambda.UpdateFunctionConfiguration(&lambda.UpdateFunctionConfigurationInput{
+		FunctionName: aws.String("MinecloudBackup"),
+		Environment: &lambda.Environment{
+			Variables: map[string]*string{"MINECLOUD_BUCKET": aws.String("another-bucket")},
+		},
+	})
+	require.NoError(t, err)
+
+	group := fakes.EC2.SecurityGroup("us-east-1", "Minecloud_Servers")
+	group.IpPermissions = group.IpPermissions[:2]
+
+	drift, err := awsdetail.PlanInit(detail)
+	require.NoError(t, err)
+	drift = awsdetail.Drift(drift)
+	require.Equal(t, map[string]awsdetail.Action{
+		"iam policy Minecloud_ServerPolicy":             awsdetail.ActionUpdate,
+		"lambda MinecloudBackup in " + fakeaws.Region:   awsdetail.ActionUpdate,
+		"security group Minecloud_Servers in us-east-1": awsdetail.ActionUpdate,
+	}, actions(drift))
+
+	require.NoError(t, awsdetail.Init(detail))
+
+	changes, err := awsdetail.PlanInit(detail)
+	require.NoError(t, err)
+	require.Empty(t, awsdetail.Drift(changes))
+
+	document, _ := fakes.IAM.PolicyDocument("Minecloud_ServerPolicy")
+	require.Contains(t, document, "arn:aws:s3:::ogage-minecraft-us-east-1")
+	require.Equal(t, "ogage-minecraft", *fakes.Lambda.Function("MinecloudBackup").Environment.Variables["MINECLOUD_BUCKET"])
+	require.Len(t, group.IpPermissions, 3)
+}
+
+func TestPlanDeinit(t *testing.T) {
+	fakes, detail, dir := newEmptyDetail(t)
+	defer os.RemoveAll(dir)
+
+	changes, err := awsdetail.PlanDeinit(detail)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	require.NoError(t, awsdetail.Init(detail))
+	fakes.S3.Put("ogage-minecraft", "worlds/cliff/level.dat", []byte("level"))
+
+	changes, err = awsdetail.PlanDeinit(detail)
+	require.NoError(t, err)
+
+	planned := actions(changes)
+	require.Equal(t, awsdetail.ActionKeep, planned["s3 bucket ogage-minecraft in "+fakeaws.Region])
+	require.Equal(t, awsdetail.ActionDelete, planned["s3 bucket ogage-minecraft-secrets in "+fakeaws.Region])
+	require.Equal(t, awsdetail.ActionDelete, planned["lambda MinecloudSingleton in "+fakeaws.Region])
+
+	// Deleted in reverse.
+	require.Equal(t, "lambda MinecloudBackup in "+fakeaws.Region, changes[0].Resource)
+	require.True(t, bucketExists(fakes, "ogage-minecraft-secrets"))
+}
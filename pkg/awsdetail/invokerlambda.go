@@ -1,21 +1,40 @@
 package awsdetail
 
 import (
+	"fmt"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
 )
 
 // LambdaInvoker invokes lambda functions on AWS.
 type LambdaInvoker struct {
-	LS *lambda.Lambda
+	LS lambdaiface.LambdaAPI
+
+	// Wait for the function to finish, and fail if it did. Otherwise the
+	// function is only queued to run.
+	Wait bool
 }
 
 // Invoke lambda function.
 func (invoker *LambdaInvoker) Invoke(name string, payload []byte) error {
-	_, err := invoker.LS.Invoke(&lambda.InvokeInput{
+	invocationType := lambda.InvocationTypeEvent
+	if invoker.Wait {
+		invocationType = lambda.InvocationTypeRequestResponse
+	}
+
+	out, err := invoker.LS.Invoke(&lambda.InvokeInput{
 		FunctionName:   &name,
-		InvocationType: aws.String(lambda.InvocationTypeEvent),
+		InvocationType: aws.String(invocationType),
 		Payload:        payload,
 	})
-	return err
+	if err != nil {
+		return err
+	}
+
+	if out.FunctionError != nil {
+		return fmt.Errorf("%s failed: %s: %s", name, *out.FunctionError, out.Payload)
+	}
+	return nil
 }
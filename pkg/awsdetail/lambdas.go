@@ -0,0 +1,198 @@
+package awsdetail
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/mcconfig"
+)
+
+// lambdaFunction is one of the lambdas in the lambdas directory.
+type lambdaFunction struct {
+	name    string
+	timeout int64 // seconds
+}
+
+var lambdaFunctions = []lambdaFunction{
+	{name: "MinecloudSingleton", timeout: 30},
+	{name: "MinecraftCommand", timeout: int64(backend.OperationTimeout / time.Second)},
+	{name: "MinecloudWeb", timeout: 90}, // saving waits up to a minute.
+	{name: "MinecloudBackup", timeout: 900},
+}
+
+// roleRetries is how many times to try creating a function while its newly
+// created role propagates, waiting roleRetryDelay between tries.
+const roleRetries = 10
+
+var roleRetryDelay = 5 * time.Second
+
+func lambdaResources(detail *Detail) []resource {
+	rs := []resource{}
+	for _, fn := range lambdaFunctions {
+		rs = append(rs, functionResource(detail, fn))
+	}
+	return rs
+}
+
+func functionResource(detail *Detail, fn lambdaFunction) resource {
+	return resource{
+		kind:   "lambda",
+		name:   fn.name,
+		region: detail.Region(),
+
+		exists: func() (bool, error) {
+			_, err := detail.Lambda.GetFunction(&lambda.GetFunctionInput{FunctionName: aws.String(fn.name)})
+			return found(err, lambda.ErrCodeResourceNotFoundException)
+		},
+		create: func() error {
+			role, err := lambdaRole(detail)
+			if err != nil {
+				return err
+			}
+
+			code, err := placeholderCode()
+			if err != nil {
+				return err
+			}
+
+			input := &lambda.CreateFunctionInput{
+				FunctionName: aws.String(fn.name),
+				Runtime:      aws.String(lambda.RuntimeGo1X),
+				Handler:      aws.String("main"),
+				Role:         aws.String(role),
+				Timeout:      aws.Int64(fn.timeout),
+				Code:         &lambda.FunctionCode{ZipFile: code},
+				Environment: &lambda.Environment{
+					Variables: lambdaEnvironment(detail.Config.Config),
+				},
+			}
+
+			for try := 1; ; try++ {
+				_, err = detail.Lambda.CreateFunction(input)
+				aerr, ok := err.(awserr.Error)
+				if !ok || aerr.Code() != lambda.ErrCodeInvalidParameterValueException || try == roleRetries {
+					return err
+				}
+
+				detail.Logger.Infof("waiting for %s to be assumable: %v", lambdaRoleName, err)
+				time.Sleep(roleRetryDelay)
+			}
+		},
+		delete: func() error {
+			_, err := detail.Lambda.DeleteFunction(&lambda.DeleteFunctionInput{FunctionName: aws.String(fn.name)})
+			return err
+		},
+		diff: func() ([]string, error) {
+			role, err := lambdaRole(detail)
+			if err != nil {
+				return nil, err
+			}
+
+			out, err := detail.Lambda.GetFunction(&lambda.GetFunctionInput{FunctionName: aws.String(fn.name)})
+			if err != nil {
+				return nil, err
+			}
+			config := out.Configuration
+
+			details := []string{}
+			if aws.Int64Value(config.Timeout) != fn.timeout {
+				details = append(details, fmt.Sprintf("timeout is %ds, should be %ds", aws.Int64Value(config.Timeout), fn.timeout))
+			}
+			if aws.StringValue(config.Role) != role {
+				details = append(details, fmt.Sprintf("role is %s, should be %s", aws.StringValue(config.Role), role))
+			}
+
+			var env map[string]*string
+			if config.Environment != nil {
+				env = config.Environment.Variables
+			}
+			for _, key := range envDiff(env, lambdaEnvironment(detail.Config.Config)) {
+				details = append(details, key+" differs from config")
+			}
+			return details, nil
+		},
+		update: func() error {
+			role, err := lambdaRole(detail)
+			if err != nil {
+				return err
+			}
+
+			// Replaces the whole configuration, so only lambdas that have
+			// drifted are updated.
+			_, err = detail.Lambda.UpdateFunctionConfiguration(&lambda.UpdateFunctionConfigurationInput{
+				FunctionName: aws.String(fn.name),
+				Role:         aws.String(role),
+				Timeout:      aws.Int64(fn.timeout),
+				Environment: &lambda.Environment{
+					Variables: lambdaEnvironment(detail.Config.Config),
+				},
+			})
+			return err
+		},
+	}
+}
+
+// lambdaRole is the ARN of the role the lambdas run as.
+func lambdaRole(detail *Detail) (string, error) {
+	account, err := detail.Account()
+	return "arn:aws:iam::" + account + ":role/" + lambdaRoleName, err
+}
+
+// envDiff lists the variables that differ between two environments, sorted.
+func envDiff(have, want map[string]*string) []string {
+	keys := []string{}
+	for key, value := range want {
+		if aws.StringValue(have[key]) != aws.StringValue(value) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range have {
+		if _, ok := want[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lambdaEnvironment passes the config to the lambdas, which only read it from
+// the environment.
+func lambdaEnvironment(config mcconfig.Config) map[string]*string {
+	env := map[string]*string{}
+	for _, field := range mcconfig.Fields {
+		if value, _ := config.Get(field.Key); value != "" {
+			env[field.Env] = aws.String(value)
+		}
+	}
+	return env
+}
+
+// placeholderCode is deployed until the release scripts deploy the real code.
+// It fails every invocation.
+func placeholderCode() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+
+	header := &zip.FileHeader{Name: "main", Method: zip.Deflate}
+	header.SetMode(0755)
+
+	w, err := archive.CreateHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write([]byte("#!/bin/sh\necho 'not deployed yet, run the release scripts' >&2\nexit 1\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	err = archive.Close()
+	return buf.Bytes(), err
+}
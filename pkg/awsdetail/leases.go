@@ -0,0 +1,297 @@
+package awsdetail
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/minecloud"
+)
+
+// Claims are items in the claims table keyed by world. Leases are stored as
+// attributes of the claim, alongside the wrapper API credentials. Claims from
+// before leases have no token and never expire, so they can be taken over
+// once no server is running the world.
+
+func leaseItem(lease backend.Lease) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"world":        {S: aws.String(string(lease.World))},
+		"leaseOwner":   {S: aws.String(lease.Owner)},
+		"leaseExpires": timeValue(lease.Expires),
+		"leaseToken":   tokenValue(lease.Token),
+		"leaseState":   {S: aws.String(string(lease.State))},
+		"stateChanged": timeValue(lease.StateChanged),
+	}
+}
+
+func leaseFromItem(item map[string]*dynamodb.AttributeValue) (backend.Lease, error) {
+	lease := backend.Lease{
+		World: minecloud.World(aws.StringValue(item["world"].S)),
+	}
+	if owner, ok := item["leaseOwner"]; ok {
+		lease.Owner = aws.StringValue(owner.S)
+	}
+	if id, ok := item["instanceId"]; ok {
+		lease.InstanceID = aws.StringValue(id.S)
+	}
+	if state, ok := item["leaseState"]; ok {
+		lease.State = backend.WorldState(aws.StringValue(state.S))
+	}
+	if operation, ok := item["leaseOperation"]; ok {
+		lease.Operation = aws.StringValue(operation.S)
+	}
+	if step, ok := item["leaseStep"]; ok {
+		lease.Step = aws.StringValue(step.S)
+	}
+
+	var err error
+	lease.Expires, err = timeFromItem(item, "leaseExpires")
+	if err != nil {
+		return lease, fmt.Errorf("lease expiry: %w", err)
+	}
+	lease.StateChanged, err = timeFromItem(item, "stateChanged")
+	if err != nil {
+		return lease, fmt.Errorf("lease state change: %w", err)
+	}
+
+	if token, ok := item["leaseToken"]; ok {
+		var err error
+		lease.Token, err = strconv.ParseInt(aws.StringValue(token.N), 10, 64)
+		if err != nil {
+			return lease, fmt.Errorf("lease token: %w", err)
+		}
+	}
+	return lease, nil
+}
+
+func tokenValue(token int64) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(token, 10))}
+}
+
+// timeValue stores times to the second, as unix time.
+func timeValue(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.Unix(), 10))}
+}
+
+// timeFromItem is zero if the item doesn't have the attribute.
+func timeFromItem(item map[string]*dynamodb.AttributeValue, name string) (time.Time, error) {
+	value, ok := item[name]
+	if !ok {
+		return time.Time{}, nil
+	}
+
+	seconds, err := strconv.ParseInt(aws.StringValue(value.N), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// isConditionFailed is whether err is a failed conditional write.
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// GetLease gets a world's current lease. ErrWorldNotClaimed if it isn't
+// claimed.
+func GetLease(detail *Detail, world string) (backend.Lease, error) {
+	out, err := detail.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(detail.Config.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"world": {S: aws.String(world)},
+		},
+	})
+	if err != nil {
+		return backend.Lease{}, err
+	}
+	if out.Item == nil {
+		return backend.Lease{}, fmt.Errorf("%w: %s", ErrWorldNotClaimed, world)
+	}
+	return leaseFromItem(out.Item)
+}
+
+// TakeOverLease claims a world for owner in place of an expired lease, which
+// must not have changed since it was read. Check nothing is running the world
+// first, see backend.Claim.
+func TakeOverLease(detail *Detail, expired backend.Lease, owner string) (backend.Lease, error) {
+	detail.Logger.Info("taking over lease")
+
+	lease := backend.NewLease(expired.World, owner, expired.Token)
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(detail.Config.TableName),
+		Item:      leaseItem(lease),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": timeValue(time.Now()),
+		},
+	}
+	if expired.Token == 0 {
+		input.ConditionExpression = aws.String("attribute_exists(world) AND attribute_not_exists(leaseToken)")
+		input.ExpressionAttributeValues = nil
+	} else {
+		input.ConditionExpression = aws.String("leaseToken = :token AND leaseExpires <= :now")
+		input.ExpressionAttributeValues[":token"] = tokenValue(expired.Token)
+	}
+
+	_, err := detail.DynamoDB.PutItem(input)
+	if isConditionFailed(err) {
+		return backend.Lease{}, fmt.Errorf("%w: %s", ErrLeaseLost, expired.World)
+	}
+	if err != nil {
+		return backend.Lease{}, err
+	}
+	return lease, nil
+}
+
+// RenewLease extends a world's lease, as long as it still has the token.
+// The instance running the world is recorded unless instanceID is empty.
+func RenewLease(detail *Detail, world string, token int64, instanceID string) (backend.Lease, error) {
+	expires := time.Now().Add(backend.LeaseDuration)
+
+	update := "SET leaseExpires = :expires"
+	values := map[string]*dynamodb.AttributeValue{
+		":token":   tokenValue(token),
+		":expires": timeValue(expires),
+	}
+	if instanceID != "" {
+		update += ", instanceId = :id"
+		values[":id"] = &dynamodb.AttributeValue{S: aws.String(instanceID)}
+	}
+
+	out, err := detail.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(detail.Config.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"world": {S: aws.String(world)},
+		},
+		ConditionExpression:       aws.String("leaseToken = :token"),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if isConditionFailed(err) {
+		return backend.Lease{}, fmt.Errorf("%w: %s", ErrLeaseLost, world)
+	}
+	if err != nil {
+		return backend.Lease{}, err
+	}
+	return leaseFromItem(out.Attributes)
+}
+
+// ReleaseWorld unclaims a world, as long as the lease with the token still
+// holds it.
+func ReleaseWorld(detail *Detail, world string, token int64) error {
+	detail.Logger.Info("releasing")
+
+	_, err := detail.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(detail.Config.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"world": {S: aws.String(world)},
+		},
+		ConditionExpression:       aws.String("leaseToken = :token"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":token": tokenValue(token)},
+	})
+	if isConditionFailed(err) {
+		return fmt.Errorf("%w: %s", ErrLeaseLost, world)
+	}
+	if err != nil {
+		return err
+	}
+
+	removeWrapperSecrets(detail, world)
+	return nil
+}
+
+// SetWorldState moves a claimed world from one state to another, as long as
+// it's still in the from state. See backend.Transition for which moves are
+// allowed.
+func SetWorldState(detail *Detail, world string, from, to backend.WorldState) (backend.Lease, error) {
+	values := map[string]*dynamodb.AttributeValue{
+		":to":  {S: aws.String(string(to))},
+		":now": timeValue(time.Now()),
+	}
+
+	condition := "attribute_exists(world) AND attribute_not_exists(leaseState)"
+	if from != "" {
+		condition = "leaseState = :from"
+		values[":from"] = &dynamodb.AttributeValue{S: aws.String(string(from))}
+	}
+
+	out, err := detail.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(detail.Config.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"world": {S: aws.String(world)},
+		},
+		ConditionExpression:       aws.String(condition),
+		UpdateExpression:          aws.String("SET leaseState = :to, stateChanged = :now"),
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if isConditionFailed(err) {
+		return backend.Lease{}, fmt.Errorf("%w: %s is no longer %s", backend.ErrInvalidTransition, world, from)
+	}
+	if err != nil {
+		return backend.Lease{}, err
+	}
+	return leaseFromItem(out.Attributes)
+}
+
+// SetWorldStep records the last step of an operation completed on a world,
+// and renews its lease, as long as it still has the token. An empty step
+// records that the operation has started.
+func SetWorldStep(detail *Detail, world string, token int64, operation, step string) (backend.Lease, error) {
+	update := "SET leaseExpires = :expires, leaseOperation = :operation"
+	values := map[string]*dynamodb.AttributeValue{
+		":token":     tokenValue(token),
+		":expires":   timeValue(time.Now().Add(backend.LeaseDuration)),
+		":operation": {S: aws.String(operation)},
+	}
+	if step == "" {
+		update += " REMOVE leaseStep"
+	} else {
+		update += ", leaseStep = :step"
+		values[":step"] = &dynamodb.AttributeValue{S: aws.String(step)}
+	}
+
+	out, err := detail.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(detail.Config.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"world": {S: aws.String(world)},
+		},
+		ConditionExpression:       aws.String("leaseToken = :token"),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if isConditionFailed(err) {
+		return backend.Lease{}, fmt.Errorf("%w: %s", ErrLeaseLost, world)
+	}
+	if err != nil {
+		return backend.Lease{}, err
+	}
+	return leaseFromItem(out.Attributes)
+}
+
+// ListLeases lists the lease of every claimed world.
+func ListLeases(detail *Detail) ([]backend.Lease, error) {
+	leases := []backend.Lease{}
+
+	err := detail.DynamoDB.ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(detail.Config.TableName),
+	}, func(out *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range out.Items {
+			lease, err := leaseFromItem(item)
+			if err != nil {
+				detail.Logger.Warnf("skipping claim of %s: %v", lease.World, err)
+				continue
+			}
+			leases = append(leases, lease)
+		}
+		return true
+	})
+	return leases, err
+}
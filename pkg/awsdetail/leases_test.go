@@ -0,0 +1,174 @@
+package awsdetail_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenewLease(t *testing.T) {
+	_, detail := newFakeDetail(t)
+	lease := claim(t, detail, "cliff")
+
+	renewed, err := awsdetail.RenewLease(detail, "cliff", lease.Token, "i-123")
+	require.NoError(t, err)
+	require.Equal(t, "i-123", renewed.InstanceID)
+	require.Equal(t, lease.Token, renewed.Token)
+	require.Equal(t, "tester@host", renewed.Owner)
+
+	// The instance is kept if not given.
+	renewed, err = awsdetail.RenewLease(detail, "cliff", lease.Token, "")
+	require.NoError(t, err)
+	require.Equal(t, "i-123", renewed.InstanceID)
+
+	_, err = awsdetail.RenewLease(detail, "cliff", lease.Token+1, "")
+	require.True(t, errors.Is(err, awsdetail.ErrLeaseLost))
+}
+
+func TestReleaseWorld(t *testing.T) {
+	_, detail := newFakeDetail(t)
+	lease := claim(t, detail, "cliff")
+
+	err := awsdetail.ReleaseWorld(detail, "cliff", lease.Token+1)
+	require.True(t, errors.Is(err, awsdetail.ErrLeaseLost))
+
+	require.NoError(t, awsdetail.ReleaseWorld(detail, "cliff", lease.Token))
+
+	_, err = awsdetail.GetLease(detail, "cliff")
+	require.True(t, errors.Is(err, awsdetail.ErrWorldNotClaimed))
+}
+
+func expireLeases(t *testing.T) func() {
+	duration := backend.LeaseDuration
+	backend.LeaseDuration = -time.Minute
+	return func() { backend.LeaseDuration = duration }
+}
+
+func TestClaimUnexpiredLease(t *testing.T) {
+	_, detail := newFakeDetail(t)
+	b := awsdetail.NewBackend(detail)
+	claim(t, detail, "cliff")
+
+	_, err := backend.Claim(b, "cliff", "someone@else")
+	require.True(t, errors.Is(err, awsdetail.ErrWorldAlreadyClaimed))
+	require.Contains(t, err.Error(), "tester@host")
+}
+
+func TestClaimTakesOverExpiredLease(t *testing.T) {
+	_, detail := newFakeDetail(t)
+	b := awsdetail.NewBackend(detail)
+
+	defer expireLeases(t)()
+	stale := claim(t, detail, "cliff")
+
+	lease, err := backend.Claim(b, "cliff", "someone@else")
+	require.NoError(t, err)
+	require.Equal(t, "someone@else", lease.Owner)
+	require.True(t, lease.Token > stale.Token)
+
+	// The old holder is fenced out.
+	_, err = awsdetail.RenewLease(detail, "cliff", stale.Token, "")
+	require.True(t, errors.Is(err, awsdetail.ErrLeaseLost))
+	err = awsdetail.ReleaseWorld(detail, "cliff", stale.Token)
+	require.True(t, errors.Is(err, awsdetail.ErrLeaseLost))
+}
+
+func TestClaimExpiredLeaseStillRunning(t *testing.T) {
+	_, detail := newFakeDetail(t)
+	b := awsdetail.NewBackend(detail)
+
+	defer expireLeases(t)()
+	stale := claim(t, detail, "cliff")
+	require.NoError(t, backend.RunStored(b, "cliff", minecloud.UpOptions{}))
+
+	_, err := backend.Claim(b, "cliff", "someone@else")
+	require.True(t, errors.Is(err, awsdetail.ErrWorldAlreadyClaimed))
+	require.Contains(t, err.Error(), "still running")
+
+	lease, err := awsdetail.GetLease(detail, "cliff")
+	require.NoError(t, err)
+	require.Equal(t, stale.Token, lease.Token)
+	require.NotEmpty(t, lease.InstanceID)
+}
+
+func TestClaimExpiredLeaseStillStarting(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+	b := awsdetail.NewBackend(detail)
+
+	defer expireLeases(t)()
+	stale := claim(t, detail, "cliff")
+
+	// The lambda bringing it up died just after reserving a server.
+	id, err := awsdetail.ReserveInstance(detail, "cliff", minecloud.UpOptions{})
+	require.NoError(t, err)
+	fakes.EC2.SetStuck(id, true)
+
+	_, err = backend.Claim(b, "cliff", "someone@else")
+	require.True(t, errors.Is(err, awsdetail.ErrWorldAlreadyClaimed))
+	require.Contains(t, err.Error(), id+" is still pending")
+
+	lease, err := awsdetail.GetLease(detail, "cliff")
+	require.NoError(t, err)
+	require.Equal(t, stale.Token, lease.Token)
+}
+
+func TestRunStoredLostLease(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+	b := awsdetail.NewBackend(detail)
+	claim(t, detail, "cliff")
+
+	// Someone else claims the world while the server is being reserved.
+	b.Compute = &reclaimingCompute{Compute: b.Compute, detail: detail}
+
+	err := backend.RunStored(b, "cliff", minecloud.UpOptions{})
+	require.True(t, errors.Is(err, awsdetail.ErrLeaseLost))
+
+	servers, err := awsdetail.GetRunning(fakes.EC2)
+	require.NoError(t, err)
+	for _, server := range servers {
+		require.False(t, awsdetail.IsActiveInstanceState(server.InstanceState))
+	}
+}
+
+type reclaimingCompute struct {
+	backend.Compute
+	detail *awsdetail.Detail
+}
+
+func (c *reclaimingCompute) Reserve(world minecloud.World, opts minecloud.UpOptions) (string, error) {
+	id, err := c.Compute.Reserve(world, opts)
+	if err != nil {
+		return id, err
+	}
+
+	if err := awsdetail.UnclaimWorld(c.detail, string(world)); err != nil {
+		return id, err
+	}
+	_, err = awsdetail.ClaimWorld(c.detail, string(world), "someone@else")
+	return id, err
+}
+
+func TestSetWorldState(t *testing.T) {
+	_, detail := newFakeDetail(t)
+	lease := claim(t, detail, "cliff")
+	require.Equal(t, backend.StateProvisioning, lease.State)
+
+	running, err := awsdetail.SetWorldState(detail, "cliff", backend.StateProvisioning, backend.StateRunning)
+	require.NoError(t, err)
+	require.Equal(t, backend.StateRunning, running.State)
+	require.Equal(t, lease.Token, running.Token)
+
+	// Not in the from state any more.
+	_, err = awsdetail.SetWorldState(detail, "cliff", backend.StateProvisioning, backend.StateFailed)
+	require.True(t, errors.Is(err, backend.ErrInvalidTransition))
+
+	leases, err := awsdetail.ListLeases(detail)
+	require.NoError(t, err)
+	require.Len(t, leases, 1)
+	require.Equal(t, backend.StateRunning, leases[0].State)
+}
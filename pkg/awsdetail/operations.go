@@ -0,0 +1,64 @@
+package awsdetail
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/owengage/minecloud/pkg/backend"
+)
+
+// Operations are kept as JSON in the main bucket, under
+//
+//	operations/<id>.json
+func s3OperationKey(id string) string {
+	return "operations/" + id + ".json"
+}
+
+// PutOperation records an operation, replacing any with the same ID.
+func PutOperation(detail *Detail, op backend.Operation) error {
+	main := detail.Main()
+
+	body, err := json.MarshalIndent(op, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = main.S3.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(main.Config.Bucket),
+		Key:    aws.String(s3OperationKey(op.ID)),
+		Body:   bytes.NewReader(body),
+	})
+	if err != nil {
+		return fmt.Errorf("put operation: %w", err)
+	}
+	return nil
+}
+
+// GetOperation gets a recorded operation. ErrOperationNotFound if there's no
+// operation with the ID.
+func GetOperation(detail *Detail, id string) (backend.Operation, error) {
+	main := detail.Main()
+
+	out, err := main.S3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(main.Config.Bucket),
+		Key:    aws.String(s3OperationKey(id)),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return backend.Operation{}, fmt.Errorf("%w: %s", backend.ErrOperationNotFound, id)
+	}
+	if err != nil {
+		return backend.Operation{}, fmt.Errorf("get operation: %w", err)
+	}
+	defer out.Body.Close()
+
+	var op backend.Operation
+	err = json.NewDecoder(out.Body).Decode(&op)
+	if err != nil {
+		return op, fmt.Errorf("get operation %s: %w", id, err)
+	}
+	return op, nil
+}
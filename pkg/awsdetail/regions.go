@@ -0,0 +1,151 @@
+package awsdetail
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ErrUnknownRegion given for a region that isn't configured.
+var ErrUnknownRegion = errors.New("region not configured")
+
+// s3RegionKey records the region a world lives in, in the main bucket. Worlds
+// without one live in the main region.
+func s3RegionKey(world string) string {
+	return "regions/" + world
+}
+
+// WorldRegion is the region a world lives in: where it is stored, and where
+// servers for it are launched.
+func WorldRegion(detail *Detail, world string) (string, error) {
+	main := detail.Main()
+
+	out, err := main.S3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(main.Config.Bucket),
+		Key:    aws.String(s3RegionKey(world)),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return main.Region(), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("world region: %w", err)
+	}
+	defer out.Body.Close()
+
+	region, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return "", fmt.Errorf("world region: %w", err)
+	}
+	return strings.TrimSpace(string(region)), nil
+}
+
+// ForWorld returns the Detail for the region a world lives in.
+func (detail *Detail) ForWorld(world string) (*Detail, error) {
+	region, err := WorldRegion(detail, world)
+	if err != nil {
+		return nil, err
+	}
+	return detail.In(region), nil
+}
+
+// SetWorldRegion moves a world to another region, along with its server files
+// and backups. The world is claimed while it moves, so it can't be brought up
+// halfway through.
+func SetWorldRegion(detail *Detail, world, region string) error {
+	main := detail.Main()
+	if !main.Config.HasRegion(region) {
+		return fmt.Errorf("set world region: %w: %s", ErrUnknownRegion, region)
+	}
+
+	from, err := main.ForWorld(world)
+	if err != nil {
+		return err
+	}
+	to := main.In(region)
+	if from == to {
+		return nil
+	}
+
+	if _, err := ClaimWorld(main, world, "minecloud region"); err != nil {
+		return fmt.Errorf("set world region: %w", err)
+	}
+	defer func() {
+		if err := UnclaimWorld(main, world); err != nil {
+			main.Logger.Errorf("failed to unclaim %s after moving it: %v", world, err)
+		}
+	}()
+
+	for _, prefix := range []string{S3WorldPrefix(world), S3ServerPrefix(world)} {
+		if err := moveObjects(from, to, prefix+"/"); err != nil {
+			return fmt.Errorf("set world region: %w", err)
+		}
+	}
+
+	if region == main.Region() {
+		_, err = main.S3.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(main.Config.Bucket),
+			Key:    aws.String(s3RegionKey(world)),
+		})
+	} else {
+		_, err = main.S3.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(main.Config.Bucket),
+			Key:    aws.String(s3RegionKey(world)),
+			Body:   strings.NewReader(region),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("set world region: %w", err)
+	}
+
+	main.Logger.Infof("moved %s from %s to %s", world, from.Region(), region)
+	return nil
+}
+
+// moveObjects copies everything under prefix from one region's bucket to
+// another's, then deletes the originals.
+func moveObjects(from, to *Detail, prefix string) error {
+	objects, err := listObjects(from, prefix)
+	if err != nil {
+		return err
+	}
+
+	from.Logger.Infof("moving %d files under %s", len(objects), prefix)
+	for _, obj := range objects {
+		_, err = to.S3.CopyObject(&s3.CopyObjectInput{
+			Bucket:     aws.String(to.Config.Bucket),
+			Key:        obj.Key,
+			CopySource: aws.String((&url.URL{Path: from.Config.Bucket + "/" + *obj.Key}).EscapedPath()),
+		})
+		if err != nil {
+			return fmt.Errorf("copy %s: %w", *obj.Key, err)
+		}
+	}
+
+	return deleteObjects(from, objects)
+}
+
+// QualifyID makes an instance ID from this detail's region usable with any
+// Detail, by prefixing it with the region if it isn't the main one, eg
+// "us-east-1/i-0123456789".
+func (detail *Detail) QualifyID(instanceID string) string {
+	if detail.main == nil {
+		return instanceID
+	}
+	return detail.Region() + "/" + instanceID
+}
+
+// ResolveID splits an ID from QualifyID into the Detail for its region and
+// the plain instance ID.
+func (detail *Detail) ResolveID(id string) (*Detail, string) {
+	parts := strings.SplitN(id, "/", 2)
+	if len(parts) == 1 {
+		return detail.Main(), id
+	}
+	return detail.In(parts[0]), parts[1]
+}
@@ -0,0 +1,139 @@
+package awsdetail_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/fakeaws"
+	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/stretchr/testify/require"
+)
+
+func newMultiRegionDetail(t *testing.T) (*fakeaws.Services, *awsdetail.Detail) {
+	fakes, detail := newFakeDetail(t)
+	detail.Config.Regions = "us-east-1"
+
+	_, err := fakes.S3.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("ogage-minecraft-us-east-1")})
+	require.NoError(t, err)
+
+	return fakes, detail
+}
+
+func TestSetWorldRegion(t *testing.T) {
+	fakes, detail := newMultiRegionDetail(t)
+
+	region, err := awsdetail.WorldRegion(detail, "cliff")
+	require.NoError(t, err)
+	require.Equal(t, fakeaws.Region, region)
+
+	require.NoError(t, awsdetail.SetWorldRegion(detail, "cliff", "us-east-1"))
+
+	region, err = awsdetail.WorldRegion(detail, "cliff")
+	require.NoError(t, err)
+	require.Equal(t, "us-east-1", region)
+
+	require.Empty(t, fakes.S3.Keys("ogage-minecraft", "worlds/cliff/"))
+	require.Equal(t, []byte("level"), fakes.S3.Get("ogage-minecraft-us-east-1", "worlds/cliff/level.dat"))
+	require.Equal(t, []byte("motd=cliff"), fakes.S3.Get("ogage-minecraft-us-east-1", "servers/cliff/server.properties"))
+	require.Nil(t, fakes.DynamoDB.Item("MinecloudServers", "cliff"))
+
+	// And back again.
+	require.NoError(t, awsdetail.SetWorldRegion(detail, "cliff", fakeaws.Region))
+	require.Equal(t, []byte("level"), fakes.S3.Get("ogage-minecraft", "worlds/cliff/level.dat"))
+	require.Nil(t, fakes.S3.Get("ogage-minecraft", "regions/cliff"))
+}
+
+func TestSetWorldRegionUnknown(t *testing.T) {
+	_, detail := newMultiRegionDetail(t)
+
+	err := awsdetail.SetWorldRegion(detail, "cliff", "ap-south-1")
+	require.True(t, errors.Is(err, awsdetail.ErrUnknownRegion))
+}
+
+func TestSetWorldRegionClaimed(t *testing.T) {
+	fakes, detail := newMultiRegionDetail(t)
+	claim(t, detail, "cliff")
+
+	err := awsdetail.SetWorldRegion(detail, "cliff", "us-east-1")
+	require.True(t, errors.Is(err, awsdetail.ErrWorldAlreadyClaimed))
+	require.NotNil(t, fakes.S3.Get("ogage-minecraft", "worlds/cliff/level.dat"))
+}
+
+func TestRunStoredInWorldRegion(t *testing.T) {
+	fakes, detail := newMultiRegionDetail(t)
+	require.NoError(t, awsdetail.SetWorldRegion(detail, "cliff", "us-east-1"))
+
+	// The configured security group is only for the main region.
+	group, err := fakes.EC2.InRegion("us-east-1").CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+		GroupName: aws.String("Minecloud_Servers"),
+	})
+	require.NoError(t, err)
+
+	claim(t, detail, "cliff")
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+
+	// Nothing runs in the main region.
+	_, err = awsdetail.FindRunning(detail.EC2, "cliff")
+	require.True(t, errors.Is(err, awsdetail.ErrServerNotFound))
+
+	b := awsdetail.NewBackend(detail)
+	server, err := b.Compute.Find(minecloud.World("cliff"))
+	require.NoError(t, err)
+	require.Equal(t, "us-east-1", server.Region)
+
+	regional, id := detail.ResolveID(server.ID)
+	require.Equal(t, "us-east-1", regional.Region())
+	require.Equal(t, server.ID, "us-east-1/"+id)
+
+	input := fakes.EC2.RunInput(id)
+	require.Equal(t, fakeaws.BaseImageID, *input.ImageId)
+	require.Equal(t, []*string{group.GroupId}, input.SecurityGroupIds)
+
+	userData, err := base64.StdEncoding.DecodeString(*input.UserData)
+	require.NoError(t, err)
+	require.Contains(t, string(userData), `download -bucket "ogage-minecraft-us-east-1" -prefix "worlds/cliff"`)
+	require.Contains(t, string(userData), `-idle-lambda-region "eu-west-2"`)
+
+	servers, err := b.Compute.List()
+	require.NoError(t, err)
+	require.Len(t, servers, 1)
+	require.Equal(t, server.ID, servers[0].ID)
+
+	record := fakes.Route53.Record(zoneID, "cliff.example.com.", "A")
+	require.NotNil(t, record)
+	require.Equal(t, *server.Address, *record.ResourceRecords[0].Value)
+
+	require.NoError(t, awsdetail.StoreRunning(detail, "cliff"))
+	require.Nil(t, fakes.DynamoDB.Item("MinecloudServers", "cliff"))
+	require.Equal(t, ec2.InstanceStateNameShuttingDown, *fakes.EC2.Instance(id).State.Name)
+}
+
+func TestInConcurrently(t *testing.T) {
+	_, detail := newMultiRegionDetail(t)
+	detail.Config.Regions = "us-east-1,us-west-2"
+
+	var wg sync.WaitGroup
+	details := make([]*awsdetail.Detail, 20)
+	for i := range details {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			regional, _ := detail.ResolveID([]string{"us-east-1", "us-west-2"}[i%2] + "/i-0123")
+			details[i] = regional
+		}(i)
+	}
+	wg.Wait()
+
+	// Each region's Detail is only made once.
+	require.Same(t, detail.In("us-east-1"), details[0])
+	require.Same(t, detail.In("us-west-2"), details[1])
+	for i, regional := range details {
+		require.Same(t, details[i%2], regional)
+	}
+}
@@ -2,31 +2,163 @@ package awsdetail
 
 import (
 	"bytes"
+	"encoding/base64"
 	"text/template"
+	"time"
 )
 
+// BootstrapScript installs everything an instance needs to run the server
+// wrapper.
+const BootstrapScript = `
+	set -x
+	sudo yum update -y;
+	sudo yum install -y docker;
+	sudo systemctl enable docker;
+	sudo service docker start;
+	sudo usermod -a -G docker ec2-user;
+`
+
+// UserDataScriptOpts options for UserDataScript.
+type UserDataScriptOpts struct {
+	Region   string
+	Download DownloadScriptOpts
+	Start    StartWrapperScriptOpts
+
+	// SkipBootstrap for images that already have everything installed.
+	SkipBootstrap bool
+}
+
+// UserDataScript returns a script for EC2 user data that bootstraps the
+// instance, downloads the world and starts the server wrapper, so nothing has
+// to be run over SSH. Progress is reported in the BootStageTagKey tag.
+func UserDataScript(opts UserDataScriptOpts) string {
+	steps := []bootStep{}
+	if !opts.SkipBootstrap {
+		steps = append(steps, bootStep{BootStageBootstrapping, BootstrapScript})
+	}
+	steps = append(steps,
+		bootStep{BootStageDownloading, DownloadScript(opts.Download)},
+		bootStep{BootStageStarting, StartWrapperScript(opts.Start)},
+	)
+
+	return bootScript(opts.Region, steps)
+}
+
+// BakeScriptOpts options for BakeScript.
+type BakeScriptOpts struct {
+	AccountID string
+	Region    string
+}
+
+// BakeScript returns user data for an instance that will be made into an
+// image: everything is installed and the server wrapper image pulled, so
+// instances launched from it can skip bootstrapping.
+func BakeScript(opts BakeScriptOpts) string {
+	funcMap := template.FuncMap{
+		"wrapperImage": wrapperImage,
+	}
+
+	const templ = `
+	set -xe
+	` + ecrLogin
+
+	t := template.Must(template.New("pull").Funcs(funcMap).Parse(templ))
+	buf := &bytes.Buffer{}
+	t.Execute(buf, opts)
+
+	return bootScript(opts.Region, []bootStep{
+		{BootStageBootstrapping, BootstrapScript + javaScript},
+		{BootStagePulling, buf.String()},
+	})
+}
+
+// javaScript installs Java runtimes on the host, for running a server
+// outside the wrapper image when debugging.
+const javaScript = `
+	sudo amazon-linux-extras install -y java-openjdk11;
+	sudo yum install -y java-1.8.0-openjdk-headless;
+`
+
+type bootStep struct {
+	Stage  string
+	Script string
+}
+
+// bootScript runs each step in turn as user data, tagging the instance with
+// the stage it has reached.
+func bootScript(region string, steps []bootStep) string {
+	const templ = `#!/bin/bash
+	set -e
+
+	INSTANCE_ID=$(curl -s http://169.254.169.254/latest/meta-data/instance-id)
+
+	stage() {
+		aws ec2 create-tags --region "{{.Region}}" --resources "$INSTANCE_ID" --tags "Key={{.TagKey}},Value=$1"
+	}
+	trap 'stage {{.Failed}}' ERR
+
+	# Scripts work relative to the current directory.
+	cd "$(mktemp -d)"
+	{{range .Steps}}
+	stage {{.Stage}}
+	{{.Script}}
+	{{- end}}
+	stage {{.Ready}}
+	`
+
+	t := template.Must(template.New("userdata").Parse(templ))
+	buf := &bytes.Buffer{}
+	t.Execute(buf, map[string]interface{}{
+		"Region": region,
+		"TagKey": BootStageTagKey,
+		"Ready":  BootStageReady,
+		"Failed": BootStageFailed,
+		"Steps":  steps,
+	})
+
+	return buf.String()
+}
+
 // DownloadScriptOpts options for DownloadScript.
 type DownloadScriptOpts struct {
+	AccountID      string
+	Region         string
 	S3Bucket       string
 	S3WorldPrefix  string
 	S3ServerPrefix string
 }
 
+// ecrLogin logs docker in to our registry and pulls the server wrapper image,
+// which also holds worldsync. Needs .AccountID and .Region.
+const ecrLogin = `
+	# Log in to docker
+	# sed hack to remove an invalid argument, god knows why it's there.
+	$(aws ecr get-login --region "{{.Region}}" | sed 's/-e none//g')
+	
+	docker pull "{{wrapperImage .AccountID .Region}}"
+`
+
 // DownloadScript returns a script for running on an EC2 instance to download the world and server.
 func DownloadScript(opts DownloadScriptOpts) string {
 	funcMap := template.FuncMap{
-		"toS3Path": toS3Path,
+		"toS3Path":     toS3Path,
+		"wrapperImage": wrapperImage,
 	}
 
 	const templ = `
 	set -xe
-	
-	# Download the world
-	aws s3 cp --recursive "{{toS3Path .S3WorldPrefix}}/" "world/"
-	sudo mv "world/" "/"
+	` + ecrLogin + `
+	# Download the world, only fetching files that have changed
+	sudo mkdir -p /world
+	docker run --rm \
+		--volume /world:/world \
+		--env AWS_REGION="{{.Region}}" \
+		--entrypoint ./worldsync \
+		"{{wrapperImage .AccountID .Region}}" \
+		download -bucket "{{.S3Bucket}}" -prefix "{{.S3WorldPrefix}}" -dir /world
 
 	# Create server directory
-	aws s3 cp --recursive "{{toS3Path $.S3ServerPrefix}}/" "server/"
+	aws s3 cp --recursive "{{toS3Path $.S3Bucket $.S3ServerPrefix}}/" "server/"
 	sudo mv "server/" "/"
 	`
 
@@ -39,16 +171,23 @@ func DownloadScript(opts DownloadScriptOpts) string {
 
 // UploadScriptOpts options for UploadScript.
 type UploadScriptOpts struct {
+	AccountID      string
+	Region         string
 	S3Bucket       string
 	S3WorldPrefix  string
 	S3ServerPrefix string
 	ServerFiles    []string
+
+	// SkipWorld uploads only the server files, for when the wrapper uploads
+	// the world itself.
+	SkipWorld bool
 }
 
 // UploadScript returns a script for running on an EC2 instance to upload the world and server.
 func UploadScript(opts UploadScriptOpts) string {
 	funcMap := template.FuncMap{
-		"toS3Path": toS3Path,
+		"toS3Path":     toS3Path,
+		"wrapperImage": wrapperImage,
 	}
 
 	const templ = `
@@ -57,12 +196,19 @@ func UploadScript(opts UploadScriptOpts) string {
 	pushd /server
 	# We use '|| true' here because some files are read-only and can't be uploaded thanks to fabric, which causes a warning
 	# It seems aws s3 cp doesn't check the filter before trying to stat a thing.
-	aws s3 cp --recursive "." "{{toS3Path $.S3ServerPrefix}}/" --exclude "logs/*" --exclude ".fabric/*" --exclude ".mixin.out/*" || true
+	aws s3 cp --recursive "." "{{toS3Path $.S3Bucket $.S3ServerPrefix}}/" --exclude "logs/*" --exclude ".fabric/*" --exclude ".mixin.out/*" || true
 	popd
 
-	# Upload the world
-	cd /world
-	aws s3 cp --recursive "." "{{toS3Path $.S3WorldPrefix}}/"
+	{{- if not .SkipWorld}}
+
+	# Upload the world, only sending files that have changed
+	docker run --rm \
+		--volume /world:/world \
+		--env AWS_REGION="{{.Region}}" \
+		--entrypoint ./worldsync \
+		"{{wrapperImage .AccountID .Region}}" \
+		upload -bucket "{{.S3Bucket}}" -prefix "{{.S3WorldPrefix}}" -dir /world
+	{{- end}}
 	`
 
 	t := template.Must(template.New("upload").Funcs(funcMap).Parse(templ))
@@ -72,42 +218,115 @@ func UploadScript(opts UploadScriptOpts) string {
 	return buf.String()
 }
 
-// StartWrapperScriptOpts options for UploadScript.
+// StartWrapperScriptOpts options for StartWrapperScript.
 type StartWrapperScriptOpts struct {
-	AccountID string
-	Region    string
+	AccountID   string
+	Region      string
+	Bucket      string // for live uploads and snapshots.
+	World       string
+	IdleTimeout time.Duration // zero or less disables idle shutdown.
+	IdleGrace   time.Duration
+
+	// LambdaRegion is where the idle lambda is, if not in Region.
+	LambdaRegion string
+
+	// Spot watches for the instance being reclaimed, saving and uploading the
+	// world and releasing its claim before it is.
+	Spot bool
+
+	// LeaseToken is the world's lease, which the wrapper renews while it
+	// runs. Zero if the world isn't claimed.
+	LeaseToken int64
+
+	// API credentials. Without them the API is only reachable over SSH. The
+	// token and private key are fetched from SSM parameters "token" and "key"
+	// under APISecrets, so they are never in the script. They may only be put
+	// once the instance exists, so are waited for.
+	APISecrets string
+	APICert    []byte
+	APIPort    int
 }
 
 // StartWrapperScript returns a script for running on an EC2 instance to start the server wrapper.
 func StartWrapperScript(opts StartWrapperScriptOpts) string {
 
+	funcMap := template.FuncMap{
+		"wrapperImage": wrapperImage,
+		"base64":       base64.StdEncoding.EncodeToString,
+	}
+
 	const templ = `
 	set -xe
-	# Log in to docker
-	# sed hack to remove an invalid argument, god knows why it's there.
-	$(aws ecr get-login --region "{{.Region}}" | sed 's/-e none//g')
-	
-	docker pull "{{.AccountID}}.dkr.ecr.{{.Region}}.amazonaws.com/minecloud/server-wrapper:latest"
+	` + ecrLogin + `
+	{{- if .APISecrets}}
+	# Credentials for the HTTPS API.
+	sudo mkdir -p /etc/minecloud
+	sudo touch /etc/minecloud/tls.key /etc/minecloud/token
+	sudo chmod 600 /etc/minecloud/tls.key /etc/minecloud/token
+	echo '{{base64 .APICert}}' | base64 -d | sudo tee /etc/minecloud/tls.crt > /dev/null
+	set -o pipefail
+	secret() {
+		for try in $(seq 60); do
+			aws ssm get-parameter --region "{{.Region}}" --with-decryption --name "$1" \
+				--query Parameter.Value --output text && return
+			sleep 2
+		done
+		return 1
+	}
+	secret "{{.APISecrets}}/key" | sudo tee /etc/minecloud/tls.key > /dev/null
+	secret "{{.APISecrets}}/token" | sudo tee /etc/minecloud/token > /dev/null
+	{{- end}}
 
 	docker run -d \
 		--rm \
-		-p 8080:80 \
+		-p 127.0.0.1:8080:80 \
+		{{- if .APISecrets}}
+		-p {{.APIPort}}:443 \
+		--volume /etc/minecloud:/etc/minecloud:ro \
+		{{- end}}
 		-p 25565:25565 \
 		--name serverwrapper \
+		--env AWS_REGION="{{.Region}}" \
 		--volume /server:/server \
 		--volume /world:/world \
-		"{{.AccountID}}.dkr.ecr.{{.Region}}.amazonaws.com/minecloud/server-wrapper:latest" \
+		"{{wrapperImage .AccountID .Region}}" \
 		-world-dir /world \
-		-server-dir /server
+		-server-dir /server \
+		-world-name "{{.World}}" \
+		-bucket "{{.Bucket}}" \
+		{{- if .APISecrets}}
+		-tls-address 0.0.0.0:443 \
+		-tls-cert /etc/minecloud/tls.crt \
+		-tls-key /etc/minecloud/tls.key \
+		-token-file /etc/minecloud/token \
+		{{- end}}
+		{{- if gt .IdleTimeout 0}}
+		-idle-timeout "{{.IdleTimeout}}" \
+		-idle-grace "{{.IdleGrace}}" \
+		{{- end}}
+		{{- if .LambdaRegion}}
+		-idle-lambda-region "{{.LambdaRegion}}" \
+		{{- end}}
+		{{- if .Spot}}
+		-spot \
+		{{- end}}
+		{{- if .LeaseToken}}
+		-lease-token "{{.LeaseToken}}" \
+		{{- end}}
+		-idle-lambda MinecloudSingleton
 	`
 
-	t := template.Must(template.New("wrapper").Parse(templ))
+	t := template.Must(template.New("wrapper").Funcs(funcMap).Parse(templ))
 	buf := &bytes.Buffer{}
 	t.Execute(buf, opts)
 
 	return buf.String()
 }
 
-func toS3Path(key string) string {
-	return "s3://" + s3BucketName + "/" + key
+func wrapperImage(accountID, region string) string {
+	return accountID + ".dkr.ecr." + region + ".amazonaws.com/" + wrapperRepository + ":latest"
+}
+
+func toS3Path(bucket, key string) string {
+	return "s3://" + bucket + "/" + key
 }
@@ -2,27 +2,38 @@ package awsdetail
 
 import (
 	"testing"
+	"time"
 )
 
 // Doesn't actually test much, but lets me see the rendered scripts.
 
 func TestDownloadScript(t *testing.T) {
 	_ = DownloadScript(DownloadScriptOpts{
-		S3ServerPrefix: s3ServerPrefix("cliff"),
-		S3WorldPrefix:  s3WorldPrefix("cliff"),
+		AccountID:      "12345",
+		Region:         "eu-west-2",
+		S3Bucket:       "ogage-minecraft",
+		S3ServerPrefix: S3ServerPrefix("cliff"),
+		S3WorldPrefix:  S3WorldPrefix("cliff"),
 	})
 }
 
 func TestUploadScript(t *testing.T) {
 	_ = UploadScript(UploadScriptOpts{
-		S3ServerPrefix: s3ServerPrefix("cliff"),
-		S3WorldPrefix:  s3WorldPrefix("cliff"),
+		AccountID:      "12345",
+		Region:         "eu-west-2",
+		S3Bucket:       "ogage-minecraft",
+		S3ServerPrefix: S3ServerPrefix("cliff"),
+		S3WorldPrefix:  S3WorldPrefix("cliff"),
 	})
 }
 
 func TestStartWrapperScript(t *testing.T) {
 	_ = StartWrapperScript(StartWrapperScriptOpts{
-		AccountID: "12345",
-		Region:    "eu-west-2",
+		AccountID:   "12345",
+		Region:      "eu-west-2",
+		Bucket:      "ogage-minecraft",
+		World:       "cliff",
+		IdleTimeout: 30 * time.Minute,
+		IdleGrace:   15 * time.Minute,
 	})
 }
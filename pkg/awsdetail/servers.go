@@ -1,31 +1,43 @@
 package awsdetail
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/owengage/minecloud/pkg/backend"
 	"github.com/owengage/minecloud/pkg/minecloud"
 	"github.com/owengage/minecloud/pkg/serverwrapper"
+	"github.com/owengage/minecloud/pkg/worldsync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
 // ErrServerNotFound given if server isn't found on cloud
-var ErrServerNotFound error = errors.New("server not found")
+var ErrServerNotFound = backend.ErrServerNotFound
 
 // ErrWorldAlreadyClaimed given if a world is already claimed for a server.
-var ErrWorldAlreadyClaimed error = errors.New("world already claimed")
+var ErrWorldAlreadyClaimed = backend.ErrWorldAlreadyClaimed
 
 // ErrWorldNotClaimed given if a world is already NOT claimed for a server.
-var ErrWorldNotClaimed error = errors.New("world already not claimed")
+var ErrWorldNotClaimed = backend.ErrWorldNotClaimed
+
+// ErrLeaseLost given if a world has been claimed again since a lease was
+// taken.
+var ErrLeaseLost = backend.ErrLeaseLost
 
 // MCServer is a Minecraft server.
 type MCServer struct {
@@ -36,7 +48,7 @@ type MCServer struct {
 }
 
 // GetRunning gets the list of current Minecraft servers, including recently terminated.
-func GetRunning(svc *ec2.EC2) ([]MCServer, error) {
+func GetRunning(svc ec2iface.EC2API) ([]MCServer, error) {
 	serverFilter := &ec2.Filter{
 		Name: aws.String("tag-key"),
 		Values: []*string{
@@ -68,14 +80,32 @@ func GetRunning(svc *ec2.EC2) ([]MCServer, error) {
 	return servers, nil
 }
 
-func s3ServerPrefix(name string) string {
+// S3ServerPrefix is the key prefix a world's server files are stored under,
+// without a trailing slash.
+func S3ServerPrefix(name string) string {
 	return "servers/" + name
 }
 
-func s3WorldPrefix(name string) string {
+// S3WorldPrefix is the key prefix a world is stored under, without a trailing
+// slash.
+func S3WorldPrefix(name string) string {
 	return "worlds/" + name
 }
 
+// s3MetaPrefix is where Minecloud keeps its own files for a world, such as
+// snapshots. It is not downloaded with the world.
+func s3MetaPrefix(name string) string {
+	return S3WorldPrefix(name) + "/.minecloud"
+}
+
+// SnapshotTimeFormat is the time format used in snapshot names.
+const SnapshotTimeFormat = "20060102T150405Z"
+
+// SnapshotKey is the S3 key for a snapshot of a world taken at the given time.
+func SnapshotKey(name string, at time.Time) string {
+	return s3MetaPrefix(name) + "/snapshots/" + at.UTC().Format(SnapshotTimeFormat) + ".tar.gz"
+}
+
 // UpdateDNS of a world so that it can be accessed via domain name.
 func UpdateDNS(detail *Detail, ip string, world minecloud.World) error {
 	ipstruct := net.ParseIP(ip)
@@ -86,8 +116,7 @@ func UpdateDNS(detail *Detail, ip string, world minecloud.World) error {
 	// TODO sanity check the name.
 	subdomain := string(world) + "." + detail.Config.HostedZoneSuffix
 
-	r53 := route53.New(detail.Session)
-	_, err := r53.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+	_, err := detail.Route53.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
 		HostedZoneId: aws.String(detail.Config.HostedZoneID),
 		ChangeBatch: &route53.ChangeBatch{
 			Changes: []*route53.Change{
@@ -116,12 +145,80 @@ func UpdateDNS(detail *Detail, ip string, world minecloud.World) error {
 	return nil
 }
 
+// RemoveDNS deletes the world's record, if it has one.
+func RemoveDNS(detail *Detail, world minecloud.World) error {
+	subdomain := string(world) + "." + detail.Config.HostedZoneSuffix
+
+	out, err := detail.Route53.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(detail.Config.HostedZoneID),
+		StartRecordName: aws.String(subdomain),
+		StartRecordType: aws.String(route53.RRTypeA),
+		MaxItems:        aws.String("1"),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, set := range out.ResourceRecordSets {
+		if aws.StringValue(set.Type) != route53.RRTypeA || !sameRecordName(aws.StringValue(set.Name), subdomain) {
+			continue
+		}
+
+		_, err = detail.Route53.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+			HostedZoneId: aws.String(detail.Config.HostedZoneID),
+			ChangeBatch: &route53.ChangeBatch{
+				Changes: []*route53.Change{
+					{
+						Action:            aws.String(route53.ChangeActionDelete),
+						ResourceRecordSet: set,
+					},
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		detail.Logger.Infof("DNS removed: %s", subdomain)
+		return nil
+	}
+
+	detail.Logger.Infof("no DNS to remove: %s", subdomain)
+	return nil
+}
+
+// sameRecordName compares record names, which Route53 returns lower case and
+// fully qualified.
+func sameRecordName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
+
+// ListStored lists the worlds stored in the detail's bucket, sorted.
+func ListStored(detail *Detail) ([]string, error) {
+	worlds := []string{}
+	err := detail.S3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(detail.Config.Bucket),
+		Prefix:    aws.String(S3WorldPrefix("")),
+		Delimiter: aws.String("/"),
+	}, func(out *s3.ListObjectsV2Output, last bool) bool {
+		for _, prefix := range out.CommonPrefixes {
+			name := strings.TrimPrefix(aws.StringValue(prefix.Prefix), S3WorldPrefix(""))
+			worlds = append(worlds, strings.TrimSuffix(name, "/"))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list stored: %w", err)
+	}
+	return worlds, nil
+}
+
 // FindStored returns the file name for a servers storage.
 // ErrServerNotFound if no file found. Errors if multiple match.
-func FindStored(s3Service *s3.S3, name string) error {
-	objects, err := s3Service.ListObjectsV2(&s3.ListObjectsV2Input{
-		Bucket:  aws.String(s3BucketName),
-		Prefix:  aws.String(s3WorldPrefix(name)),
+func FindStored(detail *Detail, name string) error {
+	objects, err := detail.S3.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:  aws.String(detail.Config.Bucket),
+		Prefix:  aws.String(S3WorldPrefix(name)),
 		MaxKeys: aws.Int64(1),
 	})
 
@@ -137,19 +234,67 @@ func FindStored(s3Service *s3.S3, name string) error {
 }
 
 // ReserveInstance (run) an EC2 instance
-func ReserveInstance(services *Detail, name string, instanceType *string) (string, error) {
+func ReserveInstance(services *Detail, name string, opts minecloud.UpOptions) (string, error) {
 	services.Logger.Info("reserving EC2 instance")
+	instanceType := opts.InstanceType
 	if instanceType == nil {
-		instanceType = aws.String("z1d.large")
+		instanceType = aws.String(services.Config.InstanceType)
+	}
+
+	// The instance sets itself up from user data, which is readable by
+	// anything that can describe the instance's attributes or reach its
+	// metadata service. The wrapper API's secrets are fetched from SSM instead.
+	download, err := downloadOpts(services, name)
+	if err != nil {
+		return "", err
 	}
 
+	if opts.Backup != "" {
+		if err := findBackup(services, name, opts.Backup); err != nil {
+			return "", err
+		}
+		services.Logger.Infof("running backup %s", opts.Backup)
+		download.S3WorldPrefix = S3BackupWorldPrefix(name, opts.Backup)
+	}
+
+	start, secrets, err := startWrapperOpts(services, name, opts.Spot)
+	if err != nil {
+		return "", err
+	}
+
+	var imageID string
+	image, err := LatestImage(services)
+	if err == nil {
+		services.Logger.Infof("using image %s (%s)", image.Name, image.ID)
+		imageID = image.ID
+	} else if errors.Is(err, ErrImageNotFound) {
+		imageID, err = baseImage(services)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		return "", err
+	}
+
+	securityGroup, err := securityGroupID(services)
+	if err != nil {
+		return "", err
+	}
+
+	userData := UserDataScript(UserDataScriptOpts{
+		Region:        services.Region(),
+		Download:      download,
+		Start:         start,
+		SkipBootstrap: imageID == image.ID,
+	})
+
 	reservation, err := services.EC2.RunInstances(&ec2.RunInstancesInput{
 		MaxCount:     aws.Int64(1),
 		MinCount:     aws.Int64(1),
-		ImageId:      aws.String("ami-08b993f76f42c3e2f"), // Normal Amazon Linux 2.
+		ImageId:      aws.String(imageID),
 		InstanceType: instanceType,
 		IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
-			Name: aws.String("Minecloud_ServerRole"),
+			Name: aws.String(serverRoleName),
 		},
 		TagSpecifications: []*ec2.TagSpecification{
 			{
@@ -167,9 +312,11 @@ func ReserveInstance(services *Detail, name string, instanceType *string) (strin
 			},
 		},
 		SecurityGroupIds: []*string{
-			aws.String("sg-001670db09337d6a9"), // FIXME configurable
+			aws.String(securityGroup),
 		},
-		KeyName: aws.String("MinecraftServerKeyPair"),
+		KeyName:               aws.String(services.Config.KeyPairName),
+		UserData:              aws.String(base64.StdEncoding.EncodeToString([]byte(userData))),
+		InstanceMarketOptions: marketOptions(opts),
 	})
 
 	services.Logger.Info("API call complete")
@@ -182,7 +329,35 @@ func ReserveInstance(services *Detail, name string, instanceType *string) (strin
 		return "", fmt.Errorf("runstored: reservation returned non-1 (%d) instances", len(reservation.Instances))
 	}
 
-	return *reservation.Instances[0].InstanceId, nil
+	// The secrets can only be tagged for the instance once it exists, the
+	// user data waits for them.
+	instanceID := *reservation.Instances[0].InstanceId
+	if start.APISecrets != "" {
+		err = saveWrapperSecrets(services, name, instanceID, secrets)
+	}
+	return instanceID, err
+}
+
+// marketOptions requests one-time spot capacity if asked for. When the
+// capacity is reclaimed the instance is terminated, the wrapper having saved
+// the world and released its claim.
+func marketOptions(opts minecloud.UpOptions) *ec2.InstanceMarketOptionsRequest {
+	if !opts.Spot {
+		return nil
+	}
+
+	spot := &ec2.SpotMarketOptions{
+		SpotInstanceType:             aws.String(ec2.SpotInstanceTypeOneTime),
+		InstanceInterruptionBehavior: aws.String(ec2.InstanceInterruptionBehaviorTerminate),
+	}
+	if opts.MaxPrice != "" {
+		spot.MaxPrice = aws.String(opts.MaxPrice)
+	}
+
+	return &ec2.InstanceMarketOptionsRequest{
+		MarketType:  aws.String(ec2.MarketTypeSpot),
+		SpotOptions: spot,
+	}
 }
 
 // TerminateInstance terminates an EC2 instance.
@@ -200,53 +375,12 @@ func TerminateInstance(services *Detail, instanceID string) error {
 
 // RunStored runs a Minecraft server on EC2 from a world stored on S3.
 func RunStored(detail *Detail, world string, instanceType *string) error {
-
-	err := FindStored(detail.S3, world)
-	if err != nil {
-		return err
-	}
-
-	instanceID, err := ReserveInstance(detail, world, instanceType)
-	if err != nil {
-		return err
-	}
-
-	err = SetupInstance(detail, instanceID, world)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return backend.RunStored(NewBackend(detail), minecloud.World(world), minecloud.UpOptions{InstanceType: instanceType})
 }
 
 // StoreRunning takes a running minecraft server and safely stops, saves, and terminates the instance.
 func StoreRunning(detail *Detail, world string) error {
-	server, err := FindRunning(detail.EC2, world)
-	if err != nil {
-		return err
-	}
-
-	if server.InstanceState == "terminated" || server.InstanceState == "shutting-down" {
-		return fmt.Errorf("server not running (%s): state is %s", server.Name, server.InstanceState)
-	}
-
-	err = StopServerWrapper(detail, server.InstanceID)
-	if err != nil {
-		return fmt.Errorf("failed to stop server wrapper (%s): %w", server.Name, err)
-	}
-
-	err = UploadWorld(detail, server.InstanceID, server.Name)
-	if err != nil {
-		return fmt.Errorf("failed to upload world (%s): %w", server.Name, err)
-	}
-
-	err = UnclaimWorld(detail, world)
-	if err != nil {
-		// This shouldn't be a fatal error, it but is important.
-		detail.Logger.Errorf("after successful world upload, failed to unclaim: %v", err)
-	}
-
-	return TerminateInstance(detail, server.InstanceID)
+	return backend.StoreRunning(NewBackend(detail), minecloud.World(world))
 }
 
 // BootstrapInstance takes an existing EC2 instance and installs all prerequisites
@@ -255,25 +389,29 @@ func BootstrapInstance(services *Detail, instanceID string) error {
 
 	services.Logger.Info("bootstrapping instance")
 
-	err := services.RunOn(instanceID, `
-        set -x
-        sudo yum update -y;
-        sudo yum install -y docker;
-        sudo systemctl enable docker;
-        sudo service docker start;
-        sudo usermod -a -G docker ec2-user;
-    `, RunOpts{NewKeyBehaviour: SSHNewKeyAccept})
+	err := services.RunOn(instanceID, BootstrapScript, RunOpts{NewKeyBehaviour: SSHNewKeyAccept})
 
 	return err
 }
 
 // DownloadWorld on remote instance.
 func DownloadWorld(services *Detail, instanceID, name string) error {
-	services.Logger.Info("downloading world")
+	return DownloadWorldVersion(services, instanceID, name, "")
+}
+
+// DownloadWorldVersion downloads a backup of the world to an EC2 instance, or
+// the stored world if backupID is empty.
+func DownloadWorldVersion(services *Detail, instanceID, name, backupID string) error {
+	opts, err := downloadOpts(services, name)
+	if err != nil {
+		return err
+	}
 
-	opts := DownloadScriptOpts{
-		S3WorldPrefix:  s3WorldPrefix(name),
-		S3ServerPrefix: s3ServerPrefix(name),
+	if backupID == "" {
+		services.Logger.Info("downloading world")
+	} else {
+		services.Logger.Infof("downloading world from backup %s", backupID)
+		opts.S3WorldPrefix = S3BackupWorldPrefix(name, backupID)
 	}
 
 	script := DownloadScript(opts)
@@ -281,8 +419,32 @@ func DownloadWorld(services *Detail, instanceID, name string) error {
 	return services.RunOn(instanceID, script, RunOpts{})
 }
 
+func downloadOpts(services *Detail, name string) (DownloadScriptOpts, error) {
+	account, err := services.Account()
+	if err != nil {
+		return DownloadScriptOpts{}, err
+	}
+
+	return DownloadScriptOpts{
+		AccountID:      account,
+		Region:         services.Region(),
+		S3Bucket:       services.Config.Bucket,
+		S3WorldPrefix:  S3WorldPrefix(name),
+		S3ServerPrefix: S3ServerPrefix(name),
+	}, nil
+}
+
 // Status gets the status of an instance's server wrapper.
 func Status(services *Detail, instanceID string) (serverwrapper.StatusResponse, error) {
+	var resp serverwrapper.StatusResponse
+	ok, err := withWrapperAPI(services, instanceID, func(client *serverwrapper.Client) error {
+		var err error
+		resp, err = client.Status()
+		return err
+	})
+	if ok {
+		return resp, err
+	}
 
 	out, _, err := services.OutputOn(instanceID, "curl localhost:8080/status", RunOpts{})
 	if err != nil {
@@ -294,6 +456,63 @@ func Status(services *Detail, instanceID string) (serverwrapper.StatusResponse,
 	return statusResponse, err
 }
 
+// SaveWorld has the server wrapper on an instance flush the world to disk,
+// waiting up to timeout for the game to be saved.
+func SaveWorld(services *Detail, instanceID string, timeout time.Duration) error {
+	ok, err := withWrapperAPI(services, instanceID, func(client *serverwrapper.Client) error {
+		return client.Save(timeout)
+	})
+	if ok {
+		return err
+	}
+
+	query := url.Values{"timeout": {timeout.String()}}
+
+	// Give up a little after the wrapper would, in case it never answers.
+	maxTime := int((timeout + 10*time.Second).Seconds())
+	script := fmt.Sprintf("curl -sS --max-time %d -X POST 'localhost:8080/save?%s'", maxTime, query.Encode())
+
+	out, _, err := services.OutputOn(instanceID, script, RunOpts{})
+	if err != nil {
+		return fmt.Errorf("save world: %w", err)
+	}
+
+	var resp serverwrapper.SaveResponse
+	err = json.Unmarshal(out, &resp)
+	if err != nil {
+		return fmt.Errorf("save world: %w", err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("save world: %s", resp.Error)
+	}
+	return nil
+}
+
+// Logs gets console output from an instance's server wrapper, streaming it
+// back over SSH if the API can't be reached.
+func Logs(services *Detail, instanceID string, opts serverwrapper.LogsOptions, handle func(serverwrapper.LogLine)) error {
+	ok, err := withWrapperAPI(services, instanceID, func(client *serverwrapper.Client) error {
+		return client.Logs(context.Background(), opts, handle)
+	})
+	if ok {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		script := fmt.Sprintf("curl -sSN 'localhost:8080/logs?%s'", opts.Query())
+		writer.CloseWithError(services.RunOn(instanceID, script, RunOpts{Stdout: writer}))
+	}()
+
+	err = serverwrapper.ReadLogs(reader, handle)
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("logs: %w", err)
+	}
+	return nil
+}
+
 // UploadWorld uploads a world from an EC2 instance to S3.
 func UploadWorld(services *Detail, instanceID, name string) error {
 	// TODO: Verify the world name somehow before upload to prevent accidental overwrite?
@@ -303,9 +522,17 @@ func UploadWorld(services *Detail, instanceID, name string) error {
 	}
 
 	if resp.Status == serverwrapper.StatusStopped {
+		account, err := services.Account()
+		if err != nil {
+			return err
+		}
+
 		opts := UploadScriptOpts{
-			S3WorldPrefix:  s3WorldPrefix(name),
-			S3ServerPrefix: s3ServerPrefix(name),
+			AccountID:      account,
+			Region:         services.Region(),
+			S3Bucket:       services.Config.Bucket,
+			S3WorldPrefix:  S3WorldPrefix(name),
+			S3ServerPrefix: S3ServerPrefix(name),
 		}
 
 		script := UploadScript(opts)
@@ -313,35 +540,160 @@ func UploadWorld(services *Detail, instanceID, name string) error {
 
 		return err
 	} else if resp.Status == serverwrapper.StatusRunning {
-		// Stop server saving
-		// Force save all
-		// Upload world and server files
-		// Start server saving again
-		return fmt.Errorf("server still running for world '%s', must be stopped to upload world", name)
+		return uploadRunningWorld(services, instanceID, name)
 	}
 
 	return fmt.Errorf("upload world: server in unknown state (%v), refusing to act", resp.Status)
 }
 
+// uploadRunningWorld has the wrapper upload the world with saving turned off,
+// then uploads the server files.
+func uploadRunningWorld(services *Detail, instanceID, name string) error {
+	var stats *worldsync.Stats
+	ok, err := withWrapperAPI(services, instanceID, func(client *serverwrapper.Client) error {
+		var err error
+		stats, err = client.Upload(name)
+		return err
+	})
+	if !ok {
+		stats, err = uploadRunningWorldSSH(services, instanceID, name)
+	}
+	if err != nil {
+		return fmt.Errorf("upload world: %w", err)
+	}
+	if stats != nil {
+		services.Logger.Infof("uploaded world: %s", stats)
+	}
+
+	opts := UploadScriptOpts{
+		S3Bucket:       services.Config.Bucket,
+		S3WorldPrefix:  S3WorldPrefix(name),
+		S3ServerPrefix: S3ServerPrefix(name),
+		SkipWorld:      true,
+	}
+
+	return services.RunOn(instanceID, UploadScript(opts), RunOpts{})
+}
+
+// uploadRunningWorldSSH asks the wrapper to upload the world by running curl
+// on the instance.
+func uploadRunningWorldSSH(services *Detail, instanceID, name string) (*worldsync.Stats, error) {
+	req, err := json.Marshal(serverwrapper.UploadRequest{World: name})
+	if err != nil {
+		return nil, err
+	}
+
+	script := fmt.Sprintf("curl -sS -X POST -d '%s' localhost:8080/upload", req)
+	out, _, err := services.OutputOn(instanceID, script, RunOpts{})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp serverwrapper.UploadResponse
+	err = json.Unmarshal(out, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Sync, nil
+}
+
 // StartServerWrapper starts the server wrapper on the EC2 instance that the
 // ssh client is connected to. Expects it isn't already running.
-func StartServerWrapper(services *Detail, instanceID string) error {
-	account, err := services.Account()
+func StartServerWrapper(services *Detail, instanceID, name string) error {
+	spot, err := isSpot(services, instanceID)
 	if err != nil {
 		return err
 	}
 
-	opts := StartWrapperScriptOpts{
-		AccountID: account,
-		Region:    services.Region(),
+	opts, secrets, err := startWrapperOpts(services, name, spot)
+	if err != nil {
+		return err
+	}
+	if opts.APISecrets != "" {
+		if err := saveWrapperSecrets(services, name, instanceID, secrets); err != nil {
+			return err
+		}
 	}
 
 	return services.RunOn(instanceID, StartWrapperScript(opts), RunOpts{})
 }
 
+// isSpot reports whether an instance is running on spot capacity.
+func isSpot(services *Detail, instanceID string) (bool, error) {
+	out, err := services.EC2.DescribeInstances(descInput(instanceID))
+	if err != nil {
+		return false, err
+	}
+
+	for _, res := range out.Reservations {
+		for _, instance := range res.Instances {
+			return aws.StringValue(instance.InstanceLifecycle) == ec2.InstanceLifecycleTypeSpot, nil
+		}
+	}
+	return false, fmt.Errorf("instance %s not found", instanceID)
+}
+
+// startWrapperOpts gives the wrapper fresh credentials for its HTTPS API,
+// stored with the world's claim, and the claim's lease to renew. Only the
+// public certificate is given directly, the token and private key are
+// returned for saveWrapperSecrets to put in SSM for the instance to fetch. If
+// the world isn't claimed the API is only reachable over SSH. Spot servers
+// watch for their capacity being reclaimed.
+func startWrapperOpts(services *Detail, name string, spot bool) (StartWrapperScriptOpts, wrapperSecrets, error) {
+	account, err := services.Account()
+	if err != nil {
+		return StartWrapperScriptOpts{}, wrapperSecrets{}, err
+	}
+
+	opts := StartWrapperScriptOpts{
+		AccountID:   account,
+		Region:      services.Region(),
+		Bucket:      services.Config.Bucket,
+		World:       name,
+		IdleTimeout: services.Config.IdleTimeout,
+		IdleGrace:   services.Config.IdleGrace,
+		Spot:        spot,
+	}
+	if services.Region() != services.Main().Region() {
+		opts.LambdaRegion = services.Main().Region()
+	}
+
+	creds, key, err := serverwrapper.NewCredentials(time.Now())
+	if err != nil {
+		return opts, wrapperSecrets{}, err
+	}
+
+	err = saveWrapperCredentials(services, name, creds)
+	if errors.Is(err, ErrWorldNotClaimed) {
+		services.Logger.Warnf("%v, wrapper api will only be available over ssh", err)
+		return opts, wrapperSecrets{}, nil
+	} else if err != nil {
+		return opts, wrapperSecrets{}, err
+	}
+
+	opts.APISecrets = wrapperSecretsPath(name)
+	opts.APICert = creds.Cert
+	opts.APIPort = WrapperAPIPort
+
+	lease, err := GetLease(services, name)
+	if err != nil {
+		return opts, wrapperSecrets{}, err
+	}
+	opts.LeaseToken = lease.Token
+	return opts, wrapperSecrets{token: creds.Token, key: key}, nil
+}
+
 // StopServerWrapper stops the server wrapper
 func StopServerWrapper(services *Detail, instanceID string) error {
-	err := services.RunOn(instanceID, "curl -X POST localhost:8080/stop", RunOpts{})
+	ok, err := withWrapperAPI(services, instanceID, func(client *serverwrapper.Client) error {
+		return client.Stop()
+	})
+	if !ok {
+		err = services.RunOn(instanceID, "curl -X POST localhost:8080/stop", RunOpts{})
+	}
 	if err != nil {
 		return err
 	}
@@ -349,41 +701,39 @@ func StopServerWrapper(services *Detail, instanceID string) error {
 	return WaitForStopped(services, instanceID)
 }
 
-// ClaimWorld for use on a server.
-func ClaimWorld(detail *Detail, world string) error {
+// ClaimWorld for use on a server, leased to owner for
+// backend.LeaseDuration. See backend.Claim for taking over expired leases.
+func ClaimWorld(detail *Detail, world, owner string) (backend.Lease, error) {
 	detail.Logger.Info("claiming")
-	db := dynamodb.New(detail.Session)
 
-	_, err := db.PutItem(&dynamodb.PutItemInput{
+	lease := backend.NewLease(minecloud.World(world), owner, 0)
+	_, err := detail.DynamoDB.PutItem(&dynamodb.PutItemInput{
 		ConditionExpression: aws.String("attribute_not_exists(world)"),
-		Item: map[string]*dynamodb.AttributeValue{
-			"world": {S: aws.String(world)},
-		},
-		TableName: aws.String("MinecloudServers"),
+		Item:                leaseItem(lease),
+		TableName:           aws.String(detail.Config.TableName),
 	})
 
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case dynamodb.ErrCodeConditionalCheckFailedException:
-				return fmt.Errorf("%w: %s", ErrWorldAlreadyClaimed, world)
+				return backend.Lease{}, fmt.Errorf("%w: %s", ErrWorldAlreadyClaimed, world)
 			default:
 			}
 		}
+		return backend.Lease{}, err
 	}
 
-	return err
+	return lease, nil
 }
 
 // UnclaimWorld for use on a server.
 func UnclaimWorld(detail *Detail, world string) error {
 	detail.Logger.Info("unclaiming")
 
-	db := dynamodb.New(detail.Session)
-
-	_, err := db.DeleteItem(&dynamodb.DeleteItemInput{
+	_, err := detail.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
 		ConditionExpression: aws.String("attribute_exists(world)"),
-		TableName:           aws.String("MinecloudServers"),
+		TableName:           aws.String(detail.Config.TableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			"world": {S: aws.String(world)},
 		},
@@ -397,9 +747,11 @@ func UnclaimWorld(detail *Detail, world string) error {
 			default:
 			}
 		}
+		return err
 	}
 
-	return err
+	removeWrapperSecrets(detail, world)
+	return nil
 }
 
 // WaitForStopped server wrapper.
@@ -452,51 +804,82 @@ func WaitForSSH(services *Detail, instanceID string, acceptNewKey bool) error {
 	return err
 }
 
-// SetupInstance sets up an existing EC2 instance into a Minecraft server.
-func SetupInstance(services *Detail, instanceID, name string) error {
+// WaitForBoot waits for an instance to finish setting itself up from its user
+// data, logging each stage it reaches.
+func WaitForBoot(services *Detail, instanceID string) error {
+	deadline := time.Now().Add(DefaultBootTimeout)
+	stage := ""
 
-	err := WaitForSSH(services, instanceID, true)
-	if err != nil {
-		return err
-	}
+	for {
+		description, err := services.EC2.DescribeInstances(descInput(instanceID))
+		if err != nil {
+			return err
+		}
+		if len(description.Reservations) != 1 || len(description.Reservations[0].Instances) != 1 {
+			return fmt.Errorf("wait for boot: %s: %w", instanceID, ErrServerNotFound)
+		}
 
-	ip, err := services.IP(instanceID)
-	if err != nil {
-		return err
-	}
+		instance := description.Reservations[0].Instances[0]
+		if !IsActiveInstanceState(aws.StringValue(instance.State.Name)) {
+			return fmt.Errorf("wait for boot: instance is %s", aws.StringValue(instance.State.Name))
+		}
 
-	err = UpdateDNS(services, ip, minecloud.World(name))
-	if err != nil {
-		return err
-	}
+		for _, tag := range instance.Tags {
+			if aws.StringValue(tag.Key) == BootStageTagKey && aws.StringValue(tag.Value) != stage {
+				stage = aws.StringValue(tag.Value)
+				services.Logger.Infof("boot: %s", stage)
+			}
+		}
 
-	// Not required if running from Minecloud image.
-	err = BootstrapInstance(services, instanceID)
-	if err != nil {
-		return err
+		switch stage {
+		case BootStageReady:
+			return nil
+		case BootStageFailed:
+			return errors.New("wait for boot: user data failed, see /var/log/cloud-init-output.log on the instance")
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("wait for boot: timed out after %s at stage '%s'", DefaultBootTimeout, stage)
+		}
+		time.Sleep(5 * time.Second)
 	}
+}
 
-	err = DownloadWorld(services, instanceID, name)
-	if err != nil {
-		return err
+// IsActiveInstanceState returns true if a state represents a running, not-shutting-down instance.
+func IsActiveInstanceState(state string) bool {
+	return state == "running"
+}
+
+// IsLiveInstanceState returns true if an instance in the state may still
+// have a world on it: it's starting, running, or stopping.
+func IsLiveInstanceState(state string) bool {
+	switch state {
+	case ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopping:
+		return true
 	}
+	return false
+}
 
-	err = StartServerWrapper(services, instanceID)
+// FindLive returns the server for a world that may still have it, see
+// IsLiveInstanceState. Error will be ErrServerNotFound if there isn't one.
+func FindLive(svc ec2iface.EC2API, name string) (MCServer, error) {
+	servers, err := GetRunning(svc)
 	if err != nil {
-		return err
+		return MCServer{}, err
 	}
 
-	return nil
-}
+	for _, server := range servers {
+		if server.Name == name && IsLiveInstanceState(server.InstanceState) {
+			return server, nil
+		}
+	}
 
-// IsActiveInstanceState returns true if a state represents a running, not-shutting-down instance.
-func IsActiveInstanceState(state string) bool {
-	return state == "running"
+	return MCServer{}, ErrServerNotFound
 }
 
 // FindRunning returns the server if it exists. Error will be ErrServerNotFound if
 // not found, and a different error otherwise.
-func FindRunning(svc *ec2.EC2, name string) (MCServer, error) {
+func FindRunning(svc ec2iface.EC2API, name string) (MCServer, error) {
 	servers, err := GetRunning(svc)
 	if err != nil {
 		return MCServer{}, err
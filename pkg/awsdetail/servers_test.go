@@ -0,0 +1,337 @@
+package awsdetail_test
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/fakeaws"
+	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+	"github.com/stretchr/testify/require"
+)
+
+const zoneID = fakeaws.ZoneID
+
+func newFakeDetail(t *testing.T) (*fakeaws.Services, *awsdetail.Detail) {
+	fakes := fakeaws.NewWithTable()
+	fakes.S3.Put("ogage-minecraft", "worlds/cliff/level.dat", []byte("level"))
+	fakes.S3.Put("ogage-minecraft", "servers/cliff/server.properties", []byte("motd=cliff"))
+
+	return fakes, fakes.Detail(fakeaws.TestConfig())
+}
+
+func TestRunStored(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	err := awsdetail.RunStored(detail, "cliff", nil)
+	require.NoError(t, err)
+
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+	require.Equal(t, "z1d.large", *fakes.EC2.RunInput(server.InstanceID).InstanceType)
+
+	record := fakes.Route53.Record(zoneID, "cliff.example.com.", "A")
+	require.NotNil(t, record)
+	require.Equal(t, *server.PublicIP, *record.ResourceRecords[0].Value)
+
+	require.True(t, fakes.SSH.Ran(server.InstanceID, "yum install -y docker"))
+	require.True(t, fakes.SSH.Ran(server.InstanceID, `download -bucket "ogage-minecraft" -prefix "worlds/cliff"`))
+	require.True(t, fakes.SSH.Ran(server.InstanceID, fakeaws.Account+".dkr.ecr."+fakeaws.Region+".amazonaws.com/minecloud/server-wrapper"))
+	require.Equal(t, serverwrapper.StatusRunning, fakes.Wrapper.Status(server.InstanceID))
+}
+
+func TestRunStoredBootsFromUserData(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+
+	userData, err := base64.StdEncoding.DecodeString(*fakes.EC2.RunInput(server.InstanceID).UserData)
+	require.NoError(t, err)
+	require.Contains(t, string(userData), "yum install -y docker")
+	require.Contains(t, string(userData), "--name serverwrapper")
+
+	// Nothing is run over SSH except the user data itself.
+	for _, call := range fakes.SSH.Calls() {
+		require.Equal(t, string(userData), call.Script)
+	}
+
+	var stage string
+	for _, tag := range fakes.EC2.Instance(server.InstanceID).Tags {
+		if *tag.Key == awsdetail.BootStageTagKey {
+			stage = *tag.Value
+		}
+	}
+	require.Equal(t, awsdetail.BootStageReady, stage)
+}
+
+func TestRunStoredBootFails(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+	fakes.EC2.Boot = func(instanceID, userData string) error {
+		return errors.New("yum is down")
+	}
+
+	err := awsdetail.RunStored(detail, "cliff", nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "user data failed")
+}
+
+func TestRunStoredUnknownWorld(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	err := awsdetail.RunStored(detail, "nowhere", nil)
+	require.True(t, errors.Is(err, awsdetail.ErrServerNotFound))
+	require.Empty(t, fakes.SSH.Calls())
+}
+
+func TestRunStoredSpot(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	claim(t, detail, "cliff")
+	err := backend.RunStored(awsdetail.NewBackend(detail), "cliff", minecloud.UpOptions{Spot: true, MaxPrice: "0.05"})
+	require.NoError(t, err)
+
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+
+	input := fakes.EC2.RunInput(server.InstanceID)
+	require.Equal(t, ec2.MarketTypeSpot, *input.InstanceMarketOptions.MarketType)
+	require.Equal(t, "0.05", *input.InstanceMarketOptions.SpotOptions.MaxPrice)
+	require.Equal(t, ec2.InstanceLifecycleTypeSpot, *fakes.EC2.Instance(server.InstanceID).InstanceLifecycle)
+
+	userData, err := base64.StdEncoding.DecodeString(*input.UserData)
+	require.NoError(t, err)
+	require.Contains(t, string(userData), "-spot")
+}
+
+func TestStoreRunning(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	claim(t, detail, "cliff")
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", aws.String("t3.medium")))
+
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+
+	err = awsdetail.StoreRunning(detail, "cliff")
+	require.NoError(t, err)
+
+	require.Equal(t, serverwrapper.StatusStopped, fakes.Wrapper.Status(server.InstanceID))
+	require.True(t, fakes.SSH.Ran(server.InstanceID, `upload -bucket "ogage-minecraft" -prefix "worlds/cliff"`))
+	require.Nil(t, fakes.DynamoDB.Item("MinecloudServers", "cliff"))
+	require.Equal(t, ec2.InstanceStateNameShuttingDown, *fakes.EC2.Instance(server.InstanceID).State.Name)
+
+	_, err = awsdetail.FindRunning(detail.EC2, "cliff")
+	require.True(t, errors.Is(err, awsdetail.ErrServerNotFound))
+}
+
+func TestStoreRunningNotRunning(t *testing.T) {
+	_, detail := newFakeDetail(t)
+
+	err := awsdetail.StoreRunning(detail, "cliff")
+	require.True(t, errors.Is(err, awsdetail.ErrServerNotFound))
+}
+
+func TestUploadWorldRunning(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+
+	err = awsdetail.UploadWorld(detail, server.InstanceID, "cliff")
+	require.NoError(t, err)
+
+	// The wrapper uploads the world, we only upload the server files.
+	require.True(t, fakes.SSH.Ran(server.InstanceID, `localhost:8080/upload`))
+	require.True(t, fakes.SSH.Ran(server.InstanceID, `"s3://ogage-minecraft/servers/cliff/"`))
+	require.False(t, fakes.SSH.Ran(server.InstanceID, `upload -bucket "ogage-minecraft" -prefix "worlds/cliff"`))
+	require.Equal(t, serverwrapper.StatusRunning, fakes.Wrapper.Status(server.InstanceID))
+}
+
+func TestSaveWorld(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+
+	require.NoError(t, awsdetail.SaveWorld(detail, server.InstanceID, time.Minute))
+	require.True(t, fakes.SSH.Ran(server.InstanceID, `localhost:8080/save?timeout=1m0s`))
+
+	fakes.Wrapper.SetStatus(server.InstanceID, serverwrapper.StatusStopped)
+	err = awsdetail.SaveWorld(detail, server.InstanceID, time.Minute)
+	require.EqualError(t, err, "save world: server is stopped")
+}
+
+func TestWrapperAPI(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	claim(t, detail, "cliff")
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+
+	claim := fakes.DynamoDB.Item("MinecloudServers", "cliff")
+	token := aws.StringValue(claim["wrapperToken"].S)
+	require.NotEmpty(t, token)
+	require.True(t, fakes.SSH.Ran(server.InstanceID, "-tls-address 0.0.0.0:443"))
+	require.True(t, fakes.SSH.Ran(server.InstanceID, "-p 8443:443"))
+
+	// Secrets are fetched by the instance, not put in its user data.
+	param := fakes.SSM.Parameter("/minecloud/wrapper/cliff/token")
+	require.Equal(t, token, aws.StringValue(param.Value))
+	require.Equal(t, ssm.ParameterTypeSecureString, aws.StringValue(param.Type))
+	require.Contains(t, aws.StringValue(fakes.SSM.Parameter("/minecloud/wrapper/cliff/key").Value), "PRIVATE KEY")
+	require.True(t, fakes.SSH.Ran(server.InstanceID, `secret "/minecloud/wrapper/cliff/key"`))
+
+	// Only the instance may read them.
+	arn := "arn:aws:ec2:" + fakeaws.Region + ":" + fakeaws.Account + ":instance/" + server.InstanceID
+	require.Equal(t, arn, fakes.SSM.Tag("/minecloud/wrapper/cliff/token", "MinecloudInstance"))
+	require.Equal(t, arn, fakes.SSM.Tag("/minecloud/wrapper/cliff/key", "MinecloudInstance"))
+	userData, err := base64.StdEncoding.DecodeString(*fakes.EC2.RunInput(server.InstanceID).UserData)
+	require.NoError(t, err)
+	require.NotContains(t, string(userData), token)
+	require.NotContains(t, string(userData), "PRIVATE KEY")
+
+	resp, err := awsdetail.Status(detail, server.InstanceID)
+	require.NoError(t, err)
+	require.Equal(t, serverwrapper.StatusRunning, resp.Status)
+	require.NoError(t, awsdetail.SaveWorld(detail, server.InstanceID, time.Minute))
+	require.NoError(t, awsdetail.StopServerWrapper(detail, server.InstanceID))
+
+	require.True(t, fakes.Wrapper.Requested(server.InstanceID, "/status"))
+	require.True(t, fakes.Wrapper.Requested(server.InstanceID, "/save"))
+	require.True(t, fakes.Wrapper.Requested(server.InstanceID, "/stop"))
+	require.False(t, fakes.SSH.Ran(server.InstanceID, "localhost:8080"))
+
+	// The secrets go with the claim.
+	require.NoError(t, awsdetail.UnclaimWorld(detail, "cliff"))
+	require.Nil(t, fakes.SSM.Parameter("/minecloud/wrapper/cliff/token"))
+	require.Nil(t, fakes.SSM.Parameter("/minecloud/wrapper/cliff/key"))
+}
+
+func TestReleaseDeletesWrapperSecrets(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	lease := claim(t, detail, "cliff")
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+	require.NotNil(t, fakes.SSM.Parameter("/minecloud/wrapper/cliff/token"))
+
+	require.NoError(t, awsdetail.ReleaseWorld(detail, "cliff", lease.Token))
+	require.Nil(t, fakes.SSM.Parameter("/minecloud/wrapper/cliff/token"))
+	require.Nil(t, fakes.SSM.Parameter("/minecloud/wrapper/cliff/key"))
+}
+
+func TestWrapperAPIFallsBackToSSH(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	claim(t, detail, "cliff")
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+
+	fakes.Wrapper.SetUnreachable(true)
+
+	resp, err := awsdetail.Status(detail, server.InstanceID)
+	require.NoError(t, err)
+	require.Equal(t, serverwrapper.StatusRunning, resp.Status)
+	require.True(t, fakes.SSH.Ran(server.InstanceID, "localhost:8080/status"))
+}
+
+func TestWrapperAPIOnlyFallsBackBeforeSending(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	claim(t, detail, "cliff")
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+
+	// The stop may have happened, so it mustn't be sent again over SSH.
+	fakes.Wrapper.SetLoseResponses(true)
+	require.Error(t, awsdetail.StopServerWrapper(detail, server.InstanceID))
+	require.True(t, fakes.Wrapper.Requested(server.InstanceID, "/stop"))
+	require.False(t, fakes.SSH.Ran(server.InstanceID, "localhost:8080/stop"))
+	fakes.Wrapper.SetLoseResponses(false)
+
+	fakes.Wrapper.SetWrongCert(true)
+	_, err = awsdetail.Status(detail, server.InstanceID)
+	var certErr x509.UnknownAuthorityError
+	require.True(t, errors.As(err, &certErr), "%v", err)
+	require.False(t, fakes.SSH.Ran(server.InstanceID, "localhost:8080/status"))
+}
+
+func TestWrapperAPIClientErrors(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	claim(t, detail, "cliff")
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+
+	// A client that can't be made isn't a reason to go around it.
+	detail.NewWrapperClient = func(string, serverwrapper.Credentials) (*serverwrapper.Client, error) {
+		return nil, errors.New("bad certificate")
+	}
+	_, err = awsdetail.Status(detail, server.InstanceID)
+	require.EqualError(t, err, "bad certificate")
+	require.False(t, fakes.SSH.Ran(server.InstanceID, "localhost:8080/status"))
+
+	// Without credentials there's no API to use.
+	item := fakes.DynamoDB.Item("MinecloudServers", "cliff")
+	delete(item, "wrapperToken")
+	_, err = fakes.DynamoDB.PutItem(&dynamodb.PutItemInput{TableName: aws.String("MinecloudServers"), Item: item})
+	require.NoError(t, err)
+	_, err = awsdetail.Status(detail, server.InstanceID)
+	require.NoError(t, err)
+	require.True(t, fakes.SSH.Ran(server.InstanceID, "localhost:8080/status"))
+}
+
+func TestWrapperAPIWrongToken(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	claim(t, detail, "cliff")
+	require.NoError(t, awsdetail.RunStored(detail, "cliff", nil))
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+
+	client, err := fakes.Wrapper.Client("https://"+*server.PublicIP+":8443", serverwrapper.Credentials{Token: "wrong"})
+	require.NoError(t, err)
+
+	_, err = client.Status()
+	require.Error(t, err)
+	require.False(t, fakes.Wrapper.Requested(server.InstanceID, "/status"))
+}
+
+func claim(t *testing.T, detail *awsdetail.Detail, world string) backend.Lease {
+	lease, err := awsdetail.ClaimWorld(detail, world, "tester@host")
+	require.NoError(t, err)
+	return lease
+}
+
+func TestClaimWorld(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+
+	lease := claim(t, detail, "cliff")
+	require.NotNil(t, fakes.DynamoDB.Item("MinecloudServers", "cliff"))
+	require.Equal(t, "tester@host", lease.Owner)
+	require.False(t, lease.Expired(time.Now()))
+
+	_, err := awsdetail.ClaimWorld(detail, "cliff", "someone@else")
+	require.True(t, errors.Is(err, awsdetail.ErrWorldAlreadyClaimed))
+
+	require.NoError(t, awsdetail.UnclaimWorld(detail, "cliff"))
+
+	err = awsdetail.UnclaimWorld(detail, "cliff")
+	require.True(t, errors.Is(err, awsdetail.ErrWorldNotClaimed))
+}
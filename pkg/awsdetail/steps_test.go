@@ -0,0 +1,188 @@
+package awsdetail_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/fakeaws"
+	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/stretchr/testify/require"
+)
+
+// failSetup brings cliff up with user data that fails, so the up fails at
+// its last step.
+func failSetup(t *testing.T, fakes *fakeaws.Services, b *backend.Backend) backend.Lease {
+	fakes.EC2.Boot = func(instanceID, userData string) error {
+		return errors.New("yum is down")
+	}
+
+	err := backend.RunStored(b, "cliff", minecloud.UpOptions{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "setup")
+
+	lease, err := b.Claims.Lease("cliff")
+	require.NoError(t, err)
+	require.Equal(t, backend.StateFailed, lease.State)
+	require.Equal(t, backend.OpUp, lease.Operation)
+	require.Equal(t, backend.StepDNS, lease.Step)
+	require.NotEmpty(t, lease.InstanceID)
+	return lease
+}
+
+func TestResumeFailedUp(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+	b := awsdetail.NewBackend(detail)
+	claim(t, detail, "cliff")
+
+	boot := fakes.EC2.Boot
+	failed := failSetup(t, fakes, b)
+
+	// Whatever broke the boot is fixed, but the server still says it failed.
+	fakes.EC2.Boot = boot
+	require.NoError(t, backend.Resume(b, "cliff", minecloud.UpOptions{}))
+
+	lease, err := b.Claims.Lease("cliff")
+	require.NoError(t, err)
+	require.Equal(t, backend.StateRunning, lease.State)
+	require.Equal(t, backend.StepSetup, lease.Step)
+
+	// The failed server was replaced.
+	require.NotEqual(t, failed.InstanceID, lease.InstanceID)
+	require.False(t, awsdetail.IsActiveInstanceState(*fakes.EC2.Instance(failed.InstanceID).State.Name))
+	server, err := awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+	require.Equal(t, lease.InstanceID, server.InstanceID)
+
+	// Only failed worlds can be resumed.
+	err = backend.Resume(b, "cliff", minecloud.UpOptions{})
+	require.True(t, errors.Is(err, backend.ErrInvalidTransition))
+}
+
+// failingRenew can't record servers with leases.
+type failingRenew struct {
+	backend.Claims
+}
+
+func (failingRenew) Renew(world minecloud.World, token int64, instanceID string) (backend.Lease, error) {
+	return backend.Lease{}, errors.New("throttled")
+}
+
+// failRecord brings cliff up, failing to record its server with the lease,
+// then leaves the server in state, eg still starting.
+func failRecord(t *testing.T, fakes *fakeaws.Services, b *backend.Backend, state string) string {
+	claims := b.Claims
+	b.Claims = failingRenew{claims}
+	require.Error(t, backend.RunStored(b, "cliff", minecloud.UpOptions{}))
+	b.Claims = claims
+
+	lease, err := b.Claims.Lease("cliff")
+	require.NoError(t, err)
+	require.Equal(t, backend.StateFailed, lease.State)
+	require.Empty(t, lease.InstanceID)
+
+	servers, err := awsdetail.GetRunning(fakes.EC2)
+	require.NoError(t, err)
+	require.Len(t, servers, 1)
+	fakes.EC2.SetState(servers[0].InstanceID, state)
+	fakes.EC2.SetStuck(servers[0].InstanceID, true)
+	return servers[0].InstanceID
+}
+
+func TestResumeUnrecordedServer(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+	b := awsdetail.NewBackend(detail)
+	claim(t, detail, "cliff")
+
+	orphan := failRecord(t, fakes, b, ec2.InstanceStateNameRunning)
+	require.NoError(t, backend.Resume(b, "cliff", minecloud.UpOptions{}))
+
+	lease, err := b.Claims.Lease("cliff")
+	require.NoError(t, err)
+	require.Equal(t, backend.StateRunning, lease.State)
+	require.NotEqual(t, orphan, lease.InstanceID)
+	require.False(t, awsdetail.IsActiveInstanceState(*fakes.EC2.Instance(orphan).State.Name))
+}
+
+func TestRollbackUnrecordedServer(t *testing.T) {
+	for _, state := range []string{ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning} {
+		fakes, detail := newFakeDetail(t)
+		b := awsdetail.NewBackend(detail)
+		claim(t, detail, "cliff")
+
+		orphan := failRecord(t, fakes, b, state)
+		require.NoError(t, backend.Rollback(b, "cliff"), state)
+
+		// Terminated, or at least on its way.
+		fakes.EC2.SetStuck(orphan, false)
+		require.False(t, awsdetail.IsLiveInstanceState(*fakes.EC2.Instance(orphan).State.Name), state)
+	}
+}
+
+func TestRollbackFailedUp(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+	b := awsdetail.NewBackend(detail)
+	claim(t, detail, "cliff")
+
+	failed := failSetup(t, fakes, b)
+	require.NotNil(t, fakes.Route53.Record(zoneID, "cliff.example.com.", "A"))
+
+	require.NoError(t, backend.Rollback(b, "cliff"))
+
+	require.False(t, awsdetail.IsActiveInstanceState(*fakes.EC2.Instance(failed.InstanceID).State.Name))
+	require.Nil(t, fakes.Route53.Record(zoneID, "cliff.example.com.", "A"))
+
+	_, err := b.Claims.Lease("cliff")
+	require.True(t, errors.Is(err, awsdetail.ErrWorldNotClaimed))
+
+	// The world can be brought up afresh.
+	fakes.EC2.Boot = nil
+	claim(t, detail, "cliff")
+	require.NoError(t, backend.RunStored(b, "cliff", minecloud.UpOptions{}))
+}
+
+func TestRollbackFailedDown(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+	b := awsdetail.NewBackend(detail)
+	claim(t, detail, "cliff")
+	require.NoError(t, backend.RunStored(b, "cliff", minecloud.UpOptions{}))
+
+	// Running worlds have nothing to roll back.
+	err := backend.Rollback(b, "cliff")
+	require.True(t, errors.Is(err, backend.ErrInvalidTransition))
+
+	fakes.SSH.Handle(`-prefix "worlds/cliff"`, func(call fakeaws.SSHCall, stdout io.Writer) error {
+		return errors.New("upload failed")
+	})
+	require.Error(t, backend.StoreRunning(b, "cliff"))
+
+	lease, err := b.Claims.Lease("cliff")
+	require.NoError(t, err)
+	require.Equal(t, backend.OpDown, lease.Operation)
+	require.Equal(t, backend.StepStop, lease.Step)
+
+	// The world may only be on the server, so it's kept.
+	err = backend.Rollback(b, "cliff")
+	require.True(t, errors.Is(err, backend.ErrInvalidTransition))
+	_, err = awsdetail.FindRunning(detail.EC2, "cliff")
+	require.NoError(t, err)
+}
+
+func TestWaitReadyGivesUp(t *testing.T) {
+	fakes, detail := newFakeDetail(t)
+	b := awsdetail.NewBackend(detail)
+	claim(t, detail, "cliff")
+
+	id, err := b.Compute.Reserve("cliff", minecloud.UpOptions{})
+	require.NoError(t, err)
+	server, err := awsdetail.FindLive(detail.EC2, "cliff")
+	require.NoError(t, err)
+	fakes.EC2.SetStuck(server.InstanceID, true)
+
+	err = b.Compute.WaitReady(id)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "giving up after "+awsdetail.DefaultRunningTimeout.String())
+}
@@ -0,0 +1,256 @@
+package awsdetail
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+)
+
+// errNoWrapperAPI given if an instance's wrapper can't be reached over HTTPS,
+// eg because it was started without credentials.
+var errNoWrapperAPI = errors.New("no wrapper api credentials")
+
+// saveWrapperCredentials stores the credentials for a world's wrapper API
+// with its claim, so they go when the world is unclaimed, along with its
+// secrets, see removeWrapperSecrets.
+func saveWrapperCredentials(detail *Detail, world string, creds serverwrapper.Credentials) error {
+	_, err := detail.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(detail.Config.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"world": {S: aws.String(world)},
+		},
+		ConditionExpression: aws.String("attribute_exists(world)"),
+		UpdateExpression:    aws.String("SET wrapperToken = :token, wrapperCert = :cert"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":token": {S: aws.String(creds.Token)},
+			":cert":  {S: aws.String(string(creds.Cert))},
+		},
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return fmt.Errorf("%w: %s", ErrWorldNotClaimed, world)
+	}
+	return err
+}
+
+// wrapperSecretsPrefix is where the wrapper API's secrets are kept in SSM,
+// under the world's name.
+const wrapperSecretsPrefix = "/minecloud/wrapper/"
+
+// wrapperSecretsTag on a world's secrets is the ARN of the instance they are
+// for. Servers may only read secrets tagged with their own ARN.
+const wrapperSecretsTag = "MinecloudInstance"
+
+// wrapperSecrets are the parts of a wrapper's API credentials only the
+// server may know.
+type wrapperSecrets struct {
+	token string
+	key   []byte
+}
+
+// wrapperSecretsPath is where a world's wrapper secrets are, the server
+// fetches "token" and "key" from under it as it starts.
+func wrapperSecretsPath(world string) string {
+	return wrapperSecretsPrefix + world
+}
+
+// saveWrapperSecrets puts the token and private key for a world's wrapper in
+// SSM as SecureStrings, in the region the instance runs in, tagged so only it
+// can read them. Any an earlier server had are replaced.
+func saveWrapperSecrets(detail *Detail, world, instanceID string, secrets wrapperSecrets) error {
+	account, err := detail.Account()
+	if err != nil {
+		return fmt.Errorf("save wrapper secrets: %w", err)
+	}
+	arn := fmt.Sprintf("arn:aws:ec2:%s:%s:instance/%s", detail.Region(), account, instanceID)
+
+	// Tags can't be changed when overwriting, so they're put afresh.
+	if err := deleteWrapperSecrets(detail, world); err != nil {
+		return fmt.Errorf("save wrapper secrets: %w", err)
+	}
+
+	values := map[string]string{"token": secrets.token, "key": string(secrets.key)}
+	for name, value := range values {
+		_, err := detail.SSM.PutParameter(&ssm.PutParameterInput{
+			Name:  aws.String(wrapperSecretsPath(world) + "/" + name),
+			Type:  aws.String(ssm.ParameterTypeSecureString),
+			Value: aws.String(value),
+			Tags:  []*ssm.Tag{{Key: aws.String(wrapperSecretsTag), Value: aws.String(arn)}},
+		})
+		if err != nil {
+			return fmt.Errorf("save wrapper secrets: %w", err)
+		}
+	}
+	return nil
+}
+
+// deleteWrapperSecrets of a world from the region of detail, if it has any.
+func deleteWrapperSecrets(detail *Detail, world string) error {
+	_, err := detail.SSM.DeleteParameters(&ssm.DeleteParametersInput{
+		Names: aws.StringSlice([]string{wrapperSecretsPath(world) + "/token", wrapperSecretsPath(world) + "/key"}),
+	})
+	return err
+}
+
+// removeWrapperSecrets of a world once it's unclaimed, from the region it
+// lives in. The claim is already gone, so failing is only logged.
+func removeWrapperSecrets(detail *Detail, world string) {
+	regional, err := detail.ForWorld(world)
+	if err == nil {
+		err = deleteWrapperSecrets(regional, world)
+	}
+	if err != nil {
+		detail.Logger.Warnf("failed to delete wrapper secrets of %s: %v", world, err)
+	}
+}
+
+// wrapperCredentials gets the credentials stored with a world's claim.
+func wrapperCredentials(detail *Detail, world string) (serverwrapper.Credentials, error) {
+	out, err := detail.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(detail.Config.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"world": {S: aws.String(world)},
+		},
+	})
+	if err != nil {
+		return serverwrapper.Credentials{}, err
+	}
+
+	token, cert := out.Item["wrapperToken"], out.Item["wrapperCert"]
+	if token == nil || cert == nil {
+		return serverwrapper.Credentials{}, errNoWrapperAPI
+	}
+
+	return serverwrapper.Credentials{
+		Token: aws.StringValue(token.S),
+		Cert:  []byte(aws.StringValue(cert.S)),
+	}, nil
+}
+
+// WrapperClient gets a client for the HTTPS API of the wrapper on an instance.
+func WrapperClient(detail *Detail, instanceID string) (*serverwrapper.Client, error) {
+	description, err := detail.EC2.DescribeInstances(descInput(instanceID))
+	if err != nil {
+		return nil, err
+	}
+	if len(description.Reservations) != 1 || len(description.Reservations[0].Instances) != 1 {
+		return nil, fmt.Errorf("wrapper client: %s: %w", instanceID, ErrServerNotFound)
+	}
+
+	instance := description.Reservations[0].Instances[0]
+	if instance.PublicIpAddress == nil {
+		return nil, errors.New("wrapper client: instance has no public IP (terminated?)")
+	}
+
+	var world string
+	for _, tag := range instance.Tags {
+		if aws.StringValue(tag.Key) == serverTagKey {
+			world = aws.StringValue(tag.Value)
+		}
+	}
+
+	creds, err := wrapperCredentials(detail, world)
+	if err != nil {
+		return nil, fmt.Errorf("wrapper client: %w", err)
+	}
+
+	newClient := detail.NewWrapperClient
+	if newClient == nil {
+		newClient = serverwrapper.NewTLSClient
+	}
+
+	address := net.JoinHostPort(*instance.PublicIpAddress, strconv.Itoa(WrapperAPIPort))
+	return newClient("https://"+address, creds)
+}
+
+// withWrapperAPI calls the wrapper's HTTPS API on an instance. If it has no
+// API or can't be connected to, false is returned and the caller should fall
+// back to SSH. Any other error is returned as it is: once a request may have
+// been sent, trying again over SSH could do things like stopping or uploading
+// twice, a certificate that doesn't match isn't a reason to stop checking it,
+// and failing to look the instance up would fail over SSH too.
+func withWrapperAPI(detail *Detail, instanceID string, call func(*serverwrapper.Client) error) (bool, error) {
+	client, err := WrapperClient(detail, instanceID)
+	if errors.Is(err, errNoWrapperAPI) {
+		detail.Logger.Debugf("using ssh: %v", err)
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+
+	err = call(client)
+	if notConnected(err) {
+		detail.Logger.Warnf("wrapper api unreachable, using ssh: %v", err)
+		return false, nil
+	}
+	return true, err
+}
+
+// notConnected reports whether a request failed to connect, so was never
+// sent. TLS handshake failures happen after connecting, so don't count.
+func notConnected(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// wrapperSecretsResource is every world's wrapper secrets in a region. They
+// are deleted as worlds are unclaimed, but Deinit deletes any left behind, eg
+// by a server that was never released.
+func wrapperSecretsResource(detail *Detail) resource {
+	return resource{
+		kind:   "ssm parameters",
+		name:   wrapperSecretsPrefix + "*",
+		region: detail.Region(),
+
+		exists: func() (bool, error) {
+			names, err := listWrapperSecrets(detail)
+			return len(names) > 0, err
+		},
+		delete: func() error {
+			names, err := listWrapperSecrets(detail)
+			if err != nil {
+				return err
+			}
+
+			// At most 10 can be deleted at once.
+			for start := 0; start < len(names); start += 10 {
+				end := start + 10
+				if end > len(names) {
+					end = len(names)
+				}
+
+				_, err := detail.SSM.DeleteParameters(&ssm.DeleteParametersInput{
+					Names: aws.StringSlice(names[start:end]),
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// listWrapperSecrets names every world's wrapper secrets in a region.
+func listWrapperSecrets(detail *Detail) ([]string, error) {
+	names := []string{}
+	err := detail.SSM.GetParametersByPathPages(&ssm.GetParametersByPathInput{
+		Path:      aws.String(strings.TrimSuffix(wrapperSecretsPrefix, "/")),
+		Recursive: aws.Bool(true),
+	}, func(out *ssm.GetParametersByPathOutput, last bool) bool {
+		for _, param := range out.Parameters {
+			names = append(names, aws.StringValue(param.Name))
+		}
+		return true
+	})
+	return names, err
+}
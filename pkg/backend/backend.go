@@ -0,0 +1,371 @@
+// Package backend describes the infrastructure Minecloud needs in order to run
+// a world: somewhere to compute, somewhere to store worlds, a way to claim a
+// world so only one server runs it, and DNS so players can find it.
+//
+// The up/down flow is written once against these interfaces, letting AWS and
+// a plain local host share it.
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrServerNotFound given if server isn't found on the backend.
+var ErrServerNotFound error = errors.New("server not found")
+
+// ErrWorldAlreadyClaimed given if a world is already claimed for a server.
+var ErrWorldAlreadyClaimed error = errors.New("world already claimed")
+
+// ErrWorldNotClaimed given if a world is already NOT claimed for a server.
+var ErrWorldNotClaimed error = errors.New("world already not claimed")
+
+// ErrLeaseLost given if a world has been claimed again since a lease was
+// taken, so its holder must no longer run the world.
+var ErrLeaseLost error = errors.New("world lease lost")
+
+// LeaseDuration is how long a claim lasts unless it is renewed. Running
+// servers renew their world's lease well within it.
+var LeaseDuration = 15 * time.Minute
+
+// Lease is a world's claim. It lasts until Expires, after which the world can
+// be claimed again as long as no server is running it.
+type Lease struct {
+	World      minecloud.World
+	Owner      string // who claimed it, eg user@host.
+	InstanceID string // server running the world, once there is one.
+	Expires    time.Time
+
+	// Token fences the lease: every claim of a world has a larger one, so
+	// a holder that has been taken over can't renew or release it.
+	Token int64
+
+	State        WorldState
+	StateChanged time.Time
+
+	// Operation is the last up or down on the world, and Step the last of
+	// its steps completed, so a failed operation can be resumed or rolled
+	// back.
+	Operation string `json:",omitempty"`
+	Step      string `json:",omitempty"`
+}
+
+// NewLease for a new claim of a world, which starts provisioning. previous
+// is the token of the claim it replaces, if any.
+func NewLease(world minecloud.World, owner string, previous int64) Lease {
+	now := time.Now()
+	return Lease{
+		World:        world,
+		Owner:        owner,
+		Expires:      now.Add(LeaseDuration),
+		Token:        NextToken(now, previous),
+		State:        StateProvisioning,
+		StateChanged: now,
+	}
+}
+
+// Expired reports whether the lease has run out.
+func (l Lease) Expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+// NextToken gives a fencing token for a new claim at now, larger than the
+// previous claim's.
+func NextToken(now time.Time, previous int64) int64 {
+	token := now.UnixNano()
+	if token <= previous {
+		token = previous + 1
+	}
+	return token
+}
+
+// Server is a machine running, or recently running, a world.
+type Server struct {
+	Name    string
+	State   string
+	ID      string
+	Address *string
+	Region  string `json:",omitempty"` // for backends spanning regions.
+}
+
+// Compute reserves machines and runs the server wrapper on them.
+type Compute interface {
+	// Reserve a machine for the world, returning its ID.
+	Reserve(world minecloud.World, opts minecloud.UpOptions) (string, error)
+
+	// WaitReady blocks until a reserved machine can be set up.
+	WaitReady(id string) error
+
+	// Address players should use to connect to the machine.
+	Address(id string) (string, error)
+
+	// Setup puts the stored world on the machine and starts the server wrapper.
+	Setup(id string, world minecloud.World) error
+
+	// Find the active server for a world. ErrServerNotFound if there isn't one.
+	Find(world minecloud.World) (Server, error)
+
+	// FindLive finds a server for a world that may still hold it, including
+	// one still starting or stopping. ErrServerNotFound if there isn't one.
+	FindLive(world minecloud.World) (Server, error)
+
+	// List all servers, including recently terminated.
+	List() ([]Server, error)
+
+	// Status of the server wrapper on the machine.
+	Status(id string) (serverwrapper.StatusResponse, error)
+
+	// Logs gets console output from the server wrapper, calling handle for
+	// each line. Blocks while following.
+	Logs(id string, opts serverwrapper.LogsOptions, handle func(serverwrapper.LogLine)) error
+
+	// Save flushes the running world to disk, waiting up to timeout for the
+	// server to report the game saved.
+	Save(id string, timeout time.Duration) error
+
+	// Stop the server wrapper, waiting for it to report stopped.
+	Stop(id string) error
+
+	// Upload the world on the machine back to storage. Backends that can
+	// pause saving upload running servers, others require them stopped.
+	Upload(id string, world minecloud.World) error
+
+	// Terminate the machine.
+	Terminate(id string) error
+}
+
+// Storage holds worlds while they are not running.
+type Storage interface {
+	// FindStored returns ErrServerNotFound if the world is not stored.
+	FindStored(world minecloud.World) error
+
+	// List the stored worlds, sorted.
+	List() ([]minecloud.World, error)
+}
+
+// Claims makes sure a world is only run by one server at a time.
+type Claims interface {
+	// Claim the world for owner. ErrWorldAlreadyClaimed if it already is,
+	// even if the lease has expired, see Claim.
+	Claim(world minecloud.World, owner string) (Lease, error)
+
+	// Lease gets the world's current lease. ErrWorldNotClaimed if there
+	// isn't one.
+	Lease(world minecloud.World) (Lease, error)
+
+	// TakeOver claims the world for owner in place of an expired lease.
+	// ErrLeaseLost if the lease has changed since it was read.
+	TakeOver(expired Lease, owner string) (Lease, error)
+
+	// Renew extends the lease with the token, recording the server running
+	// the world unless instanceID is empty. ErrLeaseLost if the world has
+	// been claimed again.
+	Renew(world minecloud.World, token int64, instanceID string) (Lease, error)
+
+	// Release unclaims the world if the lease with the token still holds it.
+	// ErrLeaseLost if not.
+	Release(world minecloud.World, token int64) error
+
+	// Unclaim the world, whoever holds it.
+	Unclaim(world minecloud.World) error
+
+	// SetState moves the world from one state to another, see Transition.
+	// ErrInvalidTransition if it isn't in the from state.
+	SetState(world minecloud.World, from, to WorldState) (Lease, error)
+
+	// List the leases of every claimed world.
+	List() ([]Lease, error)
+
+	// SetStep records the last step of an operation completed, renewing the
+	// lease with the token. ErrLeaseLost if the world has been claimed again.
+	SetStep(world minecloud.World, token int64, operation, step string) (Lease, error)
+}
+
+// DNS points a world's name at the server running it.
+type DNS interface {
+	Update(world minecloud.World, address string) error
+
+	// Remove the world's name, if it has one.
+	Remove(world minecloud.World) error
+}
+
+// Backend is everything needed to bring worlds up and down.
+type Backend struct {
+	Compute Compute
+	Storage Storage
+	Claims  Claims
+	DNS     DNS
+	Logger  *logrus.Logger
+
+	// Operations records the progress of ups and downs requested
+	// asynchronously, see RunOperation.
+	Operations Operations
+}
+
+// Claim the world for owner. An expired lease is taken over, but only once
+// it's certain no server is still running the world.
+func Claim(b *Backend, world minecloud.World, owner string) (Lease, error) {
+	lease, err := b.Claims.Claim(world, owner)
+	if !errors.Is(err, ErrWorldAlreadyClaimed) {
+		return lease, err
+	}
+
+	current, err := b.Claims.Lease(world)
+	if errors.Is(err, ErrWorldNotClaimed) {
+		// Unclaimed since, so try again.
+		return b.Claims.Claim(world, owner)
+	}
+	if err != nil {
+		return Lease{}, err
+	}
+
+	if !current.Expired(time.Now()) {
+		return Lease{}, fmt.Errorf("%w: %s by %s until %s",
+			ErrWorldAlreadyClaimed, world, current.Owner, current.Expires.Format(time.RFC3339))
+	}
+
+	server, err := b.Compute.FindLive(world)
+	if err == nil {
+		return Lease{}, fmt.Errorf("%w: %s, lease expired but %s is still %s with it",
+			ErrWorldAlreadyClaimed, world, server.ID, server.State)
+	}
+	if !errors.Is(err, ErrServerNotFound) {
+		return Lease{}, err
+	}
+
+	b.Logger.Warnf("taking over expired lease on %s from %s", world, current.Owner)
+	return b.Claims.TakeOver(current, owner)
+}
+
+// RunStored runs a server from a stored world. The world should already be
+// claimed, and is running once this returns, or failed part way, see Resume
+// and Rollback. The lease is renewed as the server comes up, and if it has
+// been lost the server is terminated rather than run alongside another.
+func RunStored(b *Backend, world minecloud.World, opts minecloud.UpOptions) error {
+	return runStored(b, world, opts, nil)
+}
+
+func runStored(b *Backend, world minecloud.World, opts minecloud.UpOptions, track progress) error {
+	lease, err := b.Claims.Lease(world)
+	if errors.Is(err, ErrWorldNotClaimed) {
+		b.Logger.Warnf("%v, running without a lease", err)
+	} else if err != nil {
+		return err
+	}
+
+	return runUp(b, world, opts, lease, track)
+}
+
+func runUp(b *Backend, world minecloud.World, opts minecloud.UpOptions, lease Lease, track progress) error {
+	claimed := lease.World != ""
+	id := lease.InstanceID
+
+	steps := []step{
+		{StepReserve, func() error {
+			err := b.Storage.FindStored(world)
+			if err != nil {
+				return err
+			}
+
+			id, err = b.Compute.Reserve(world, opts)
+			if err != nil || lease.Token == 0 {
+				return err
+			}
+
+			_, err = b.Claims.Renew(world, lease.Token, id)
+			return err
+		}},
+		{StepWaitReady, func() error {
+			return b.Compute.WaitReady(id)
+		}},
+		{StepDNS, func() error {
+			address, err := b.Compute.Address(id)
+			if err != nil {
+				return err
+			}
+			return b.DNS.Update(world, address)
+		}},
+		{StepSetup, func() error {
+			return b.Compute.Setup(id, world)
+		}},
+	}
+
+	err := runSteps(b, world, &lease, OpUp, steps, track)
+	if errors.Is(err, ErrLeaseLost) {
+		// The world is no longer this server's to run, or change the state of.
+		if id != "" {
+			b.Logger.Errorf("lost lease on %s, terminating %s", world, id)
+			if terr := b.Compute.Terminate(id); terr != nil {
+				b.Logger.Errorf("failed to terminate %s: %v", id, terr)
+			}
+		}
+		return err
+	}
+	if !claimed {
+		return err
+	}
+	if err != nil {
+		return fail(b, world, err)
+	}
+
+	_, err = Transition(b, world, StateRunning)
+	return err
+}
+
+// StoreRunning takes a running server and safely stops, stores, and terminates it.
+// The world is stopping until it's unclaimed, or failed part way, see Resume.
+func StoreRunning(b *Backend, world minecloud.World) error {
+	return storeRunning(b, world, nil)
+}
+
+func storeRunning(b *Backend, world minecloud.World, track progress) error {
+	server, err := b.Compute.Find(world)
+	if err != nil {
+		return err
+	}
+
+	lease, err := Transition(b, world, StateStopping)
+	if errors.Is(err, ErrWorldNotClaimed) {
+		b.Logger.Warnf("%v, storing anyway", err)
+	} else if err != nil {
+		return err
+	}
+
+	steps := []step{
+		{StepStop, func() error {
+			err := b.Compute.Stop(server.ID)
+			if err != nil {
+				return fmt.Errorf("failed to stop server wrapper (%s): %w", server.Name, err)
+			}
+			return nil
+		}},
+		{StepUpload, func() error {
+			err := b.Compute.Upload(server.ID, world)
+			if err != nil {
+				return fmt.Errorf("failed to upload world (%s): %w", server.Name, err)
+			}
+			return nil
+		}},
+	}
+
+	err = runSteps(b, world, &lease, OpDown, steps, track)
+	if err != nil {
+		if lease.World == "" || errors.Is(err, ErrLeaseLost) {
+			return err
+		}
+		return fail(b, world, err)
+	}
+
+	err = b.Claims.Unclaim(world)
+	if err != nil {
+		// This shouldn't be a fatal error, it but is important.
+		b.Logger.Errorf("after successful world upload, failed to unclaim: %v", err)
+	}
+
+	return b.Compute.Terminate(server.ID)
+}
@@ -0,0 +1,116 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/minecloud"
+)
+
+// ErrInvalidTransition given if a world can't move to a state from the one
+// it's in, eg taking it down while it's still coming up.
+var ErrInvalidTransition error = errors.New("invalid world state transition")
+
+// WorldState is where a claimed world is in its lifecycle. Unclaimed worlds
+// are stored, and have no state.
+type WorldState string
+
+// World states, see transitions for how they move between each other.
+const (
+	StateProvisioning WorldState = "provisioning" // claimed, server coming up.
+	StateRunning      WorldState = "running"
+	StateSaving       WorldState = "saving"
+	StateStopping     WorldState = "stopping" // being stored, then unclaimed.
+	StateFailed       WorldState = "failed"   // needs taking down or resuming.
+)
+
+// transitions lists the states each state can move to. Any state can fail.
+var transitions = map[WorldState][]WorldState{
+	StateProvisioning: {StateRunning},
+	StateRunning:      {StateSaving, StateStopping},
+	StateSaving:       {StateRunning, StateStopping},
+	StateStopping:     {},
+	StateFailed:       {StateProvisioning, StateStopping},
+}
+
+// CanTransition reports whether a world can move between states. Claims from
+// before states have an empty one, which can move to any state.
+func CanTransition(from, to WorldState) bool {
+	if from == "" || (to == StateFailed && from != StateFailed) {
+		return true
+	}
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTransition gives ErrInvalidTransition if the world can't move between
+// the states.
+func CheckTransition(world minecloud.World, from, to WorldState) error {
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s is %s, can't move to %s", ErrInvalidTransition, world, from, to)
+	}
+	return nil
+}
+
+// Transition moves a claimed world to a new state, if its current one
+// allows it.
+func Transition(b *Backend, world minecloud.World, to WorldState) (Lease, error) {
+	lease, err := b.Claims.Lease(world)
+	if err != nil {
+		return Lease{}, err
+	}
+
+	if err := CheckTransition(world, lease.State, to); err != nil {
+		return Lease{}, err
+	}
+
+	b.Logger.Infof("%s: %s -> %s", world, displayState(lease.State), to)
+	return b.Claims.SetState(world, lease.State, to)
+}
+
+// fail moves a world to StateFailed after err, logging rather than hiding
+// err if that fails too.
+func fail(b *Backend, world minecloud.World, err error) error {
+	if _, ferr := Transition(b, world, StateFailed); ferr != nil && !errors.Is(ferr, ErrWorldNotClaimed) {
+		b.Logger.Errorf("failed to mark %s failed: %v", world, ferr)
+	} else if ferr == nil {
+		b.Logger.Errorf("%v, see 'minecloud resume' and 'minecloud rollback'", err)
+	}
+	return err
+}
+
+func displayState(state WorldState) WorldState {
+	if state == "" {
+		return "unknown"
+	}
+	return state
+}
+
+// SaveRunning saves a running world, moving it through StateSaving.
+func SaveRunning(b *Backend, world minecloud.World, timeout time.Duration) error {
+	server, err := b.Compute.Find(world)
+	if err != nil {
+		return err
+	}
+
+	_, err = Transition(b, world, StateSaving)
+	if errors.Is(err, ErrWorldNotClaimed) {
+		b.Logger.Warnf("%v, saving anyway", err)
+		return b.Compute.Save(server.ID, timeout)
+	} else if err != nil {
+		return err
+	}
+
+	saveErr := b.Compute.Save(server.ID, timeout)
+
+	// Still running either way.
+	if _, err := Transition(b, world, StateRunning); err != nil {
+		b.Logger.Errorf("failed to mark %s running after saving: %v", world, err)
+	}
+	return saveErr
+}
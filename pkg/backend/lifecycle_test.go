@@ -0,0 +1,33 @@
+package backend_test
+
+import (
+	"testing"
+
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from, to backend.WorldState
+		ok       bool
+	}{
+		{backend.StateProvisioning, backend.StateRunning, true},
+		{backend.StateProvisioning, backend.StateStopping, false},
+		{backend.StateProvisioning, backend.StateFailed, true},
+		{backend.StateRunning, backend.StateSaving, true},
+		{backend.StateRunning, backend.StateStopping, true},
+		{backend.StateRunning, backend.StateProvisioning, false},
+		{backend.StateSaving, backend.StateRunning, true},
+		{backend.StateStopping, backend.StateRunning, false},
+		{backend.StateStopping, backend.StateFailed, true},
+		{backend.StateFailed, backend.StateStopping, true},
+		{backend.StateFailed, backend.StateProvisioning, true},
+		{backend.StateFailed, backend.StateFailed, false},
+		{"", backend.StateStopping, true},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.ok, backend.CanTransition(c.from, c.to), "%s -> %s", c.from, c.to)
+	}
+}
@@ -0,0 +1,187 @@
+package backend
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/minecloud"
+)
+
+// ErrOperationNotFound given if there's no operation with the requested ID.
+var ErrOperationNotFound error = errors.New("operation not found")
+
+// OperationStatus is how far an operation has got.
+type OperationStatus string
+
+// Statuses of an operation, from requested to done.
+const (
+	StatusPending   OperationStatus = "pending"
+	StatusRunning   OperationStatus = "running"
+	StatusSucceeded OperationStatus = "succeeded"
+	StatusFailed    OperationStatus = "failed"
+)
+
+// OperationTimeout is the longest an operation can run for, the timeout of
+// the lambda that runs them. One that hasn't recorded anything for longer has
+// died without recording its outcome, see GetOperation.
+const OperationTimeout = 15 * time.Minute
+
+// Operation is an up or down of a world, recorded as it runs so whoever
+// asked for it can follow it, even when it was requested asynchronously.
+type Operation struct {
+	ID    string
+	Kind  string // OpUp or OpDown.
+	World minecloud.World
+
+	Status OperationStatus
+	Step   string `json:",omitempty"` // running, or that failed.
+	Error  string `json:",omitempty"`
+
+	// Address players can connect to, once an up has succeeded.
+	Address string `json:",omitempty"`
+
+	Requested time.Time
+	Updated   time.Time
+}
+
+// Done reports whether the operation has finished, either way.
+func (op Operation) Done() bool {
+	return op.Status == StatusSucceeded || op.Status == StatusFailed
+}
+
+// Operations records operations by ID.
+type Operations interface {
+	// Put the operation, replacing any with the same ID.
+	Put(op Operation) error
+
+	// Get an operation. ErrOperationNotFound if there isn't one.
+	Get(id string) (Operation, error)
+}
+
+// NewOperation of kind on a world, pending until it's run. IDs start with
+// the time requested, so they sort.
+func NewOperation(kind string, world minecloud.World) (Operation, error) {
+	now := time.Now().UTC()
+
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return Operation{}, fmt.Errorf("operation id: %w", err)
+	}
+
+	return Operation{
+		ID:        now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix),
+		Kind:      kind,
+		World:     world,
+		Status:    StatusPending,
+		Requested: now,
+		Updated:   now,
+	}, nil
+}
+
+// StartOperation records a new pending operation of kind on a world.
+func StartOperation(b *Backend, kind string, world minecloud.World) (Operation, error) {
+	op, err := NewOperation(kind, world)
+	if err != nil {
+		return Operation{}, err
+	}
+	return op, b.Operations.Put(op)
+}
+
+// RunOperation runs a pending operation, recording each step as it starts and
+// then the outcome. opts are used for ups.
+func RunOperation(b *Backend, id string, opts minecloud.UpOptions) error {
+	op, err := b.Operations.Get(id)
+	if err != nil {
+		return err
+	}
+
+	op.Status = StatusRunning
+	record(b, &op)
+
+	track := func(step string) {
+		op.Step = step
+		record(b, &op)
+	}
+
+	switch op.Kind {
+	case OpUp:
+		err = runStored(b, op.World, opts, track)
+	case OpDown:
+		err = storeRunning(b, op.World, track)
+	default:
+		err = fmt.Errorf("unknown operation: %s", op.Kind)
+	}
+
+	return FinishOperation(b, op, err)
+}
+
+// FinishOperation records the outcome of an operation from the error that
+// ended it, nil if it succeeded, and returns the error.
+func FinishOperation(b *Backend, op Operation, err error) error {
+	op.Status = StatusSucceeded
+	if err != nil {
+		op.Status = StatusFailed
+		op.Error = err.Error()
+	} else if op.Kind == OpUp {
+		server, ferr := b.Compute.Find(op.World)
+		if ferr == nil && server.Address != nil {
+			op.Address = *server.Address
+		} else if ferr != nil {
+			b.Logger.Warnf("could not find address of %s: %v", op.World, ferr)
+		}
+	}
+
+	record(b, &op)
+	return err
+}
+
+// record puts the operation. Failing to isn't fatal to the operation itself.
+func record(b *Backend, op *Operation) {
+	op.Updated = time.Now().UTC()
+	if err := b.Operations.Put(*op); err != nil {
+		b.Logger.Warnf("failed to record operation %s: %v", op.ID, err)
+	}
+}
+
+// GetOperation gets an operation. One that has gone longer than
+// OperationTimeout without being updated is recorded as failed, since
+// whatever was running it has died.
+func GetOperation(b *Backend, id string) (Operation, error) {
+	op, err := b.Operations.Get(id)
+	if err != nil {
+		return op, err
+	}
+
+	if !op.Done() && time.Since(op.Updated) > OperationTimeout {
+		op.Status = StatusFailed
+		op.Error = fmt.Sprintf("no progress since %s, it was probably killed by a timeout", op.Updated.Format(time.RFC3339))
+		record(b, &op)
+	}
+	return op, nil
+}
+
+// WaitOperation polls an operation every interval until it's done, calling
+// changed whenever its status or step changes. See GetOperation.
+func WaitOperation(b *Backend, id string, interval time.Duration, changed func(Operation)) (Operation, error) {
+	var last Operation
+	for {
+		op, err := GetOperation(b, id)
+		if err != nil {
+			return op, err
+		}
+
+		if op.Status != last.Status || op.Step != last.Step {
+			changed(op)
+		}
+		if op.Done() {
+			return op, nil
+		}
+
+		last = op
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/owengage/minecloud/pkg/minecloud"
+)
+
+// Operations whose steps are recorded with the world's lease.
+const (
+	OpUp   = "up"
+	OpDown = "down"
+)
+
+// Steps of each operation, in order.
+const (
+	StepReserve   = "reserve"
+	StepWaitReady = "wait-ready"
+	StepDNS       = "dns"
+	StepSetup     = "setup"
+
+	StepStop   = "stop"
+	StepUpload = "upload"
+)
+
+type step struct {
+	name string
+	run  func() error
+}
+
+// progress is told each step as it starts, if not nil.
+type progress func(step string)
+
+// runSteps runs an operation's steps, recording each with the lease as it
+// completes. If the lease shows the operation got part way, it carries on
+// from the step after the last completed. Unclaimed worlds, and claims from
+// before leases, aren't recorded.
+func runSteps(b *Backend, world minecloud.World, lease *Lease, operation string, steps []step, track progress) error {
+	start := 0
+	if lease.Operation == operation {
+		for i, s := range steps {
+			if s.name == lease.Step {
+				start = i + 1
+			}
+		}
+	} else if lease.Token != 0 {
+		started, err := b.Claims.SetStep(world, lease.Token, operation, "")
+		if err != nil {
+			return err
+		}
+		*lease = started
+	}
+
+	for _, s := range steps[start:] {
+		b.Logger.Infof("%s %s: %s", operation, world, s.name)
+		if track != nil {
+			track(s.name)
+		}
+
+		err := s.run()
+		if err != nil {
+			return fmt.Errorf("%s %s: %s: %w", operation, world, s.name, err)
+		}
+
+		if lease.Token == 0 {
+			continue
+		}
+		done, err := b.Claims.SetStep(world, lease.Token, operation, s.name)
+		if err != nil {
+			return err
+		}
+		*lease = done
+	}
+
+	return nil
+}
+
+// failedLease gets the lease of a world whose last operation failed.
+func failedLease(b *Backend, world minecloud.World, action string) (Lease, error) {
+	lease, err := b.Claims.Lease(world)
+	if err != nil {
+		return Lease{}, err
+	}
+	if lease.State != StateFailed {
+		return Lease{}, fmt.Errorf("%w: %s is %s, only failed worlds can be %s",
+			ErrInvalidTransition, world, displayState(lease.State), action)
+	}
+	return lease, nil
+}
+
+// Resume a world's failed up or down from the step that failed. opts are
+// used if the up failed before recording a server, or its server failed, in
+// which case any server it has is replaced.
+func Resume(b *Backend, world minecloud.World, opts minecloud.UpOptions) error {
+	lease, err := failedLease(b, world, "resumed")
+	if err != nil {
+		return err
+	}
+
+	switch lease.Operation {
+	case OpUp:
+		lease, err = Transition(b, world, StateProvisioning)
+		if err != nil {
+			return err
+		}
+		if lease.Step == "" || serverFailed(lease) {
+			lease, err = replaceServer(b, world, lease)
+			if err != nil {
+				return fail(b, world, fmt.Errorf("resume %s: %w", world, err))
+			}
+		}
+		return runUp(b, world, opts, lease, nil)
+	case OpDown:
+		return StoreRunning(b, world)
+	default:
+		return fmt.Errorf("%s has no recorded operation to resume", world)
+	}
+}
+
+// serverFailed reports whether an up failed waiting for its server to run or
+// set up. Checking again won't help, eg a server whose user data failed stays
+// failed, so it needs replacing.
+func serverFailed(lease Lease) bool {
+	return lease.Step == StepReserve || lease.Step == StepDNS
+}
+
+// replaceServer terminates the server of a failed up, if it has one, and
+// records the up as not started, so resuming it reserves another.
+func replaceServer(b *Backend, world minecloud.World, lease Lease) (Lease, error) {
+	id, err := reservedServer(b, world, lease)
+	if err != nil || (id == "" && lease.Step == "") {
+		return lease, err
+	}
+
+	if id != "" {
+		b.Logger.Infof("resume %s: replacing failed server %s", world, id)
+		err = b.Compute.Terminate(id)
+		if err != nil && !errors.Is(err, ErrServerNotFound) {
+			return lease, err
+		}
+	}
+	return b.Claims.SetStep(world, lease.Token, OpUp, "")
+}
+
+// reservedServer is the ID of the server a failed up reserved, empty if it
+// has none. An up can fail after reserving a server but before recording it
+// with the lease, so if there isn't one recorded it's looked for by world,
+// including servers that are still starting.
+func reservedServer(b *Backend, world minecloud.World, lease Lease) (string, error) {
+	if lease.InstanceID != "" {
+		return lease.InstanceID, nil
+	}
+
+	server, err := b.Compute.FindLive(world)
+	if errors.Is(err, ErrServerNotFound) {
+		return "", nil
+	}
+	return server.ID, err
+}
+
+// Rollback undoes a world's failed up: terminates its server, removes its DNS
+// name and unclaims it. A failed down can only be resumed, since the world
+// may only be safe on the server.
+func Rollback(b *Backend, world minecloud.World) error {
+	lease, err := failedLease(b, world, "rolled back")
+	if err != nil {
+		return err
+	}
+	if lease.Operation != OpUp {
+		return fmt.Errorf("%w: only a failed up can be rolled back, resume the %s instead",
+			ErrInvalidTransition, displayOperation(lease.Operation))
+	}
+
+	id, err := reservedServer(b, world, lease)
+	if err != nil {
+		return fmt.Errorf("rollback %s: %w", world, err)
+	}
+	if id != "" {
+		b.Logger.Infof("rollback %s: terminating %s", world, id)
+		err = b.Compute.Terminate(id)
+		if err != nil && !errors.Is(err, ErrServerNotFound) {
+			return fmt.Errorf("rollback %s: %w", world, err)
+		}
+	}
+
+	b.Logger.Infof("rollback %s: removing dns", world)
+	if err := b.DNS.Remove(world); err != nil {
+		return fmt.Errorf("rollback %s: %w", world, err)
+	}
+
+	b.Logger.Infof("rollback %s: unclaiming", world)
+	if lease.Token == 0 {
+		return b.Claims.Unclaim(world)
+	}
+	return b.Claims.Release(world, lease.Token)
+}
+
+func displayOperation(operation string) string {
+	if operation == "" {
+		return "operation"
+	}
+	return operation
+}
@@ -0,0 +1,87 @@
+// Package backups describes versioned world backups and decides which to keep.
+package backups
+
+import (
+	"sort"
+	"time"
+)
+
+// IDFormat is the time format backup IDs are made from, so they sort in the
+// order they were taken.
+const IDFormat = "20060102T150405.000Z"
+
+// Manifest records what a backup is. It is stored alongside the backup.
+type Manifest struct {
+	ID    string
+	World string
+	Time  time.Time
+
+	// Size in bytes and number of files in the backup.
+	Size  int64
+	Files int
+
+	// SourceInstance the world was uploaded from before the backup was taken,
+	// empty if it was taken from the stored world.
+	SourceInstance string `json:",omitempty"`
+}
+
+// NewID creates a backup ID for a backup taken at t.
+func NewID(t time.Time) string {
+	return t.UTC().Format(IDFormat)
+}
+
+// Policy says how many backups to keep. Within each window the newest backup
+// per hour, day or month is kept. The most recent backup is always kept.
+type Policy struct {
+	Hourly time.Duration // keep hourly backups this long.
+	Daily  time.Duration // then keep daily backups this long.
+
+	// Monthly backups are kept this long after that. Zero keeps them forever.
+	Monthly time.Duration
+}
+
+// DefaultPolicy keeps hourly backups for a day, daily for a month and monthly
+// forever.
+var DefaultPolicy = Policy{
+	Hourly: 24 * time.Hour,
+	Daily:  30 * 24 * time.Hour,
+}
+
+// Prune splits backups into those the policy keeps and those it doesn't. Both
+// are returned newest first.
+func Prune(manifests []Manifest, now time.Time, policy Policy) (keep, remove []Manifest) {
+	sorted := append([]Manifest{}, manifests...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	seen := map[string]bool{}
+
+	for i, m := range sorted {
+		bucket, ok := policy.bucket(now.Sub(m.Time), m.Time.UTC())
+
+		if i == 0 || ok && !seen[bucket] {
+			seen[bucket] = true
+			keep = append(keep, m)
+		} else {
+			remove = append(remove, m)
+		}
+	}
+
+	return keep, remove
+}
+
+// bucket names the period a backup of the given age falls into. Only the
+// newest backup in each bucket is kept. Returns false if it is too old to
+// keep at all.
+func (p Policy) bucket(age time.Duration, t time.Time) (string, bool) {
+	switch {
+	case age < p.Hourly:
+		return t.Format("hour 2006-01-02T15"), true
+	case age < p.Hourly+p.Daily:
+		return t.Format("day 2006-01-02"), true
+	case p.Monthly == 0 || age < p.Hourly+p.Daily+p.Monthly:
+		return t.Format("month 2006-01"), true
+	}
+	return "", false
+}
@@ -0,0 +1,63 @@
+package backups
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func ids(manifests []Manifest) []string {
+	out := []string{}
+	for _, m := range manifests {
+		out = append(out, m.ID)
+	}
+	return out
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 30, 0, 0, time.UTC)
+
+	at := func(id string, ago time.Duration) Manifest {
+		return Manifest{ID: id, Time: now.Add(-ago)}
+	}
+
+	manifests := []Manifest{
+		at("now", 0),
+		at("10m", 10*time.Minute),    // same hour as now
+		at("1h", time.Hour),          // hourly
+		at("2h", 2*time.Hour),        // hourly
+		at("2d-a", 48*time.Hour),     // daily
+		at("2d-b", 49*time.Hour),     // same day as 2d-a
+		at("40d", 40*24*time.Hour),   // monthly, May
+		at("41d", 41*24*time.Hour),   // same month as 40d
+		at("400d", 400*24*time.Hour), // monthly, forever
+	}
+
+	keep, remove := Prune(manifests, now, DefaultPolicy)
+	require.Equal(t, []string{"now", "1h", "2h", "2d-a", "40d", "400d"}, ids(keep))
+	require.Equal(t, []string{"10m", "2d-b", "41d"}, ids(remove))
+}
+
+func TestPruneMonthlyLimit(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 30, 0, 0, time.UTC)
+	policy := Policy{Hourly: time.Hour, Daily: 24 * time.Hour, Monthly: 60 * 24 * time.Hour}
+
+	manifests := []Manifest{
+		{ID: "old", Time: now.Add(-400 * 24 * time.Hour)},
+		{ID: "recent", Time: now.Add(-40 * 24 * time.Hour)},
+	}
+
+	keep, remove := Prune(manifests, now, policy)
+	require.Equal(t, []string{"recent"}, ids(keep))
+	require.Equal(t, []string{"old"}, ids(remove))
+}
+
+func TestPruneAlwaysKeepsNewest(t *testing.T) {
+	now := time.Now()
+	policy := Policy{Hourly: time.Hour, Daily: time.Hour, Monthly: time.Hour}
+
+	keep, remove := Prune([]Manifest{{ID: "ancient", Time: now.Add(-1000 * time.Hour)}}, now, policy)
+	require.Equal(t, []string{"ancient"}, ids(keep))
+	require.Empty(t, remove)
+}
@@ -0,0 +1,307 @@
+package fakeaws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DynamoDB is a stateful, in-memory fake of the DynamoDB API. It supports
+// conditional writes using the expressions described in expression.go.
+type DynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu     sync.Mutex
+	tables map[string]*table
+}
+
+type table struct {
+	description *dynamodb.TableDescription
+	keys        []string
+	items       map[string]map[string]*dynamodb.AttributeValue
+}
+
+// NewDynamoDB creates a DynamoDB fake with no tables.
+func NewDynamoDB() *DynamoDB {
+	return &DynamoDB{
+		tables: map[string]*table{},
+	}
+}
+
+// CreateTable creates a table. Only the key schema is used.
+func (f *DynamoDB) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.StringValue(input.TableName)
+	if _, ok := f.tables[name]; ok {
+		return nil, awserr.New(dynamodb.ErrCodeResourceInUseException, "Table already exists: "+name, nil)
+	}
+
+	t := &table{
+		description: &dynamodb.TableDescription{
+			TableName:            input.TableName,
+			TableStatus:          aws.String(dynamodb.TableStatusActive),
+			KeySchema:            input.KeySchema,
+			AttributeDefinitions: input.AttributeDefinitions,
+			TableArn:             aws.String("arn:aws:dynamodb:eu-west-2:" + Account + ":table/" + name),
+		},
+		items: map[string]map[string]*dynamodb.AttributeValue{},
+	}
+	for _, key := range input.KeySchema {
+		t.keys = append(t.keys, aws.StringValue(key.AttributeName))
+	}
+
+	f.tables[name] = t
+	return &dynamodb.CreateTableOutput{TableDescription: t.description}, nil
+}
+
+// DescribeTable describes an existing table.
+func (f *DynamoDB) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.DescribeTableOutput{Table: t.description}, nil
+}
+
+// WaitUntilTableExists returns immediately, tables are created active.
+func (f *DynamoDB) WaitUntilTableExists(input *dynamodb.DescribeTableInput) error {
+	_, err := f.DescribeTable(input)
+	return err
+}
+
+// DeleteTable deletes a table and its items.
+func (f *DynamoDB) DeleteTable(input *dynamodb.DeleteTableInput) (*dynamodb.DeleteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	delete(f.tables, aws.StringValue(input.TableName))
+	return &dynamodb.DeleteTableOutput{TableDescription: t.description}, nil
+}
+
+// PutItem writes an item if the condition holds.
+func (f *DynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := t.keyOf(input.Item)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := exprContext{names: input.ExpressionAttributeNames, values: input.ExpressionAttributeValues}
+	if err := checkCondition(input.ConditionExpression, ctx, t.items[key]); err != nil {
+		return nil, err
+	}
+
+	t.items[key] = copyItem(input.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// GetItem reads an item. Item is nil if it doesn't exist.
+func (f *DynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := t.keyOf(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	item := t.items[key]
+	if item == nil {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return &dynamodb.GetItemOutput{Item: copyItem(item)}, nil
+}
+
+// UpdateItem applies an update expression if the condition holds, creating
+// the item if it doesn't exist.
+func (f *DynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := t.keyOf(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := exprContext{names: input.ExpressionAttributeNames, values: input.ExpressionAttributeValues}
+	existing := t.items[key]
+	if err := checkCondition(input.ConditionExpression, ctx, existing); err != nil {
+		return nil, err
+	}
+
+	item := copyItem(existing)
+	if item == nil {
+		item = copyItem(input.Key)
+	}
+	if err := applyUpdate(aws.StringValue(input.UpdateExpression), ctx, item); err != nil {
+		return nil, awserr.New("ValidationException", err.Error(), nil)
+	}
+
+	t.items[key] = item
+
+	output := &dynamodb.UpdateItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllNew {
+		output.Attributes = copyItem(item)
+	}
+	return output, nil
+}
+
+// DeleteItem deletes an item if the condition holds.
+func (f *DynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := t.keyOf(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := exprContext{names: input.ExpressionAttributeNames, values: input.ExpressionAttributeValues}
+	if err := checkCondition(input.ConditionExpression, ctx, t.items[key]); err != nil {
+		return nil, err
+	}
+
+	delete(t.items, key)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// Scan returns every item in the table, optionally filtered.
+func (f *DynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for key := range t.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ctx := exprContext{names: input.ExpressionAttributeNames, values: input.ExpressionAttributeValues}
+	output := &dynamodb.ScanOutput{}
+	for _, key := range keys {
+		item := t.items[key]
+		ok, err := evalCondition(aws.StringValue(input.FilterExpression), ctx, item)
+		if err != nil {
+			return nil, awserr.New("ValidationException", err.Error(), nil)
+		}
+		if ok {
+			output.Items = append(output.Items, copyItem(item))
+		}
+	}
+	output.Count = aws.Int64(int64(len(output.Items)))
+	return output, nil
+}
+
+// ScanPages scans the table as a single page.
+func (f *DynamoDB) ScanPages(input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool) error {
+	output, err := f.Scan(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+// Item is a helper returning an item by its string key values, or nil.
+func (f *DynamoDB) Item(tableName string, keyValues ...string) map[string]*dynamodb.AttributeValue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, ok := f.tables[tableName]
+	if !ok {
+		return nil
+	}
+	return copyItem(t.items[strings.Join(keyValues, "\x00")])
+}
+
+func (f *DynamoDB) table(name *string) (*table, error) {
+	t, ok := f.tables[aws.StringValue(name)]
+	if !ok {
+		return nil, awserr.New(dynamodb.ErrCodeResourceNotFoundException, "Requested resource not found", nil)
+	}
+	return t, nil
+}
+
+// keyOf builds a map key from the item's key attributes.
+func (t *table) keyOf(item map[string]*dynamodb.AttributeValue) (string, error) {
+	parts := []string{}
+	for _, name := range t.keys {
+		value, ok := item[name]
+		if !ok {
+			return "", awserr.New("ValidationException", fmt.Sprintf("missing key attribute %s", name), nil)
+		}
+		switch {
+		case value.S != nil:
+			parts = append(parts, *value.S)
+		case value.N != nil:
+			parts = append(parts, *value.N)
+		default:
+			return "", awserr.New("ValidationException", fmt.Sprintf("unsupported key type for %s", name), nil)
+		}
+	}
+	return strings.Join(parts, "\x00"), nil
+}
+
+func checkCondition(expr *string, ctx exprContext, item map[string]*dynamodb.AttributeValue) error {
+	ok, err := evalCondition(aws.StringValue(expr), ctx, item)
+	if err != nil {
+		return awserr.New("ValidationException", err.Error(), nil)
+	}
+	if !ok {
+		return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	return nil
+}
+
+func copyItem(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	c := make(map[string]*dynamodb.AttributeValue, len(item))
+	for k, v := range item {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,528 @@
+package fakeaws
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+)
+
+// EC2 is a stateful fake of the EC2 API. Instances move through their states
+// as they are observed: each DescribeInstances moves pending instances to
+// running and shutting-down instances to terminated, unless they are stuck,
+// see SetStuck.
+//
+// The fake is for one region, and only sees instances and images launched in
+// it. InRegion gives the same fake for another region. Helpers that aren't
+// part of the API, such as Instance, see every region.
+//
+// Calling an API that isn't faked panics via the nil embedded interface.
+type EC2 struct {
+	ec2iface.EC2API
+	*ec2State
+
+	region string
+}
+
+type ec2State struct {
+	mu        sync.Mutex
+	instances []*ec2.Instance
+	inputs    map[string]*ec2.RunInstancesInput
+	nextID    int
+	booting   []string
+	stuck     map[string]bool
+	images    []*ec2.Image
+	regions   map[string]string           // of instances, images and security groups, by ID.
+	keyPairs  map[string]*ec2.KeyPairInfo // by region and name, see keyPairKey.
+	groups    []*ec2.SecurityGroup
+
+	// Boot, if set, runs an instance's user data once it is running. The
+	// instance's boot stage tag is then set to ready, or failed if Boot
+	// returns an error. See awsdetail.BootStageTagKey.
+	Boot func(instanceID, userData string) error
+}
+
+// NewEC2 creates an EC2 fake with no instances.
+func NewEC2() *EC2 {
+	return &EC2{
+		ec2State: &ec2State{
+			inputs:   map[string]*ec2.RunInstancesInput{},
+			regions:  map[string]string{},
+			keyPairs: map[string]*ec2.KeyPairInfo{},
+			stuck:    map[string]bool{},
+		},
+		region: Region,
+	}
+}
+
+// InRegion returns the fake for another region, sharing state with this one.
+func (f *EC2) InRegion(region string) *EC2 {
+	return &EC2{ec2State: f.ec2State, region: region}
+}
+
+// RunInstances creates pending instances.
+func (f *EC2) RunInstances(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	count := aws.Int64Value(input.MinCount)
+	if count < 1 {
+		count = 1
+	}
+
+	reservation := &ec2.Reservation{}
+
+	for i := int64(0); i < count; i++ {
+		f.nextID++
+		id := fmt.Sprintf("i-fake%08d", f.nextID)
+
+		instance := &ec2.Instance{
+			InstanceId:   aws.String(id),
+			ImageId:      input.ImageId,
+			InstanceType: input.InstanceType,
+			KeyName:      input.KeyName,
+			LaunchTime:   aws.Time(time.Now()),
+			State:        stateOf(ec2.InstanceStateNamePending),
+		}
+
+		if opts := input.InstanceMarketOptions; opts != nil && aws.StringValue(opts.MarketType) == ec2.MarketTypeSpot {
+			instance.InstanceLifecycle = aws.String(ec2.InstanceLifecycleTypeSpot)
+		}
+
+		for _, spec := range input.TagSpecifications {
+			if aws.StringValue(spec.ResourceType) == ec2.ResourceTypeInstance {
+				instance.Tags = append(instance.Tags, spec.Tags...)
+			}
+		}
+
+		f.instances = append(f.instances, instance)
+		f.regions[id] = f.region
+		f.inputs[id] = input
+		reservation.Instances = append(reservation.Instances, copyInstance(instance))
+	}
+
+	return reservation, nil
+}
+
+// DescribeInstances returns instances matching the IDs and filters given, then
+// advances instance states.
+func (f *EC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	defer f.boot()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &ec2.DescribeInstancesOutput{}
+
+	for _, id := range input.InstanceIds {
+		if f.find(aws.StringValue(id)) == nil {
+			return nil, awserr.New("InvalidInstanceID.NotFound", fmt.Sprintf("The instance ID '%s' does not exist", aws.StringValue(id)), nil)
+		}
+	}
+
+	for _, instance := range f.instances {
+		if f.regions[aws.StringValue(instance.InstanceId)] != f.region {
+			continue
+		}
+		if len(input.InstanceIds) > 0 && !containsString(input.InstanceIds, aws.StringValue(instance.InstanceId)) {
+			continue
+		}
+		if !matchesFilters(instance, input.Filters) {
+			continue
+		}
+
+		// One reservation per instance, as we only ever launch one at a time.
+		output.Reservations = append(output.Reservations, &ec2.Reservation{
+			Instances: []*ec2.Instance{copyInstance(instance)},
+		})
+	}
+
+	f.advance()
+	return output, nil
+}
+
+// WaitUntilInstanceRunningWithContext is WaitUntilInstanceRunning, except
+// stuck instances fail it, as though ctx ran out waiting for them.
+func (f *EC2) WaitUntilInstanceRunningWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, _ ...request.WaiterOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	for _, id := range input.InstanceIds {
+		if f.stuck[aws.StringValue(id)] {
+			f.mu.Unlock()
+			return awserr.New(request.CanceledErrorCode, "waiter context canceled", context.DeadlineExceeded)
+		}
+	}
+	f.mu.Unlock()
+
+	return f.WaitUntilInstanceRunning(input)
+}
+
+// WaitUntilInstanceRunning brings pending instances up immediately.
+func (f *EC2) WaitUntilInstanceRunning(input *ec2.DescribeInstancesInput) error {
+	defer f.boot()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, id := range input.InstanceIds {
+		instance := f.find(aws.StringValue(id))
+		if instance == nil {
+			return awserr.New("InvalidInstanceID.NotFound", "instance not found", nil)
+		}
+
+		switch aws.StringValue(instance.State.Name) {
+		case ec2.InstanceStateNamePending:
+			f.setState(instance, ec2.InstanceStateNameRunning)
+		case ec2.InstanceStateNameRunning:
+		default:
+			return awserr.New("ResourceNotReady", "failed waiting for successful resource state", nil)
+		}
+	}
+
+	return nil
+}
+
+// TerminateInstances moves instances to shutting-down.
+func (f *EC2) TerminateInstances(input *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &ec2.TerminateInstancesOutput{}
+
+	for _, id := range input.InstanceIds {
+		instance := f.find(aws.StringValue(id))
+		if instance == nil {
+			return nil, awserr.New("InvalidInstanceID.NotFound", "instance not found", nil)
+		}
+
+		previous := instance.State
+		if aws.StringValue(previous.Name) != ec2.InstanceStateNameTerminated {
+			f.setState(instance, ec2.InstanceStateNameShuttingDown)
+		}
+
+		output.TerminatingInstances = append(output.TerminatingInstances, &ec2.InstanceStateChange{
+			InstanceId:    instance.InstanceId,
+			PreviousState: previous,
+			CurrentState:  instance.State,
+		})
+	}
+
+	return output, nil
+}
+
+// CreateTags adds or overwrites tags on instances.
+func (f *EC2) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, id := range input.Resources {
+		var tags *[]*ec2.Tag
+		if instance := f.find(aws.StringValue(id)); instance != nil {
+			tags = &instance.Tags
+		} else if image := f.findImage(aws.StringValue(id)); image != nil {
+			tags = &image.Tags
+		} else {
+			return nil, awserr.New("InvalidID", fmt.Sprintf("The ID '%s' is not valid", aws.StringValue(id)), nil)
+		}
+
+		for _, tag := range input.Tags {
+			replaced := false
+			for _, existing := range *tags {
+				if aws.StringValue(existing.Key) == aws.StringValue(tag.Key) {
+					existing.Value = tag.Value
+					replaced = true
+				}
+			}
+			if !replaced {
+				*tags = append(*tags, &ec2.Tag{Key: tag.Key, Value: tag.Value})
+			}
+		}
+	}
+
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+// CreateImage creates an image of an instance, available immediately.
+func (f *EC2) CreateImage(input *ec2.CreateImageInput) (*ec2.CreateImageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.find(aws.StringValue(input.InstanceId)) == nil {
+		return nil, awserr.New("InvalidInstanceID.NotFound", "instance not found", nil)
+	}
+
+	id := fmt.Sprintf("ami-fake%08d", len(f.images)+1)
+	f.regions[id] = f.region
+	f.images = append(f.images, &ec2.Image{
+		ImageId:      aws.String(id),
+		Name:         input.Name,
+		Description:  input.Description,
+		State:        aws.String(ec2.ImageStateAvailable),
+		CreationDate: aws.String(time.Now().UTC().Format("2006-01-02T15:04:05.000Z")),
+	})
+
+	return &ec2.CreateImageOutput{ImageId: aws.String(id)}, nil
+}
+
+// DescribeImages returns images matching the IDs and tag filters given. All
+// images are owned by us, so owners are ignored.
+func (f *EC2) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &ec2.DescribeImagesOutput{}
+	for _, image := range f.images {
+		if f.regions[aws.StringValue(image.ImageId)] != f.region {
+			continue
+		}
+		if len(input.ImageIds) > 0 && !containsString(input.ImageIds, aws.StringValue(image.ImageId)) {
+			continue
+		}
+		if !matchesTags(image.Tags, input.Filters) {
+			continue
+		}
+
+		copied := *image
+		copied.Tags = append([]*ec2.Tag{}, image.Tags...)
+		output.Images = append(output.Images, &copied)
+	}
+	return output, nil
+}
+
+// WaitUntilImageAvailable returns immediately, images are created available.
+func (f *EC2) WaitUntilImageAvailable(input *ec2.DescribeImagesInput) error {
+	return nil
+}
+
+func (f *EC2) findImage(id string) *ec2.Image {
+	for _, image := range f.images {
+		if aws.StringValue(image.ImageId) == id && f.regions[id] == f.region {
+			return image
+		}
+	}
+	return nil
+}
+
+// matchesTags checks tag filters only, eg "tag:Name".
+func matchesTags(tags []*ec2.Tag, filters []*ec2.Filter) bool {
+	for _, filter := range filters {
+		name := aws.StringValue(filter.Name)
+		if !strings.HasPrefix(name, "tag:") {
+			panic("fakeaws: unsupported EC2 image filter: " + name)
+		}
+
+		matched := false
+		for _, tag := range tags {
+			if aws.StringValue(tag.Key) == strings.TrimPrefix(name, "tag:") && containsString(filter.Values, aws.StringValue(tag.Value)) {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Instance returns a copy of the instance with the given ID, or nil.
+func (f *EC2) Instance(id string) *ec2.Instance {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	instance := f.findAny(id)
+	if instance == nil {
+		return nil
+	}
+	return copyInstance(instance)
+}
+
+// RunInput returns the input used to launch an instance.
+func (f *EC2) RunInput(id string) *ec2.RunInstancesInput {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.inputs[id]
+}
+
+// SetState forces an instance into a state, eg to simulate a crash.
+func (f *EC2) SetState(id, state string) {
+	defer f.boot()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if instance := f.findAny(id); instance != nil {
+		f.setState(instance, state)
+	}
+}
+
+// advance moves every instance on by one state.
+// SetStuck keeps an instance in its state as it's observed, eg to simulate
+// one slow to start.
+func (f *EC2) SetStuck(id string, stuck bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stuck[id] = stuck
+}
+
+func (f *EC2) advance() {
+	for _, instance := range f.instances {
+		if f.stuck[aws.StringValue(instance.InstanceId)] {
+			continue
+		}
+
+		switch aws.StringValue(instance.State.Name) {
+		case ec2.InstanceStateNamePending:
+			f.setState(instance, ec2.InstanceStateNameRunning)
+		case ec2.InstanceStateNameShuttingDown:
+			f.setState(instance, ec2.InstanceStateNameTerminated)
+		}
+	}
+}
+
+func (f *EC2) setState(instance *ec2.Instance, state string) {
+	instance.State = stateOf(state)
+
+	switch state {
+	case ec2.InstanceStateNameRunning:
+		if input := f.inputs[aws.StringValue(instance.InstanceId)]; instance.PublicIpAddress == nil && input != nil && input.UserData != nil {
+			f.booting = append(f.booting, aws.StringValue(instance.InstanceId))
+		}
+		if instance.PublicIpAddress == nil {
+			var n int
+			fmt.Sscanf(aws.StringValue(instance.InstanceId), "i-fake%d", &n)
+			instance.PublicIpAddress = aws.String(fmt.Sprintf("198.51.100.%d", n%250+1))
+		}
+	case ec2.InstanceStateNameTerminated:
+		instance.PublicIpAddress = nil
+	}
+}
+
+// boot runs the user data of instances that have just started. It must be
+// called without the lock held, as Boot may call back into the fake.
+func (f *EC2) boot() {
+	f.mu.Lock()
+	booting := f.booting
+	f.booting = nil
+	f.mu.Unlock()
+
+	for _, id := range booting {
+		stage := awsdetail.BootStageReady
+
+		if f.Boot != nil {
+			userData, err := base64.StdEncoding.DecodeString(aws.StringValue(f.RunInput(id).UserData))
+			if err == nil {
+				err = f.Boot(id, string(userData))
+			}
+			if err != nil {
+				stage = awsdetail.BootStageFailed
+			}
+		}
+
+		_, _ = f.InRegion(f.regionOf(id)).CreateTags(&ec2.CreateTagsInput{
+			Resources: []*string{aws.String(id)},
+			Tags:      []*ec2.Tag{{Key: aws.String(awsdetail.BootStageTagKey), Value: aws.String(stage)}},
+		})
+	}
+}
+
+func (f *EC2) regionOf(id string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.regions[id]
+}
+
+// find an instance in this region.
+func (f *EC2) find(id string) *ec2.Instance {
+	if f.regions[id] != f.region {
+		return nil
+	}
+	return f.findAny(id)
+}
+
+// findAny finds an instance in any region.
+func (f *EC2) findAny(id string) *ec2.Instance {
+	for _, instance := range f.instances {
+		if aws.StringValue(instance.InstanceId) == id {
+			return instance
+		}
+	}
+	return nil
+}
+
+func matchesFilters(instance *ec2.Instance, filters []*ec2.Filter) bool {
+	for _, filter := range filters {
+		name := aws.StringValue(filter.Name)
+		var actual []string
+
+		switch {
+		case name == "tag-key":
+			for _, tag := range instance.Tags {
+				actual = append(actual, aws.StringValue(tag.Key))
+			}
+		case strings.HasPrefix(name, "tag:"):
+			key := strings.TrimPrefix(name, "tag:")
+			for _, tag := range instance.Tags {
+				if aws.StringValue(tag.Key) == key {
+					actual = append(actual, aws.StringValue(tag.Value))
+				}
+			}
+		case name == "instance-state-name":
+			actual = append(actual, aws.StringValue(instance.State.Name))
+		case name == "instance-id":
+			actual = append(actual, aws.StringValue(instance.InstanceId))
+		default:
+			panic("fakeaws: unsupported EC2 filter: " + name)
+		}
+
+		matched := false
+		for _, a := range actual {
+			if containsString(filter.Values, a) {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func stateOf(name string) *ec2.InstanceState {
+	codes := map[string]int64{
+		ec2.InstanceStateNamePending:      0,
+		ec2.InstanceStateNameRunning:      16,
+		ec2.InstanceStateNameShuttingDown: 32,
+		ec2.InstanceStateNameTerminated:   48,
+		ec2.InstanceStateNameStopping:     64,
+		ec2.InstanceStateNameStopped:      80,
+	}
+	return &ec2.InstanceState{Name: aws.String(name), Code: aws.Int64(codes[name])}
+}
+
+func copyInstance(instance *ec2.Instance) *ec2.Instance {
+	c := *instance
+	c.State = stateOf(aws.StringValue(instance.State.Name))
+	c.Tags = nil
+	for _, tag := range instance.Tags {
+		c.Tags = append(c.Tags, &ec2.Tag{Key: tag.Key, Value: tag.Value})
+	}
+	return &c
+}
+
+func containsString(list []*string, s string) bool {
+	for _, item := range list {
+		if aws.StringValue(item) == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package fakeaws
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// CreateKeyPair generates a real RSA key, so it can be used with SSH
+// libraries and imported into other regions.
+func (f *EC2) CreateKeyPair(input *ec2.CreateKeyPairInput) (*ec2.CreateKeyPairOutput, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	material := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	info, err := f.addKeyPair(input.KeyName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ec2.CreateKeyPairOutput{
+		KeyName:        info.KeyName,
+		KeyPairId:      info.KeyPairId,
+		KeyFingerprint: info.KeyFingerprint,
+		KeyMaterial:    aws.String(string(material)),
+	}, nil
+}
+
+// ImportKeyPair adds a key pair from a public key.
+func (f *EC2) ImportKeyPair(input *ec2.ImportKeyPairInput) (*ec2.ImportKeyPairOutput, error) {
+	if len(input.PublicKeyMaterial) == 0 {
+		return nil, awserr.New("InvalidKey.Format", "Key is not in valid OpenSSH public key format", nil)
+	}
+
+	info, err := f.addKeyPair(input.KeyName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ec2.ImportKeyPairOutput{
+		KeyName:        info.KeyName,
+		KeyFingerprint: info.KeyFingerprint,
+	}, nil
+}
+
+func (f *EC2) addKeyPair(name *string) (*ec2.KeyPairInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := f.keyPairKey(aws.StringValue(name))
+	if _, ok := f.keyPairs[key]; ok {
+		return nil, awserr.New("InvalidKeyPair.Duplicate", fmt.Sprintf("The keypair '%s' already exists.", aws.StringValue(name)), nil)
+	}
+
+	f.nextID++
+	info := &ec2.KeyPairInfo{
+		KeyName:        name,
+		KeyPairId:      aws.String(fmt.Sprintf("key-fake%08d", f.nextID)),
+		KeyFingerprint: aws.String("fa:ke"),
+	}
+	f.keyPairs[key] = info
+	return info, nil
+}
+
+// DescribeKeyPairs describes the named key pairs in this region.
+func (f *EC2) DescribeKeyPairs(input *ec2.DescribeKeyPairsInput) (*ec2.DescribeKeyPairsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &ec2.DescribeKeyPairsOutput{}
+	for _, name := range input.KeyNames {
+		info, ok := f.keyPairs[f.keyPairKey(aws.StringValue(name))]
+		if !ok {
+			return nil, awserr.New("InvalidKeyPair.NotFound", fmt.Sprintf("The key pair '%s' does not exist", aws.StringValue(name)), nil)
+		}
+		output.KeyPairs = append(output.KeyPairs, info)
+	}
+	return output, nil
+}
+
+// DeleteKeyPair deletes a key pair, succeeding if it doesn't exist like EC2.
+func (f *EC2) DeleteKeyPair(input *ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.keyPairs, f.keyPairKey(aws.StringValue(input.KeyName)))
+	return &ec2.DeleteKeyPairOutput{}, nil
+}
+
+// KeyPair returns the key pair with the given name in a region, or nil.
+func (f *EC2) KeyPair(region, name string) *ec2.KeyPairInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.keyPairs[region+"/"+name]
+}
+
+func (f *EC2) keyPairKey(name string) string {
+	return f.region + "/" + name
+}
+
+// CreateSecurityGroup creates a security group with no rules.
+func (f *EC2) CreateSecurityGroup(input *ec2.CreateSecurityGroupInput) (*ec2.CreateSecurityGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.findGroupByName(aws.StringValue(input.GroupName)) != nil {
+		return nil, awserr.New("InvalidGroup.Duplicate", fmt.Sprintf("The security group '%s' already exists", aws.StringValue(input.GroupName)), nil)
+	}
+
+	f.nextID++
+	id := fmt.Sprintf("sg-fake%08d", f.nextID)
+
+	f.groups = append(f.groups, &ec2.SecurityGroup{
+		GroupId:     aws.String(id),
+		GroupName:   input.GroupName,
+		Description: input.Description,
+	})
+	f.regions[id] = f.region
+
+	return &ec2.CreateSecurityGroupOutput{GroupId: aws.String(id)}, nil
+}
+
+// AuthorizeSecurityGroupIngress adds rules to a security group.
+func (f *EC2) AuthorizeSecurityGroupIngress(input *ec2.AuthorizeSecurityGroupIngressInput) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, err := f.findGroup(aws.StringValue(input.GroupId))
+	if err != nil {
+		return nil, err
+	}
+
+	group.IpPermissions = append(group.IpPermissions, input.IpPermissions...)
+	return &ec2.AuthorizeSecurityGroupIngressOutput{}, nil
+}
+
+// DescribeSecurityGroups describes security groups in this region, filtered
+// by ID and group-name only.
+func (f *EC2) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &ec2.DescribeSecurityGroupsOutput{}
+	for _, group := range f.groups {
+		if f.regions[aws.StringValue(group.GroupId)] != f.region {
+			continue
+		}
+
+		matched := len(input.GroupIds) == 0 || containsString(input.GroupIds, aws.StringValue(group.GroupId))
+		for _, filter := range input.Filters {
+			if aws.StringValue(filter.Name) == "group-name" && !containsString(filter.Values, aws.StringValue(group.GroupName)) {
+				matched = false
+			}
+		}
+		if matched {
+			output.SecurityGroups = append(output.SecurityGroups, group)
+		}
+	}
+	return output, nil
+}
+
+// DeleteSecurityGroup deletes a security group.
+func (f *EC2) DeleteSecurityGroup(input *ec2.DeleteSecurityGroupInput) (*ec2.DeleteSecurityGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.findGroup(aws.StringValue(input.GroupId)); err != nil {
+		return nil, err
+	}
+
+	for i, group := range f.groups {
+		if aws.StringValue(group.GroupId) == aws.StringValue(input.GroupId) {
+			f.groups = append(f.groups[:i], f.groups[i+1:]...)
+			break
+		}
+	}
+	return &ec2.DeleteSecurityGroupOutput{}, nil
+}
+
+// SecurityGroup returns the named security group in a region, or nil.
+func (f *EC2) SecurityGroup(region, name string) *ec2.SecurityGroup {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.InRegion(region).findGroupByName(name)
+}
+
+func (f *EC2) findGroupByName(name string) *ec2.SecurityGroup {
+	for _, group := range f.groups {
+		if aws.StringValue(group.GroupName) == name && f.regions[aws.StringValue(group.GroupId)] == f.region {
+			return group
+		}
+	}
+	return nil
+}
+
+func (f *EC2) findGroup(id string) (*ec2.SecurityGroup, error) {
+	for _, group := range f.groups {
+		if aws.StringValue(group.GroupId) == id && f.regions[id] == f.region {
+			return group, nil
+		}
+	}
+	return nil, awserr.New("InvalidGroup.NotFound", fmt.Sprintf("The security group '%s' does not exist", id), nil)
+}
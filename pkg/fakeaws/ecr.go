@@ -0,0 +1,101 @@
+package fakeaws
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+)
+
+// ECR is a fake of the ECR API, holding repositories but no images. Like EC2
+// it is for one region, with InRegion giving the fake for another.
+type ECR struct {
+	ecriface.ECRAPI
+	*ecrState
+
+	region string
+}
+
+type ecrState struct {
+	mu           sync.Mutex
+	repositories map[string]*ecr.Repository // by region and name.
+}
+
+// NewECR creates an ECR fake with no repositories.
+func NewECR() *ECR {
+	return &ECR{
+		ecrState: &ecrState{repositories: map[string]*ecr.Repository{}},
+		region:   Region,
+	}
+}
+
+// InRegion returns the fake for another region, sharing state with this one.
+func (f *ECR) InRegion(region string) *ECR {
+	return &ECR{ecrState: f.ecrState, region: region}
+}
+
+// CreateRepository creates an empty repository.
+func (f *ECR) CreateRepository(input *ecr.CreateRepositoryInput) (*ecr.CreateRepositoryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.StringValue(input.RepositoryName)
+	if _, ok := f.repositories[f.region+"/"+name]; ok {
+		return nil, awserr.New(ecr.ErrCodeRepositoryAlreadyExistsException, fmt.Sprintf("The repository '%s' already exists", name), nil)
+	}
+
+	repo := &ecr.Repository{
+		RepositoryName: input.RepositoryName,
+		RepositoryArn:  aws.String("arn:aws:ecr:" + f.region + ":" + Account + ":repository/" + name),
+		RepositoryUri:  aws.String(Account + ".dkr.ecr." + f.region + ".amazonaws.com/" + name),
+	}
+	f.repositories[f.region+"/"+name] = repo
+	return &ecr.CreateRepositoryOutput{Repository: repo}, nil
+}
+
+// DescribeRepositories describes the named repositories.
+func (f *ECR) DescribeRepositories(input *ecr.DescribeRepositoriesInput) (*ecr.DescribeRepositoriesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &ecr.DescribeRepositoriesOutput{}
+	for _, name := range input.RepositoryNames {
+		repo, err := f.repository(name)
+		if err != nil {
+			return nil, err
+		}
+		output.Repositories = append(output.Repositories, repo)
+	}
+	return output, nil
+}
+
+// DeleteRepository deletes a repository.
+func (f *ECR) DeleteRepository(input *ecr.DeleteRepositoryInput) (*ecr.DeleteRepositoryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	repo, err := f.repository(input.RepositoryName)
+	if err != nil {
+		return nil, err
+	}
+	delete(f.repositories, f.region+"/"+aws.StringValue(input.RepositoryName))
+	return &ecr.DeleteRepositoryOutput{Repository: repo}, nil
+}
+
+// Repository returns the named repository in a region, or nil.
+func (f *ECR) Repository(region, name string) *ecr.Repository {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.repositories[region+"/"+name]
+}
+
+func (f *ECR) repository(name *string) (*ecr.Repository, error) {
+	repo, ok := f.repositories[f.region+"/"+aws.StringValue(name)]
+	if !ok {
+		return nil, awserr.New(ecr.ErrCodeRepositoryNotFoundException, fmt.Sprintf("The repository '%s' does not exist", aws.StringValue(name)), nil)
+	}
+	return repo, nil
+}
@@ -0,0 +1,305 @@
+package fakeaws
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// A small evaluator for the subset of DynamoDB condition and update
+// expressions Minecloud uses:
+//
+//	attribute_exists(a), attribute_not_exists(a)
+//	a = :v, a <> :v, a < :v, a <= :v, a > :v, a >= :v
+//	AND, OR, NOT and parentheses
+//	SET a = :v, b = :w REMOVE c, d
+//
+// Paths are top level attribute names, optionally via #placeholders.
+
+type exprContext struct {
+	names  map[string]*string
+	values map[string]*dynamodb.AttributeValue
+}
+
+func (ctx exprContext) name(token string) (string, error) {
+	if strings.HasPrefix(token, "#") {
+		name, ok := ctx.names[token]
+		if !ok || name == nil {
+			return "", fmt.Errorf("undefined expression attribute name %s", token)
+		}
+		return *name, nil
+	}
+	return token, nil
+}
+
+func (ctx exprContext) operand(token string, item map[string]*dynamodb.AttributeValue) (*dynamodb.AttributeValue, error) {
+	if strings.HasPrefix(token, ":") {
+		value, ok := ctx.values[token]
+		if !ok {
+			return nil, fmt.Errorf("undefined expression attribute value %s", token)
+		}
+		return value, nil
+	}
+
+	name, err := ctx.name(token)
+	if err != nil {
+		return nil, err
+	}
+	return item[name], nil
+}
+
+func tokenize(expr string) []string {
+	tokens := []string{}
+	i := 0
+	for i < len(expr) {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')' || c == ',' || c == '=':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '<' || c == '>':
+			if i+1 < len(expr) && (expr[i+1] == '=' || (c == '<' && expr[i+1] == '>')) {
+				tokens = append(tokens, expr[i:i+2])
+				i += 2
+			} else {
+				tokens = append(tokens, string(c))
+				i++
+			}
+		default:
+			j := i
+			for j < len(expr) && !strings.ContainsRune(" \t\n(),=<>", rune(expr[j])) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+type condParser struct {
+	tokens []string
+	pos    int
+	ctx    exprContext
+	item   map[string]*dynamodb.AttributeValue
+}
+
+// evalCondition evaluates a condition expression against an item, which is
+// nil if the item does not exist.
+func evalCondition(expr string, ctx exprContext, item map[string]*dynamodb.AttributeValue) (bool, error) {
+	if strings.TrimSpace(expr) == "" {
+		return true, nil
+	}
+
+	p := &condParser{tokens: tokenize(expr), ctx: ctx, item: item}
+	result, err := p.or()
+	if err != nil {
+		return false, err
+	}
+	if p.pos != len(p.tokens) {
+		return false, fmt.Errorf("unexpected token %q in condition", p.tokens[p.pos])
+	}
+	return result, nil
+}
+
+func (p *condParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *condParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *condParser) expect(t string) error {
+	if got := p.next(); got != t {
+		return fmt.Errorf("expected %q in condition, got %q", t, got)
+	}
+	return nil
+}
+
+func (p *condParser) or() (bool, error) {
+	left, err := p.and()
+	if err != nil {
+		return false, err
+	}
+	for strings.EqualFold(p.peek(), "OR") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return false, err
+		}
+		left = left || right
+	}
+	return left, nil
+}
+
+func (p *condParser) and() (bool, error) {
+	left, err := p.not()
+	if err != nil {
+		return false, err
+	}
+	for strings.EqualFold(p.peek(), "AND") {
+		p.next()
+		right, err := p.not()
+		if err != nil {
+			return false, err
+		}
+		left = left && right
+	}
+	return left, nil
+}
+
+func (p *condParser) not() (bool, error) {
+	if strings.EqualFold(p.peek(), "NOT") {
+		p.next()
+		v, err := p.not()
+		return !v, err
+	}
+	return p.primary()
+}
+
+func (p *condParser) primary() (bool, error) {
+	token := p.next()
+
+	if token == "(" {
+		v, err := p.or()
+		if err != nil {
+			return false, err
+		}
+		return v, p.expect(")")
+	}
+
+	if token == "attribute_exists" || token == "attribute_not_exists" {
+		if err := p.expect("("); err != nil {
+			return false, err
+		}
+		name, err := p.ctx.name(p.next())
+		if err != nil {
+			return false, err
+		}
+		if err := p.expect(")"); err != nil {
+			return false, err
+		}
+		_, exists := p.item[name]
+		return exists == (token == "attribute_exists"), nil
+	}
+
+	left, err := p.ctx.operand(token, p.item)
+	if err != nil {
+		return false, err
+	}
+	op := p.next()
+	right, err := p.ctx.operand(p.next(), p.item)
+	if err != nil {
+		return false, err
+	}
+
+	return compare(left, op, right)
+}
+
+func compare(left *dynamodb.AttributeValue, op string, right *dynamodb.AttributeValue) (bool, error) {
+	if left == nil || right == nil {
+		// Comparisons with missing attributes are false, except not-equals.
+		return op == "<>" && (left != nil || right != nil), nil
+	}
+
+	var cmp int
+	switch {
+	case left.N != nil && right.N != nil:
+		// Exactly, like DynamoDB, so large numbers like tokens compare.
+		l, ok := new(big.Rat).SetString(*left.N)
+		if !ok {
+			return false, fmt.Errorf("invalid number %s", *left.N)
+		}
+		r, ok := new(big.Rat).SetString(*right.N)
+		if !ok {
+			return false, fmt.Errorf("invalid number %s", *right.N)
+		}
+		cmp = l.Cmp(r)
+	case left.S != nil && right.S != nil:
+		cmp = strings.Compare(*left.S, *right.S)
+	case left.BOOL != nil && right.BOOL != nil:
+		if *left.BOOL != *right.BOOL {
+			cmp = 1
+		}
+	default:
+		cmp = 1 // mismatched types are never equal
+		if op != "=" && op != "<>" {
+			return false, nil
+		}
+	}
+
+	switch op {
+	case "=":
+		return cmp == 0, nil
+	case "<>":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return false, fmt.Errorf("unsupported comparator %q", op)
+}
+
+// applyUpdate applies a SET/REMOVE update expression to item in place.
+func applyUpdate(expr string, ctx exprContext, item map[string]*dynamodb.AttributeValue) error {
+	tokens := tokenize(expr)
+	clause := ""
+
+	for i := 0; i < len(tokens); {
+		token := tokens[i]
+
+		if strings.EqualFold(token, "SET") || strings.EqualFold(token, "REMOVE") {
+			clause = strings.ToUpper(token)
+			i++
+			continue
+		}
+		if token == "," {
+			i++
+			continue
+		}
+
+		name, err := ctx.name(token)
+		if err != nil {
+			return err
+		}
+
+		switch clause {
+		case "SET":
+			if i+2 >= len(tokens) || tokens[i+1] != "=" {
+				return fmt.Errorf("malformed SET in update expression %q", expr)
+			}
+			value, err := ctx.operand(tokens[i+2], item)
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				return fmt.Errorf("SET of missing attribute in %q", expr)
+			}
+			item[name] = value
+			i += 3
+		case "REMOVE":
+			delete(item, name)
+			i++
+		default:
+			return fmt.Errorf("unsupported update expression %q", expr)
+		}
+	}
+
+	return nil
+}
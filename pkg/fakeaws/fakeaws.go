@@ -0,0 +1,151 @@
+// Package fakeaws provides stateful, in-memory fakes of the AWS services
+// Minecloud uses, plus a fake SSH runner, so awsdetail can be exercised
+// without an AWS account.
+//
+// Each fake embeds its SDK interface. Calling a method that hasn't been faked
+// panics with a nil pointer dereference, which makes it obvious when a fake
+// needs extending.
+package fakeaws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/mcconfig"
+	"github.com/sirupsen/logrus"
+)
+
+// Region the fakes pretend to be in.
+const Region = "eu-west-2"
+
+// What NewWithTable sets up, and TestConfig points at.
+const (
+	Table         = "MinecloudServers"
+	Bucket        = "ogage-minecraft"
+	ZoneID        = "ZFAKE"
+	ZoneSuffix    = "example.com."
+	SecurityGroup = "sg-fake"
+)
+
+// Services bundles a fake of every service a Detail needs.
+type Services struct {
+	EC2      *EC2
+	S3       *S3
+	DynamoDB *DynamoDB
+	Route53  *Route53
+	STS      *STS
+	SSM      *SSM
+	ECR      *ECR
+	IAM      *IAM
+	Lambda   *Lambda
+	SSH      *SSH
+	Wrapper  *Wrapper
+}
+
+// New creates a fresh set of fakes with nothing in them.
+func New() *Services {
+	ec2 := NewEC2()
+	ssh := &SSH{EC2: ec2}
+	db := NewDynamoDB()
+
+	// Instances run their user data over the fake SSH, so tests can check
+	// what was run the same way either way.
+	ec2.Boot = func(instanceID, userData string) error {
+		return ssh.Run(instanceID, userData, awsdetail.RunOpts{})
+	}
+
+	return &Services{
+		EC2:      ec2,
+		S3:       NewS3(),
+		DynamoDB: db,
+		Route53:  NewRoute53(),
+		STS:      &STS{},
+		SSM:      &SSM{},
+		ECR:      NewECR(),
+		IAM:      NewIAM(),
+		Lambda:   NewLambda(),
+		SSH:      ssh,
+		Wrapper:  NewWrapper(ssh, db),
+	}
+}
+
+// NewWithTable creates fakes with what Init would have made for the main
+// region: the claims table and the worlds bucket, ready for TestConfig.
+func NewWithTable() *Services {
+	s := New()
+
+	_, err := s.DynamoDB.CreateTable(&dynamodb.CreateTableInput{
+		TableName: aws.String(Table),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("world"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = s.S3.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(Bucket)})
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+// TestConfig uses the resources from NewWithTable, with a security group
+// configured so none has to be looked up.
+func TestConfig() awsdetail.Config {
+	return awsdetail.Config{
+		Config: mcconfig.Config{
+			HostedZoneID:     ZoneID,
+			HostedZoneSuffix: ZoneSuffix,
+			Bucket:           Bucket,
+			SecurityGroupID:  SecurityGroup,
+		},
+	}
+}
+
+// Detail creates an awsdetail.Detail backed by the fakes.
+func (s *Services) Detail(config awsdetail.Config) *awsdetail.Detail {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(Region),
+		Credentials: credentials.AnonymousCredentials,
+	}))
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	config.Config = config.Config.WithDefaults()
+	if config.Region == "" {
+		config.Region = Region
+	}
+
+	return &awsdetail.Detail{
+		Session:  sess,
+		EC2:      s.EC2,
+		S3:       s.S3,
+		DynamoDB: s.DynamoDB,
+		Route53:  s.Route53,
+		STS:      s.STS,
+		SSM:      s.SSM,
+		ECR:      s.ECR,
+		IAM:      s.IAM,
+		Lambda:   s.Lambda,
+		Runner:   s.SSH,
+		Logger:   logger,
+		Config:   config,
+
+		NewWrapperClient: s.Wrapper.Client,
+		NewRegionClients: func(sess *session.Session) awsdetail.RegionClients {
+			return awsdetail.RegionClients{
+				EC2: s.EC2.InRegion(aws.StringValue(sess.Config.Region)),
+				S3:  s.S3,
+				SSM: s.SSM,
+				ECR: s.ECR.InRegion(aws.StringValue(sess.Config.Region)),
+			}
+		},
+	}
+}
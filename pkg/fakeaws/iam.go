@@ -0,0 +1,448 @@
+package fakeaws
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// IAM is a stateful fake of the IAM API, holding roles, customer managed
+// policies and instance profiles.
+type IAM struct {
+	iamiface.IAMAPI
+
+	mu       sync.Mutex
+	roles    map[string]*iam.Role
+	policies map[string]*policy // by ARN.
+	profiles map[string]*iam.InstanceProfile
+}
+
+type policy struct {
+	policy   *iam.Policy
+	versions []*iam.PolicyVersion // documents URL encoded, like IAM gives them.
+	attached map[string]bool      // role names.
+	next     int
+}
+
+// NewIAM creates an IAM fake with nothing in it.
+func NewIAM() *IAM {
+	return &IAM{
+		roles:    map[string]*iam.Role{},
+		policies: map[string]*policy{},
+		profiles: map[string]*iam.InstanceProfile{},
+	}
+}
+
+func noSuchEntity(kind, name string) error {
+	return awserr.New(iam.ErrCodeNoSuchEntityException, fmt.Sprintf("The %s with name %s cannot be found.", kind, name), nil)
+}
+
+func entityExists(kind, name string) error {
+	return awserr.New(iam.ErrCodeEntityAlreadyExistsException, fmt.Sprintf("%s with name %s already exists.", kind, name), nil)
+}
+
+// CreateRole creates a role.
+func (f *IAM) CreateRole(input *iam.CreateRoleInput) (*iam.CreateRoleOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.StringValue(input.RoleName)
+	if _, ok := f.roles[name]; ok {
+		return nil, entityExists("Role", name)
+	}
+
+	role := &iam.Role{
+		RoleName:                 input.RoleName,
+		Arn:                      aws.String("arn:aws:iam::" + Account + ":role/" + name),
+		Description:              input.Description,
+		AssumeRolePolicyDocument: input.AssumeRolePolicyDocument,
+	}
+	f.roles[name] = role
+	return &iam.CreateRoleOutput{Role: role}, nil
+}
+
+// GetRole describes a role.
+func (f *IAM) GetRole(input *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	role, ok := f.roles[aws.StringValue(input.RoleName)]
+	if !ok {
+		return nil, noSuchEntity("role", aws.StringValue(input.RoleName))
+	}
+	return &iam.GetRoleOutput{Role: role}, nil
+}
+
+// DeleteRole deletes a role, which must have no policies attached and not be
+// in an instance profile.
+func (f *IAM) DeleteRole(input *iam.DeleteRoleInput) (*iam.DeleteRoleOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.StringValue(input.RoleName)
+	if _, ok := f.roles[name]; !ok {
+		return nil, noSuchEntity("role", name)
+	}
+
+	for _, p := range f.policies {
+		if p.attached[name] {
+			return nil, awserr.New(iam.ErrCodeDeleteConflictException, "Cannot delete entity, must detach all policies first.", nil)
+		}
+	}
+	for _, profile := range f.profiles {
+		for _, role := range profile.Roles {
+			if aws.StringValue(role.RoleName) == name {
+				return nil, awserr.New(iam.ErrCodeDeleteConflictException, "Cannot delete entity, must remove roles from instance profile first.", nil)
+			}
+		}
+	}
+
+	delete(f.roles, name)
+	return &iam.DeleteRoleOutput{}, nil
+}
+
+// CreatePolicy creates a customer managed policy.
+func (f *IAM) CreatePolicy(input *iam.CreatePolicyInput) (*iam.CreatePolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.StringValue(input.PolicyName)
+	arn := "arn:aws:iam::" + Account + ":policy/" + name
+	if _, ok := f.policies[arn]; ok {
+		return nil, entityExists("A policy called", name)
+	}
+
+	p := &policy{
+		policy: &iam.Policy{
+			PolicyName:  input.PolicyName,
+			Arn:         aws.String(arn),
+			Description: input.Description,
+		},
+		attached: map[string]bool{},
+	}
+	p.addVersion(aws.StringValue(input.PolicyDocument), true)
+	f.policies[arn] = p
+	return &iam.CreatePolicyOutput{Policy: p.policy}, nil
+}
+
+func (p *policy) addVersion(document string, isDefault bool) *iam.PolicyVersion {
+	p.next++
+	version := &iam.PolicyVersion{
+		VersionId:        aws.String(fmt.Sprintf("v%d", p.next)),
+		Document:         aws.String(url.PathEscape(document)),
+		IsDefaultVersion: aws.Bool(isDefault),
+		CreateDate:       aws.Time(time.Now()),
+	}
+
+	if isDefault {
+		for _, v := range p.versions {
+			v.IsDefaultVersion = aws.Bool(false)
+		}
+		p.policy.DefaultVersionId = version.VersionId
+	}
+	p.versions = append(p.versions, version)
+	return version
+}
+
+// CreatePolicyVersion adds a version to a policy, which can have at most five.
+func (f *IAM) CreatePolicyVersion(input *iam.CreatePolicyVersionInput) (*iam.CreatePolicyVersionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, err := f.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.versions) >= 5 {
+		return nil, awserr.New(iam.ErrCodeLimitExceededException, "A managed policy can have up to 5 versions.", nil)
+	}
+
+	version := p.addVersion(aws.StringValue(input.PolicyDocument), aws.BoolValue(input.SetAsDefault))
+	return &iam.CreatePolicyVersionOutput{PolicyVersion: version}, nil
+}
+
+// GetPolicyVersion gives a version of a policy, with its document.
+func (f *IAM) GetPolicyVersion(input *iam.GetPolicyVersionInput) (*iam.GetPolicyVersionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, err := f.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, version := range p.versions {
+		if aws.StringValue(version.VersionId) == aws.StringValue(input.VersionId) {
+			return &iam.GetPolicyVersionOutput{PolicyVersion: version}, nil
+		}
+	}
+	return nil, noSuchEntity("policy version", aws.StringValue(input.VersionId))
+}
+
+// DeletePolicyVersion deletes a version that isn't the default.
+func (f *IAM) DeletePolicyVersion(input *iam.DeletePolicyVersionInput) (*iam.DeletePolicyVersionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, err := f.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, version := range p.versions {
+		if aws.StringValue(version.VersionId) != aws.StringValue(input.VersionId) {
+			continue
+		}
+		if aws.BoolValue(version.IsDefaultVersion) {
+			return nil, awserr.New(iam.ErrCodeDeleteConflictException, "Cannot delete the default version of a policy.", nil)
+		}
+		p.versions = append(p.versions[:i], p.versions[i+1:]...)
+		return &iam.DeletePolicyVersionOutput{}, nil
+	}
+	return nil, noSuchEntity("policy version", aws.StringValue(input.VersionId))
+}
+
+// GetPolicy describes a policy.
+func (f *IAM) GetPolicy(input *iam.GetPolicyInput) (*iam.GetPolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, err := f.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+	return &iam.GetPolicyOutput{Policy: p.policy}, nil
+}
+
+// ListPolicyVersions lists the versions of a policy, oldest first.
+func (f *IAM) ListPolicyVersions(input *iam.ListPolicyVersionsInput) (*iam.ListPolicyVersionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, err := f.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &iam.ListPolicyVersionsOutput{}
+	for _, version := range p.versions {
+		output.Versions = append(output.Versions, &iam.PolicyVersion{
+			VersionId:        version.VersionId,
+			IsDefaultVersion: version.IsDefaultVersion,
+			CreateDate:       version.CreateDate,
+		})
+	}
+	return output, nil
+}
+
+// DeletePolicy deletes a policy, which must not be attached to anything.
+func (f *IAM) DeletePolicy(input *iam.DeletePolicyInput) (*iam.DeletePolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, err := f.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.attached) > 0 {
+		return nil, awserr.New(iam.ErrCodeDeleteConflictException, "Cannot delete a policy attached to entities.", nil)
+	}
+
+	delete(f.policies, aws.StringValue(input.PolicyArn))
+	return &iam.DeletePolicyOutput{}, nil
+}
+
+// AttachRolePolicy attaches a policy to a role.
+func (f *IAM) AttachRolePolicy(input *iam.AttachRolePolicyInput) (*iam.AttachRolePolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, err := f.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := f.roles[aws.StringValue(input.RoleName)]; !ok {
+		return nil, noSuchEntity("role", aws.StringValue(input.RoleName))
+	}
+
+	p.attached[aws.StringValue(input.RoleName)] = true
+	return &iam.AttachRolePolicyOutput{}, nil
+}
+
+// DetachRolePolicy detaches a policy from a role.
+func (f *IAM) DetachRolePolicy(input *iam.DetachRolePolicyInput) (*iam.DetachRolePolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, err := f.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+	if !p.attached[aws.StringValue(input.RoleName)] {
+		return nil, noSuchEntity("attachment", aws.StringValue(input.RoleName))
+	}
+
+	delete(p.attached, aws.StringValue(input.RoleName))
+	return &iam.DetachRolePolicyOutput{}, nil
+}
+
+// ListAttachedRolePolicies lists the policies attached to a role.
+func (f *IAM) ListAttachedRolePolicies(input *iam.ListAttachedRolePoliciesInput) (*iam.ListAttachedRolePoliciesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.StringValue(input.RoleName)
+	if _, ok := f.roles[name]; !ok {
+		return nil, noSuchEntity("role", name)
+	}
+
+	output := &iam.ListAttachedRolePoliciesOutput{}
+	for _, p := range f.policies {
+		if p.attached[name] {
+			output.AttachedPolicies = append(output.AttachedPolicies, &iam.AttachedPolicy{
+				PolicyName: p.policy.PolicyName,
+				PolicyArn:  p.policy.Arn,
+			})
+		}
+	}
+	return output, nil
+}
+
+// CreateInstanceProfile creates an instance profile with no role.
+func (f *IAM) CreateInstanceProfile(input *iam.CreateInstanceProfileInput) (*iam.CreateInstanceProfileOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.StringValue(input.InstanceProfileName)
+	if _, ok := f.profiles[name]; ok {
+		return nil, entityExists("Instance Profile", name)
+	}
+
+	profile := &iam.InstanceProfile{
+		InstanceProfileName: input.InstanceProfileName,
+		Arn:                 aws.String("arn:aws:iam::" + Account + ":instance-profile/" + name),
+	}
+	f.profiles[name] = profile
+	return &iam.CreateInstanceProfileOutput{InstanceProfile: profile}, nil
+}
+
+// GetInstanceProfile describes an instance profile.
+func (f *IAM) GetInstanceProfile(input *iam.GetInstanceProfileInput) (*iam.GetInstanceProfileOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	profile, ok := f.profiles[aws.StringValue(input.InstanceProfileName)]
+	if !ok {
+		return nil, noSuchEntity("instance profile", aws.StringValue(input.InstanceProfileName))
+	}
+	return &iam.GetInstanceProfileOutput{InstanceProfile: profile}, nil
+}
+
+// AddRoleToInstanceProfile adds a role to an instance profile.
+func (f *IAM) AddRoleToInstanceProfile(input *iam.AddRoleToInstanceProfileInput) (*iam.AddRoleToInstanceProfileOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	profile, ok := f.profiles[aws.StringValue(input.InstanceProfileName)]
+	if !ok {
+		return nil, noSuchEntity("instance profile", aws.StringValue(input.InstanceProfileName))
+	}
+	role, ok := f.roles[aws.StringValue(input.RoleName)]
+	if !ok {
+		return nil, noSuchEntity("role", aws.StringValue(input.RoleName))
+	}
+
+	profile.Roles = append(profile.Roles, role)
+	return &iam.AddRoleToInstanceProfileOutput{}, nil
+}
+
+// RemoveRoleFromInstanceProfile removes a role from an instance profile.
+func (f *IAM) RemoveRoleFromInstanceProfile(input *iam.RemoveRoleFromInstanceProfileInput) (*iam.RemoveRoleFromInstanceProfileOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	profile, ok := f.profiles[aws.StringValue(input.InstanceProfileName)]
+	if !ok {
+		return nil, noSuchEntity("instance profile", aws.StringValue(input.InstanceProfileName))
+	}
+
+	for i, role := range profile.Roles {
+		if aws.StringValue(role.RoleName) == aws.StringValue(input.RoleName) {
+			profile.Roles = append(profile.Roles[:i], profile.Roles[i+1:]...)
+			return &iam.RemoveRoleFromInstanceProfileOutput{}, nil
+		}
+	}
+	return nil, noSuchEntity("role", aws.StringValue(input.RoleName))
+}
+
+// DeleteInstanceProfile deletes an instance profile, which must have no role.
+func (f *IAM) DeleteInstanceProfile(input *iam.DeleteInstanceProfileInput) (*iam.DeleteInstanceProfileOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	profile, ok := f.profiles[aws.StringValue(input.InstanceProfileName)]
+	if !ok {
+		return nil, noSuchEntity("instance profile", aws.StringValue(input.InstanceProfileName))
+	}
+	if len(profile.Roles) > 0 {
+		return nil, awserr.New(iam.ErrCodeDeleteConflictException, "Cannot delete entity, must remove roles from instance profile first.", nil)
+	}
+
+	delete(f.profiles, aws.StringValue(input.InstanceProfileName))
+	return &iam.DeleteInstanceProfileOutput{}, nil
+}
+
+// Role returns the named role, or nil.
+func (f *IAM) Role(name string) *iam.Role {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.roles[name]
+}
+
+// PolicyDocument returns the default document of the named policy and the
+// roles it is attached to, or an empty document if there is no such policy.
+func (f *IAM) PolicyDocument(name string) (string, []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.policies["arn:aws:iam::"+Account+":policy/"+name]
+	if !ok {
+		return "", nil
+	}
+
+	roles := []string{}
+	for role := range p.attached {
+		roles = append(roles, role)
+	}
+
+	for _, version := range p.versions {
+		if aws.BoolValue(version.IsDefaultVersion) {
+			document, _ := url.PathUnescape(aws.StringValue(version.Document))
+			return document, roles
+		}
+	}
+	return "", roles
+}
+
+// InstanceProfile returns the named instance profile, or nil.
+func (f *IAM) InstanceProfile(name string) *iam.InstanceProfile {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.profiles[name]
+}
+
+func (f *IAM) policy(arn *string) (*policy, error) {
+	p, ok := f.policies[aws.StringValue(arn)]
+	if !ok {
+		name := aws.StringValue(arn)
+		return nil, noSuchEntity("policy", name[strings.LastIndex(name, "/")+1:])
+	}
+	return p, nil
+}
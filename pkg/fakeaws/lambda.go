@@ -0,0 +1,119 @@
+package fakeaws
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// Lambda is a fake of the Lambda API, holding functions that can't be
+// invoked. Use functions.LocalInvoker to run the lambdas' code.
+type Lambda struct {
+	lambdaiface.LambdaAPI
+
+	mu        sync.Mutex
+	functions map[string]*lambda.CreateFunctionInput
+}
+
+// NewLambda creates a Lambda fake with no functions.
+func NewLambda() *Lambda {
+	return &Lambda{
+		functions: map[string]*lambda.CreateFunctionInput{},
+	}
+}
+
+// CreateFunction stores the function's configuration.
+func (f *Lambda) CreateFunction(input *lambda.CreateFunctionInput) (*lambda.FunctionConfiguration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.StringValue(input.FunctionName)
+	if _, ok := f.functions[name]; ok {
+		return nil, awserr.New(lambda.ErrCodeResourceConflictException, "Function already exist: "+name, nil)
+	}
+
+	fn := *input
+	f.functions[name] = &fn
+	return configurationOf(&fn), nil
+}
+
+// GetFunction describes a function.
+func (f *Lambda) GetFunction(input *lambda.GetFunctionInput) (*lambda.GetFunctionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fn, err := f.function(input.FunctionName)
+	if err != nil {
+		return nil, err
+	}
+	return &lambda.GetFunctionOutput{Configuration: configurationOf(fn)}, nil
+}
+
+// UpdateFunctionConfiguration changes a function's timeout, role and
+// environment.
+func (f *Lambda) UpdateFunctionConfiguration(input *lambda.UpdateFunctionConfigurationInput) (*lambda.FunctionConfiguration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fn, err := f.function(input.FunctionName)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Timeout != nil {
+		fn.Timeout = input.Timeout
+	}
+	if input.Role != nil {
+		fn.Role = input.Role
+	}
+	if input.Environment != nil {
+		fn.Environment = input.Environment
+	}
+	return configurationOf(fn), nil
+}
+
+// DeleteFunction deletes a function.
+func (f *Lambda) DeleteFunction(input *lambda.DeleteFunctionInput) (*lambda.DeleteFunctionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.function(input.FunctionName); err != nil {
+		return nil, err
+	}
+	delete(f.functions, aws.StringValue(input.FunctionName))
+	return &lambda.DeleteFunctionOutput{}, nil
+}
+
+// Function returns the input a function was created with, or nil.
+func (f *Lambda) Function(name string) *lambda.CreateFunctionInput {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.functions[name]
+}
+
+func (f *Lambda) function(name *string) (*lambda.CreateFunctionInput, error) {
+	fn, ok := f.functions[aws.StringValue(name)]
+	if !ok {
+		return nil, awserr.New(lambda.ErrCodeResourceNotFoundException, fmt.Sprintf("Function not found: %s", aws.StringValue(name)), nil)
+	}
+	return fn, nil
+}
+
+func configurationOf(input *lambda.CreateFunctionInput) *lambda.FunctionConfiguration {
+	config := &lambda.FunctionConfiguration{
+		FunctionName: input.FunctionName,
+		FunctionArn:  aws.String("arn:aws:lambda:" + Region + ":" + Account + ":function:" + aws.StringValue(input.FunctionName)),
+		Runtime:      input.Runtime,
+		Handler:      input.Handler,
+		Role:         input.Role,
+		Timeout:      input.Timeout,
+	}
+	if input.Environment != nil {
+		config.Environment = &lambda.EnvironmentResponse{Variables: input.Environment.Variables}
+	}
+	return config
+}
@@ -0,0 +1,104 @@
+package fakeaws
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+)
+
+// Route53 is a stateful, in-memory fake of the Route53 API. Zones spring into
+// existence when first changed.
+type Route53 struct {
+	route53iface.Route53API
+
+	mu    sync.Mutex
+	zones map[string]map[string]*route53.ResourceRecordSet
+}
+
+// NewRoute53 creates a Route53 fake with no records.
+func NewRoute53() *Route53 {
+	return &Route53{
+		zones: map[string]map[string]*route53.ResourceRecordSet{},
+	}
+}
+
+// ChangeResourceRecordSets applies a change batch atomically.
+func (f *Route53) ChangeResourceRecordSets(input *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	zoneID := aws.StringValue(input.HostedZoneId)
+	zone := map[string]*route53.ResourceRecordSet{}
+	for k, v := range f.zones[zoneID] {
+		zone[k] = v
+	}
+
+	for _, change := range input.ChangeBatch.Changes {
+		set := change.ResourceRecordSet
+		key := recordKey(aws.StringValue(set.Name), aws.StringValue(set.Type))
+
+		switch aws.StringValue(change.Action) {
+		case route53.ChangeActionCreate:
+			if _, ok := zone[key]; ok {
+				return nil, awserr.New(route53.ErrCodeInvalidChangeBatch, "record already exists: "+key, nil)
+			}
+			zone[key] = set
+		case route53.ChangeActionUpsert:
+			zone[key] = set
+		case route53.ChangeActionDelete:
+			if _, ok := zone[key]; !ok {
+				return nil, awserr.New(route53.ErrCodeInvalidChangeBatch, "record not found: "+key, nil)
+			}
+			delete(zone, key)
+		}
+	}
+
+	f.zones[zoneID] = zone
+
+	return &route53.ChangeResourceRecordSetsOutput{
+		ChangeInfo: &route53.ChangeInfo{
+			Id:     aws.String("/change/FAKE"),
+			Status: aws.String(route53.ChangeStatusInsync),
+		},
+	}, nil
+}
+
+// ListResourceRecordSets lists every record in a zone, in name order.
+func (f *Route53) ListResourceRecordSets(input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	zone := f.zones[aws.StringValue(input.HostedZoneId)]
+
+	keys := []string{}
+	for key := range zone {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	output := &route53.ListResourceRecordSetsOutput{IsTruncated: aws.Bool(false)}
+	for _, key := range keys {
+		output.ResourceRecordSets = append(output.ResourceRecordSets, zone[key])
+	}
+	return output, nil
+}
+
+// Record is a helper returning the record set with the given name and type, or nil.
+func (f *Route53) Record(zoneID, name, recordType string) *route53.ResourceRecordSet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.zones[zoneID][recordKey(name, recordType)]
+}
+
+func recordKey(name, recordType string) string {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return strings.ToLower(name) + " " + recordType
+}
@@ -0,0 +1,398 @@
+package fakeaws
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// S3 is a stateful, in-memory fake of the S3 API.
+type S3 struct {
+	s3iface.S3API
+
+	mu        sync.Mutex
+	buckets   map[string]map[string]*object
+	protected map[string]bool
+}
+
+type object struct {
+	data     []byte
+	modified time.Time
+}
+
+// NewS3 creates an S3 fake with no buckets.
+func NewS3() *S3 {
+	return &S3{
+		buckets:   map[string]map[string]*object{},
+		protected: map[string]bool{},
+	}
+}
+
+// CreateBucket creates an empty bucket.
+func (f *S3) CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.StringValue(input.Bucket)
+	if _, ok := f.buckets[name]; ok {
+		return nil, awserr.New(s3.ErrCodeBucketAlreadyOwnedByYou, "bucket already exists", nil)
+	}
+
+	f.buckets[name] = map[string]*object{}
+	return &s3.CreateBucketOutput{Location: aws.String("/" + name)}, nil
+}
+
+// HeadBucket succeeds if the bucket exists.
+func (f *S3) HeadBucket(input *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.bucket(input.Bucket); err != nil {
+		// Real S3 returns a bare 404 for HEAD requests.
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+// DeleteBucket deletes an empty bucket.
+func (f *S3) DeleteBucket(input *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects, err := f.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) > 0 {
+		return nil, awserr.New("BucketNotEmpty", "The bucket you tried to delete is not empty", nil)
+	}
+
+	delete(f.buckets, aws.StringValue(input.Bucket))
+	return &s3.DeleteBucketOutput{}, nil
+}
+
+// PutObject stores an object.
+func (f *S3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	var data []byte
+	if input.Body != nil {
+		var err error
+		data, err = ioutil.ReadAll(input.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects, err := f.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	objects[aws.StringValue(input.Key)] = &object{data: data, modified: time.Now()}
+	return &s3.PutObjectOutput{}, nil
+}
+
+// GetObject returns an object's contents.
+func (f *S3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	obj, err := f.object(input.Bucket, input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader(obj.data)),
+		ContentLength: aws.Int64(int64(len(obj.data))),
+		LastModified:  aws.Time(obj.modified),
+	}, nil
+}
+
+// HeadObject returns an object's metadata.
+func (f *S3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	obj, err := f.object(input.Bucket, input.Key)
+	if err != nil {
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(obj.data))),
+		LastModified:  aws.Time(obj.modified),
+	}, nil
+}
+
+// CopyObject copies an object. CopySource is "bucket/key", URL encoded.
+func (f *S3) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	copySource, err := url.PathUnescape(aws.StringValue(input.CopySource))
+	if err != nil {
+		return nil, awserr.New("InvalidArgument", "invalid copy source", err)
+	}
+
+	source := strings.SplitN(copySource, "/", 2)
+	if len(source) != 2 {
+		return nil, awserr.New("InvalidArgument", "invalid copy source", nil)
+	}
+
+	obj, err := f.object(aws.String(source[0]), aws.String(source[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := f.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	objects[aws.StringValue(input.Key)] = &object{data: obj.data, modified: time.Now()}
+	return &s3.CopyObjectOutput{}, nil
+}
+
+// DeleteObject deletes an object. Deleting a missing key succeeds, like S3.
+func (f *S3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects, err := f.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(objects, aws.StringValue(input.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// DeleteObjects deletes a batch of objects.
+func (f *S3) DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects, err := f.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &s3.DeleteObjectsOutput{}
+	for _, id := range input.Delete.Objects {
+		if f.protected[aws.StringValue(input.Bucket)+"/"+aws.StringValue(id.Key)] {
+			output.Errors = append(output.Errors, &s3.Error{Key: id.Key, Code: aws.String("AccessDenied"), Message: aws.String("Access Denied")})
+			continue
+		}
+
+		delete(objects, aws.StringValue(id.Key))
+		output.Deleted = append(output.Deleted, &s3.DeletedObject{Key: id.Key})
+	}
+	return output, nil
+}
+
+// ListObjectsV2 lists objects in key order. Continuation tokens are the index
+// of the next key.
+func (f *S3) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects, err := f.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := aws.StringValue(input.Prefix)
+	delimiter := aws.StringValue(input.Delimiter)
+
+	keys := []string{}
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > aws.StringValue(input.StartAfter) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if input.ContinuationToken != nil {
+		start, err = strconv.Atoi(*input.ContinuationToken)
+		if err != nil {
+			return nil, awserr.New("InvalidArgument", "invalid continuation token", nil)
+		}
+	}
+
+	maxKeys := int(aws.Int64Value(input.MaxKeys))
+	if maxKeys <= 0 || maxKeys > 1000 {
+		maxKeys = 1000
+	}
+
+	output := &s3.ListObjectsV2Output{
+		Name:      input.Bucket,
+		Prefix:    input.Prefix,
+		Delimiter: input.Delimiter,
+	}
+	seenPrefixes := map[string]bool{}
+
+	i := start
+	for ; i < len(keys) && int(aws.Int64Value(output.KeyCount)) < maxKeys; i++ {
+		key := keys[i]
+
+		if delimiter != "" {
+			rest := strings.TrimPrefix(key, prefix)
+			if idx := strings.Index(rest, delimiter); idx >= 0 {
+				common := prefix + rest[:idx+len(delimiter)]
+				if !seenPrefixes[common] {
+					seenPrefixes[common] = true
+					output.CommonPrefixes = append(output.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(common)})
+					output.KeyCount = aws.Int64(aws.Int64Value(output.KeyCount) + 1)
+				}
+				continue
+			}
+		}
+
+		obj := objects[key]
+		output.Contents = append(output.Contents, &s3.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(obj.data))),
+			LastModified: aws.Time(obj.modified),
+		})
+		output.KeyCount = aws.Int64(aws.Int64Value(output.KeyCount) + 1)
+	}
+
+	output.IsTruncated = aws.Bool(i < len(keys))
+	if i < len(keys) {
+		output.NextContinuationToken = aws.String(strconv.Itoa(i))
+	}
+
+	return output, nil
+}
+
+// ListObjectsV2Pages calls fn with each page of results.
+func (f *S3) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	in := *input
+	for {
+		page, err := f.ListObjectsV2(&in)
+		if err != nil {
+			return err
+		}
+
+		last := !aws.BoolValue(page.IsTruncated)
+		if !fn(page, last) || last {
+			return nil
+		}
+		in.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+// ListObjectsV2PagesWithContext is ListObjectsV2Pages, failing if ctx is done.
+func (f *S3) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, _ ...request.Option) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.ListObjectsV2Pages(input, fn)
+}
+
+// GetObjectWithContext is GetObject, failing if ctx is done.
+func (f *S3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.GetObject(input)
+}
+
+// PutObjectWithContext is PutObject, failing if ctx is done.
+func (f *S3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, _ ...request.Option) (*s3.PutObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.PutObject(input)
+}
+
+// DeleteObjectsWithContext is DeleteObjects, failing if ctx is done.
+func (f *S3) DeleteObjectsWithContext(ctx aws.Context, input *s3.DeleteObjectsInput, _ ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.DeleteObjects(input)
+}
+
+// Put is a helper to store an object directly, creating the bucket if needed.
+func (f *S3) Put(bucket, key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.buckets[bucket]; !ok {
+		f.buckets[bucket] = map[string]*object{}
+	}
+	f.buckets[bucket][key] = &object{data: data, modified: time.Now()}
+}
+
+// Protect is a helper making an object fail to delete in DeleteObjects, as
+// S3 reports per object errors in the output rather than failing the call.
+func (f *S3) Protect(bucket, key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.protected[bucket+"/"+key] = true
+}
+
+// Get is a helper returning an object's contents, or nil if it doesn't exist.
+func (f *S3) Get(bucket, key string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	obj, err := f.object(&bucket, &key)
+	if err != nil {
+		return nil
+	}
+	return obj.data
+}
+
+// Keys is a helper returning every key in a bucket with the given prefix, sorted.
+func (f *S3) Keys(bucket, prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := []string{}
+	for key := range f.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *S3) bucket(name *string) (map[string]*object, error) {
+	objects, ok := f.buckets[aws.StringValue(name)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil)
+	}
+	return objects, nil
+}
+
+func (f *S3) object(bucket, key *string) (*object, error) {
+	objects, err := f.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, ok := objects[aws.StringValue(key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return obj, nil
+}
@@ -0,0 +1,105 @@
+package fakeaws
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+)
+
+// SSHCall is a script run on an instance.
+type SSHCall struct {
+	InstanceID string
+	Script     string
+}
+
+// SSHHandler fakes the result of running a script. Anything written to stdout
+// is returned to the caller.
+type SSHHandler func(call SSHCall, stdout io.Writer) error
+
+// SSH is a fake awsdetail.Runner. It records every script run and answers
+// using handlers registered for substrings of the script. Scripts with no
+// handler succeed with no output.
+type SSH struct {
+	// EC2, if set, is checked so scripts can only run on running instances.
+	EC2 *EC2
+
+	mu       sync.Mutex
+	calls    []SSHCall
+	handlers []sshHandler
+}
+
+type sshHandler struct {
+	contains string
+	handler  SSHHandler
+}
+
+// Handle scripts containing the substring. Later handlers take precedence.
+func (f *SSH) Handle(contains string, handler SSHHandler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.handlers = append([]sshHandler{{contains, handler}}, f.handlers...)
+}
+
+// Run implements awsdetail.Runner.
+func (f *SSH) Run(instanceID, script string, opts awsdetail.RunOpts) error {
+	if f.EC2 != nil {
+		instance := f.EC2.Instance(instanceID)
+		if instance == nil || aws.StringValue(instance.State.Name) != ec2.InstanceStateNameRunning {
+			return errors.New("instance has no public IP (terminated?)")
+		}
+	}
+
+	call := SSHCall{InstanceID: instanceID, Script: script}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	var handler SSHHandler
+	for _, h := range f.handlers {
+		if strings.Contains(script, h.contains) {
+			handler = h.handler
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	if handler == nil {
+		return nil
+	}
+
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	return handler(call, stdout)
+}
+
+// Calls returns every script run so far.
+func (f *SSH) Calls() []SSHCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]SSHCall{}, f.calls...)
+}
+
+// Ran reports whether a script containing the substring was run on the instance.
+func (f *SSH) Ran(instanceID, contains string) bool {
+	for _, call := range f.Calls() {
+		if call.InstanceID == instanceID && strings.Contains(call.Script, contains) {
+			return true
+		}
+	}
+	return false
+}
+
+// exitError mimics the error from a remote command exiting non-zero.
+func exitError(status int, format string, args ...interface{}) error {
+	return fmt.Errorf("Process exited with status %d: %s", status, fmt.Sprintf(format, args...))
+}
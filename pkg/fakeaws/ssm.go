@@ -0,0 +1,129 @@
+package fakeaws
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// BaseImageID is the image the fake SSM gives as the latest Amazon Linux 2.
+const BaseImageID = "ami-fakebase"
+
+// SSM is a fake of the SSM API, holding the public parameters Minecloud reads
+// and any it puts.
+type SSM struct {
+	ssmiface.SSMAPI
+
+	mu     sync.Mutex
+	params map[string]*ssm.Parameter
+	tags   map[string]map[string]string
+}
+
+// GetParameter returns the fake base image for any Amazon Linux parameter,
+// or a parameter that was put.
+func (f *SSM) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	if param := f.Parameter(aws.StringValue(input.Name)); param != nil {
+		return &ssm.GetParameterOutput{Parameter: param}, nil
+	}
+
+	if aws.StringValue(input.Name) != "/aws/service/ami-amazon-linux-latest/amzn2-ami-hvm-x86_64-gp2" {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "parameter not found", nil)
+	}
+
+	return &ssm.GetParameterOutput{
+		Parameter: &ssm.Parameter{Name: input.Name, Value: aws.String(BaseImageID)},
+	}, nil
+}
+
+// PutParameter stores a parameter, failing if it exists unless overwriting.
+// Like SSM, tags can only be given for new parameters.
+func (f *SSM) PutParameter(input *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.StringValue(input.Name)
+	if _, ok := f.params[name]; ok && !aws.BoolValue(input.Overwrite) {
+		return nil, awserr.New(ssm.ErrCodeParameterAlreadyExists, "parameter already exists", nil)
+	}
+	if len(input.Tags) > 0 && aws.BoolValue(input.Overwrite) {
+		return nil, awserr.New("ValidationException", "tags can't be given when overwriting", nil)
+	}
+
+	if f.params == nil {
+		f.params = map[string]*ssm.Parameter{}
+		f.tags = map[string]map[string]string{}
+	}
+	f.params[name] = &ssm.Parameter{Name: input.Name, Type: input.Type, Value: input.Value}
+
+	if len(input.Tags) > 0 {
+		f.tags[name] = map[string]string{}
+		for _, tag := range input.Tags {
+			f.tags[name][aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return &ssm.PutParameterOutput{}, nil
+}
+
+// DeleteParameters deletes parameters that were put, listing any that
+// weren't as invalid.
+func (f *SSM) DeleteParameters(input *ssm.DeleteParametersInput) (*ssm.DeleteParametersOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := &ssm.DeleteParametersOutput{}
+	for _, name := range input.Names {
+		if _, ok := f.params[aws.StringValue(name)]; !ok {
+			out.InvalidParameters = append(out.InvalidParameters, name)
+			continue
+		}
+		delete(f.params, aws.StringValue(name))
+		delete(f.tags, aws.StringValue(name))
+		out.DeletedParameters = append(out.DeletedParameters, name)
+	}
+	return out, nil
+}
+
+// GetParametersByPathPages gives the parameters that were put under a path,
+// one per page.
+func (f *SSM) GetParametersByPathPages(input *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	f.mu.Lock()
+	names := []string{}
+	for name := range f.params {
+		if strings.HasPrefix(name, strings.TrimSuffix(aws.StringValue(input.Path), "/")+"/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	pages := []*ssm.GetParametersByPathOutput{}
+	for _, name := range names {
+		pages = append(pages, &ssm.GetParametersByPathOutput{Parameters: []*ssm.Parameter{f.params[name]}})
+	}
+	f.mu.Unlock()
+
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+// Parameter is a helper returning a parameter that was put, or nil.
+func (f *SSM) Parameter(name string) *ssm.Parameter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.params[name]
+}
+
+// Tag is a helper returning the value of a parameter's tag.
+func (f *SSM) Tag(name, key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tags[name][key]
+}
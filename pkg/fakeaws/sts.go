@@ -0,0 +1,24 @@
+package fakeaws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// Account is the AWS account ID the fakes pretend to be.
+const Account = "123456789012"
+
+// STS is a fake of the STS API.
+type STS struct {
+	stsiface.STSAPI
+}
+
+// GetCallerIdentity returns the fake account.
+func (f *STS) GetCallerIdentity(input *sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String(Account),
+		Arn:     aws.String("arn:aws:iam::" + Account + ":user/fake"),
+		UserId:  aws.String("AIDAFAKE"),
+	}, nil
+}
@@ -0,0 +1,268 @@
+package fakeaws
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+)
+
+// Wrapper fakes the server wrapper's HTTP API, both as reached by running curl
+// over SSH and over HTTPS with a client from Client. A wrapper is considered
+// started on an instance once a script running the server-wrapper container
+// has been run there. HTTPS requests must carry the token stored with the
+// world's claim in Table.
+type Wrapper struct {
+	mu       sync.Mutex
+	statuses map[string]string
+	requests map[string][]string
+	ec2      *EC2
+	dynamoDB *DynamoDB
+
+	unreachable   bool
+	wrongCert     bool
+	loseResponses bool
+}
+
+// NewWrapper creates a wrapper fake and installs its handlers on ssh. Tokens
+// are checked against claims in db.
+func NewWrapper(ssh *SSH, db *DynamoDB) *Wrapper {
+	w := &Wrapper{
+		statuses: map[string]string{},
+		requests: map[string][]string{},
+		ec2:      ssh.EC2,
+		dynamoDB: db,
+	}
+
+	ssh.Handle("--name serverwrapper", func(call SSHCall, stdout io.Writer) error {
+		w.SetStatus(call.InstanceID, serverwrapper.StatusRunning)
+		return nil
+	})
+
+	ssh.Handle("localhost:8080/status", w.sshHandler(w.statusResponse))
+	ssh.Handle("localhost:8080/stop", w.sshHandler(w.stopResponse))
+	ssh.Handle("localhost:8080/save", w.sshHandler(w.saveResponse))
+	ssh.Handle("localhost:8080/upload", w.sshHandler(w.uploadResponse))
+
+	return w
+}
+
+// Status of the wrapper on an instance, empty if never started.
+func (w *Wrapper) Status(instanceID string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.statuses[instanceID]
+}
+
+// SetStatus of the wrapper on an instance.
+func (w *Wrapper) SetStatus(instanceID, status string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.statuses[instanceID] = status
+}
+
+// SetUnreachable makes HTTPS requests fail, as if blocked by a firewall.
+func (w *Wrapper) SetUnreachable(unreachable bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.unreachable = unreachable
+}
+
+// SetWrongCert makes HTTPS requests fail certificate verification, as if
+// something else were answering on the wrapper's address.
+func (w *Wrapper) SetWrongCert(wrongCert bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.wrongCert = wrongCert
+}
+
+// SetLoseResponses makes HTTPS requests time out after the wrapper has
+// handled them, as if the connection dropped before the response came back.
+func (w *Wrapper) SetLoseResponses(lose bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.loseResponses = lose
+}
+
+// Requested reports whether path was requested from an instance's wrapper
+// over HTTPS, eg "/status".
+func (w *Wrapper) Requested(instanceID, path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, requested := range w.requests[instanceID] {
+		if requested == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Client creates a client that talks to the fake over HTTPS. It can be used
+// as awsdetail.Detail.NewWrapperClient.
+func (w *Wrapper) Client(baseURL string, creds serverwrapper.Credentials) (*serverwrapper.Client, error) {
+	client := serverwrapper.NewClient(baseURL)
+	client.Token = creds.Token
+	client.HTTP.Transport = &wrapperTransport{wrapper: w}
+	return client, nil
+}
+
+// wrapperTransport answers requests in memory, finding the instance by the
+// IP they are sent to.
+type wrapperTransport struct {
+	wrapper *Wrapper
+}
+
+func (t *wrapperTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	w := t.wrapper
+	instanceID, world := w.instanceAt(req.URL.Hostname())
+
+	w.mu.Lock()
+	unreachable := w.unreachable || instanceID == "" || w.statuses[instanceID] == ""
+	wrongCert, loseResponses := w.wrongCert, w.loseResponses
+	w.mu.Unlock()
+
+	if unreachable {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	if wrongCert {
+		return nil, x509.UnknownAuthorityError{}
+	}
+
+	rec := httptest.NewRecorder()
+	if token := w.token(world); token == "" || req.Header.Get("Authorization") != "Bearer "+token {
+		rec.WriteHeader(http.StatusUnauthorized)
+		return rec.Result(), nil
+	}
+
+	w.mu.Lock()
+	w.requests[instanceID] = append(w.requests[instanceID], req.URL.Path)
+	w.mu.Unlock()
+
+	var respond func(string) (interface{}, error)
+	switch req.URL.Path {
+	case "/status":
+		respond = w.statusResponse
+	case "/stop":
+		respond = w.stopResponse
+	case "/save":
+		respond = w.saveResponse
+	case "/upload":
+		respond = w.uploadResponse
+	default:
+		rec.WriteHeader(http.StatusNotFound)
+		return rec.Result(), nil
+	}
+
+	resp, err := respond(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if loseResponses {
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: errors.New("i/o timeout")}
+	}
+	_ = json.NewEncoder(rec).Encode(resp)
+	return rec.Result(), nil
+}
+
+// instanceAt finds the instance with a public IP, and the world it's for.
+func (w *Wrapper) instanceAt(ip string) (instanceID, world string) {
+	if w.ec2 == nil {
+		return "", ""
+	}
+
+	w.ec2.mu.Lock()
+	defer w.ec2.mu.Unlock()
+
+	for _, instance := range w.ec2.instances {
+		if aws.StringValue(instance.PublicIpAddress) != ip {
+			continue
+		}
+		for _, tag := range instance.Tags {
+			if aws.StringValue(tag.Key) == "MinecraftServerName" {
+				world = aws.StringValue(tag.Value)
+			}
+		}
+		return aws.StringValue(instance.InstanceId), world
+	}
+	return "", ""
+}
+
+// token the wrapper for a world was started with, as saved with its claim.
+func (w *Wrapper) token(world string) string {
+	if w.dynamoDB == nil {
+		return ""
+	}
+	token := w.dynamoDB.Item(Table, world)["wrapperToken"]
+	if token == nil {
+		return ""
+	}
+	return aws.StringValue(token.S)
+}
+
+func (w *Wrapper) sshHandler(respond func(string) (interface{}, error)) SSHHandler {
+	return func(call SSHCall, stdout io.Writer) error {
+		resp, err := respond(call.InstanceID)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(stdout).Encode(resp)
+	}
+}
+
+func (w *Wrapper) statusResponse(instanceID string) (interface{}, error) {
+	status, err := w.status(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return serverwrapper.StatusResponse{Status: status}, nil
+}
+
+func (w *Wrapper) stopResponse(instanceID string) (interface{}, error) {
+	if _, err := w.status(instanceID); err != nil {
+		return nil, err
+	}
+	w.SetStatus(instanceID, serverwrapper.StatusStopped)
+	return map[string]interface{}{"error": nil}, nil
+}
+
+func (w *Wrapper) saveResponse(instanceID string) (interface{}, error) {
+	status, err := w.status(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := serverwrapper.SaveResponse{}
+	if status != serverwrapper.StatusRunning {
+		resp.Error = "server is " + status
+	}
+	return resp, nil
+}
+
+func (w *Wrapper) uploadResponse(instanceID string) (interface{}, error) {
+	status, err := w.status(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := serverwrapper.UploadResponse{}
+	if status != serverwrapper.StatusRunning {
+		resp.Error = "server is " + status
+	}
+	return resp, nil
+}
+
+func (w *Wrapper) status(instanceID string) (string, error) {
+	status := w.Status(instanceID)
+	if status == "" {
+		return "", exitError(7, "curl: (7) Failed to connect to localhost port 8080: Connection refused")
+	}
+	return status, nil
+}
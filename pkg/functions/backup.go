@@ -0,0 +1,63 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backups"
+)
+
+// Backup takes scheduled backups of running worlds, pruning old ones.
+type Backup struct {
+	Detail *awsdetail.Detail
+	Policy backups.Policy
+}
+
+// BackupEvent optionally names a world to back up. Without one every running
+// world is backed up.
+type BackupEvent struct {
+	World *string `json:"world"`
+}
+
+// HandleRequest from lambda
+func (env *Backup) HandleRequest(ctx context.Context, event BackupEvent) error {
+	worlds := []string{}
+
+	if event.World != nil {
+		worlds = append(worlds, *event.World)
+	} else {
+		for _, region := range env.Detail.Config.AllRegions() {
+			servers, err := awsdetail.GetRunning(env.Detail.In(region).EC2)
+			if err != nil {
+				return err
+			}
+			for _, server := range servers {
+				if server.InstanceState == ec2.InstanceStateNameRunning {
+					worlds = append(worlds, server.Name)
+				}
+			}
+		}
+	}
+
+	var failed []string
+	for _, world := range worlds {
+		detail, err := env.Detail.ForWorld(world)
+		if err == nil {
+			_, err = awsdetail.CreateBackup(detail, world)
+		}
+		if err == nil {
+			_, err = awsdetail.PruneBackups(detail, world, env.Policy, false)
+		}
+		if err != nil {
+			env.Detail.Logger.Errorf("backup %s: %v", world, err)
+			failed = append(failed, world)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("backup failed for %v", failed)
+	}
+	return nil
+}
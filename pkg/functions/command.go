@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 
-	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/minecloud"
 )
 
+// Command does the heavy lifting of bringing worlds up and down.
 type Command struct {
-	Detail *awsdetail.Detail
+	Backend *backend.Backend
 }
 
 // HandleRequest from lambda
@@ -21,13 +23,28 @@ func (env *Command) HandleRequest(ctx context.Context, event Event) error {
 		return errors.New("no world specified")
 	}
 
+	// The lease may have changed hands since the singleton checked it.
+	if *event.Command == "down" && event.Token != nil {
+		lease, err := env.Backend.Claims.Lease(minecloud.World(*event.World))
+		if err == nil {
+			err = checkToken(lease, event)
+		}
+		if err != nil {
+			return failOperation(env.Backend, event, err)
+		}
+	}
+
+	if event.Operation != nil {
+		return backend.RunOperation(env.Backend, *event.Operation, event.UpOptions())
+	}
+
 	var err error
 
 	switch *event.Command {
 	case "up":
-		err = awsdetail.RunStored(env.Detail, *event.World, event.InstanceType)
+		err = backend.RunStored(env.Backend, minecloud.World(*event.World), event.UpOptions())
 	case "down":
-		err = awsdetail.StoreRunning(env.Detail, *event.World)
+		err = backend.StoreRunning(env.Backend, minecloud.World(*event.World))
 	default:
 		err = errors.New("unknown command")
 	}
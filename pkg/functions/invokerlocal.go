@@ -5,13 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 
-	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
 )
 
 // LocalInvoker invokes the same code as AWS lambdas, but locally.
-// Note that the functions themselves may still interact with AWS.
+// Note that the functions themselves may still interact with AWS, depending
+// on the backend.
 type LocalInvoker struct {
-	Detail *awsdetail.Detail
+	Backend *backend.Backend
 }
 
 // Invoke function locally.
@@ -23,7 +24,7 @@ func (invoker *LocalInvoker) Invoke(name string, payload []byte) error {
 		if err != nil {
 			return err
 		}
-		cmd := Command{Detail: invoker.Detail}
+		cmd := Command{Backend: invoker.Backend}
 		return cmd.HandleRequest(context.Background(), event)
 	case "MinecloudSingleton":
 		event := Event{}
@@ -32,7 +33,7 @@ func (invoker *LocalInvoker) Invoke(name string, payload []byte) error {
 			return err
 		}
 		singleton := Singleton{
-			Detail:  invoker.Detail,
+			Backend: invoker.Backend,
 			Invoker: invoker,
 		}
 		return singleton.HandleRequest(context.Background(), event)
@@ -9,18 +9,76 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/user"
 
-	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/minecloud"
 )
 
 type Event struct {
 	Command      *string `json:"command"`
 	World        *string `json:"world"`
 	InstanceType *string `json:"instanceType"`
+	Spot         *bool   `json:"spot"`
+	MaxPrice     *string `json:"maxPrice"`
+	Backup       *string `json:"backup"`
+
+	// Owner claiming the world for up, see LocalOwner.
+	Owner *string `json:"owner"`
+
+	// Token of the lease a server is renewing or releasing.
+	Token *int64 `json:"token"`
+
+	// Operation recording the progress of an up or down, see
+	// backend.RunOperation.
+	Operation *string `json:"operation"`
+}
+
+// LocalOwner identifies the user and host a request comes from, for claims.
+func LocalOwner() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return name + "@" + host
+}
+
+// UpOptions the event asks for, for the up command.
+func (e Event) UpOptions() minecloud.UpOptions {
+	opts := minecloud.UpOptions{
+		InstanceType: e.InstanceType,
+		Spot:         e.Spot != nil && *e.Spot,
+	}
+	if e.MaxPrice != nil {
+		opts.MaxPrice = *e.MaxPrice
+	}
+	if e.Backup != nil {
+		opts.Backup = *e.Backup
+	}
+	return opts
+}
+
+// SetUpOptions sets the event's fields for the up command.
+func (e *Event) SetUpOptions(opts minecloud.UpOptions) {
+	e.InstanceType = opts.InstanceType
+	if opts.Spot {
+		e.Spot = &opts.Spot
+	}
+	if opts.MaxPrice != "" {
+		e.MaxPrice = &opts.MaxPrice
+	}
+	if opts.Backup != "" {
+		e.Backup = &opts.Backup
+	}
 }
 
 type Singleton struct {
-	Detail  *awsdetail.Detail
+	Backend *backend.Backend
 	Invoker Invoker
 }
 
@@ -51,32 +109,171 @@ func (env *Singleton) HandleRequest(ctx context.Context, event Event) error {
 		return env.HandleUp(ctx, event)
 	case "down":
 		return env.HandleDown(ctx, event)
+	case "release":
+		return env.HandleRelease(ctx, event)
+	case "renew":
+		return env.HandleRenew(ctx, event)
 	}
 
 	return nil
 }
 
+// HandleUp claims the world, taking over an expired lease if nothing is
+// running it, then brings it up.
 func (env *Singleton) HandleUp(ctx context.Context, event Event) error {
-	err := awsdetail.ClaimWorld(env.Detail, *event.World)
+	owner := "unknown"
+	if event.Owner != nil {
+		owner = *event.Owner
+	}
+
+	_, err := backend.Claim(env.Backend, minecloud.World(*event.World), owner)
+	if err != nil {
+		return env.fail(event, err)
+	}
+
+	return env.invokeCommand(event)
+}
+
+// HandleDown takes the world down, if it's claimed and in a state that can
+// stop, eg not while it's still coming up. Servers give their lease's token,
+// and only take down their own world. Users request downs as an operation,
+// see RequestOperation.
+func (env *Singleton) HandleDown(ctx context.Context, event Event) error {
+	world := minecloud.World(*event.World)
+
+	lease, err := env.Backend.Claims.Lease(world)
+	if err != nil {
+		return env.fail(event, err)
+	}
+	if err := checkDownRequest(env.Backend, lease, event); err != nil {
+		return env.fail(event, err)
+	}
+	if err := backend.CheckTransition(world, lease.State, backend.StateStopping); err != nil {
+		return env.fail(event, err)
+	}
+
+	return env.invokeCommand(event)
+}
+
+// checkToken fails with backend.ErrLeaseLost if the event is from a server
+// whose lease has since been taken over.
+func checkToken(lease backend.Lease, event Event) error {
+	if event.Token != nil && lease.Token != *event.Token {
+		return fmt.Errorf("%w: %s", backend.ErrLeaseLost, lease.World)
+	}
+	return nil
+}
+
+// checkDownRequest makes sure a down either has the token of the world's
+// lease, or was requested as a down operation on the world that hasn't run
+// yet. Every server may invoke the singleton, so without a token one could
+// otherwise take down any world.
+func checkDownRequest(b *backend.Backend, lease backend.Lease, event Event) error {
+	if event.Token != nil {
+		return checkToken(lease, event)
+	}
+	if event.Operation == nil {
+		return fmt.Errorf("down of %s has neither a token nor an operation", lease.World)
+	}
+
+	op, err := b.Operations.Get(*event.Operation)
 	if err != nil {
 		return err
 	}
+	if op.Kind != backend.OpDown || op.World != lease.World || op.Status != backend.StatusPending {
+		return fmt.Errorf("operation %s is not a pending down of %s", op.ID, lease.World)
+	}
+	return nil
+}
 
+func (env *Singleton) invokeCommand(event Event) error {
 	b, err := json.Marshal(event)
 	if err != nil {
+		return env.fail(event, err)
+	}
+
+	err = env.Invoker.Invoke("MinecraftCommand", b)
+	if err != nil {
+		return env.fail(event, err)
+	}
+	return nil
+}
+
+func (env *Singleton) fail(event Event, err error) error {
+	return failOperation(env.Backend, event, err)
+}
+
+// failOperation fails the event's operation, if it has one, before it gets
+// to run.
+func failOperation(b *backend.Backend, event Event, err error) error {
+	if event.Operation == nil {
 		return err
 	}
 
-	return env.Invoker.Invoke("MinecraftCommand", b)
+	op, gerr := b.Operations.Get(*event.Operation)
+	if gerr != nil {
+		b.Logger.Warnf("could not record failure of operation %s: %v", *event.Operation, gerr)
+		return err
+	}
+	return backend.FinishOperation(b, op, err)
 }
 
-func (env *Singleton) HandleDown(ctx context.Context, event Event) error {
-	// TODO: Add an "isClaimed" type check.
+// HandleRelease unclaims a world whose server is going away without being
+// taken down, eg a spot instance being reclaimed. The server has already
+// uploaded the world. The world is only unclaimed if that server's lease
+// still holds it.
+func (env *Singleton) HandleRelease(ctx context.Context, event Event) error {
+	if event.Token == nil {
+		return fmt.Errorf("token not specified")
+	}
 
-	b, err := json.Marshal(event)
+	return env.Backend.Claims.Release(minecloud.World(*event.World), *event.Token)
+}
+
+// HandleRenew extends the lease of a running server's world.
+func (env *Singleton) HandleRenew(ctx context.Context, event Event) error {
+	if event.Token == nil {
+		return fmt.Errorf("token not specified")
+	}
+
+	_, err := env.Backend.Claims.Renew(minecloud.World(*event.World), *event.Token, "")
+	return err
+}
+
+// StartOperation records a pending operation for an up or down event, and
+// sets it on the event so its progress is recorded as it runs.
+func StartOperation(b *backend.Backend, event *Event) (backend.Operation, error) {
+	op, err := backend.StartOperation(b, *event.Command, minecloud.World(*event.World))
 	if err != nil {
-		return err
+		return op, err
 	}
 
-	return env.Invoker.Invoke("MinecraftCommand", b)
+	event.Operation = &op.ID
+	return op, nil
+}
+
+// RequestOperation records a new operation for an up or down event and
+// invokes the singleton with it, returning the operation's ID. With an
+// asynchronous invoker it returns before the operation has run.
+func RequestOperation(b *backend.Backend, invoker Invoker, event Event) (string, error) {
+	op, err := StartOperation(b, &event)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return op.ID, backend.FinishOperation(b, op, err)
+	}
+
+	err = invoker.Invoke("MinecloudSingleton", payload)
+	if err != nil {
+		// A local invoke may have got far enough to record the failure.
+		current, gerr := b.Operations.Get(op.ID)
+		if gerr == nil && !current.Done() {
+			return op.ID, backend.FinishOperation(b, current, err)
+		}
+		return op.ID, err
+	}
+	return op.ID, nil
 }
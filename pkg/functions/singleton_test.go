@@ -0,0 +1,254 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/fakeaws"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeSingleton(t *testing.T) (*fakeaws.Services, *Singleton) {
+	fakes := fakeaws.NewWithTable()
+	fakes.S3.Put("ogage-minecraft", "worlds/cliff/level.dat", []byte("level"))
+
+	b := awsdetail.NewBackend(fakes.Detail(fakeaws.TestConfig()))
+	return fakes, &Singleton{
+		Backend: b,
+		Invoker: &LocalInvoker{Backend: b},
+	}
+}
+
+// downRequest is a down as a user requests it, see RequestOperation.
+func downRequest(t *testing.T, singleton *Singleton, world string) Event {
+	event := Event{Command: aws.String("down"), World: aws.String(world)}
+	_, err := StartOperation(singleton.Backend, &event)
+	require.NoError(t, err)
+	return event
+}
+
+func TestSingletonUpDown(t *testing.T) {
+	fakes, singleton := newFakeSingleton(t)
+	ctx := context.Background()
+
+	err := singleton.HandleRequest(ctx, Event{Command: aws.String("up"), World: aws.String("cliff")})
+	require.NoError(t, err)
+	require.NotNil(t, fakes.DynamoDB.Item("MinecloudServers", "cliff"))
+
+	server, err := singleton.Backend.Compute.Find("cliff")
+	require.NoError(t, err)
+
+	err = singleton.HandleRequest(ctx, downRequest(t, singleton, "cliff"))
+	require.NoError(t, err)
+	require.Nil(t, fakes.DynamoDB.Item("MinecloudServers", "cliff"))
+	require.True(t, fakes.SSH.Ran(server.ID, `-prefix "worlds/cliff"`))
+}
+
+func TestSingletonUpAlreadyClaimed(t *testing.T) {
+	fakes, singleton := newFakeSingleton(t)
+	ctx := context.Background()
+
+	_, err := singleton.Backend.Claims.Claim("cliff", "someone@else")
+	require.NoError(t, err)
+
+	err = singleton.HandleRequest(ctx, Event{Command: aws.String("up"), World: aws.String("cliff")})
+	require.True(t, errors.Is(err, awsdetail.ErrWorldAlreadyClaimed))
+	require.Empty(t, fakes.SSH.Calls())
+}
+
+func TestSingletonUpSpotRelease(t *testing.T) {
+	fakes, singleton := newFakeSingleton(t)
+	ctx := context.Background()
+
+	err := singleton.HandleRequest(ctx, Event{Command: aws.String("up"), World: aws.String("cliff"), Spot: aws.Bool(true)})
+	require.NoError(t, err)
+
+	server, err := singleton.Backend.Compute.Find("cliff")
+	require.NoError(t, err)
+	require.Equal(t, "spot", *fakes.EC2.Instance(server.ID).InstanceLifecycle)
+
+	lease, err := singleton.Backend.Claims.Lease("cliff")
+	require.NoError(t, err)
+
+	err = singleton.HandleRequest(ctx, Event{Command: aws.String("release"), World: aws.String("cliff"), Token: &lease.Token})
+	require.NoError(t, err)
+	require.Nil(t, fakes.DynamoDB.Item("MinecloudServers", "cliff"))
+}
+
+func TestSingletonRequiresServerOrUser(t *testing.T) {
+	fakes, singleton := newFakeSingleton(t)
+	ctx := context.Background()
+
+	err := singleton.HandleRequest(ctx, Event{Command: aws.String("up"), World: aws.String("cliff")})
+	require.NoError(t, err)
+
+	// Another world's server can't release or take down this one.
+	err = singleton.HandleRequest(ctx, Event{Command: aws.String("release"), World: aws.String("cliff")})
+	require.EqualError(t, err, "token not specified")
+	err = singleton.HandleRequest(ctx, Event{Command: aws.String("down"), World: aws.String("cliff")})
+	require.EqualError(t, err, "down of cliff has neither a token nor an operation")
+
+	up := Event{Command: aws.String("up"), World: aws.String("cliff")}
+	op, err := StartOperation(singleton.Backend, &up)
+	require.NoError(t, err)
+	err = singleton.HandleRequest(ctx, Event{Command: aws.String("down"), World: aws.String("cliff"), Operation: &op.ID})
+	require.EqualError(t, err, "operation "+op.ID+" is not a pending down of cliff")
+
+	require.NotNil(t, fakes.DynamoDB.Item("MinecloudServers", "cliff"))
+	_, err = singleton.Backend.Compute.Find("cliff")
+	require.NoError(t, err)
+}
+
+func TestSingletonRenewRelease(t *testing.T) {
+	_, singleton := newFakeSingleton(t)
+	ctx := context.Background()
+
+	err := singleton.HandleRequest(ctx, Event{Command: aws.String("up"), World: aws.String("cliff"), Owner: aws.String("tester@host")})
+	require.NoError(t, err)
+
+	lease, err := singleton.Backend.Claims.Lease("cliff")
+	require.NoError(t, err)
+	require.Equal(t, "tester@host", lease.Owner)
+	require.NotEmpty(t, lease.InstanceID)
+
+	err = singleton.HandleRequest(ctx, Event{Command: aws.String("renew"), World: aws.String("cliff"), Token: aws.Int64(lease.Token)})
+	require.NoError(t, err)
+
+	stale := lease.Token - 1
+	err = singleton.HandleRequest(ctx, Event{Command: aws.String("renew"), World: aws.String("cliff"), Token: &stale})
+	require.True(t, errors.Is(err, backend.ErrLeaseLost))
+	err = singleton.HandleRequest(ctx, Event{Command: aws.String("release"), World: aws.String("cliff"), Token: &stale})
+	require.True(t, errors.Is(err, backend.ErrLeaseLost))
+
+	err = singleton.HandleRequest(ctx, Event{Command: aws.String("release"), World: aws.String("cliff"), Token: aws.Int64(lease.Token)})
+	require.NoError(t, err)
+
+	_, err = singleton.Backend.Claims.Lease("cliff")
+	require.True(t, errors.Is(err, backend.ErrWorldNotClaimed))
+}
+
+func TestSingletonDownWhileProvisioning(t *testing.T) {
+	fakes, singleton := newFakeSingleton(t)
+	ctx := context.Background()
+
+	_, err := singleton.Backend.Claims.Claim("cliff", "someone@else")
+	require.NoError(t, err)
+
+	err = singleton.HandleRequest(ctx, downRequest(t, singleton, "cliff"))
+	require.True(t, errors.Is(err, backend.ErrInvalidTransition))
+	require.Empty(t, fakes.SSH.Calls())
+}
+
+func TestSingletonDownNotClaimed(t *testing.T) {
+	_, singleton := newFakeSingleton(t)
+
+	err := singleton.HandleRequest(context.Background(), Event{Command: aws.String("down"), World: aws.String("cliff")})
+	require.True(t, errors.Is(err, backend.ErrWorldNotClaimed))
+}
+
+func TestSingletonDownStaleToken(t *testing.T) {
+	_, singleton := newFakeSingleton(t)
+	ctx := context.Background()
+
+	err := singleton.HandleRequest(ctx, Event{Command: aws.String("up"), World: aws.String("cliff")})
+	require.NoError(t, err)
+	lease, err := singleton.Backend.Claims.Lease("cliff")
+	require.NoError(t, err)
+
+	// A wrapper from an earlier claim of the world.
+	stale := lease.Token - 1
+	err = singleton.HandleRequest(ctx, Event{Command: aws.String("down"), World: aws.String("cliff"), Token: &stale})
+	require.True(t, errors.Is(err, backend.ErrLeaseLost))
+
+	_, err = singleton.Backend.Compute.Find("cliff")
+	require.NoError(t, err)
+
+	err = singleton.HandleRequest(ctx, Event{Command: aws.String("down"), World: aws.String("cliff"), Token: &lease.Token})
+	require.NoError(t, err)
+	_, err = singleton.Backend.Claims.Lease("cliff")
+	require.True(t, errors.Is(err, backend.ErrWorldNotClaimed))
+}
+
+func TestSingletonStates(t *testing.T) {
+	fakes, singleton := newFakeSingleton(t)
+	ctx := context.Background()
+
+	err := singleton.HandleRequest(ctx, Event{Command: aws.String("up"), World: aws.String("cliff")})
+	require.NoError(t, err)
+
+	lease, err := singleton.Backend.Claims.Lease("cliff")
+	require.NoError(t, err)
+	require.Equal(t, backend.StateRunning, lease.State)
+	require.False(t, lease.StateChanged.IsZero())
+
+	require.NoError(t, backend.SaveRunning(singleton.Backend, "cliff", time.Minute))
+	lease, err = singleton.Backend.Claims.Lease("cliff")
+	require.NoError(t, err)
+	require.Equal(t, backend.StateRunning, lease.State)
+
+	// A failed upload leaves the world failed, and it can still be taken down.
+	fakes.SSH.Handle(`-prefix "worlds/cliff"`, func(call fakeaws.SSHCall, stdout io.Writer) error {
+		return errors.New("upload failed")
+	})
+	err = singleton.HandleRequest(ctx, downRequest(t, singleton, "cliff"))
+	require.Error(t, err)
+
+	lease, err = singleton.Backend.Claims.Lease("cliff")
+	require.NoError(t, err)
+	require.Equal(t, backend.StateFailed, lease.State)
+}
+
+func TestSingletonOperations(t *testing.T) {
+	_, singleton := newFakeSingleton(t)
+	ctx := context.Background()
+
+	event := Event{Command: aws.String("up"), World: aws.String("cliff")}
+	op, err := StartOperation(singleton.Backend, &event)
+	require.NoError(t, err)
+	require.Equal(t, backend.StatusPending, op.Status)
+
+	require.NoError(t, singleton.HandleRequest(ctx, event))
+
+	up, err := singleton.Backend.Operations.Get(op.ID)
+	require.NoError(t, err)
+	require.Equal(t, backend.StatusSucceeded, up.Status)
+	require.Equal(t, backend.StepSetup, up.Step)
+
+	server, err := singleton.Backend.Compute.Find("cliff")
+	require.NoError(t, err)
+	require.Equal(t, *server.Address, up.Address)
+
+	// Bringing it up again fails before it gets to run.
+	event = Event{Command: aws.String("up"), World: aws.String("cliff")}
+	op, err = StartOperation(singleton.Backend, &event)
+	require.NoError(t, err)
+	require.Error(t, singleton.HandleRequest(ctx, event))
+
+	failed, err := backend.WaitOperation(singleton.Backend, op.ID, time.Millisecond, func(backend.Operation) {})
+	require.NoError(t, err)
+	require.Equal(t, backend.StatusFailed, failed.Status)
+	require.Contains(t, failed.Error, "already claimed")
+
+	// One whose lambda was killed part way never finishes by itself.
+	killed, err := backend.NewOperation(backend.OpUp, "cliff")
+	require.NoError(t, err)
+	killed.Status = backend.StatusRunning
+	killed.Updated = time.Now().Add(-backend.OperationTimeout - time.Minute)
+	require.NoError(t, singleton.Backend.Operations.Put(killed))
+
+	failed, err = backend.WaitOperation(singleton.Backend, killed.ID, time.Millisecond, func(backend.Operation) {})
+	require.NoError(t, err)
+	require.Equal(t, backend.StatusFailed, failed.Status)
+	recorded, err := singleton.Backend.Operations.Get(killed.ID)
+	require.NoError(t, err)
+	require.Equal(t, backend.StatusFailed, recorded.Status)
+
+	_, err = singleton.Backend.Operations.Get("nope")
+	require.True(t, errors.Is(err, backend.ErrOperationNotFound))
+}
@@ -7,15 +7,16 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/mcconfig"
 )
 
-// GetSSHKey from S3.
-func GetSSHKey(awsSession *session.Session) []byte {
+// GetSSHKey from the secrets bucket, stored under the key pair's name.
+func GetSSHKey(awsSession *session.Session, config mcconfig.Config) []byte {
 	sss := s3.New(awsSession)
 
 	req, err := sss.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(awsdetail.SecretsBucketName),
-		Key:    aws.String("MinecraftServerKeyPair.pem"),
+		Bucket: aws.String(config.SecretsBucket),
+		Key:    aws.String(awsdetail.SSHKeySecret(config)),
 	})
 	if err != nil {
 		panic("could not request SSH key from S3")
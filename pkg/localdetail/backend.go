@@ -0,0 +1,405 @@
+package localdetail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+)
+
+// NewBackend exposes the local host as a Minecloud backend.
+func NewBackend(detail *Detail) *backend.Backend {
+	return &backend.Backend{
+		Compute: &hostCompute{detail},
+		Storage: &dirStorage{detail},
+		Claims:  &fileClaims{detail},
+		DNS:     &logDNS{detail},
+		Logger:  detail.Logger,
+
+		Operations: &fileOperations{detail},
+	}
+}
+
+// Client for the wrapper API of a local instance.
+func Client(detail *Detail, id string) (*serverwrapper.Client, error) {
+	inst, err := loadInstance(detail, id)
+	if err != nil {
+		return nil, err
+	}
+	return serverwrapper.NewClient(fmt.Sprintf("http://127.0.0.1:%d", inst.Port)), nil
+}
+
+type hostCompute struct {
+	detail *Detail
+}
+
+func (c *hostCompute) Reserve(world minecloud.World, opts minecloud.UpOptions) (string, error) {
+	if opts.InstanceType != nil {
+		c.detail.Logger.Warnf("instance type %s ignored when running locally", *opts.InstanceType)
+	}
+	if opts.Spot {
+		c.detail.Logger.Warn("spot ignored when running locally")
+	}
+	if opts.Backup != "" {
+		return "", errors.New("backups aren't kept when running locally")
+	}
+
+	inst, err := ReserveInstance(c.detail, string(world))
+	return inst.ID, err
+}
+
+func (c *hostCompute) WaitReady(id string) error {
+	_, err := loadInstance(c.detail, id)
+	return err
+}
+
+func (c *hostCompute) Address(id string) (string, error) {
+	inst, err := loadInstance(c.detail, id)
+	if err != nil {
+		return "", err
+	}
+	return inst.address(c.detail.Config.Host), nil
+}
+
+func (c *hostCompute) Setup(id string, world minecloud.World) error {
+	return SetupInstance(c.detail, id, string(world))
+}
+
+func (c *hostCompute) Find(world minecloud.World) (backend.Server, error) {
+	inst, err := FindRunning(c.detail, string(world))
+	if err != nil {
+		return backend.Server{}, err
+	}
+	return inst.toBackend(c.detail.Config.Host), nil
+}
+
+// FindLive is the same as Find, local instances run from when they are
+// reserved.
+func (c *hostCompute) FindLive(world minecloud.World) (backend.Server, error) {
+	return c.Find(world)
+}
+
+func (c *hostCompute) List() ([]backend.Server, error) {
+	instances, err := Instances(c.detail)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]backend.Server, 0, len(instances))
+	for _, inst := range instances {
+		servers = append(servers, inst.toBackend(c.detail.Config.Host))
+	}
+	return servers, nil
+}
+
+func (c *hostCompute) Status(id string) (serverwrapper.StatusResponse, error) {
+	client, err := Client(c.detail, id)
+	if err != nil {
+		return serverwrapper.StatusResponse{}, err
+	}
+	return client.Status()
+}
+
+func (c *hostCompute) Logs(id string, opts serverwrapper.LogsOptions, handle func(serverwrapper.LogLine)) error {
+	client, err := Client(c.detail, id)
+	if err != nil {
+		return err
+	}
+	return client.Logs(context.Background(), opts, handle)
+}
+
+func (c *hostCompute) Save(id string, timeout time.Duration) error {
+	client, err := Client(c.detail, id)
+	if err != nil {
+		return err
+	}
+	return client.Save(timeout)
+}
+
+func (c *hostCompute) Stop(id string) error {
+	client, err := Client(c.detail, id)
+	if err != nil {
+		return err
+	}
+
+	err = client.Stop()
+	if err != nil {
+		return err
+	}
+
+	return client.WaitForStopped(3, 3*time.Second)
+}
+
+func (c *hostCompute) Upload(id string, world minecloud.World) error {
+	resp, err := c.Status(id)
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != serverwrapper.StatusStopped {
+		return fmt.Errorf("server for world '%s' is %s, must be stopped to upload world", world, resp.Status)
+	}
+
+	return UploadWorld(c.detail, id, string(world))
+}
+
+func (c *hostCompute) Terminate(id string) error {
+	return TerminateInstance(c.detail, id)
+}
+
+type dirStorage struct {
+	detail *Detail
+}
+
+func (s *dirStorage) FindStored(world minecloud.World) error {
+	files, err := ioutil.ReadDir(s.detail.worldDir(string(world)))
+	if os.IsNotExist(err) || (err == nil && len(files) == 0) {
+		return backend.ErrServerNotFound
+	}
+	return err
+}
+
+func (s *dirStorage) List() ([]minecloud.World, error) {
+	files, err := ioutil.ReadDir(s.detail.worldDir(""))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	worlds := []minecloud.World{}
+	for _, file := range files {
+		world := minecloud.World(file.Name())
+		if file.IsDir() && s.FindStored(world) == nil {
+			worlds = append(worlds, world)
+		}
+	}
+	return worlds, nil
+}
+
+// fileClaims claims worlds by exclusively creating a file per world, holding
+// its lease as JSON. Local wrappers don't renew their leases, so once one
+// expires the world is only protected by the running server check in
+// backend.Claim.
+type fileClaims struct {
+	detail *Detail
+}
+
+func (c *fileClaims) Claim(world minecloud.World, owner string) (backend.Lease, error) {
+	c.detail.Logger.Info("claiming")
+
+	err := os.MkdirAll(c.detail.claimPath(""), 0755)
+	if err != nil {
+		return backend.Lease{}, err
+	}
+
+	f, err := os.OpenFile(c.detail.claimPath(string(world)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		return backend.Lease{}, fmt.Errorf("%w: %s", backend.ErrWorldAlreadyClaimed, world)
+	}
+	if err != nil {
+		return backend.Lease{}, err
+	}
+	defer f.Close()
+
+	lease := backend.NewLease(world, owner, 0)
+	return lease, json.NewEncoder(f).Encode(lease)
+}
+
+func (c *fileClaims) Lease(world minecloud.World) (backend.Lease, error) {
+	b, err := ioutil.ReadFile(c.detail.claimPath(string(world)))
+	if os.IsNotExist(err) {
+		return backend.Lease{}, fmt.Errorf("%w: %s", backend.ErrWorldNotClaimed, world)
+	}
+	if err != nil {
+		return backend.Lease{}, err
+	}
+
+	// Claims from before leases are empty, and never expire.
+	lease := backend.Lease{World: world}
+	if len(b) == 0 {
+		return lease, nil
+	}
+	return lease, json.Unmarshal(b, &lease)
+}
+
+func (c *fileClaims) TakeOver(expired backend.Lease, owner string) (backend.Lease, error) {
+	c.detail.Logger.Info("taking over lease")
+
+	current, err := c.Lease(expired.World)
+	if err != nil {
+		return backend.Lease{}, err
+	}
+	if current.Token != expired.Token || !current.Expired(time.Now()) {
+		return backend.Lease{}, fmt.Errorf("%w: %s", backend.ErrLeaseLost, expired.World)
+	}
+
+	lease := backend.NewLease(expired.World, owner, expired.Token)
+	return lease, c.write(lease)
+}
+
+func (c *fileClaims) Renew(world minecloud.World, token int64, instanceID string) (backend.Lease, error) {
+	lease, err := c.Lease(world)
+	if errors.Is(err, backend.ErrWorldNotClaimed) || (err == nil && lease.Token != token) {
+		return backend.Lease{}, fmt.Errorf("%w: %s", backend.ErrLeaseLost, world)
+	}
+	if err != nil {
+		return backend.Lease{}, err
+	}
+
+	lease.Expires = time.Now().Add(backend.LeaseDuration)
+	if instanceID != "" {
+		lease.InstanceID = instanceID
+	}
+	return lease, c.write(lease)
+}
+
+func (c *fileClaims) Release(world minecloud.World, token int64) error {
+	lease, err := c.Lease(world)
+	if errors.Is(err, backend.ErrWorldNotClaimed) || (err == nil && lease.Token != token) {
+		return fmt.Errorf("%w: %s", backend.ErrLeaseLost, world)
+	}
+	if err != nil {
+		return err
+	}
+	return c.Unclaim(world)
+}
+
+func (c *fileClaims) SetState(world minecloud.World, from, to backend.WorldState) (backend.Lease, error) {
+	lease, err := c.Lease(world)
+	if err != nil {
+		return backend.Lease{}, err
+	}
+	if lease.State != from {
+		return backend.Lease{}, fmt.Errorf("%w: %s is no longer %s", backend.ErrInvalidTransition, world, from)
+	}
+
+	lease.State = to
+	lease.StateChanged = time.Now()
+	return lease, c.write(lease)
+}
+
+func (c *fileClaims) SetStep(world minecloud.World, token int64, operation, step string) (backend.Lease, error) {
+	lease, err := c.Lease(world)
+	if errors.Is(err, backend.ErrWorldNotClaimed) || (err == nil && lease.Token != token) {
+		return backend.Lease{}, fmt.Errorf("%w: %s", backend.ErrLeaseLost, world)
+	}
+	if err != nil {
+		return backend.Lease{}, err
+	}
+
+	lease.Expires = time.Now().Add(backend.LeaseDuration)
+	lease.Operation = operation
+	lease.Step = step
+	return lease, c.write(lease)
+}
+
+func (c *fileClaims) List() ([]backend.Lease, error) {
+	files, err := ioutil.ReadDir(c.detail.claimPath(""))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	leases := []backend.Lease{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) == ".tmp" {
+			continue
+		}
+
+		lease, err := c.Lease(minecloud.World(file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// write replaces a claim's lease, renaming so it is never half written.
+func (c *fileClaims) write(lease backend.Lease) error {
+	b, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	path := c.detail.claimPath(string(lease.World))
+	err = ioutil.WriteFile(path+".tmp", b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (c *fileClaims) Unclaim(world minecloud.World) error {
+	c.detail.Logger.Info("unclaiming")
+
+	err := os.Remove(c.detail.claimPath(string(world)))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", backend.ErrWorldNotClaimed, world)
+	}
+	return err
+}
+
+// fileOperations keeps each operation as a JSON file.
+type fileOperations struct {
+	detail *Detail
+}
+
+func (o *fileOperations) Put(op backend.Operation) error {
+	err := os.MkdirAll(o.detail.operationsDir(), 0755)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	path := o.detail.operationPath(op.ID)
+	err = ioutil.WriteFile(path+".tmp", b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (o *fileOperations) Get(id string) (backend.Operation, error) {
+	b, err := ioutil.ReadFile(o.detail.operationPath(id))
+	if os.IsNotExist(err) {
+		return backend.Operation{}, fmt.Errorf("%w: %s", backend.ErrOperationNotFound, id)
+	}
+	if err != nil {
+		return backend.Operation{}, err
+	}
+
+	var op backend.Operation
+	return op, json.Unmarshal(b, &op)
+}
+
+// logDNS has no DNS to update, it just tells the user where to connect.
+type logDNS struct {
+	detail *Detail
+}
+
+func (d *logDNS) Update(world minecloud.World, address string) error {
+	d.detail.Logger.Infof("world %s will be available at %s", world, address)
+	return nil
+}
+
+func (d *logDNS) Remove(world minecloud.World) error {
+	d.detail.Logger.Infof("world %s is no longer available", world)
+	return nil
+}
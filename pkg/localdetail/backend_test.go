@@ -0,0 +1,77 @@
+package localdetail_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/localdetail"
+	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaims(t *testing.T) {
+	detail, cleanup := newDetail(t)
+	defer cleanup()
+	claims := localdetail.NewBackend(detail).Claims
+
+	lease, err := claims.Claim("cliff", "steve")
+	require.NoError(t, err)
+	require.Equal(t, "steve", lease.Owner)
+
+	_, err = claims.Claim("cliff", "alex")
+	require.True(t, errors.Is(err, backend.ErrWorldAlreadyClaimed))
+
+	_, err = claims.Renew("cliff", lease.Token+1, "local-1")
+	require.True(t, errors.Is(err, backend.ErrLeaseLost))
+
+	_, err = claims.Renew("cliff", lease.Token, "local-1")
+	require.NoError(t, err)
+	_, err = claims.SetStep("cliff", lease.Token, backend.OpUp, backend.StepReserve)
+	require.NoError(t, err)
+
+	leases, err := claims.List()
+	require.NoError(t, err)
+	require.Len(t, leases, 1)
+	require.Equal(t, "local-1", leases[0].InstanceID)
+	require.Equal(t, backend.StepReserve, leases[0].Step)
+
+	require.NoError(t, claims.Release("cliff", lease.Token))
+	_, err = claims.Lease("cliff")
+	require.True(t, errors.Is(err, backend.ErrWorldNotClaimed))
+}
+
+func TestStorageList(t *testing.T) {
+	detail, cleanup := newDetail(t)
+	defer cleanup()
+	storage := localdetail.NewBackend(detail).Storage
+
+	writeFile(t, filepath.Join(detail.Config.Dir, "worlds", "lake", "level.dat"), "level")
+	writeFile(t, filepath.Join(detail.Config.Dir, "worlds", "cliff", "level.dat"), "level")
+	require.NoError(t, storage.FindStored("cliff"))
+
+	worlds, err := storage.List()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []minecloud.World{"cliff", "lake"}, worlds)
+
+	require.True(t, errors.Is(storage.FindStored("nope"), backend.ErrServerNotFound))
+}
+
+func TestOperations(t *testing.T) {
+	detail, cleanup := newDetail(t)
+	defer cleanup()
+	operations := localdetail.NewBackend(detail).Operations
+
+	op, err := backend.NewOperation(backend.OpUp, "cliff")
+	require.NoError(t, err)
+	require.NoError(t, operations.Put(op))
+
+	got, err := operations.Get(op.ID)
+	require.NoError(t, err)
+	require.Equal(t, op.ID, got.ID)
+	require.Equal(t, op.World, got.World)
+
+	_, err = operations.Get("nope")
+	require.True(t, errors.Is(err, backend.ErrOperationNotFound))
+}
@@ -0,0 +1,96 @@
+package localdetail
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// copyDir copies the tree at src into dst, overwriting files that exist in
+// both. Paths in exclude are relative to src and skipped entirely.
+func copyDir(src, dst string, exclude []string) error {
+	skip := map[string]bool{}
+	for _, e := range exclude {
+		skip[filepath.Clean(e)] = true
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		if skip[rel] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+// replaceDir replaces dst with a copy of src. The old dst is only removed once
+// the copy is complete.
+func replaceDir(src, dst string) error {
+	tmp := dst + ".new"
+	old := dst + ".old"
+
+	err := os.RemoveAll(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = copyDir(src, tmp, nil)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(dst, old)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(old)
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
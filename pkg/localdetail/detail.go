@@ -0,0 +1,106 @@
+// Package localdetail runs Minecloud worlds on the local host rather than in
+// a cloud. Worlds are stored in a directory and the server wrapper is launched
+// as a local process or docker container.
+//
+// The layout of the directory mirrors the S3 bucket used for AWS:
+//
+//	<dir>/worlds/<name>/       world files
+//	<dir>/servers/<name>/      server files (jar, properties, ops...)
+//	<dir>/claims/<name>        lease and state of a claimed world, as JSON
+//	<dir>/operations/<id>.json progress of each up and down
+//	<dir>/instances/<id>.json  record of each launched wrapper
+//	<dir>/instances/<id>/      working copy of the world and server
+package localdetail
+
+import (
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Config for running worlds locally.
+type Config struct {
+	// Dir holding worlds, servers, claims, operations and instances.
+	Dir string
+
+	// WrapperPath is the serverwrapper binary to run. Ignored if Image is set.
+	WrapperPath string
+
+	// Image is the server wrapper docker image to run. If empty, WrapperPath
+	// is run as a process instead.
+	Image string
+
+	// Jar is the server JAR, relative to the server directory. Defaults to
+	// fabric-server-launch.jar like the docker image.
+	Jar string
+
+	// Host is the address players use to reach this machine, eg a LAN IP.
+	Host string
+
+	// BasePort is the first port used for wrapper HTTP APIs. Each running
+	// world takes the next free port.
+	BasePort int
+
+	// GamePort is the first port players connect to. Each running world
+	// takes the next free port, so several can run at once.
+	GamePort int
+}
+
+// DefaultGamePort is the port Minecraft clients connect to if not told
+// otherwise.
+const DefaultGamePort = 25565
+
+// Detail contains useful bits for running worlds locally.
+type Detail struct {
+	Config Config
+	Logger *logrus.Logger
+}
+
+// NewDetail makes a new local helper object.
+func NewDetail(config Config) *Detail {
+	if config.Jar == "" {
+		config.Jar = "fabric-server-launch.jar"
+	}
+	if config.Host == "" {
+		config.Host = "127.0.0.1"
+	}
+	if config.BasePort == 0 {
+		config.BasePort = 8080
+	}
+	if config.GamePort == 0 {
+		config.GamePort = DefaultGamePort
+	}
+
+	return &Detail{
+		Config: config,
+		Logger: logrus.New(),
+	}
+}
+
+func (detail *Detail) worldDir(name string) string {
+	return filepath.Join(detail.Config.Dir, "worlds", name)
+}
+
+func (detail *Detail) serverDir(name string) string {
+	return filepath.Join(detail.Config.Dir, "servers", name)
+}
+
+func (detail *Detail) claimPath(name string) string {
+	return filepath.Join(detail.Config.Dir, "claims", name)
+}
+
+func (detail *Detail) operationsDir() string {
+	return filepath.Join(detail.Config.Dir, "operations")
+}
+
+func (detail *Detail) operationPath(id string) string {
+	return filepath.Join(detail.operationsDir(), id+".json")
+}
+
+func (detail *Detail) instancesDir() string {
+	return filepath.Join(detail.Config.Dir, "instances")
+}
+
+func (detail *Detail) instanceDir(id string) string {
+	return filepath.Join(detail.instancesDir(), id)
+}
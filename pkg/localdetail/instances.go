@@ -0,0 +1,374 @@
+package localdetail
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/google/uuid"
+	"github.com/owengage/minecloud/pkg/backend"
+)
+
+const (
+	stateRunning    = "running"
+	stateTerminated = "terminated"
+)
+
+// Instance is the record of a server wrapper launched on this host.
+type Instance struct {
+	ID        string
+	World     string
+	State     string
+	Port      int
+	GamePort  int    `json:",omitempty"`
+	PID       int    `json:",omitempty"`
+	Container string `json:",omitempty"`
+}
+
+// alive checks the process or container backing the instance still exists.
+// Instances that have been reserved but not yet set up count as alive.
+func (inst Instance) alive() bool {
+	if inst.PID != 0 {
+		return syscall.Kill(inst.PID, 0) == nil
+	}
+
+	if inst.Container != "" {
+		out, err := exec.Command("docker", "inspect", "-f", "{{.State.Running}}", inst.Container).Output()
+		return err == nil && strings.TrimSpace(string(out)) == "true"
+	}
+
+	return true
+}
+
+// address players connect to, leaving out the port if it's the default.
+func (inst Instance) address(host string) string {
+	if inst.GamePort == 0 || inst.GamePort == DefaultGamePort {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(inst.GamePort))
+}
+
+func (inst Instance) toBackend(host string) backend.Server {
+	server := backend.Server{
+		Name:  inst.World,
+		State: inst.State,
+		ID:    inst.ID,
+	}
+	if inst.State == stateRunning {
+		address := inst.address(host)
+		server.Address = &address
+	}
+	return server
+}
+
+// Instances returns every instance launched on this host, including terminated.
+func Instances(detail *Detail) ([]Instance, error) {
+	files, err := ioutil.ReadDir(detail.instancesDir())
+	if os.IsNotExist(err) {
+		return []Instance{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	instances := []Instance{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		inst, err := loadInstance(detail, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, inst)
+	}
+
+	return instances, nil
+}
+
+// FindRunning returns the running instance for a world, or
+// backend.ErrServerNotFound.
+func FindRunning(detail *Detail, world string) (Instance, error) {
+	instances, err := Instances(detail)
+	if err != nil {
+		return Instance{}, err
+	}
+
+	for _, inst := range instances {
+		if inst.World == world && inst.State == stateRunning && inst.alive() {
+			return inst, nil
+		}
+	}
+
+	return Instance{}, backend.ErrServerNotFound
+}
+
+// ReserveInstance records a new instance for the world, allocating it a port
+// for the wrapper API and one for players.
+func ReserveInstance(detail *Detail, world string) (Instance, error) {
+	detail.Logger.Info("reserving local instance")
+
+	instances, err := Instances(detail)
+	if err != nil {
+		return Instance{}, err
+	}
+
+	used := map[int]bool{}
+	for _, inst := range instances {
+		if inst.State == stateRunning {
+			used[inst.Port] = true
+			used[inst.GamePort] = true
+		}
+	}
+
+	inst := Instance{
+		ID:    "local-" + uuid.New().String()[:8],
+		World: world,
+		State: stateRunning,
+	}
+
+	inst.Port, err = freePort(detail.Config.BasePort, used)
+	if err != nil {
+		return Instance{}, err
+	}
+	used[inst.Port] = true
+
+	inst.GamePort, err = freePort(detail.Config.GamePort, used)
+	if err != nil {
+		return Instance{}, err
+	}
+
+	return inst, saveInstance(detail, inst)
+}
+
+// freePort finds the first port from start that no instance uses and
+// nothing else is listening on.
+func freePort(start int, used map[int]bool) (int, error) {
+	for port := start; port <= 65535; port++ {
+		if used[port] {
+			continue
+		}
+
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			continue
+		}
+		l.Close()
+		return port, nil
+	}
+
+	return 0, fmt.Errorf("no free port from %d", start)
+}
+
+// SetupInstance copies the stored world into the instance and starts the
+// server wrapper.
+func SetupInstance(detail *Detail, id, world string) error {
+	inst, err := loadInstance(detail, id)
+	if err != nil {
+		return err
+	}
+
+	dir := detail.instanceDir(id)
+
+	detail.Logger.Info("copying world")
+	err = copyDir(detail.worldDir(world), filepath.Join(dir, "world"), nil)
+	if err != nil {
+		return err
+	}
+
+	err = copyDir(detail.serverDir(world), filepath.Join(dir, "server"), nil)
+	if err != nil {
+		return err
+	}
+
+	detail.Logger.Info("starting server wrapper")
+	if detail.Config.Image != "" {
+		// Docker maps the port, the game inside keeps the default.
+		err = startContainer(detail, &inst)
+	} else {
+		err = setServerPort(filepath.Join(dir, "server", "server.properties"), inst.GamePort)
+		if err == nil {
+			err = startProcess(detail, &inst)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return saveInstance(detail, inst)
+}
+
+// UploadWorld copies the instance's world and server files back to storage.
+func UploadWorld(detail *Detail, id, world string) error {
+	dir := detail.instanceDir(id)
+
+	err := copyDir(filepath.Join(dir, "server"), detail.serverDir(world), []string{"logs", ".fabric", ".mixin.out"})
+	if err != nil {
+		return err
+	}
+
+	return replaceDir(filepath.Join(dir, "world"), detail.worldDir(world))
+}
+
+// TerminateInstance kills the wrapper and removes the instance's working files.
+func TerminateInstance(detail *Detail, id string) error {
+	detail.Logger.Info("terminating local instance")
+
+	inst, err := loadInstance(detail, id)
+	if err != nil {
+		return err
+	}
+
+	if inst.PID != 0 {
+		// Negative PID signals the whole process group, which includes Java.
+		err = syscall.Kill(-inst.PID, syscall.SIGTERM)
+		if err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+
+	if inst.Container != "" {
+		out, err := exec.Command("docker", "rm", "-f", inst.Container).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("docker rm failed: %w: %s", err, out)
+		}
+	}
+
+	dir := detail.instanceDir(id)
+	for _, sub := range []string{"world", "server"} {
+		err = os.RemoveAll(filepath.Join(dir, sub))
+		if err != nil {
+			return err
+		}
+	}
+
+	inst.State = stateTerminated
+	return saveInstance(detail, inst)
+}
+
+func startProcess(detail *Detail, inst *Instance) error {
+	dir, err := filepath.Abs(detail.instanceDir(inst.ID))
+	if err != nil {
+		return err
+	}
+
+	logFile, err := os.Create(filepath.Join(dir, "wrapper.log"))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	serverDir := filepath.Join(dir, "server")
+	cmd := exec.Command(detail.Config.WrapperPath,
+		"-address", fmt.Sprintf("127.0.0.1:%d", inst.Port),
+		"-jar", filepath.Join(serverDir, detail.Config.Jar),
+		"-world-dir", filepath.Join(dir, "world"),
+		"-server-dir", serverDir)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	// Own process group so the wrapper outlives us and can be killed along
+	// with Java in one go.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	inst.PID = cmd.Process.Pid
+	return cmd.Process.Release()
+}
+
+func startContainer(detail *Detail, inst *Instance) error {
+	dir, err := filepath.Abs(detail.instanceDir(inst.ID))
+	if err != nil {
+		return err
+	}
+
+	name := "minecloud-" + inst.ID
+	out, err := exec.Command("docker", "run", "-d",
+		"--rm",
+		"-p", fmt.Sprintf("127.0.0.1:%d:80", inst.Port),
+		"-p", fmt.Sprintf("%d:%d", inst.GamePort, DefaultGamePort),
+		"--name", name,
+		"--volume", filepath.Join(dir, "server")+":/server",
+		"--volume", filepath.Join(dir, "world")+":/world",
+		detail.Config.Image,
+		"-world-dir", "/world",
+		"-server-dir", "/server").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker run failed: %w: %s", err, out)
+	}
+
+	inst.Container = name
+	return nil
+}
+
+// setServerPort makes the game listen on the port, by setting it in the
+// instance's copy of server.properties.
+func setServerPort(path string, port int) error {
+	if port == 0 {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	setting := fmt.Sprintf("server-port=%d", port)
+	lines := []string{}
+	found := false
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "server-port=") {
+			line = setting
+			found = true
+		}
+		if line != "" || len(lines) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	if !found {
+		lines = append(lines, setting)
+	}
+
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+func loadInstance(detail *Detail, id string) (Instance, error) {
+	var inst Instance
+
+	b, err := ioutil.ReadFile(filepath.Join(detail.instancesDir(), id+".json"))
+	if os.IsNotExist(err) {
+		return inst, backend.ErrServerNotFound
+	}
+	if err != nil {
+		return inst, err
+	}
+
+	err = json.Unmarshal(b, &inst)
+	return inst, err
+}
+
+func saveInstance(detail *Detail, inst Instance) error {
+	err := os.MkdirAll(detail.instanceDir(inst.ID), 0755)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(inst, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(detail.instancesDir(), inst.ID+".json"), b, 0644)
+}
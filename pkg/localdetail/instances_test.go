@@ -0,0 +1,108 @@
+package localdetail_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/owengage/minecloud/pkg/localdetail"
+	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/stretchr/testify/require"
+)
+
+func newDetail(t *testing.T) (*localdetail.Detail, func()) {
+	dir, err := ioutil.TempDir("", "localdetail")
+	require.NoError(t, err)
+
+	// Ports from one nothing is listening on, so tests don't need 25565.
+	l, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	detail := localdetail.NewDetail(localdetail.Config{
+		Dir:         dir,
+		WrapperPath: "true",
+		BasePort:    port,
+		GamePort:    port,
+	})
+	return detail, func() { os.RemoveAll(dir) }
+}
+
+func writeFile(t *testing.T, path, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func TestReserveInstancePorts(t *testing.T) {
+	detail, cleanup := newDetail(t)
+	defer cleanup()
+
+	// Something else has the first port.
+	l, err := net.Listen("tcp", ":"+strconv.Itoa(detail.Config.GamePort))
+	require.NoError(t, err)
+	defer l.Close()
+
+	cliff, err := localdetail.ReserveInstance(detail, "cliff")
+	require.NoError(t, err)
+	lake, err := localdetail.ReserveInstance(detail, "lake")
+	require.NoError(t, err)
+
+	ports := map[int]bool{detail.Config.GamePort: true}
+	for _, port := range []int{cliff.Port, cliff.GamePort, lake.Port, lake.GamePort} {
+		require.False(t, ports[port], "port %d used twice", port)
+		ports[port] = true
+	}
+}
+
+func TestSetupInstance(t *testing.T) {
+	detail, cleanup := newDetail(t)
+	defer cleanup()
+	b := localdetail.NewBackend(detail)
+
+	writeFile(t, filepath.Join(detail.Config.Dir, "worlds", "cliff", "level.dat"), "level")
+	writeFile(t, filepath.Join(detail.Config.Dir, "servers", "cliff", "server.properties"), "motd=cliff\nserver-port=25565\n")
+
+	id, err := b.Compute.Reserve("cliff", minecloud.UpOptions{})
+	require.NoError(t, err)
+	require.NoError(t, b.Compute.Setup(id, "cliff"))
+
+	inst, err := localdetail.FindRunning(detail, "cliff")
+	require.NoError(t, err)
+	require.Equal(t, id, inst.ID)
+
+	properties, err := ioutil.ReadFile(filepath.Join(detail.Config.Dir, "instances", id, "server", "server.properties"))
+	require.NoError(t, err)
+	require.Equal(t, "motd=cliff\nserver-port="+strconv.Itoa(inst.GamePort)+"\n", string(properties))
+
+	address, err := b.Compute.Address(id)
+	require.NoError(t, err)
+	require.Equal(t, net.JoinHostPort("127.0.0.1", strconv.Itoa(inst.GamePort)), address)
+
+	// Stored server files keep their own port.
+	stored, err := ioutil.ReadFile(filepath.Join(detail.Config.Dir, "servers", "cliff", "server.properties"))
+	require.NoError(t, err)
+	require.Contains(t, string(stored), "server-port=25565")
+
+	require.NoError(t, b.Compute.Terminate(id))
+	servers, err := b.Compute.List()
+	require.NoError(t, err)
+	require.Len(t, servers, 1)
+	require.Equal(t, "terminated", servers[0].State)
+	require.Nil(t, servers[0].Address)
+}
+
+func TestDefaultGamePortAddress(t *testing.T) {
+	detail, cleanup := newDetail(t)
+	defer cleanup()
+
+	// Instances from before game ports were allocated have none.
+	writeFile(t, filepath.Join(detail.Config.Dir, "instances", "local-1.json"), `{"ID":"local-1","World":"cliff","State":"running"}`)
+
+	address, err := localdetail.NewBackend(detail).Compute.Address("local-1")
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", address)
+}
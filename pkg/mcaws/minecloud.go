@@ -1,12 +1,11 @@
 package mcaws
 
 import (
-	"encoding/json"
-
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
 	"github.com/owengage/minecloud/pkg/functions"
 	"github.com/owengage/minecloud/pkg/minecloud"
 )
@@ -18,7 +17,7 @@ func NewMinecloudAWS(sess *session.Session, detail *awsdetail.Detail, localLambd
 
 	if localLambda {
 		invoker = &functions.LocalInvoker{
-			Detail: detail,
+			Backend: awsdetail.NewBackend(detail),
 		}
 	} else {
 		invoker = &awsdetail.LambdaInvoker{
@@ -28,40 +27,38 @@ func NewMinecloudAWS(sess *session.Session, detail *awsdetail.Detail, localLambd
 
 	return &minecloudAWS{
 		detail:  detail,
+		backend: awsdetail.NewBackend(detail),
 		invoker: invoker,
 	}
 }
 
 type minecloudAWS struct {
 	detail  *awsdetail.Detail
+	backend *backend.Backend
 	invoker functions.Invoker
 }
 
-func (a *minecloudAWS) Up(world minecloud.World, instanceType *string) error {
+func (a *minecloudAWS) Up(world minecloud.World, opts minecloud.UpOptions) (string, error) {
 	event := functions.Event{
-		Command:      aws.String("up"),
-		World:        aws.String(string(world)),
-		InstanceType: instanceType,
-	}
-
-	eventPayload, err := json.Marshal(event)
-	if err != nil {
-		return err
+		Command: aws.String("up"),
+		World:   aws.String(string(world)),
+		Owner:   aws.String(functions.LocalOwner()),
 	}
+	event.SetUpOptions(opts)
 
-	return a.invoker.Invoke("MinecloudSingleton", eventPayload)
+	return a.invokeSingleton(event)
 }
 
-func (a *minecloudAWS) Down(world minecloud.World) error {
+func (a *minecloudAWS) Down(world minecloud.World) (string, error) {
 	event := functions.Event{
 		Command: aws.String("down"),
 		World:   aws.String(string(world)),
 	}
 
-	eventPayload, err := json.Marshal(event)
-	if err != nil {
-		return err
-	}
+	return a.invokeSingleton(event)
+}
 
-	return a.invoker.Invoke("MinecloudSingleton", eventPayload)
+// invokeSingleton with the event as a new operation, returning its ID.
+func (a *minecloudAWS) invokeSingleton(event functions.Event) (string, error) {
+	return functions.RequestOperation(a.backend, a.invoker, event)
 }
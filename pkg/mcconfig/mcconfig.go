@@ -0,0 +1,242 @@
+// Package mcconfig holds the account specific settings Minecloud needs, eg
+// which bucket worlds are kept in. The CLI reads them from ~/.minecloud/config
+// and the environment, lambdas from the environment alone.
+package mcconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config for a Minecloud deployment. Keys in the config file are the JSON
+// names.
+type Config struct {
+	Region           string `json:"region,omitempty"`  // main region, with the bucket, claims table and lambdas.
+	Regions          string `json:"regions,omitempty"` // other regions worlds can live in, comma separated.
+	HostedZoneID     string `json:"hostedZoneId,omitempty"`
+	HostedZoneSuffix string `json:"hostedZoneSuffix,omitempty"` // eg "example.com." note final dot.
+	Bucket           string `json:"bucket,omitempty"`           // worlds, servers and backups.
+	SecretsBucket    string `json:"secretsBucket,omitempty"`    // SSH key for lambdas.
+	SecurityGroupID  string `json:"securityGroupId,omitempty"`  // in the main region, defaults to the one init creates.
+	KeyPairName      string `json:"keyPairName,omitempty"`
+	ImageID          string `json:"imageId,omitempty"` // base image in the main region, defaults to the latest Amazon Linux 2.
+	InstanceType     string `json:"instanceType,omitempty"`
+	TableName        string `json:"tableName,omitempty"` // DynamoDB table of claimed worlds.
+
+	// OpenID Connect provider users of the website log in with, eg a
+	// Cognito user pool.
+	OIDCIssuer   string `json:"oidcIssuer,omitempty"`
+	OIDCClientID string `json:"oidcClientId,omitempty"`
+	OIDCJWKSURL  string `json:"oidcJwksUrl,omitempty"`  // keys tokens are signed with, defaults to the issuer's.
+	OIDCAuthURL  string `json:"oidcAuthUrl,omitempty"`  // authorization endpoint the website sends users to.
+	OIDCTokenURL string `json:"oidcTokenUrl,omitempty"` // token endpoint the website redeems logins at, defaults to the authorization endpoint's.
+}
+
+// Defaults for settings that can reasonably have one.
+var Defaults = Config{
+	KeyPairName:  "MinecraftServerKeyPair",
+	InstanceType: "z1d.large",
+	TableName:    "MinecloudServers",
+}
+
+// Field describes a setting, for showing and setting them by key.
+type Field struct {
+	Key string // in the config file.
+	Env string // environment variable overriding the file.
+	get func(*Config) *string
+}
+
+// Fields lists every setting.
+var Fields = []Field{
+	{"region", "MINECLOUD_REGION", func(c *Config) *string { return &c.Region }},
+	{"regions", "MINECLOUD_REGIONS", func(c *Config) *string { return &c.Regions }},
+	{"hostedZoneId", "MINECLOUD_HOSTED_ZONE_ID", func(c *Config) *string { return &c.HostedZoneID }},
+	{"hostedZoneSuffix", "MINECLOUD_HOSTED_ZONE_SUFFIX", func(c *Config) *string { return &c.HostedZoneSuffix }},
+	{"bucket", "MINECLOUD_BUCKET", func(c *Config) *string { return &c.Bucket }},
+	{"secretsBucket", "MINECLOUD_SECRETS_BUCKET", func(c *Config) *string { return &c.SecretsBucket }},
+	{"securityGroupId", "MINECLOUD_SECURITY_GROUP_ID", func(c *Config) *string { return &c.SecurityGroupID }},
+	{"keyPairName", "MINECLOUD_KEY_PAIR_NAME", func(c *Config) *string { return &c.KeyPairName }},
+	{"imageId", "MINECLOUD_IMAGE_ID", func(c *Config) *string { return &c.ImageID }},
+	{"instanceType", "MINECLOUD_INSTANCE_TYPE", func(c *Config) *string { return &c.InstanceType }},
+	{"tableName", "MINECLOUD_TABLE_NAME", func(c *Config) *string { return &c.TableName }},
+	{"oidcIssuer", "MINECLOUD_OIDC_ISSUER", func(c *Config) *string { return &c.OIDCIssuer }},
+	{"oidcClientId", "MINECLOUD_OIDC_CLIENT_ID", func(c *Config) *string { return &c.OIDCClientID }},
+	{"oidcJwksUrl", "MINECLOUD_OIDC_JWKS_URL", func(c *Config) *string { return &c.OIDCJWKSURL }},
+	{"oidcAuthUrl", "MINECLOUD_OIDC_AUTH_URL", func(c *Config) *string { return &c.OIDCAuthURL }},
+	{"oidcTokenUrl", "MINECLOUD_OIDC_TOKEN_URL", func(c *Config) *string { return &c.OIDCTokenURL }},
+}
+
+// ErrUnknownKey given when getting or setting a key that isn't in Fields.
+var ErrUnknownKey = errors.New("unknown config key")
+
+// DefaultPath is where the CLI keeps its config.
+func DefaultPath(home string) string {
+	return filepath.Join(home, ".minecloud", "config")
+}
+
+// Load reads the config file, applies the environment and defaults,
+// then validates it. An empty file name or a missing file uses the environment
+// alone.
+func Load(file string) (Config, error) {
+	config := Config{}
+	if file != "" {
+		var err error
+		config, err = Read(file)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	config = config.FromEnv(os.Getenv).WithDefaults()
+	return config, config.Validate()
+}
+
+// Read a config file. A missing file is an empty config.
+func Read(file string) (Config, error) {
+	config := Config{}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("read config %s: %w", file, err)
+	}
+	return config, nil
+}
+
+// Write a config file, creating its directory if needed.
+func Write(file string, config Config) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+
+	if err := ioutil.WriteFile(file, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+// FromEnv returns the config with any settings given in the environment
+// replaced. getenv is normally os.Getenv.
+func (c Config) FromEnv(getenv func(string) string) Config {
+	for _, field := range Fields {
+		if value := getenv(field.Env); value != "" {
+			*field.get(&c) = value
+		}
+	}
+	return c
+}
+
+// WithDefaults returns the config with unset settings defaulted. The secrets
+// bucket defaults to the bucket with a "-secrets" suffix, and the OIDC keys
+// and token endpoint to where Cognito keeps them for the issuer and
+// authorization endpoint.
+func (c Config) WithDefaults() Config {
+	defaults := Defaults
+	if c.Bucket != "" {
+		defaults.SecretsBucket = c.Bucket + "-secrets"
+	}
+	if c.OIDCIssuer != "" {
+		defaults.OIDCJWKSURL = strings.TrimSuffix(c.OIDCIssuer, "/") + "/.well-known/jwks.json"
+	}
+	if strings.HasSuffix(c.OIDCAuthURL, "/authorize") {
+		defaults.OIDCTokenURL = strings.TrimSuffix(c.OIDCAuthURL, "/authorize") + "/token"
+	}
+
+	for _, field := range Fields {
+		if value := field.get(&c); *value == "" {
+			*value = *field.get(&defaults)
+		}
+	}
+	return c
+}
+
+// Validate checks everything without a default has been set.
+func (c Config) Validate() error {
+	missing := []string{}
+	for _, key := range []string{"hostedZoneId", "hostedZoneSuffix", "bucket"} {
+		if value, _ := c.Get(key); value == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing config: %s", strings.Join(missing, ", "))
+	}
+
+	if !strings.HasSuffix(c.HostedZoneSuffix, ".") {
+		return fmt.Errorf("hostedZoneSuffix must end with a dot, eg %q", c.HostedZoneSuffix+".")
+	}
+	return nil
+}
+
+// AllRegions lists the main region followed by the others worlds can live in.
+func (c Config) AllRegions() []string {
+	regions := []string{c.Region}
+	for _, region := range strings.Split(c.Regions, ",") {
+		region = strings.TrimSpace(region)
+		if region != "" && !contains(regions, region) {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// HasRegion reports whether worlds can live in the region.
+func (c Config) HasRegion(region string) bool {
+	return contains(c.AllRegions(), region)
+}
+
+// RegionBucket is the bucket worlds living in a region are stored in. It is
+// the bucket in the main region, otherwise the bucket with the region as a
+// suffix, eg "my-worlds-us-east-1".
+func (c Config) RegionBucket(region string) string {
+	if region == "" || region == c.Region {
+		return c.Bucket
+	}
+	return c.Bucket + "-" + region
+}
+
+// Get a setting by key.
+func (c Config) Get(key string) (string, error) {
+	for _, field := range Fields {
+		if field.Key == key {
+			return *field.get(&c), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownKey, key)
+}
+
+// Set a setting by key. An empty value unsets it.
+func (c *Config) Set(key, value string) error {
+	for _, field := range Fields {
+		if field.Key == key {
+			*field.get(c) = value
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownKey, key)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
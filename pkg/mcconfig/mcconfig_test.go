@@ -0,0 +1,87 @@
+package mcconfig_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/owengage/minecloud/pkg/mcconfig"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := mcconfig.DefaultPath(dir)
+
+	config, err := mcconfig.Read(file)
+	require.NoError(t, err)
+	require.Equal(t, mcconfig.Config{}, config)
+
+	require.NoError(t, config.Set("bucket", "my-worlds"))
+	require.NoError(t, mcconfig.Write(file, config))
+
+	config, err = mcconfig.Read(file)
+	require.NoError(t, err)
+	require.Equal(t, "my-worlds", config.Bucket)
+	require.Equal(t, filepath.Join(dir, ".minecloud", "config"), file)
+
+	err = config.Set("bukkit", "my-worlds")
+	require.True(t, errors.Is(err, mcconfig.ErrUnknownKey))
+}
+
+func TestEnvAndDefaults(t *testing.T) {
+	env := map[string]string{
+		"MINECLOUD_BUCKET":        "env-worlds",
+		"MINECLOUD_INSTANCE_TYPE": "t3.large",
+	}
+
+	config := mcconfig.Config{Bucket: "file-worlds", TableName: "Servers"}
+	config = config.FromEnv(func(key string) string { return env[key] }).WithDefaults()
+
+	require.Equal(t, "env-worlds", config.Bucket)
+	require.Equal(t, "env-worlds-secrets", config.SecretsBucket)
+	require.Equal(t, "t3.large", config.InstanceType)
+	require.Equal(t, "Servers", config.TableName)
+	require.Equal(t, mcconfig.Defaults.KeyPairName, config.KeyPairName)
+}
+
+func TestOIDCDefaults(t *testing.T) {
+	config := mcconfig.Config{
+		OIDCIssuer:  "https://cognito-idp.eu-west-2.amazonaws.com/pool/",
+		OIDCAuthURL: "https://example.auth.eu-west-2.amazoncognito.com/oauth2/authorize",
+	}.WithDefaults()
+
+	require.Equal(t, "https://cognito-idp.eu-west-2.amazonaws.com/pool/.well-known/jwks.json", config.OIDCJWKSURL)
+	require.Equal(t, "https://example.auth.eu-west-2.amazoncognito.com/oauth2/token", config.OIDCTokenURL)
+
+	config = mcconfig.Config{OIDCAuthURL: "https://login.example.com/auth"}.WithDefaults()
+	require.Equal(t, "", config.OIDCTokenURL)
+}
+
+func TestValidate(t *testing.T) {
+	config := mcconfig.Config{HostedZoneSuffix: "example.com"}.WithDefaults()
+	require.EqualError(t, config.Validate(), "missing config: hostedZoneId, bucket")
+
+	config.HostedZoneID = "ZFAKE"
+	config.Bucket = "my-worlds"
+	require.EqualError(t, config.Validate(), `hostedZoneSuffix must end with a dot, eg "example.com."`)
+
+	config.HostedZoneSuffix = "example.com."
+	require.NoError(t, config.Validate())
+}
+
+func TestRegions(t *testing.T) {
+	config := mcconfig.Config{Region: "eu-west-2", Regions: "us-east-1, eu-west-2,,us-west-2", Bucket: "my-worlds"}
+
+	require.Equal(t, []string{"eu-west-2", "us-east-1", "us-west-2"}, config.AllRegions())
+	require.True(t, config.HasRegion("us-west-2"))
+	require.False(t, config.HasRegion("ap-south-1"))
+
+	require.Equal(t, "my-worlds", config.RegionBucket("eu-west-2"))
+	require.Equal(t, "my-worlds-us-east-1", config.RegionBucket("us-east-1"))
+}
@@ -0,0 +1,56 @@
+package mclocal
+
+import (
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/functions"
+	"github.com/owengage/minecloud/pkg/minecloud"
+)
+
+// NewMinecloudLocal creates a Minecloud instance that runs the lambda function
+// code in-process against the given backend, which is typically the local host.
+func NewMinecloudLocal(b *backend.Backend) minecloud.Interface {
+	invoker := &functions.LocalInvoker{
+		Backend: b,
+	}
+
+	return &minecloudLocal{
+		singleton: functions.Singleton{
+			Backend: b,
+			Invoker: invoker,
+		},
+	}
+}
+
+type minecloudLocal struct {
+	singleton functions.Singleton
+}
+
+func (l *minecloudLocal) Up(world minecloud.World, opts minecloud.UpOptions) (string, error) {
+	command := "up"
+	name := string(world)
+	owner := functions.LocalOwner()
+
+	event := functions.Event{
+		Command: &command,
+		World:   &name,
+		Owner:   &owner,
+	}
+	event.SetUpOptions(opts)
+
+	return l.handle(event)
+}
+
+func (l *minecloudLocal) Down(world minecloud.World) (string, error) {
+	command := "down"
+	name := string(world)
+
+	return l.handle(functions.Event{
+		Command: &command,
+		World:   &name,
+	})
+}
+
+// handle the event as a new operation, which is done by the time it returns.
+func (l *minecloudLocal) handle(event functions.Event) (string, error) {
+	return functions.RequestOperation(l.singleton.Backend, l.singleton.Invoker, event)
+}
@@ -3,8 +3,29 @@ package minecloud
 // World is a strong type for a world name.
 type World string
 
-// Interface is the main interface to Minecloud services
+// UpOptions for bringing a world up.
+type UpOptions struct {
+	// InstanceType to run the server on, nil for the default.
+	InstanceType *string
+
+	// Spot runs the server on spot capacity, which is much cheaper but can be
+	// reclaimed with two minutes notice. The server saves and uploads the
+	// world when that happens.
+	Spot bool
+
+	// MaxPrice is the most to pay per hour for spot capacity, in USD, eg
+	// "0.05". Empty caps it at the on-demand price.
+	MaxPrice string
+
+	// Backup is the ID of a backup to run instead of the stored world. The
+	// stored world is replaced by it when the server is taken down.
+	Backup string
+}
+
+// Interface is the main interface to Minecloud services. Up and Down may
+// return before the world is up or down, giving the ID of the operation to
+// follow its progress with.
 type Interface interface {
-	Up(world World, instanceType *string) error
-	Down(world World) error
+	Up(world World, opts UpOptions) (string, error)
+	Down(world World) (string, error)
 }
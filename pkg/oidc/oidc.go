@@ -0,0 +1,262 @@
+// Package oidc verifies OpenID Connect ID tokens: JWTs signed by the issuer
+// with one of the keys it publishes as a JWKS. Only RS256 is supported, which
+// is what Cognito and most providers sign with.
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken given if a token is malformed, badly signed, or not for us.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Leeway allowed for clock skew when checking a token's times.
+const Leeway = time.Minute
+
+// RefetchInterval is the least time between fetching the keys again, when a
+// token is signed with a key we don't know, eg after the issuer rotates them.
+var RefetchInterval = time.Minute
+
+// Config of the provider to trust.
+type Config struct {
+	Issuer   string // must match the token's iss exactly.
+	ClientID string // token must be for this audience.
+	JWKSURL  string // keys the issuer signs with.
+}
+
+// Claims of a verified token that we use.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ClientID  string   `json:"client_id"` // Cognito access tokens have this instead of aud.
+	TokenUse  string   `json:"token_use"` // Cognito's "id" or "access".
+	Expires   int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+
+	Email    string `json:"email"`
+	Username string `json:"preferred_username"`
+}
+
+// Name of the user to show, and record as a claim's owner.
+func (c Claims) Name() string {
+	switch {
+	case c.Email != "":
+		return c.Email
+	case c.Username != "":
+		return c.Username
+	}
+	return c.Subject
+}
+
+// audience is a single string or an array of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	err := json.Unmarshal(b, &many)
+	*a = many
+	return err
+}
+
+func (a audience) contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Verifier checks tokens against the issuer's keys, fetching them as needed.
+type Verifier struct {
+	Config Config
+	Client *http.Client
+	Now    func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// NewVerifier for tokens from the configured issuer.
+func NewVerifier(config Config) *Verifier {
+	return &Verifier{
+		Config: config,
+		Client: &http.Client{Timeout: 10 * time.Second},
+		Now:    time.Now,
+	}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify a token's signature and claims.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return Claims{}, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	if h.Alg != "RS256" {
+		return Claims{}, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, h.Alg)
+	}
+
+	key, err := v.key(h.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return Claims{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	return claims, v.check(claims)
+}
+
+// check the claims are from the issuer, for us, and current.
+func (v *Verifier) check(claims Claims) error {
+	if claims.Issuer != v.Config.Issuer {
+		return fmt.Errorf("%w: issued by %q", ErrInvalidToken, claims.Issuer)
+	}
+	if !v.forClient(claims) {
+		return fmt.Errorf("%w: not for this client", ErrInvalidToken)
+	}
+
+	now := v.Now()
+	if claims.Expires == 0 || now.Add(-Leeway).After(time.Unix(claims.Expires, 0)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if claims.NotBefore != 0 && now.Add(Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	return nil
+}
+
+// forClient reports whether the token was issued to our client. ID tokens
+// name it as their audience, Cognito access tokens as their client_id. Each
+// must say it's that kind of token, so one kind can't be passed off as the
+// other.
+func (v *Verifier) forClient(claims Claims) bool {
+	switch {
+	case claims.Audience.contains(v.Config.ClientID):
+		return claims.TokenUse == "" || claims.TokenUse == "id"
+	case claims.ClientID == v.Config.ClientID:
+		return claims.TokenUse == "access"
+	}
+	return false
+}
+
+// key with the ID, fetching the keys if we don't have it.
+func (v *Verifier) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+
+	if v.keys == nil || v.Now().Sub(v.fetched) >= RefetchInterval {
+		keys, err := v.fetch()
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+		v.fetched = v.Now()
+	}
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// fetch the issuer's RSA keys by ID. Other kinds of key are ignored.
+func (v *Verifier) fetch() (map[string]*rsa.PublicKey, error) {
+	resp, err := v.Client.Get(v.Config.JWKSURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetch keys: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch keys: %s from %s", resp.Status, v.Config.JWKSURL)
+	}
+
+	var set jwks
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("fetch keys: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("fetch keys: %s: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("fetch keys: %s: %w", k.Kid, err)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,136 @@
+package oidc_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/oidc"
+	"github.com/owengage/minecloud/pkg/oidc/oidctest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	issuer := oidctest.NewIssuer()
+	defer issuer.Close()
+	verifier := oidc.NewVerifier(issuer.Config())
+
+	claims, err := verifier.Verify(issuer.Token("steve@example.com"))
+	require.NoError(t, err)
+	require.Equal(t, "steve@example.com", claims.Name())
+	require.Equal(t, "user-steve@example.com", claims.Subject)
+}
+
+func TestVerifyAudienceList(t *testing.T) {
+	issuer := oidctest.NewIssuer()
+	defer issuer.Close()
+	verifier := oidc.NewVerifier(issuer.Config())
+
+	_, err := verifier.Verify(issuer.Sign(map[string]interface{}{
+		"iss": issuer.URL,
+		"sub": "alex",
+		"aud": []string{"other", oidctest.ClientID},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}))
+	require.NoError(t, err)
+}
+
+func TestVerifyAccessToken(t *testing.T) {
+	issuer := oidctest.NewIssuer()
+	defer issuer.Close()
+	verifier := oidc.NewVerifier(issuer.Config())
+
+	_, err := verifier.Verify(issuer.Sign(map[string]interface{}{
+		"iss":       issuer.URL,
+		"sub":       "alex",
+		"client_id": oidctest.ClientID,
+		"token_use": "access",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	}))
+	require.NoError(t, err)
+}
+
+func TestVerifyRejects(t *testing.T) {
+	issuer := oidctest.NewIssuer()
+	defer issuer.Close()
+	verifier := oidc.NewVerifier(issuer.Config())
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": issuer.URL,
+			"sub": "steve",
+			"aud": oidctest.ClientID,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	expired := valid()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+
+	early := valid()
+	early["nbf"] = time.Now().Add(time.Hour).Unix()
+
+	otherIssuer := valid()
+	otherIssuer["iss"] = "https://evil.example.com"
+
+	otherClient := valid()
+	otherClient["aud"] = "someone-else"
+
+	accessWithAud := valid()
+	accessWithAud["token_use"] = "access"
+
+	idWithClientID := valid()
+	delete(idWithClientID, "aud")
+	idWithClientID["client_id"] = oidctest.ClientID
+	idWithClientID["token_use"] = "id"
+
+	untypedClientID := valid()
+	delete(untypedClientID, "aud")
+	untypedClientID["client_id"] = oidctest.ClientID
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tokens := map[string]string{
+		"expired":       issuer.Sign(expired),
+		"not yet valid": issuer.Sign(early),
+		"other issuer":  issuer.Sign(otherIssuer),
+		"other client":  issuer.Sign(otherClient),
+		"access as id":  issuer.Sign(accessWithAud),
+		"id as access":  issuer.Sign(idWithClientID),
+		"untyped":       issuer.Sign(untypedClientID),
+		"forged":        oidctest.Sign(otherKey, issuer.Kid, valid()),
+		"unknown key":   oidctest.Sign(otherKey, "other-key", valid()),
+		"garbage":       "not.a.jwt",
+		"empty":         "",
+	}
+
+	for name, token := range tokens {
+		_, err := verifier.Verify(token)
+		require.True(t, errors.Is(err, oidc.ErrInvalidToken), "%s: %v", name, err)
+	}
+}
+
+func TestVerifyRotatedKey(t *testing.T) {
+	issuer := oidctest.NewIssuer()
+	defer issuer.Close()
+	verifier := oidc.NewVerifier(issuer.Config())
+
+	_, err := verifier.Verify(issuer.Token("steve@example.com"))
+	require.NoError(t, err)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	issuer.Key, issuer.Kid = key, "rotated"
+
+	// Keys aren't fetched again straight away.
+	_, err = verifier.Verify(issuer.Token("steve@example.com"))
+	require.True(t, errors.Is(err, oidc.ErrInvalidToken))
+
+	now := time.Now
+	verifier.Now = func() time.Time { return now().Add(oidc.RefetchInterval) }
+	_, err = verifier.Verify(issuer.Token("steve@example.com"))
+	require.NoError(t, err)
+}
@@ -0,0 +1,216 @@
+// Package rcon implements the Source RCON protocol as spoken by Minecraft
+// servers with enable-rcon=true in their server.properties.
+//
+// See https://wiki.vg/RCON for the protocol.
+package rcon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultPort is Minecraft's default rcon.port.
+const DefaultPort = 25575
+
+// Packet types. ExecCommand and AuthResponse share a value, which packet is
+// meant depends on the direction.
+const (
+	typeResponseValue int32 = 0
+	typeExecCommand   int32 = 2
+	typeAuthResponse  int32 = 2
+	typeAuth          int32 = 3
+)
+
+// MaxCommandLength is the longest command body Minecraft will accept.
+const MaxCommandLength = 1446
+
+// maxPacketLength is the largest packet we will read. Minecraft splits
+// responses into 4096 byte bodies.
+const maxPacketLength = 4096 + 10
+
+var (
+	ErrAuthFailed      = errors.New("rcon: authentication failed")
+	ErrCommandTooLong  = errors.New("rcon: command too long")
+	ErrInvalidResponse = errors.New("rcon: invalid response")
+)
+
+// Client is an authenticated RCON connection. It is safe for concurrent use,
+// commands are sent one at a time.
+type Client struct {
+	// Timeout for each command, including reading the full response.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	nextID int32
+}
+
+// Dial connects to the RCON server at address and authenticates.
+func Dial(address, password string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("rcon: %w", err)
+	}
+
+	client := &Client{
+		Timeout: timeout,
+		conn:    conn,
+	}
+
+	err = client.auth(password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Close the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Command runs cmd on the server, eg Command("list"), returning its output.
+// Responses split over several packets are joined.
+func (c *Client) Command(cmd string) (string, error) {
+	if len(cmd) > MaxCommandLength {
+		return "", ErrCommandTooLong
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setDeadline()
+
+	id := c.id()
+	err := writePacket(c.conn, packet{ID: id, Type: typeExecCommand, Body: cmd})
+	if err != nil {
+		return "", fmt.Errorf("rcon: %w", err)
+	}
+
+	// There's no marker for the last packet of a response. Follow the command
+	// with a request the server can't understand, it responds in order so
+	// everything before the reply to that is the command's output.
+	terminator := c.id()
+	err = writePacket(c.conn, packet{ID: terminator, Type: typeResponseValue})
+	if err != nil {
+		return "", fmt.Errorf("rcon: %w", err)
+	}
+
+	var output bytes.Buffer
+	for {
+		p, err := readPacket(c.conn)
+		if err != nil {
+			return "", fmt.Errorf("rcon: %w", err)
+		}
+
+		switch p.ID {
+		case id:
+			output.WriteString(p.Body)
+		case terminator:
+			return output.String(), nil
+		}
+	}
+}
+
+func (c *Client) auth(password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setDeadline()
+
+	id := c.id()
+	err := writePacket(c.conn, packet{ID: id, Type: typeAuth, Body: password})
+	if err != nil {
+		return fmt.Errorf("rcon: %w", err)
+	}
+
+	for {
+		p, err := readPacket(c.conn)
+		if err != nil {
+			return fmt.Errorf("rcon: %w", err)
+		}
+
+		// Some servers send an empty response value before the auth
+		// response. Minecraft doesn't, but it costs nothing to skip it.
+		if p.Type != typeAuthResponse {
+			continue
+		}
+
+		if p.ID == -1 {
+			return ErrAuthFailed
+		}
+		if p.ID != id {
+			return ErrInvalidResponse
+		}
+		return nil
+	}
+}
+
+func (c *Client) id() int32 {
+	c.nextID++
+	return c.nextID
+}
+
+func (c *Client) setDeadline() {
+	if c.Timeout > 0 {
+		_ = c.conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+}
+
+type packet struct {
+	ID   int32
+	Type int32
+	Body string
+}
+
+// writePacket writes p. Packets are a little endian length, then the ID,
+// type, null terminated body and an empty null terminated string.
+func writePacket(w io.Writer, p packet) error {
+	var buf bytes.Buffer
+	length := int32(4 + 4 + len(p.Body) + 2)
+
+	_ = binary.Write(&buf, binary.LittleEndian, length)
+	_ = binary.Write(&buf, binary.LittleEndian, p.ID)
+	_ = binary.Write(&buf, binary.LittleEndian, p.Type)
+	buf.WriteString(p.Body)
+	buf.Write([]byte{0, 0})
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func readPacket(r io.Reader) (packet, error) {
+	var length int32
+	err := binary.Read(r, binary.LittleEndian, &length)
+	if err != nil {
+		return packet{}, err
+	}
+
+	if length < 10 || length > maxPacketLength {
+		return packet{}, ErrInvalidResponse
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return packet{}, err
+	}
+
+	if data[length-2] != 0 || data[length-1] != 0 {
+		return packet{}, ErrInvalidResponse
+	}
+
+	return packet{
+		ID:   int32(binary.LittleEndian.Uint32(data[0:4])),
+		Type: int32(binary.LittleEndian.Uint32(data[4:8])),
+		Body: string(data[8 : length-2]),
+	}, nil
+}
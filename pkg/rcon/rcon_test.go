@@ -0,0 +1,129 @@
+package rcon
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer behaves like Minecraft's RCON listener. Responses longer than
+// 4096 bytes are split over several packets.
+func fakeServer(t *testing.T, password string, commands map[string]string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFake(conn, password, commands)
+		}
+	}()
+
+	return listener
+}
+
+func serveFake(conn net.Conn, password string, commands map[string]string) {
+	defer conn.Close()
+
+	authed := false
+	for {
+		p, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+
+		switch {
+		case p.Type == typeAuth:
+			id := p.ID
+			authed = p.Body == password
+			if !authed {
+				id = -1
+			}
+			_ = writePacket(conn, packet{ID: id, Type: typeAuthResponse})
+		case !authed:
+			return
+		case p.Type == typeExecCommand:
+			out, ok := commands[p.Body]
+			if !ok {
+				out = "Unknown or incomplete command"
+			}
+			for {
+				n := len(out)
+				if n > 4096 {
+					n = 4096
+				}
+				_ = writePacket(conn, packet{ID: p.ID, Type: typeResponseValue, Body: out[:n]})
+				out = out[n:]
+				if out == "" {
+					break
+				}
+			}
+		default:
+			_ = writePacket(conn, packet{ID: p.ID, Type: typeResponseValue, Body: fmt.Sprintf("Unknown request %x", p.Type)})
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	listener := fakeServer(t, "hunter2", map[string]string{
+		"list": "There are 1 of a max of 20 players online: NeroGage",
+	})
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	client, err := Dial(addr, "hunter2", time.Second)
+	require.NoError(t, err)
+	defer client.Close()
+
+	out, err := client.Command("list")
+	require.NoError(t, err)
+	require.Equal(t, "There are 1 of a max of 20 players online: NeroGage", out)
+
+	out, err = client.Command("nonsense")
+	require.NoError(t, err)
+	require.Equal(t, "Unknown or incomplete command", out)
+}
+
+func TestCommandMultiPacketResponse(t *testing.T) {
+	long := strings.Repeat("a", 4096) + strings.Repeat("b", 4096) + "c"
+	listener := fakeServer(t, "hunter2", map[string]string{"help": long})
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	client, err := Dial(addr, "hunter2", time.Second)
+	require.NoError(t, err)
+	defer client.Close()
+
+	out, err := client.Command("help")
+	require.NoError(t, err)
+	require.Equal(t, long, out)
+}
+
+func TestDialWrongPassword(t *testing.T) {
+	listener := fakeServer(t, "hunter2", nil)
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	_, err := Dial(addr, "letmein", time.Second)
+	require.Equal(t, ErrAuthFailed, err)
+}
+
+func TestCommandTooLong(t *testing.T) {
+	listener := fakeServer(t, "hunter2", nil)
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	client, err := Dial(addr, "hunter2", time.Second)
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Command(strings.Repeat("x", MaxCommandLength+1))
+	require.Equal(t, ErrCommandTooLong, err)
+}
@@ -0,0 +1,38 @@
+package serverwrapper
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir("", "world")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "region"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "level.dat"), []byte("level"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "region", "r.0.0.mca"), []byte("region"), 0644))
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteArchive(&buf, src))
+
+	dst, err := ioutil.TempDir("", "restored")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	require.NoError(t, ExtractArchive(&buf, dst))
+
+	level, err := ioutil.ReadFile(filepath.Join(dst, "level.dat"))
+	require.NoError(t, err)
+	require.Equal(t, "level", string(level))
+
+	region, err := ioutil.ReadFile(filepath.Join(dst, "region", "r.0.0.mca"))
+	require.NoError(t, err)
+	require.Equal(t, "region", string(region))
+}
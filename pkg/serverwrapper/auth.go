@@ -0,0 +1,112 @@
+package serverwrapper
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TLSServerName is the name in every wrapper certificate. Wrappers are reached
+// by IP, so clients verify against this name and the pinned certificate rather
+// than the address.
+const TLSServerName = "minecloud-wrapper"
+
+// Credentials let a client talk to one wrapper's HTTPS API.
+type Credentials struct {
+	Token string // bearer token the wrapper requires.
+	Cert  []byte // PEM certificate the wrapper serves.
+}
+
+// NewCredentials generates a token and a self-signed certificate for a
+// wrapper. The certificate's private key is returned as PEM, it only needs to
+// be given to the wrapper.
+func NewCredentials(now time.Time) (Credentials, []byte, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return Credentials{}, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Credentials{}, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return Credentials{}, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: TLSServerName},
+		DNSNames:     []string{TLSServerName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return Credentials{}, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return Credentials{}, nil, err
+	}
+
+	creds := Credentials{
+		Token: hex.EncodeToString(token),
+		Cert:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return creds, keyPEM, nil
+}
+
+// NewTLSClient creates a client for a wrapper's HTTPS API at baseURL, eg
+// "https://198.51.100.1:8443", trusting only the wrapper's own certificate.
+func NewTLSClient(baseURL string, creds Credentials) (*Client, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(creds.Cert) {
+		return nil, errors.New("tls client: invalid certificate")
+	}
+
+	client := NewClient(baseURL)
+	client.Token = creds.Token
+	client.HTTP.Transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{
+			RootCAs:    pool,
+			ServerName: TLSServerName,
+		},
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return client, nil
+}
+
+// RequireToken only lets requests with the bearer token through to next.
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,79 @@
+package serverwrapper_test
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+	"github.com/stretchr/testify/require"
+)
+
+func newTLSServer(t *testing.T, creds serverwrapper.Credentials, key []byte) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(serverwrapper.StatusResponse{Status: serverwrapper.StatusRunning})
+	})
+
+	cert, err := tls.X509KeyPair(creds.Cert, key)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(serverwrapper.RequireToken(creds.Token, mux))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0) // handshake failures are expected.
+	server.StartTLS()
+	return server
+}
+
+func TestTLSClient(t *testing.T) {
+	creds, key, err := serverwrapper.NewCredentials(time.Now())
+	require.NoError(t, err)
+
+	server := newTLSServer(t, creds, key)
+	defer server.Close()
+
+	client, err := serverwrapper.NewTLSClient(server.URL, creds)
+	require.NoError(t, err)
+
+	status, err := client.Status()
+	require.NoError(t, err)
+	require.Equal(t, serverwrapper.StatusRunning, status.Status)
+}
+
+func TestTLSClientWrongToken(t *testing.T) {
+	creds, key, err := serverwrapper.NewCredentials(time.Now())
+	require.NoError(t, err)
+
+	server := newTLSServer(t, creds, key)
+	defer server.Close()
+
+	creds.Token = "guess"
+	client, err := serverwrapper.NewTLSClient(server.URL, creds)
+	require.NoError(t, err)
+
+	_, err = client.Status()
+	require.EqualError(t, err, "status: unexpected response: 401 Unauthorized")
+}
+
+func TestTLSClientWrongCert(t *testing.T) {
+	creds, key, err := serverwrapper.NewCredentials(time.Now())
+	require.NoError(t, err)
+
+	server := newTLSServer(t, creds, key)
+	defer server.Close()
+
+	other, _, err := serverwrapper.NewCredentials(time.Now())
+	require.NoError(t, err)
+	other.Token = creds.Token
+
+	client, err := serverwrapper.NewTLSClient(server.URL, other)
+	require.NoError(t, err)
+
+	_, err = client.Status()
+	require.Error(t, err)
+}
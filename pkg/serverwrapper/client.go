@@ -0,0 +1,378 @@
+package serverwrapper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/worldsync"
+)
+
+// Client talks to a server wrapper's HTTP API.
+type Client struct {
+	BaseURL string // eg "http://localhost:8080"
+	HTTP    *http.Client
+
+	// Token is sent as a bearer token if set, see RequireToken.
+	Token string
+}
+
+// NewClient creates a client for the wrapper listening at baseURL.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL: baseURL,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends req using client, with the token if there is one.
+func (c *Client) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return client.Do(req)
+}
+
+// send a request to path with an optional JSON body.
+func (c *Client) send(client *http.Client, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(client, req)
+}
+
+// Status of the wrapper.
+func (c *Client) Status() (StatusResponse, error) {
+	var status StatusResponse
+
+	resp, err := c.send(c.HTTP, http.MethodGet, "/status", nil)
+	if err != nil {
+		return status, fmt.Errorf("status: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("status: unexpected response: %s", resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return status, err
+}
+
+// Stop asks the wrapper to stop the Minecraft server.
+func (c *Client) Stop() error {
+	resp, err := c.send(c.HTTP, http.MethodPost, "/stop", nil)
+	if err != nil {
+		return fmt.Errorf("stop: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("stop: unexpected response: %s", resp.Status)
+	}
+	return nil
+}
+
+// Command runs a console command on the server, returning its output if the
+// wrapper has it.
+func (c *Client) Command(cmd string) (string, error) {
+	body, err := json.Marshal(CommandRequest{Command: cmd})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.send(c.HTTP, http.MethodPost, "/command", body)
+	if err != nil {
+		return "", fmt.Errorf("command: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("command: unexpected response: %s", resp.Status)
+	}
+
+	var result CommandResponse
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return "", fmt.Errorf("command: %w", err)
+	}
+
+	if result.Error != "" {
+		return result.Output, fmt.Errorf("command: %s", result.Error)
+	}
+	return result.Output, nil
+}
+
+// Save asks the wrapper to flush the world to disk, waiting up to timeout for
+// the game to be saved.
+func (c *Client) Save(timeout time.Duration) error {
+	query := url.Values{"timeout": {timeout.String()}}
+
+	// The wrapper gives up at timeout, so allow it a little longer to say so.
+	client := &http.Client{Transport: c.HTTP.Transport, Timeout: timeout + 10*time.Second}
+
+	resp, err := c.send(client, http.MethodPost, "/save?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("save: unexpected response: %s", resp.Status)
+	}
+
+	var result SaveResponse
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+
+	if result.Error != "" {
+		return fmt.Errorf("save: %s", result.Error)
+	}
+	return nil
+}
+
+// Upload asks the wrapper to upload the running world, which must be named
+// world, returning what was transferred.
+func (c *Client) Upload(world string) (*worldsync.Stats, error) {
+	body, err := json.Marshal(UploadRequest{World: world})
+	if err != nil {
+		return nil, err
+	}
+
+	// Uploading a large world takes a while, so don't use the client's timeout.
+	stream := &http.Client{Transport: c.HTTP.Transport}
+
+	resp, err := c.send(stream, http.MethodPost, "/upload", body)
+	if err != nil {
+		return nil, fmt.Errorf("upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upload: unexpected response: %s", resp.Status)
+	}
+
+	var result UploadResponse
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("upload: %w", err)
+	}
+
+	if result.Error != "" {
+		return nil, fmt.Errorf("upload: %s", result.Error)
+	}
+	return result.Sync, nil
+}
+
+// Snapshot downloads an archive of the running world, writing it to w.
+func (c *Client) Snapshot(ctx context.Context, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/snapshot", nil)
+	if err != nil {
+		return err
+	}
+
+	// Large worlds take a while, so don't use the client's timeout.
+	stream := &http.Client{Transport: c.HTTP.Transport}
+
+	resp, err := c.do(stream, req)
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("snapshot: unexpected response: %s", resp.Status)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	return nil
+}
+
+// StoreSnapshot asks the wrapper to store a snapshot of the running world,
+// returning where it went.
+func (c *Client) StoreSnapshot(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/snapshot", nil)
+	if err != nil {
+		return "", err
+	}
+
+	stream := &http.Client{Transport: c.HTTP.Transport}
+
+	resp, err := c.do(stream, req)
+	if err != nil {
+		return "", fmt.Errorf("snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("snapshot: unexpected response: %s", resp.Status)
+	}
+
+	var result SnapshotResponse
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return "", fmt.Errorf("snapshot: %w", err)
+	}
+
+	if result.Error != "" {
+		return "", fmt.Errorf("snapshot: %s", result.Error)
+	}
+	return result.Location, nil
+}
+
+// WaitForStopped polls the wrapper until it reports the server stopped.
+func (c *Client) WaitForStopped(attempts int, interval time.Duration) error {
+	for i := 0; i < attempts; i++ {
+		resp, err := c.Status()
+		if err != nil {
+			return err
+		}
+		if resp.Status == StatusStopped {
+			return nil
+		}
+		time.Sleep(interval)
+	}
+
+	return errors.New("hit max retries for server wrapper stop wait")
+}
+
+// LogsOptions select which lines of console output to get.
+type LogsOptions struct {
+	Since  uint64 // only lines after this sequence number.
+	Tail   int    // only the last n buffered lines, 0 for all.
+	Grep   string // only lines matching this regular expression.
+	Follow bool   // keep streaming new lines as they are output.
+}
+
+// Query string for the logs endpoint, eg "follow=true&tail=10".
+func (opts LogsOptions) Query() string {
+	query := url.Values{}
+	if opts.Since > 0 {
+		query.Set("since", strconv.FormatUint(opts.Since, 10))
+	}
+	if opts.Tail > 0 {
+		query.Set("tail", strconv.Itoa(opts.Tail))
+	}
+	if opts.Grep != "" {
+		query.Set("grep", opts.Grep)
+	}
+	if opts.Follow {
+		query.Set("follow", "true")
+	}
+	return query.Encode()
+}
+
+// Logs gets console output from the wrapper, calling handle for each line.
+// When following, returns once ctx is cancelled or the wrapper goes away.
+func (c *Client) Logs(ctx context.Context, opts LogsOptions, handle func(LogLine)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/logs?"+opts.Query(), nil)
+	if err != nil {
+		return err
+	}
+
+	// Following is long lived, so don't use the client's timeout.
+	stream := &http.Client{Transport: c.HTTP.Transport}
+
+	resp, err := c.do(stream, req)
+	if err != nil {
+		return fmt.Errorf("logs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("logs: unexpected response: %s", resp.Status)
+	}
+
+	err = ReadLogs(resp.Body, handle)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// ReadLogs parses a response from the logs endpoint.
+func ReadLogs(r io.Reader, handle func(LogLine)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var line LogLine
+		err := json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			return fmt.Errorf("logs: %w", err)
+		}
+		handle(line)
+	}
+
+	return scanner.Err()
+}
+
+// Events streams events from the wrapper, calling handle for each, until ctx
+// is cancelled or the wrapper closes the stream.
+func (c *Client) Events(ctx context.Context, handle func(Event)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The stream is long lived, so don't use the client's timeout.
+	stream := &http.Client{Transport: c.HTTP.Transport}
+
+	resp, err := c.do(stream, req)
+	if err != nil {
+		return fmt.Errorf("events: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("events: unexpected response: %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	var data strings.Builder
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		case line == "" && data.Len() > 0:
+			var event Event
+			err := json.Unmarshal([]byte(data.String()), &event)
+			if err != nil {
+				return fmt.Errorf("events: %w", err)
+			}
+			data.Reset()
+			handle(event)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
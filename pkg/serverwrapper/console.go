@@ -0,0 +1,102 @@
+package serverwrapper
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// ConsoleLine is a line of Minecraft server console output, eg
+//
+//	[08:28:47] [Server thread/INFO]: NeroGage joined the game
+type ConsoleLine struct {
+	Time    string // eg "08:28:47"
+	Thread  string // eg "Server thread"
+	Level   string // eg "INFO"
+	Message string // eg "NeroGage joined the game"
+}
+
+var consoleLineRe = regexp.MustCompile(`^\[([0-9:]+)\] \[([^/\]]+)/([A-Z]+)\]: (.*)$`)
+
+// ParseConsoleLine splits a line of console output into its parts. Returns
+// false for lines not in the usual format, such as stack traces.
+func ParseConsoleLine(line string) (ConsoleLine, bool) {
+	m := consoleLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return ConsoleLine{}, false
+	}
+
+	return ConsoleLine{
+		Time:    m[1],
+		Thread:  m[2],
+		Level:   m[3],
+		Message: m[4],
+	}, true
+}
+
+var (
+	playerUUIDRe  = regexp.MustCompile(`^UUID of player (\S+) is ([0-9a-f-]+)$`)
+	playerJoinRe  = regexp.MustCompile(`^(\S+) joined the game$`)
+	playerLeaveRe = regexp.MustCompile(`^(\S+) left the game$`)
+	versionRe     = regexp.MustCompile(`^Starting minecraft server version (.+)$`)
+	doneRe        = regexp.MustCompile(`^Done \(([0-9.]+)s\)!`)
+	cantKeepUpRe  = regexp.MustCompile(`^Can't keep up! .*Running (\d+)ms or (\d+) ticks behind$`)
+)
+
+// PlayerUUID matches the authenticator logging a player's UUID as they join.
+func (l ConsoleLine) PlayerUUID() (name, uuid string, ok bool) {
+	m := playerUUIDRe.FindStringSubmatch(l.Message)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// PlayerJoined matches a player joining.
+func (l ConsoleLine) PlayerJoined() (name string, ok bool) {
+	return l.serverMatch(playerJoinRe)
+}
+
+// PlayerLeft matches a player leaving.
+func (l ConsoleLine) PlayerLeft() (name string, ok bool) {
+	return l.serverMatch(playerLeaveRe)
+}
+
+// Version matches the server announcing its version as it starts.
+func (l ConsoleLine) Version() (version string, ok bool) {
+	return l.serverMatch(versionRe)
+}
+
+// Done matches the server finishing starting up.
+func (l ConsoleLine) Done() bool {
+	return l.Thread == "Server thread" && doneRe.MatchString(l.Message)
+}
+
+// TicksBehind matches the server warning that it is overloaded.
+func (l ConsoleLine) TicksBehind() (ticks int, ok bool) {
+	if l.Thread != "Server thread" {
+		return 0, false
+	}
+
+	m := cantKeepUpRe.FindStringSubmatch(l.Message)
+	if m == nil {
+		return 0, false
+	}
+
+	ticks, err := strconv.Atoi(m[2])
+	return ticks, err == nil
+}
+
+// serverMatch matches messages from the server thread, returning the first
+// submatch. Chat and the like cannot be spoofed as they are prefixed by the
+// player's name in angle brackets.
+func (l ConsoleLine) serverMatch(re *regexp.Regexp) (string, bool) {
+	if l.Thread != "Server thread" {
+		return "", false
+	}
+
+	m := re.FindStringSubmatch(l.Message)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
@@ -0,0 +1,134 @@
+package serverwrapper
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of an Event.
+type EventType string
+
+// Event types published by the wrapper.
+const (
+	EventStarted      EventType = "started"
+	EventPlayerJoined EventType = "player-joined"
+	EventPlayerLeft   EventType = "player-left"
+	EventChat         EventType = "chat"
+	EventDeath        EventType = "death"
+	EventAdvancement  EventType = "advancement"
+	EventSaved        EventType = "saved"
+	EventTaskStarted  EventType = "task-started"
+	EventTaskFinished EventType = "task-finished"
+	EventStopping     EventType = "stopping"
+)
+
+// Event is something that happened on the server, published on the wrapper's
+// /events stream.
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Player  string `json:",omitempty"`
+	Message string `json:",omitempty"` // chat message, death message or advancement name.
+	Task    string `json:",omitempty"`
+}
+
+var (
+	chatRe        = regexp.MustCompile(`^<(\S+)> (.*)$`)
+	advancementRe = regexp.MustCompile(`^(\S+) has (?:made the advancement|completed the challenge|reached the goal) \[(.+)\]$`)
+
+	// Death messages all start with the player's name, followed by one of a
+	// known set of phrasings. Names are 3-16 word characters, which rules
+	// out most other server messages.
+	deathRe = regexp.MustCompile(`^(\w{3,16}) (was |were |drowned|died|blew up|burned|fell |hit the ground|went |walked into|suffocated|starved|froze|experienced kinetic energy|tried to swim in lava|discovered the floor was lava|withered away|didn't want to live)`)
+)
+
+// ParseEvent converts a line of console output into an event, if it is one.
+func ParseEvent(line ConsoleLine, now time.Time) (Event, bool) {
+	if line.Thread != "Server thread" {
+		return Event{}, false
+	}
+
+	event := Event{Time: now}
+
+	if name, ok := line.PlayerJoined(); ok {
+		event.Type = EventPlayerJoined
+		event.Player = name
+	} else if name, ok := line.PlayerLeft(); ok {
+		event.Type = EventPlayerLeft
+		event.Player = name
+	} else if line.Done() {
+		event.Type = EventStarted
+	} else if line.Message == "Saved the game" {
+		event.Type = EventSaved
+	} else if line.Message == "Stopping server" {
+		event.Type = EventStopping
+	} else if m := chatRe.FindStringSubmatch(line.Message); m != nil {
+		event.Type = EventChat
+		event.Player = m[1]
+		event.Message = m[2]
+	} else if m := advancementRe.FindStringSubmatch(line.Message); m != nil {
+		event.Type = EventAdvancement
+		event.Player = m[1]
+		event.Message = m[2]
+	} else if m := deathRe.FindStringSubmatch(line.Message); m != nil && line.Level == "INFO" {
+		event.Type = EventDeath
+		event.Player = m[1]
+		event.Message = line.Message
+	} else {
+		return Event{}, false
+	}
+
+	return event, true
+}
+
+// eventBufferSize is how many events a slow subscriber can fall behind by
+// before events are dropped for it.
+const eventBufferSize = 64
+
+// EventBus fans events out to subscribers. Publishing never blocks; a
+// subscriber that can't keep up misses events.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// NewEventBus creates a bus with no subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subs: map[chan Event]struct{}{},
+	}
+}
+
+// Subscribe to events. Call the returned function to unsubscribe, after which
+// the channel is closed.
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish an event to every subscriber.
+func (b *EventBus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
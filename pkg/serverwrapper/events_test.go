@@ -0,0 +1,106 @@
+package serverwrapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEvent(t *testing.T) {
+	cases := []struct {
+		line  string
+		event Event
+	}{
+		{`[08:28:45] [Server thread/INFO]: Done (5.123s)! For help, type "help"`, Event{Type: EventStarted}},
+		{"[08:28:47] [Server thread/INFO]: NeroGage joined the game", Event{Type: EventPlayerJoined, Player: "NeroGage"}},
+		{"[08:28:57] [Server thread/INFO]: NeroGage left the game", Event{Type: EventPlayerLeft, Player: "NeroGage"}},
+		{"[08:29:00] [Server thread/INFO]: <NeroGage> anyone seen my sword?", Event{Type: EventChat, Player: "NeroGage", Message: "anyone seen my sword?"}},
+		{"[08:29:10] [Server thread/INFO]: NeroGage was slain by Zombie", Event{Type: EventDeath, Player: "NeroGage", Message: "NeroGage was slain by Zombie"}},
+		{"[08:29:20] [Server thread/INFO]: NeroGage fell from a high place", Event{Type: EventDeath, Player: "NeroGage", Message: "NeroGage fell from a high place"}},
+		{"[08:29:30] [Server thread/INFO]: NeroGage has made the advancement [Stone Age]", Event{Type: EventAdvancement, Player: "NeroGage", Message: "Stone Age"}},
+		{"[08:29:40] [Server thread/INFO]: Saved the game", Event{Type: EventSaved}},
+		{"[08:29:50] [Server thread/INFO]: Stopping server", Event{Type: EventStopping}},
+	}
+
+	now := time.Now()
+
+	for _, c := range cases {
+		line, ok := ParseConsoleLine(c.line)
+		require.True(t, ok, c.line)
+
+		event, ok := ParseEvent(line, now)
+		require.True(t, ok, c.line)
+
+		c.event.Time = now
+		require.Equal(t, c.event, event, c.line)
+	}
+}
+
+func TestParseEventIgnoresOtherLines(t *testing.T) {
+	lines := []string{
+		"[08:28:40] [Server thread/INFO]: Preparing level \"world\"",
+		"[08:28:47] [User Authenticator #1/INFO]: UUID of player NeroGage is a87fddc1-dc61-4c11-8472-f49001a15d21",
+		"[08:29:00] [Server thread/INFO]: <NeroGage> Steve joined the game",
+	}
+
+	for _, l := range lines {
+		line, ok := ParseConsoleLine(l)
+		require.True(t, ok, l)
+
+		event, ok := ParseEvent(line, time.Now())
+		if ok {
+			require.NotEqual(t, EventPlayerJoined, event.Type, l)
+			require.Equal(t, EventChat, event.Type, l)
+		}
+	}
+}
+
+func TestClientEvents(t *testing.T) {
+	bus := NewEventBus()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events, unsubscribe := bus.Subscribe()
+		defer unsubscribe()
+
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event := <-events:
+				fmt.Fprintf(w, "event: %s\ndata: {\"Type\":%q,\"Player\":%q}\n\n", event.Type, event.Type, event.Player)
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan Event, 16)
+	go NewClient(server.URL).Events(ctx, func(e Event) { received <- e })
+
+	// Publish until the client has subscribed and received it.
+	deadline := time.After(5 * time.Second)
+	for {
+		bus.Publish(Event{Type: EventPlayerJoined, Player: "NeroGage"})
+
+		select {
+		case e := <-received:
+			require.Equal(t, EventPlayerJoined, e.Type)
+			require.Equal(t, "NeroGage", e.Player)
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no event received")
+		}
+	}
+}
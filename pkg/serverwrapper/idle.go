@@ -0,0 +1,66 @@
+package serverwrapper
+
+import "time"
+
+// IdleState is the result of updating an IdleTimer.
+type IdleState int
+
+const (
+	// IdleNotCounting means players are online, or the server is still in its
+	// grace period after starting.
+	IdleNotCounting IdleState = iota
+
+	// IdleCountdownStarted means the server has just become empty.
+	IdleCountdownStarted
+
+	// IdleCountdownCancelled means someone joined during the countdown.
+	IdleCountdownCancelled
+
+	// IdleCounting means the server is still empty, but not for long enough.
+	IdleCounting
+
+	// IdleExpired means the server has been empty for the full timeout.
+	IdleExpired
+)
+
+// IdleTimer decides when a server has been empty long enough that it should
+// be shut down.
+type IdleTimer struct {
+	// Timeout is how long the server must be empty for. Zero or less disables
+	// the timer.
+	Timeout time.Duration
+
+	// Grace is how long after the server starts before the countdown can
+	// begin, giving people a chance to join.
+	Grace time.Duration
+
+	emptySince *time.Time
+}
+
+// Update the timer with the current state of the server. startedAt is when
+// the server finished starting, nil if it hasn't yet.
+func (t *IdleTimer) Update(now time.Time, startedAt *time.Time, players int) IdleState {
+	if t.Timeout <= 0 || startedAt == nil || now.Sub(*startedAt) < t.Grace || players > 0 {
+		if t.emptySince != nil {
+			t.emptySince = nil
+			return IdleCountdownCancelled
+		}
+		return IdleNotCounting
+	}
+
+	if t.emptySince == nil {
+		t.emptySince = &now
+		return IdleCountdownStarted
+	}
+
+	if now.Sub(*t.emptySince) >= t.Timeout {
+		return IdleExpired
+	}
+
+	return IdleCounting
+}
+
+// Restart the countdown from now, eg after a failed shutdown attempt.
+func (t *IdleTimer) Restart(now time.Time) {
+	t.emptySince = &now
+}
@@ -0,0 +1,34 @@
+package serverwrapper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdleTimer(t *testing.T) {
+	started := time.Date(2020, 3, 28, 20, 0, 0, 0, time.UTC)
+	timer := IdleTimer{Timeout: 30 * time.Minute, Grace: 10 * time.Minute}
+
+	require.Equal(t, IdleNotCounting, timer.Update(started.Add(-time.Minute), nil, 0))
+	require.Equal(t, IdleNotCounting, timer.Update(started.Add(5*time.Minute), &started, 0))
+
+	require.Equal(t, IdleCountdownStarted, timer.Update(started.Add(10*time.Minute), &started, 0))
+	require.Equal(t, IdleCounting, timer.Update(started.Add(20*time.Minute), &started, 0))
+
+	// Someone joins, cancelling the countdown.
+	require.Equal(t, IdleCountdownCancelled, timer.Update(started.Add(25*time.Minute), &started, 1))
+	require.Equal(t, IdleNotCounting, timer.Update(started.Add(30*time.Minute), &started, 1))
+
+	require.Equal(t, IdleCountdownStarted, timer.Update(started.Add(60*time.Minute), &started, 0))
+	require.Equal(t, IdleCounting, timer.Update(started.Add(89*time.Minute), &started, 0))
+	require.Equal(t, IdleExpired, timer.Update(started.Add(90*time.Minute), &started, 0))
+}
+
+func TestIdleTimerDisabled(t *testing.T) {
+	started := time.Date(2020, 3, 28, 20, 0, 0, 0, time.UTC)
+	timer := IdleTimer{}
+
+	require.Equal(t, IdleNotCounting, timer.Update(started.Add(24*time.Hour), &started, 0))
+}
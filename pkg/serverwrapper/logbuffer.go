@@ -0,0 +1,95 @@
+package serverwrapper
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+// LogLine is a line of console output kept by the wrapper.
+type LogLine struct {
+	// Seq numbers lines from 1 as they are output. Pass the last seen to
+	// /logs?since= to get only newer lines.
+	Seq  uint64
+	Time time.Time
+	Text string
+}
+
+// LogBuffer keeps the most recent lines of console output, so they can be
+// searched and followed without the container's full history.
+type LogBuffer struct {
+	mu      sync.Mutex
+	lines   []LogLine
+	start   int // index of the oldest line in lines.
+	count   int
+	lastSeq uint64
+	changed chan struct{}
+}
+
+// NewLogBuffer creates a buffer holding up to size lines.
+func NewLogBuffer(size int) *LogBuffer {
+	return &LogBuffer{
+		lines:   make([]LogLine, size),
+		changed: make(chan struct{}),
+	}
+}
+
+// Append a line, dropping the oldest if the buffer is full.
+func (b *LogBuffer) Append(text string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastSeq++
+	line := LogLine{Seq: b.lastSeq, Time: now, Text: text}
+
+	if b.count < len(b.lines) {
+		b.lines[(b.start+b.count)%len(b.lines)] = line
+		b.count++
+	} else {
+		b.lines[b.start] = line
+		b.start = (b.start + 1) % len(b.lines)
+	}
+
+	// Wake anyone following.
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// Since returns buffered lines after seq that match filter, oldest first. A
+// nil filter matches everything. The returned channel is closed when another
+// line is appended, for following the log.
+func (b *LogBuffer) Since(seq uint64, filter *regexp.Regexp) ([]LogLine, <-chan struct{}) {
+	lines, _, changed := b.Follow(seq, filter)
+	return lines, changed
+}
+
+// Follow is Since, also returning the sequence number of the last line
+// looked at. Passing that next time only looks at newer lines, even if none
+// matched the filter.
+func (b *LogBuffer) Follow(seq uint64, filter *regexp.Regexp) ([]LogLine, uint64, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Sequence numbers have no gaps, so lines after seq start at a known
+	// offset from the oldest.
+	skip := 0
+	oldest := b.lastSeq - uint64(b.count) + 1
+	if seq >= oldest {
+		skip = int(seq - oldest + 1)
+	}
+
+	lines := []LogLine{}
+	for i := skip; i < b.count; i++ {
+		line := b.lines[(b.start+i)%len(b.lines)]
+		if filter != nil && !filter.MatchString(line.Text) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	last := b.lastSeq
+	if seq > last {
+		last = seq
+	}
+	return lines, last, b.changed
+}
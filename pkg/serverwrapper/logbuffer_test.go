@@ -0,0 +1,87 @@
+package serverwrapper
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func texts(lines []LogLine) []string {
+	out := []string{}
+	for _, l := range lines {
+		out = append(out, l.Text)
+	}
+	return out
+}
+
+func TestLogBufferDropsOldest(t *testing.T) {
+	buf := NewLogBuffer(3)
+	for i := 1; i <= 5; i++ {
+		buf.Append(fmt.Sprintf("line %d", i), time.Now())
+	}
+
+	lines, _ := buf.Since(0, nil)
+	require.Equal(t, []string{"line 3", "line 4", "line 5"}, texts(lines))
+	require.Equal(t, uint64(5), lines[2].Seq)
+
+	lines, _ = buf.Since(4, nil)
+	require.Equal(t, []string{"line 5"}, texts(lines))
+}
+
+func TestLogBufferFilter(t *testing.T) {
+	buf := NewLogBuffer(10)
+	buf.Append("[08:28:47] [Server thread/INFO]: NeroGage joined the game", time.Now())
+	buf.Append("[08:28:50] [Server thread/INFO]: Saved the game", time.Now())
+	buf.Append("[08:28:57] [Server thread/INFO]: NeroGage left the game", time.Now())
+
+	lines, _ := buf.Since(0, regexp.MustCompile("NeroGage"))
+	require.Len(t, lines, 2)
+	require.Equal(t, uint64(3), lines[1].Seq)
+}
+
+func TestLogBufferChanged(t *testing.T) {
+	buf := NewLogBuffer(10)
+
+	_, changed := buf.Since(0, nil)
+	select {
+	case <-changed:
+		t.Fatal("changed before append")
+	default:
+	}
+
+	buf.Append("hello", time.Now())
+
+	select {
+	case <-changed:
+	default:
+		t.Fatal("not changed after append")
+	}
+}
+
+func TestLogBufferFollowSkipsUnmatched(t *testing.T) {
+	buf := NewLogBuffer(10)
+	filter := regexp.MustCompile("joined")
+
+	buf.Append("NeroGage joined the game", time.Now())
+	lines, last, _ := buf.Follow(0, filter)
+	require.Len(t, lines, 1)
+	require.Equal(t, uint64(1), last)
+
+	buf.Append("Saved the game", time.Now())
+	lines, last, _ = buf.Follow(last, filter)
+	require.Empty(t, lines)
+	require.Equal(t, uint64(2), last)
+
+	buf.Append("Steve joined the game", time.Now())
+	lines, last, _ = buf.Follow(last, filter)
+	require.Equal(t, []string{"Steve joined the game"}, texts(lines))
+	require.Equal(t, uint64(3), last)
+
+	// Nothing new.
+	lines, last, _ = buf.Follow(last, filter)
+	require.Empty(t, lines)
+	require.Equal(t, uint64(3), last)
+}
@@ -41,3 +41,55 @@ func AvailableMiB() (int, error) {
 
 	return 0, nil
 }
+
+// ProcessRSSMiB is the resident set size of a process.
+func ProcessRSSMiB(pid int) (int, error) {
+	status, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewBuffer(status))
+
+	for scanner.Scan() {
+		bits := strings.Fields(scanner.Text())
+		if len(bits) == 3 && bits[0] == "VmRSS:" {
+			if bits[2] != "kB" {
+				return 0, fmt.Errorf("unexpected unit: %s", bits[2])
+			}
+
+			kb, err := strconv.ParseInt(bits[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+
+			return int(kb / int64(1024)), nil
+		}
+	}
+
+	return 0, fmt.Errorf("no VmRSS for process %d", pid)
+}
+
+// JVMMemoryMiB parses a JVM memory option such as "10G" or "512M".
+func JVMMemoryMiB(mem string) (int, error) {
+	if mem == "" {
+		return 0, fmt.Errorf("empty memory size")
+	}
+
+	unit := strings.ToUpper(mem[len(mem)-1:])
+	num, err := strconv.ParseInt(mem[:len(mem)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory size %s: %w", mem, err)
+	}
+
+	switch unit {
+	case "K":
+		return int(num / 1024), nil
+	case "M":
+		return int(num), nil
+	case "G":
+		return int(num * 1024), nil
+	}
+
+	return 0, fmt.Errorf("invalid memory unit in %s", mem)
+}
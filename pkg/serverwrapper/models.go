@@ -1,8 +1,82 @@
 package serverwrapper
 
+import (
+	"time"
+
+	"github.com/owengage/minecloud/pkg/worldsync"
+)
+
 // StatusResponse is the response from the status endpoint
 type StatusResponse struct {
 	Status string
+
+	// Version of Minecraft, once the server has announced it.
+	Version string
+
+	// StartedAt is when the server finished starting, nil until it has.
+	StartedAt *time.Time
+
+	// UptimeSeconds since the server finished starting.
+	UptimeSeconds int64
+
+	// Players currently online.
+	Players []Player
+
+	// TPS is the estimated ticks per second over the last minute. 20 is
+	// perfect. Nil until the server has started.
+	TPS *float64
+
+	// Memory of the JVM, nil if unknown.
+	Memory *Memory
+}
+
+// Player is a player currently online.
+type Player struct {
+	Name     string
+	UUID     string
+	JoinedAt time.Time
+}
+
+// Memory usage of the server's JVM.
+type Memory struct {
+	UsedMiB int // resident set size of the JVM process
+	MaxMiB  int // maximum heap
+}
+
+// CommandRequest is the request to the command endpoint.
+type CommandRequest struct {
+	Command string `json:"command"`
+}
+
+// CommandResponse is the response from the command endpoint. Output is only
+// available when the wrapper sends commands over RCON.
+type CommandResponse struct {
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SnapshotResponse is the response from storing a snapshot.
+type SnapshotResponse struct {
+	Location string `json:"location"` // eg "s3://bucket/worlds/cliff/.minecloud/snapshots/20201017T101112Z.tar.gz"
+	Error    string `json:"error,omitempty"`
+}
+
+// SaveResponse is the response from the save endpoint, sent once the game is
+// saved or the save has failed.
+type SaveResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// UploadRequest is the request to the upload endpoint. World must match the
+// wrapper's world, as a guard against overwriting another world.
+type UploadRequest struct {
+	World string `json:"world"`
+}
+
+// UploadResponse is the response from the upload endpoint.
+type UploadResponse struct {
+	Error string           `json:"error,omitempty"`
+	Sync  *worldsync.Stats `json:"sync,omitempty"`
 }
 
 type Status string
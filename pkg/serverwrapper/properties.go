@@ -0,0 +1,52 @@
+package serverwrapper
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/owengage/minecloud/pkg/rcon"
+)
+
+// ReadProperties parses a server.properties file into a map.
+func ReadProperties(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	props := map[string]string{}
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		bits := strings.SplitN(line, "=", 2)
+		if len(bits) != 2 {
+			continue
+		}
+		props[strings.TrimSpace(bits[0])] = strings.TrimSpace(bits[1])
+	}
+
+	return props, scanner.Err()
+}
+
+// RCONSettings gets the local RCON address and password from server
+// properties. Returns false if RCON isn't enabled.
+func RCONSettings(props map[string]string) (address, password string, ok bool) {
+	if props["enable-rcon"] != "true" || props["rcon.password"] == "" {
+		return "", "", false
+	}
+
+	port := props["rcon.port"]
+	if port == "" {
+		port = fmt.Sprint(rcon.DefaultPort)
+	}
+
+	return "localhost:" + port, props["rcon.password"], true
+}
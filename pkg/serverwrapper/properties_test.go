@@ -0,0 +1,33 @@
+package serverwrapper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRCONSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "properties")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "server.properties")
+	err = ioutil.WriteFile(path, []byte("#Minecraft server properties\nenable-rcon=true\nrcon.port=25580\nrcon.password=hunter2\nmotd=A Minecraft Server\n"), 0644)
+	require.NoError(t, err)
+
+	props, err := ReadProperties(path)
+	require.NoError(t, err)
+	require.Equal(t, "A Minecraft Server", props["motd"])
+
+	address, password, ok := RCONSettings(props)
+	require.True(t, ok)
+	require.Equal(t, "localhost:25580", address)
+	require.Equal(t, "hunter2", password)
+
+	props["enable-rcon"] = "false"
+	_, _, ok = RCONSettings(props)
+	require.False(t, ok)
+}
@@ -0,0 +1,50 @@
+package serverwrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MetadataURL is the EC2 instance metadata service.
+const MetadataURL = "http://169.254.169.254"
+
+// instanceActionPath gives the interruption notice of a spot instance, or 404
+// if there isn't one.
+const instanceActionPath = "/latest/meta-data/spot/instance-action"
+
+// InterruptionNotice is given about two minutes before EC2 reclaims a spot
+// instance.
+type InterruptionNotice struct {
+	Action string    `json:"action"` // eg "terminate"
+	Time   time.Time `json:"time"`
+}
+
+// CheckInterruption asks the metadata service at baseURL for an interruption
+// notice, returning nil if there isn't one yet.
+//
+// This uses the original metadata API rather than the token based one, since
+// the token's responses don't reach containers by default.
+func CheckInterruption(client *http.Client, baseURL string) (*InterruptionNotice, error) {
+	resp, err := client.Get(strings.TrimSuffix(baseURL, "/") + instanceActionPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("instance action: %s", resp.Status)
+	}
+
+	var notice InterruptionNotice
+	err = json.NewDecoder(resp.Body).Decode(&notice)
+	if err != nil {
+		return nil, fmt.Errorf("instance action: %w", err)
+	}
+	return &notice, nil
+}
@@ -0,0 +1,73 @@
+package serverwrapper_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/serverwrapper"
+	"github.com/stretchr/testify/require"
+)
+
+// metadata stands in for the instance metadata service, giving an
+// interruption notice once one is set.
+type metadata struct {
+	mu     sync.Mutex
+	notice string
+}
+
+func (m *metadata) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r.URL.Path != "/latest/meta-data/spot/instance-action" || m.notice == "" {
+		http.NotFound(w, r)
+		return
+	}
+	_, _ = w.Write([]byte(m.notice))
+}
+
+func (m *metadata) interrupt(notice string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notice = notice
+}
+
+func TestCheckInterruption(t *testing.T) {
+	md := &metadata{}
+	server := httptest.NewServer(md)
+	defer server.Close()
+
+	notice, err := serverwrapper.CheckInterruption(server.Client(), server.URL)
+	require.NoError(t, err)
+	require.Nil(t, notice)
+
+	md.interrupt(`{"action": "terminate", "time": "2020-05-04T08:22:00Z"}`)
+
+	notice, err = serverwrapper.CheckInterruption(server.Client(), server.URL+"/")
+	require.NoError(t, err)
+	require.Equal(t, &serverwrapper.InterruptionNotice{
+		Action: "terminate",
+		Time:   time.Date(2020, 5, 4, 8, 22, 0, 0, time.UTC),
+	}, notice)
+}
+
+func TestCheckInterruptionErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	_, err := serverwrapper.CheckInterruption(server.Client(), server.URL)
+	require.Error(t, err)
+
+	md := &metadata{}
+	md.interrupt("not json")
+	garbled := httptest.NewServer(md)
+	defer garbled.Close()
+
+	_, err = serverwrapper.CheckInterruption(garbled.Client(), garbled.URL)
+	require.Error(t, err)
+}
@@ -0,0 +1,146 @@
+package serverwrapper
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ticksPerSecond is what a healthy Minecraft server runs at.
+const ticksPerSecond = 20
+
+// tpsWindow is how far back TPS is estimated over.
+const tpsWindow = time.Minute
+
+// Tracker follows the server's console output to keep track of who is
+// online, what version is running and how well it is keeping up.
+type Tracker struct {
+	mu sync.Mutex
+
+	now       func() time.Time
+	version   string
+	startedAt *time.Time
+	uuids     map[string]string // name to UUID, seen before joining.
+	players   map[string]Player
+	lag       []lagSpike
+}
+
+type lagSpike struct {
+	at    time.Time
+	ticks int
+}
+
+// NewTracker creates a tracker for a server that hasn't output anything yet.
+func NewTracker() *Tracker {
+	return &Tracker{
+		now:     time.Now,
+		uuids:   map[string]string{},
+		players: map[string]Player{},
+	}
+}
+
+// Observe a line of console output.
+func (t *Tracker) Observe(line string) {
+	parsed, ok := ParseConsoleLine(line)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+
+	if name, uuid, ok := parsed.PlayerUUID(); ok {
+		t.uuids[name] = uuid
+	} else if name, ok := parsed.PlayerJoined(); ok {
+		t.players[name] = Player{
+			Name:     name,
+			UUID:     t.uuids[name],
+			JoinedAt: now,
+		}
+		delete(t.uuids, name)
+	} else if name, ok := parsed.PlayerLeft(); ok {
+		delete(t.players, name)
+	} else if version, ok := parsed.Version(); ok {
+		t.version = version
+	} else if parsed.Done() {
+		t.startedAt = &now
+	} else if ticks, ok := parsed.TicksBehind(); ok {
+		t.lag = append(t.lag, lagSpike{at: now, ticks: ticks})
+	}
+}
+
+// PlayerCount is the number of players online.
+func (t *Tracker) PlayerCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.players)
+}
+
+// StartedAt is when the server finished starting, nil if it hasn't.
+func (t *Tracker) StartedAt() *time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.startedAt
+}
+
+// Fill in the parts of a status response the tracker knows about.
+func (t *Tracker) Fill(resp *StatusResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+
+	resp.Version = t.version
+	resp.StartedAt = t.startedAt
+
+	resp.Players = make([]Player, 0, len(t.players))
+	for _, p := range t.players {
+		resp.Players = append(resp.Players, p)
+	}
+	sort.Slice(resp.Players, func(i, j int) bool {
+		return resp.Players[i].Name < resp.Players[j].Name
+	})
+
+	if t.startedAt == nil {
+		return
+	}
+
+	resp.UptimeSeconds = int64(now.Sub(*t.startedAt).Seconds())
+	tps := t.tps(now)
+	resp.TPS = &tps
+}
+
+// tps estimates ticks per second from the server's "Can't keep up!" warnings,
+// which say how many ticks were skipped. Vanilla has no other way to ask.
+func (t *Tracker) tps(now time.Time) float64 {
+	window := tpsWindow
+	if since := now.Sub(*t.startedAt); since < window {
+		window = since
+	}
+	if window <= 0 {
+		return ticksPerSecond
+	}
+
+	// Drop spikes that have fallen out of the window.
+	kept := t.lag[:0]
+	behind := 0
+	for _, spike := range t.lag {
+		if now.Sub(spike.at) <= tpsWindow {
+			kept = append(kept, spike)
+			if now.Sub(spike.at) <= window {
+				behind += spike.ticks
+			}
+		}
+	}
+	t.lag = kept
+
+	expected := window.Seconds() * ticksPerSecond
+	ticked := expected - float64(behind)
+	if ticked < 0 {
+		ticked = 0
+	}
+
+	return ticked / window.Seconds()
+}
@@ -0,0 +1,72 @@
+package serverwrapper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTracker() (*Tracker, *time.Time) {
+	now := time.Date(2020, 3, 28, 8, 0, 0, 0, time.UTC)
+	tracker := NewTracker()
+	tracker.now = func() time.Time { return now }
+	return tracker, &now
+}
+
+func TestTrackerPlayers(t *testing.T) {
+	tracker, now := newTestTracker()
+
+	tracker.Observe("[08:28:40] [Server thread/INFO]: Starting minecraft server version 1.15.2")
+	tracker.Observe(`[08:28:45] [Server thread/INFO]: Done (5.123s)! For help, type "help"`)
+	tracker.Observe("[08:28:47] [User Authenticator #1/INFO]: UUID of player NeroGage is a87fddc1-dc61-4c11-8472-f49001a15d21")
+	tracker.Observe("[08:28:47] [Server thread/INFO]: NeroGage[/127.0.0.1:33062] logged in with entity id 366 at (-144.4, 64.0, -157.5)")
+	tracker.Observe("[08:28:47] [Server thread/INFO]: NeroGage joined the game")
+	tracker.Observe("[08:28:50] [Server thread/INFO]: <NeroGage> Steve joined the game")
+
+	*now = now.Add(10 * time.Second)
+
+	var resp StatusResponse
+	tracker.Fill(&resp)
+
+	require.Equal(t, "1.15.2", resp.Version)
+	require.Equal(t, int64(10), resp.UptimeSeconds)
+	require.Len(t, resp.Players, 1)
+	require.Equal(t, "NeroGage", resp.Players[0].Name)
+	require.Equal(t, "a87fddc1-dc61-4c11-8472-f49001a15d21", resp.Players[0].UUID)
+
+	tracker.Observe("[08:28:57] [Server thread/INFO]: NeroGage left the game")
+	require.Equal(t, 0, tracker.PlayerCount())
+}
+
+func TestTrackerTPS(t *testing.T) {
+	tracker, now := newTestTracker()
+
+	var resp StatusResponse
+	tracker.Fill(&resp)
+	require.Nil(t, resp.TPS)
+
+	tracker.Observe(`[08:00:00] [Server thread/INFO]: Done (5.123s)! For help, type "help"`)
+	*now = now.Add(2 * time.Minute)
+
+	tracker.Observe("[08:02:00] [Server thread/WARN]: Can't keep up! Is the server overloaded? Running 12000ms or 240 ticks behind")
+	tracker.Fill(&resp)
+	require.InDelta(t, 16.0, *resp.TPS, 0.001)
+
+	*now = now.Add(2 * time.Minute)
+	tracker.Fill(&resp)
+	require.InDelta(t, 20.0, *resp.TPS, 0.001)
+}
+
+func TestJVMMemoryMiB(t *testing.T) {
+	mib, err := JVMMemoryMiB("10G")
+	require.NoError(t, err)
+	require.Equal(t, 10240, mib)
+
+	mib, err = JVMMemoryMiB("4096M")
+	require.NoError(t, err)
+	require.Equal(t, 4096, mib)
+
+	_, err = JVMMemoryMiB("lots")
+	require.Error(t, err)
+}
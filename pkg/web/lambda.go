@@ -0,0 +1,89 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// LambdaHandler serves API Gateway proxy requests with the handler, so the
+// website can run as a lambda.
+func LambdaHandler(h http.Handler) func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		r, err := proxyRequest(ctx, event)
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		w := &responseWriter{header: http.Header{}, status: http.StatusOK}
+		h.ServeHTTP(w, r)
+
+		resp := events.APIGatewayProxyResponse{
+			StatusCode:        w.status,
+			MultiValueHeaders: w.header,
+			Body:              w.body.String(),
+		}
+		return resp, nil
+	}
+}
+
+func proxyRequest(ctx context.Context, event events.APIGatewayProxyRequest) (*http.Request, error) {
+	query := url.Values{}
+	for key, value := range event.QueryStringParameters {
+		query.Set(key, value)
+	}
+	for key, values := range event.MultiValueQueryStringParameters {
+		query[key] = values
+	}
+
+	body := []byte(event.Body)
+	if event.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(event.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	u := url.URL{Path: event.Path, RawQuery: query.Encode()}
+	r, err := http.NewRequest(event.HTTPMethod, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range event.Headers {
+		r.Header.Set(key, value)
+	}
+	for key, values := range event.MultiValueHeaders {
+		r.Header[http.CanonicalHeaderKey(key)] = values
+	}
+	r.Host = r.Header.Get("Host")
+	r.RemoteAddr = event.RequestContext.Identity.SourceIP
+
+	return r.WithContext(ctx), nil
+}
+
+// responseWriter buffers the response to return to API Gateway. Only text is
+// served, so the body never needs base64 encoding.
+type responseWriter struct {
+	header http.Header
+	status int
+	body   strings.Builder
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.status = status
+}
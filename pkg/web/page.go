@@ -0,0 +1,191 @@
+package web
+
+// pageHTML lists the worlds, refreshing every few seconds so player counts
+// stay live. Users log in with the authorization code grant and PKCE: the
+// provider sends them back with a code, which the page redeems for an ID
+// token, which is kept for the browser session.
+const pageHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Minecloud</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.4em 1em; text-align: left; border-bottom: 1px solid #ddd; }
+.failed { color: #b00; }
+#message { margin-top: 1em; }
+</style>
+</head>
+<body>
+<h1>Minecloud</h1>
+<table>
+<thead><tr><th>World</th><th>State</th><th>Address</th><th>Players</th><th></th></tr></thead>
+<tbody id="worlds"></tbody>
+</table>
+<div id="message"></div>
+<script>
+const authURL = {{.AuthURL}};
+const tokenURL = {{.TokenURL}};
+const clientID = {{.ClientID}};
+const redirectURI = window.location.origin + window.location.pathname;
+
+function base64url(bytes) {
+  return btoa(String.fromCharCode(...new Uint8Array(bytes)))
+    .replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function random() {
+  return base64url(crypto.getRandomValues(new Uint8Array(32)));
+}
+
+async function login() {
+  sessionStorage.removeItem("token");
+  const verifier = random();
+  const state = random();
+  sessionStorage.setItem("verifier", verifier);
+  sessionStorage.setItem("state", state);
+
+  const challenge = await crypto.subtle.digest("SHA-256", new TextEncoder().encode(verifier));
+  const params = new URLSearchParams({
+    response_type: "code",
+    client_id: clientID,
+    redirect_uri: redirectURI,
+    scope: "openid email",
+    state: state,
+    code_challenge: base64url(challenge),
+    code_challenge_method: "S256",
+  });
+  window.location = authURL + "?" + params;
+}
+
+// finishLogin redeems the code the provider sent the user back with, if it
+// answers the login this session started.
+async function finishLogin() {
+  const query = new URLSearchParams(window.location.search);
+  if (!query.has("code") && !query.has("error")) {
+    return;
+  }
+  history.replaceState(null, "", window.location.pathname);
+
+  const state = sessionStorage.getItem("state");
+  const verifier = sessionStorage.getItem("verifier");
+  sessionStorage.removeItem("state");
+  sessionStorage.removeItem("verifier");
+  if (query.has("error")) {
+    throw new Error(query.get("error_description") || query.get("error"));
+  }
+  if (!state || query.get("state") !== state) {
+    throw new Error("login wasn't started by this page");
+  }
+
+  const resp = await fetch(tokenURL, {
+    method: "POST",
+    headers: {"Content-Type": "application/x-www-form-urlencoded"},
+    body: new URLSearchParams({
+      grant_type: "authorization_code",
+      code: query.get("code"),
+      redirect_uri: redirectURI,
+      client_id: clientID,
+      code_verifier: verifier,
+    }),
+  });
+  const body = await resp.json();
+  if (!resp.ok) {
+    throw new Error(body.error);
+  }
+  sessionStorage.setItem("token", body.id_token);
+}
+
+async function api(method, path) {
+  const resp = await fetch(path, {method: method, headers: {Authorization: "Bearer " + sessionStorage.getItem("token")}});
+  if (resp.status === 401) {
+    login();
+    throw new Error("logging in");
+  }
+  const body = await resp.json();
+  if (!resp.ok) {
+    throw new Error(body.error);
+  }
+  return body;
+}
+
+function message(text) {
+  document.getElementById("message").textContent = text;
+}
+
+function button(label, world, action) {
+  const b = document.createElement("button");
+  b.textContent = label;
+  b.onclick = () => request(world, action);
+  return b;
+}
+
+async function request(world, action) {
+  try {
+    message(action + " " + world + "...");
+    const result = await api("POST", "/api/worlds/" + encodeURIComponent(world) + "/" + action);
+    if (result.operation) {
+      follow(result.operation);
+    } else {
+      message(action + " " + world + " done");
+    }
+  } catch (e) {
+    message(action + " " + world + " failed: " + e.message);
+  }
+}
+
+async function follow(id) {
+  const op = await api("GET", "/api/operations/" + encodeURIComponent(id));
+  let text = op.Kind + " " + op.World + ": " + op.Status;
+  if (op.Step && op.Status === "running") text += ", " + op.Step;
+  if (op.Address) text += ", available at " + op.Address;
+  if (op.Error) text += ": " + op.Error;
+  message(text);
+  refresh();
+  if (op.Status !== "succeeded" && op.Status !== "failed") {
+    setTimeout(() => follow(id), 5000);
+  }
+}
+
+async function refresh() {
+  const worlds = await api("GET", "/api/worlds");
+  const rows = document.getElementById("worlds");
+  rows.textContent = "";
+
+  for (const world of worlds) {
+    const row = rows.insertRow();
+    row.insertCell().textContent = world.name;
+
+    const state = row.insertCell();
+    state.textContent = world.state + (world.failed ? " (" + world.failed + ")" : "");
+    if (world.failed) state.className = "failed";
+
+    row.insertCell().textContent = world.address || "";
+    row.insertCell().textContent = world.state === "running" || world.state === "saving"
+      ? world.players.length + (world.players.length ? ": " + world.players.join(", ") : "")
+      : "";
+
+    const actions = row.insertCell();
+    if (world.state === "stored") {
+      actions.appendChild(button("Up", world.name, "up"));
+    }
+    if (world.state === "running") {
+      actions.appendChild(button("Save", world.name, "save"));
+      actions.appendChild(button("Down", world.name, "down"));
+    }
+  }
+}
+
+finishLogin().then(() => {
+  if (!sessionStorage.getItem("token")) {
+    login();
+    return;
+  }
+  refresh();
+  setInterval(refresh, 15000);
+}).catch(e => message("login failed: " + e.message));
+</script>
+</body>
+</html>
+`
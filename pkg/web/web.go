@@ -0,0 +1,307 @@
+// Package web is the Minecloud website: a page listing worlds, with buttons
+// to bring them up, take them down and save them, and the JSON API behind it.
+// Users log in with an OpenID Connect provider, and every API request carries
+// their token.
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/functions"
+	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/owengage/minecloud/pkg/oidc"
+)
+
+// Server handles the website and its API.
+type Server struct {
+	Backend  *backend.Backend
+	Invoker  functions.Invoker
+	Verifier *oidc.Verifier
+
+	// AuthURL is the provider's authorization endpoint, where the page sends
+	// users to log in, and TokenURL its token endpoint, where the page
+	// redeems the code they come back with.
+	AuthURL  string
+	TokenURL string
+
+	// SaveTimeout is how long to wait for a game to save.
+	SaveTimeout time.Duration
+
+	mux *http.ServeMux
+}
+
+// NewServer for the backend, invoking the singleton to bring worlds up and
+// down, and trusting tokens from the configured provider.
+func NewServer(b *backend.Backend, invoker functions.Invoker, config oidc.Config, authURL, tokenURL string) *Server {
+	s := &Server{
+		Backend:     b,
+		Invoker:     invoker,
+		Verifier:    oidc.NewVerifier(config),
+		AuthURL:     authURL,
+		TokenURL:    tokenURL,
+		SaveTimeout: time.Minute,
+		mux:         http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/", s.handlePage)
+	s.mux.HandleFunc("/api/worlds", s.authed(s.handleWorlds))
+	s.mux.HandleFunc("/api/worlds/", s.authed(s.handleWorldAction))
+	s.mux.HandleFunc("/api/operations/", s.authed(s.handleOperation))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// World as shown on the page.
+type World struct {
+	Name string `json:"name"`
+
+	// State in the world's lifecycle, or "stored" if it isn't claimed.
+	State string     `json:"state"`
+	Since *time.Time `json:"since,omitempty"`
+	Owner string     `json:"owner,omitempty"`
+
+	// Failed says how far a failed operation got, eg "up after dns".
+	Failed string `json:"failed,omitempty"`
+
+	// Address, status and players of a running server.
+	Address string   `json:"address,omitempty"`
+	Status  string   `json:"status,omitempty"`
+	Players []string `json:"players"`
+}
+
+// StateStored is the state shown for worlds that aren't claimed.
+const StateStored = "stored"
+
+// ListWorlds lists every stored or claimed world, asking running servers who
+// is playing.
+func ListWorlds(b *backend.Backend) ([]World, error) {
+	stored, err := b.Storage.List()
+	if err != nil {
+		return nil, err
+	}
+	leases, err := b.Claims.List()
+	if err != nil {
+		return nil, err
+	}
+
+	worlds := map[minecloud.World]*World{}
+	for _, name := range stored {
+		worlds[name] = &World{Name: string(name), State: StateStored, Players: []string{}}
+	}
+
+	var wg sync.WaitGroup
+	for _, lease := range leases {
+		world := &World{Name: string(lease.World), State: string(lease.State), Owner: lease.Owner, Players: []string{}}
+		worlds[lease.World] = world
+
+		if world.State == "" {
+			world.State = "claimed"
+		}
+		if !lease.StateChanged.IsZero() {
+			since := lease.StateChanged
+			world.Since = &since
+		}
+		if lease.State == backend.StateFailed && lease.Operation != "" {
+			world.Failed = lease.Operation
+			if lease.Step != "" {
+				world.Failed += " after " + lease.Step
+			}
+		}
+
+		running := lease.State == backend.StateRunning || lease.State == backend.StateSaving
+		if running && lease.InstanceID != "" {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				serverDetails(b, id, world)
+			}(lease.InstanceID)
+		}
+	}
+	wg.Wait()
+
+	list := []World{}
+	for _, world := range worlds {
+		list = append(list, *world)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// serverDetails fills in what the running server says. A server that can't
+// be reached is still listed.
+func serverDetails(b *backend.Backend, id string, world *World) {
+	address, err := b.Compute.Address(id)
+	if err != nil {
+		b.Logger.Warnf("could not get address of %s: %v", id, err)
+	}
+	world.Address = address
+
+	status, err := b.Compute.Status(id)
+	if err != nil {
+		b.Logger.Warnf("could not get status of %s: %v", id, err)
+		return
+	}
+
+	world.Status = status.Status
+	for _, player := range status.Players {
+		world.Players = append(world.Players, player.Name)
+	}
+}
+
+type authedHandler func(w http.ResponseWriter, r *http.Request, claims oidc.Claims)
+
+// authed only passes on requests with a valid bearer token.
+func (s *Server) authed(h authedHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		claims, err := s.Verifier.Verify(token)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		h(w, r, claims)
+	}
+}
+
+func (s *Server) handleWorlds(w http.ResponseWriter, r *http.Request, claims oidc.Claims) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	worlds, err := ListWorlds(s.Backend)
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, worlds)
+}
+
+// validWorld names are safe in bucket keys and DNS names, and operation IDs
+// in keys and file names.
+var (
+	validWorld     = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+	validOperation = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+)
+
+// handleWorldAction handles POST /api/worlds/<world>/<up|down|save>.
+func (s *Server) handleWorldAction(w http.ResponseWriter, r *http.Request, claims oidc.Claims) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/worlds/"), "/")
+	if len(parts) != 2 || !validWorld.MatchString(parts[0]) {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	world, action := minecloud.World(parts[0]), parts[1]
+
+	s.Backend.Logger.Infof("%s asked to %s %s", claims.Name(), action, world)
+
+	switch action {
+	case "up", "down":
+		event := functions.Event{
+			Command: &action,
+			World:   &parts[0],
+		}
+		if action == "up" {
+			owner := "web:" + claims.Name()
+			event.Owner = &owner
+		}
+
+		id, err := functions.RequestOperation(s.Backend, s.Invoker, event)
+		if err != nil {
+			s.fail(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"operation": id})
+
+	case "save":
+		if err := backend.SaveRunning(s.Backend, world, s.SaveTimeout); err != nil {
+			s.fail(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{})
+
+	default:
+		writeError(w, http.StatusNotFound, errors.New("unknown action: "+action))
+	}
+}
+
+// handleOperation handles GET /api/operations/<id>.
+func (s *Server) handleOperation(w http.ResponseWriter, r *http.Request, claims oidc.Claims) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/operations/")
+	if !validOperation.MatchString(id) {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	op, err := backend.GetOperation(s.Backend, id)
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, op)
+}
+
+// fail the request with a status fitting the error.
+func (s *Server) fail(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, backend.ErrWorldAlreadyClaimed), errors.Is(err, backend.ErrWorldNotClaimed),
+		errors.Is(err, backend.ErrInvalidTransition):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, backend.ErrServerNotFound), errors.Is(err, backend.ErrOperationNotFound):
+		writeError(w, http.StatusNotFound, err)
+	default:
+		s.Backend.Logger.Errorf("request failed: %v", err)
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := page.Execute(w, map[string]string{
+		"AuthURL":  s.AuthURL,
+		"TokenURL": s.TokenURL,
+		"ClientID": s.Verifier.Config.ClientID,
+	})
+	if err != nil {
+		s.Backend.Logger.Errorf("render page: %v", err)
+	}
+}
+
+var page = template.Must(template.New("page").Parse(pageHTML))
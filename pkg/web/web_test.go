@@ -0,0 +1,197 @@
+package web_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/owengage/minecloud/pkg/awsdetail"
+	"github.com/owengage/minecloud/pkg/backend"
+	"github.com/owengage/minecloud/pkg/fakeaws"
+	"github.com/owengage/minecloud/pkg/functions"
+	"github.com/owengage/minecloud/pkg/minecloud"
+	"github.com/owengage/minecloud/pkg/oidc/oidctest"
+	"github.com/owengage/minecloud/pkg/web"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeServer(t *testing.T) (*oidctest.Issuer, *web.Server) {
+	fakes := fakeaws.NewWithTable()
+	fakes.S3.Put("ogage-minecraft", "worlds/cliff/level.dat", []byte("level"))
+	fakes.S3.Put("ogage-minecraft", "worlds/lake/level.dat", []byte("level"))
+
+	b := awsdetail.NewBackend(fakes.Detail(fakeaws.TestConfig()))
+
+	issuer := oidctest.NewIssuer()
+	return issuer, web.NewServer(b, &functions.LocalInvoker{Backend: b}, issuer.Config(), issuer.URL+"/authorize", issuer.URL+"/token")
+}
+
+// newRunningMultiRegionBackend has cliff running in the main region and lake
+// in a second. The backend hasn't looked anything up in either yet.
+func newRunningMultiRegionBackend(t *testing.T) *backend.Backend {
+	fakes := fakeaws.NewWithTable()
+	fakes.S3.Put("ogage-minecraft", "worlds/cliff/level.dat", []byte("level"))
+	fakes.S3.Put("ogage-minecraft", "worlds/lake/level.dat", []byte("level"))
+	fakes.S3.Put("ogage-minecraft-us-east-1", "unrelated", nil)
+
+	config := fakeaws.TestConfig()
+	config.Regions = "us-east-1"
+	detail := fakes.Detail(config)
+	require.NoError(t, awsdetail.SetWorldRegion(detail, "lake", "us-east-1"))
+
+	// The configured security group is only for the main region.
+	_, err := fakes.EC2.InRegion("us-east-1").CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+		GroupName: aws.String("Minecloud_Servers"),
+	})
+	require.NoError(t, err)
+
+	up := awsdetail.NewBackend(detail)
+	for _, world := range []minecloud.World{"cliff", "lake"} {
+		_, err := backend.Claim(up, world, "tester@host")
+		require.NoError(t, err)
+		require.NoError(t, backend.RunStored(up, world, minecloud.UpOptions{}))
+	}
+
+	return awsdetail.NewBackend(fakes.Detail(config))
+}
+
+func do(t *testing.T, s http.Handler, token, method, path string, out interface{}) int {
+	r := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if out != nil {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), out), w.Body.String())
+	}
+	return w.Code
+}
+
+func states(worlds []web.World) map[string]string {
+	m := map[string]string{}
+	for _, world := range worlds {
+		m[world.Name] = world.State
+	}
+	return m
+}
+
+func TestUpDown(t *testing.T) {
+	issuer, s := newFakeServer(t)
+	defer issuer.Close()
+	token := issuer.Token("steve@example.com")
+
+	var worlds []web.World
+	require.Equal(t, http.StatusOK, do(t, s, token, "GET", "/api/worlds", &worlds))
+	require.Equal(t, map[string]string{"cliff": web.StateStored, "lake": web.StateStored}, states(worlds))
+
+	var accepted map[string]string
+	require.Equal(t, http.StatusAccepted, do(t, s, token, "POST", "/api/worlds/cliff/up", &accepted))
+
+	var op backend.Operation
+	require.Equal(t, http.StatusOK, do(t, s, token, "GET", "/api/operations/"+accepted["operation"], &op))
+	require.Equal(t, backend.StatusSucceeded, op.Status)
+	require.NotEmpty(t, op.Address)
+
+	require.Equal(t, http.StatusOK, do(t, s, token, "GET", "/api/worlds", &worlds))
+	require.Equal(t, map[string]string{"cliff": string(backend.StateRunning), "lake": web.StateStored}, states(worlds))
+	require.Equal(t, "web:steve@example.com", worlds[0].Owner)
+	require.Equal(t, op.Address, worlds[0].Address)
+	require.NotEmpty(t, worlds[0].Status)
+	require.NotNil(t, worlds[0].Players)
+
+	require.Equal(t, http.StatusOK, do(t, s, token, "POST", "/api/worlds/cliff/save", nil))
+
+	// Already up.
+	require.Equal(t, http.StatusConflict, do(t, s, token, "POST", "/api/worlds/cliff/up", nil))
+
+	require.Equal(t, http.StatusAccepted, do(t, s, token, "POST", "/api/worlds/cliff/down", nil))
+	require.Equal(t, http.StatusOK, do(t, s, token, "GET", "/api/worlds", &worlds))
+	require.Equal(t, web.StateStored, states(worlds)["cliff"])
+}
+
+// TestListWorldsAcrossRegions looks up running servers in several regions at
+// once, so is worth running with -race.
+func TestListWorldsAcrossRegions(t *testing.T) {
+	b := newRunningMultiRegionBackend(t)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	results := make([][]web.World, 20)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			results[i], errs[i] = web.ListWorlds(b)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i, worlds := range results {
+		require.NoError(t, errs[i])
+		require.Equal(t, map[string]string{"cliff": string(backend.StateRunning), "lake": string(backend.StateRunning)}, states(worlds))
+		for _, world := range worlds {
+			require.NotEmpty(t, world.Address, world.Name)
+			require.NotEmpty(t, world.Status, world.Name)
+		}
+	}
+}
+
+func TestRequiresLogin(t *testing.T) {
+	issuer, s := newFakeServer(t)
+	defer issuer.Close()
+
+	for _, token := range []string{"", "not.a.jwt", oidctest.NewIssuer().Token("steve@example.com")} {
+		require.Equal(t, http.StatusUnauthorized, do(t, s, token, "GET", "/api/worlds", nil))
+		require.Equal(t, http.StatusUnauthorized, do(t, s, token, "POST", "/api/worlds/cliff/up", nil))
+	}
+
+	// The page itself has no data, it sends users to log in.
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), oidctest.ClientID)
+	require.Contains(t, w.Body.String(), issuer.URL+"/token")
+}
+
+func TestBadRequests(t *testing.T) {
+	issuer, s := newFakeServer(t)
+	defer issuer.Close()
+	token := issuer.Token("steve@example.com")
+
+	require.Equal(t, http.StatusNotFound, do(t, s, token, "POST", "/api/worlds/cliff/explode", nil))
+	require.Equal(t, http.StatusNotFound, do(t, s, token, "POST", "/api/worlds/.hidden/up", nil))
+	require.Equal(t, http.StatusNotFound, do(t, s, token, "GET", "/api/operations/nope", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, do(t, s, token, "GET", "/api/worlds/cliff/up", nil))
+	require.Equal(t, http.StatusConflict, do(t, s, token, "POST", "/api/worlds/cliff/down", nil))
+}
+
+func TestLambdaHandler(t *testing.T) {
+	issuer, s := newFakeServer(t)
+	defer issuer.Close()
+
+	resp, err := web.LambdaHandler(s)(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod: "GET",
+		Path:       "/api/worlds",
+		Headers:    map[string]string{"authorization": "Bearer " + issuer.Token("steve@example.com")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []string{"application/json"}, resp.MultiValueHeaders["Content-Type"])
+
+	var worlds []web.World
+	require.NoError(t, json.Unmarshal([]byte(resp.Body), &worlds))
+	require.Len(t, worlds, 2)
+}
@@ -0,0 +1,111 @@
+package worldsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// S3Store keeps a world under a prefix in an S3 bucket.
+type S3Store struct {
+	S3     s3iface.S3API
+	Bucket string
+	Prefix string // eg "worlds/cliff", no trailing slash.
+
+	// Context for requests, if set. Lets a caller with a deadline, like a
+	// spot interruption, give up on a slow upload.
+	Context context.Context
+}
+
+func (s *S3Store) ctx() context.Context {
+	if s.Context == nil {
+		return context.Background()
+	}
+	return s.Context
+}
+
+func (s *S3Store) key(path string) *string {
+	return aws.String(s.Prefix + "/" + path)
+}
+
+// List every object under the prefix.
+func (s *S3Store) List() (map[string]int64, error) {
+	sizes := map[string]int64{}
+
+	err := s.S3.ListObjectsV2PagesWithContext(s.ctx(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(s.Prefix + "/"),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			path := strings.TrimPrefix(*obj.Key, s.Prefix+"/")
+			sizes[path] = aws.Int64Value(obj.Size)
+		}
+		return true
+	})
+
+	return sizes, err
+}
+
+// Get an object.
+func (s *S3Store) Get(path string) (io.ReadCloser, error) {
+	out, err := s.S3.GetObjectWithContext(s.ctx(), &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    s.key(path),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// Put an object.
+func (s *S3Store) Put(path string, body io.ReadSeeker) error {
+	_, err := s.S3.PutObjectWithContext(s.ctx(), &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    s.key(path),
+		Body:   body,
+	})
+	return err
+}
+
+// Delete objects, in batches of the most S3 allows per request.
+func (s *S3Store) Delete(paths []string) error {
+	for len(paths) > 0 {
+		n := len(paths)
+		if n > 1000 {
+			n = 1000
+		}
+
+		ids := []*s3.ObjectIdentifier{}
+		for _, path := range paths[:n] {
+			ids = append(ids, &s3.ObjectIdentifier{Key: s.key(path)})
+		}
+
+		out, err := s.S3.DeleteObjectsWithContext(s.ctx(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.Bucket),
+			Delete: &s3.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+
+		// Each object can fail on its own without failing the request.
+		if len(out.Errors) > 0 {
+			first := out.Errors[0]
+			return fmt.Errorf("delete %s: %s: %s (%d failed)",
+				aws.StringValue(first.Key), aws.StringValue(first.Code), aws.StringValue(first.Message), len(out.Errors))
+		}
+
+		paths = paths[n:]
+	}
+	return nil
+}
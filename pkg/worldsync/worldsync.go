@@ -0,0 +1,325 @@
+// Package worldsync copies a world between a directory and a store, such as
+// S3, transferring only files that have changed.
+//
+// A manifest of file hashes is kept in the store next to the world. Comparing
+// it with the hashes of local files tells us what to transfer without
+// downloading anything else.
+package worldsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestName is the manifest's path relative to the world's prefix.
+const ManifestName = ".minecloud/manifest.json"
+
+// metaDir holds Minecloud's own files in the store, which are never synced.
+const metaDir = ".minecloud/"
+
+// ErrNotFound is returned by a Store when a file doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// Store holds a world's files by path relative to the world, using forward
+// slashes, eg "region/r.0.0.mca".
+type Store interface {
+	// List every file with its size.
+	List() (map[string]int64, error)
+	Get(path string) (io.ReadCloser, error)
+	Put(path string, body io.ReadSeeker) error
+	Delete(paths []string) error
+}
+
+// Entry is a file in the manifest.
+type Entry struct {
+	Size int64
+	Hash string // hex SHA-256 of the contents.
+}
+
+// Manifest of a world's files, by path relative to the world.
+type Manifest struct {
+	Files map[string]Entry
+}
+
+// Stats of a sync, for reporting.
+type Stats struct {
+	Transferred      int
+	Deleted          int
+	Unchanged        int
+	BytesTransferred int64
+	BytesSaved       int64 // size of unchanged files that weren't transferred.
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d transferred (%s), %d deleted, %d unchanged (%s saved)",
+		s.Transferred, humanBytes(s.BytesTransferred), s.Deleted, s.Unchanged, humanBytes(s.BytesSaved))
+}
+
+// Scan hashes every file in dir.
+func Scan(dir string) (Manifest, error) {
+	manifest := Manifest{Files: map[string]Entry{}}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(rel, metaDir) {
+			return nil
+		}
+
+		hash, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+
+		manifest.Files[rel] = Entry{Size: info.Size(), Hash: hash}
+		return nil
+	})
+
+	return manifest, err
+}
+
+// Diff returns the paths in want that aren't the same in have, and the paths
+// in have that aren't in want. Both are sorted.
+func Diff(want, have Manifest) (changed, removed []string) {
+	for path, entry := range want.Files {
+		if have.Files[path] != entry {
+			changed = append(changed, path)
+		}
+	}
+	for path := range have.Files {
+		if _, ok := want.Files[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return changed, removed
+}
+
+// Upload makes the store match dir. The manifest is written last, so an
+// interrupted upload is redone next time.
+func Upload(dir string, store Store) (Stats, error) {
+	var stats Stats
+
+	local, err := Scan(dir)
+	if err != nil {
+		return stats, fmt.Errorf("upload: %w", err)
+	}
+
+	remote, err := remoteManifest(store)
+	if err != nil {
+		return stats, fmt.Errorf("upload: %w", err)
+	}
+
+	changed, removed := Diff(local, remote)
+
+	for _, path := range changed {
+		err := putFile(store, path, filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			return stats, fmt.Errorf("upload: %s: %w", path, err)
+		}
+		stats.Transferred++
+		stats.BytesTransferred += local.Files[path].Size
+	}
+
+	if len(removed) > 0 {
+		err = store.Delete(removed)
+		if err != nil {
+			return stats, fmt.Errorf("upload: %w", err)
+		}
+		stats.Deleted = len(removed)
+	}
+
+	countUnchanged(&stats, local, len(changed))
+
+	body, err := json.Marshal(local)
+	if err != nil {
+		return stats, err
+	}
+
+	err = store.Put(ManifestName, strings.NewReader(string(body)))
+	if err != nil {
+		return stats, fmt.Errorf("upload: manifest: %w", err)
+	}
+
+	return stats, nil
+}
+
+// Download makes dir match the store.
+func Download(store Store, dir string) (Stats, error) {
+	var stats Stats
+
+	remote, err := remoteManifest(store)
+	if err != nil {
+		return stats, fmt.Errorf("download: %w", err)
+	}
+
+	local, err := Scan(dir)
+	if err != nil {
+		return stats, fmt.Errorf("download: %w", err)
+	}
+
+	changed, removed := Diff(remote, local)
+
+	for _, path := range changed {
+		err := getFile(store, path, filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			return stats, fmt.Errorf("download: %s: %w", path, err)
+		}
+		stats.Transferred++
+		stats.BytesTransferred += remote.Files[path].Size
+	}
+
+	for _, path := range removed {
+		err := os.Remove(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil && !os.IsNotExist(err) {
+			return stats, fmt.Errorf("download: %w", err)
+		}
+		stats.Deleted++
+	}
+
+	countUnchanged(&stats, remote, len(changed))
+	return stats, nil
+}
+
+// remoteManifest gets the store's manifest. If it has none, for example a
+// world uploaded before manifests existed, the files are listed without
+// hashes so that they all count as changed.
+func remoteManifest(store Store) (Manifest, error) {
+	body, err := store.Get(ManifestName)
+	if err == nil {
+		defer body.Close()
+
+		var manifest Manifest
+		err = json.NewDecoder(body).Decode(&manifest)
+		if err != nil {
+			return manifest, fmt.Errorf("manifest: %w", err)
+		}
+		if manifest.Files == nil {
+			manifest.Files = map[string]Entry{}
+		}
+		return manifest, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return Manifest{}, fmt.Errorf("manifest: %w", err)
+	}
+
+	sizes, err := store.List()
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	manifest := Manifest{Files: map[string]Entry{}}
+	for path, size := range sizes {
+		if !strings.HasPrefix(path, metaDir) {
+			manifest.Files[path] = Entry{Size: size}
+		}
+	}
+	return manifest, nil
+}
+
+func countUnchanged(stats *Stats, manifest Manifest, changed int) {
+	stats.Unchanged = len(manifest.Files) - changed
+	var total int64
+	for _, entry := range manifest.Files {
+		total += entry.Size
+	}
+	stats.BytesSaved = total - stats.BytesTransferred
+}
+
+func putFile(store Store, path, localPath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return store.Put(path, f)
+}
+
+// getFile downloads to a temporary file first, so a failed download never
+// leaves a truncated region file behind.
+func getFile(store Store, path, localPath string) error {
+	body, err := store.Get(path)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	err = os.MkdirAll(filepath.Dir(localPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(localPath), ".worldsync-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), localPath)
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,152 @@
+package worldsync_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/owengage/minecloud/pkg/fakeaws"
+	"github.com/owengage/minecloud/pkg/worldsync"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for path, contents := range files {
+		full := filepath.Join(dir, filepath.FromSlash(path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+		require.NoError(t, ioutil.WriteFile(full, []byte(contents), 0644))
+	}
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "worldsync")
+	require.NoError(t, err)
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestUploadOnlyChanged(t *testing.T) {
+	s3 := fakeaws.NewS3()
+	s3.Put("bucket", "unrelated", nil)
+	store := &worldsync.S3Store{S3: s3, Bucket: "bucket", Prefix: "worlds/cliff"}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	writeFiles(t, dir, map[string]string{
+		"level.dat":        "level",
+		"region/r.0.0.mca": "region 0",
+		"region/r.0.1.mca": "region 1",
+	})
+
+	stats, err := worldsync.Upload(dir, store)
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Transferred)
+	require.Equal(t, []byte("region 0"), s3.Get("bucket", "worlds/cliff/region/r.0.0.mca"))
+
+	writeFiles(t, dir, map[string]string{"level.dat": "level 2", "region/r.1.0.mca": "region new"})
+	require.NoError(t, os.Remove(filepath.Join(dir, "region", "r.0.1.mca")))
+
+	stats, err = worldsync.Upload(dir, store)
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Transferred)
+	require.Equal(t, 1, stats.Deleted)
+	require.Equal(t, 1, stats.Unchanged)
+	require.Equal(t, int64(len("region 0")), stats.BytesSaved)
+
+	require.Equal(t, []byte("level 2"), s3.Get("bucket", "worlds/cliff/level.dat"))
+	require.Nil(t, s3.Get("bucket", "worlds/cliff/region/r.0.1.mca"))
+	require.NotNil(t, s3.Get("bucket", "worlds/cliff/"+worldsync.ManifestName))
+}
+
+func TestUploadFailedDelete(t *testing.T) {
+	s3 := fakeaws.NewS3()
+	s3.Put("bucket", "unrelated", nil)
+	store := &worldsync.S3Store{S3: s3, Bucket: "bucket", Prefix: "worlds/cliff"}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	writeFiles(t, dir, map[string]string{"level.dat": "level", "region/r.0.0.mca": "region 0"})
+	_, err := worldsync.Upload(dir, store)
+	require.NoError(t, err)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "region", "r.0.0.mca")))
+	s3.Protect("bucket", "worlds/cliff/region/r.0.0.mca")
+
+	_, err = worldsync.Upload(dir, store)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "AccessDenied")
+}
+
+func TestUploadGivesUpWithContext(t *testing.T) {
+	s3 := fakeaws.NewS3()
+	s3.Put("bucket", "unrelated", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	store := &worldsync.S3Store{S3: s3, Bucket: "bucket", Prefix: "worlds/cliff", Context: ctx}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	writeFiles(t, dir, map[string]string{"level.dat": "level"})
+	_, err := worldsync.Upload(dir, store)
+	require.True(t, errors.Is(err, context.Canceled))
+	require.Empty(t, s3.Keys("bucket", "worlds/cliff"))
+}
+
+func TestDownloadOnlyChanged(t *testing.T) {
+	s3 := fakeaws.NewS3()
+	s3.Put("bucket", "unrelated", nil)
+	store := &worldsync.S3Store{S3: s3, Bucket: "bucket", Prefix: "worlds/cliff"}
+
+	src, cleanupSrc := tempDir(t)
+	defer cleanupSrc()
+	writeFiles(t, src, map[string]string{
+		"level.dat":        "level",
+		"region/r.0.0.mca": "region 0",
+	})
+	_, err := worldsync.Upload(src, store)
+	require.NoError(t, err)
+
+	dst, cleanupDst := tempDir(t)
+	defer cleanupDst()
+	writeFiles(t, dst, map[string]string{
+		"region/r.0.0.mca": "region 0",
+		"level.dat":        "stale",
+		"session.lock":     "lock",
+	})
+
+	stats, err := worldsync.Download(store, dst)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Transferred)
+	require.Equal(t, 1, stats.Deleted)
+	require.Equal(t, 1, stats.Unchanged)
+
+	level, err := ioutil.ReadFile(filepath.Join(dst, "level.dat"))
+	require.NoError(t, err)
+	require.Equal(t, "level", string(level))
+
+	_, err = os.Stat(filepath.Join(dst, "session.lock"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadWithoutManifest(t *testing.T) {
+	s3 := fakeaws.NewS3()
+	s3.Put("bucket", "worlds/cliff/level.dat", []byte("level"))
+	s3.Put("bucket", "worlds/cliff/.minecloud/backups/1/manifest.json", []byte("{}"))
+	store := &worldsync.S3Store{S3: s3, Bucket: "bucket", Prefix: "worlds/cliff"}
+
+	dst, cleanup := tempDir(t)
+	defer cleanup()
+
+	stats, err := worldsync.Download(store, dst)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Transferred)
+
+	_, err = os.Stat(filepath.Join(dst, ".minecloud"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -1,14 +1,17 @@
 #!/bin/bash
 
 ACCOUNT=$(aws sts get-caller-identity | jq -r .Account)
-REGION=eu-west-2
+# Every region worlds can live in needs the image, eg "eu-west-2 us-east-1".
+REGIONS=${MINECLOUD_IMAGE_REGIONS:-eu-west-2}
 TAG=latest
 
-aws ecr get-login-password --region $REGION | \
-    docker login --username AWS --password-stdin $ACCOUNT.dkr.ecr.$REGION.amazonaws.com/minecloud/server-wrapper
-
 docker build -f server-wrapper.Dockerfile -t minecloud/server-wrapper:$TAG .
 
-docker tag minecloud/server-wrapper:$TAG $ACCOUNT.dkr.ecr.$REGION.amazonaws.com/minecloud/server-wrapper:$TAG
+for REGION in $REGIONS; do
+    aws ecr get-login-password --region $REGION | \
+        docker login --username AWS --password-stdin $ACCOUNT.dkr.ecr.$REGION.amazonaws.com/minecloud/server-wrapper
+
+    docker tag minecloud/server-wrapper:$TAG $ACCOUNT.dkr.ecr.$REGION.amazonaws.com/minecloud/server-wrapper:$TAG
 
-docker push $ACCOUNT.dkr.ecr.$REGION.amazonaws.com/minecloud/server-wrapper:$TAG
+    docker push $ACCOUNT.dkr.ecr.$REGION.amazonaws.com/minecloud/server-wrapper:$TAG
+done
@@ -0,0 +1,8 @@
+#!/bin/bash
+set -e
+
+go build -o main ./cmd/minecloud-web
+zip lambda-web.zip main
+aws lambda update-function-code --function-name MinecloudWeb --zip-file fileb://lambda-web.zip
+rm lambda-web.zip
+rm main